@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rounding of adjusted prices
+const (
+	roundingCents = "cents"
+	roundingEuros = "euros"
+)
+
+// AdjustPricesResult lists the prices adjust-prices changed, or would change
+type AdjustPricesResult struct {
+	StartedAt  time.Time           `json:"startedAt"`
+	DryRun     bool                `json:"dryRun,omitempty"`
+	CategoryId int                 `json:"categoryId"`
+	Percent    float64             `json:"percent"`
+	Rounding   string              `json:"rounding"`
+	Items      []AdjustedPriceDiff `json:"items"`
+	Failed     int                 `json:"failed"`
+	Version    VersionInfo         `json:"version"`
+}
+
+// AdjustedPriceDiff is the old and new price of a single item
+type AdjustedPriceDiff struct {
+	AdTitle  string `json:"adTitle"`
+	OldPrice Price  `json:"oldPrice"`
+	NewPrice Price  `json:"newPrice"`
+	// the new price was raised to the item's floor or lowered to its ceiling
+	Clamped bool   `json:"clamped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// adjustPrices changes the price of every fixed or negotiable item of
+// categoryId by percent, rounded to cents or whole euros. New prices stay
+// within the item's ExpectedPriceRange and the minPrice and maxPrice
+// validation rules of the category. The changed price changes the content
+// hash, so the next run reuploads the ads, and it is confirmed for the
+// price guard. A dry run only lists the changes.
+func (m *monitor) adjustPrices(ctx context.Context, categoryId int, percent float64, rounding string, dryRun bool) (*AdjustPricesResult, error) {
+	if categoryId == 0 {
+		return nil, fmt.Errorf("adjust-prices needs a categoryId")
+	}
+	if percent == 0 || percent <= -100 || math.IsNaN(percent) || math.IsInf(percent, 0) {
+		return nil, fmt.Errorf("adjust-prices needs a percent above -100 other than 0, got %v", percent)
+	}
+	if rounding == "" {
+		rounding = roundingCents
+	}
+	if rounding != roundingCents && rounding != roundingEuros {
+		return nil, fmt.Errorf("unknown rounding %q, expected %s or %s", rounding, roundingCents, roundingEuros)
+	}
+
+	m.log.WithFields(log.Fields{"table": m.table, "categoryId": categoryId, "percent": percent, "rounding": rounding, "dryRun": dryRun}).Info("adjusting prices...")
+
+	result := &AdjustPricesResult{
+		StartedAt:  m.now(),
+		DryRun:     dryRun,
+		CategoryId: categoryId,
+		Percent:    percent,
+		Rounding:   rounding,
+		Items:      make([]AdjustedPriceDiff, 0),
+		Version:    version,
+	}
+
+	bItems, err := m.getBolhaItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := m.loadValidationRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cr := rules.forCategory(categoryId)
+
+	for i := range bItems {
+		bItem := &bItems[i]
+		if bItem.unmarshalErr != nil || bItem.AdCategoryId != categoryId {
+			continue
+		}
+		if bItem.AdPrice == invalidPrice || bItem.AdPrice <= 0 || bItem.priceType() == priceTypeFree {
+			m.log.WithField("AdTitle", bItem.AdTitle).Info("skipping item without a price")
+			continue
+		}
+
+		floor, ceiling := eurosPrice(cr.MinPrice), eurosPrice(cr.MaxPrice)
+		if r := bItem.ExpectedPriceRange; r != nil {
+			if r.Min > floor {
+				floor = r.Min
+			}
+			if r.Max > 0 && (ceiling == 0 || r.Max < ceiling) {
+				ceiling = r.Max
+			}
+		}
+
+		diff := AdjustedPriceDiff{AdTitle: bItem.AdTitle, OldPrice: bItem.AdPrice}
+		diff.NewPrice, diff.Clamped = adjustPrice(bItem.AdPrice, percent, rounding, floor, ceiling)
+		if diff.NewPrice == diff.OldPrice {
+			continue
+		}
+
+		if !dryRun {
+			if err := m.setPrice(ctx, bItem.AdTitle, diff.NewPrice); err != nil {
+				m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Error("could not set price")
+				diff.Error = err.Error()
+				result.Failed++
+			}
+		}
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "oldPrice": diff.OldPrice.String(), "newPrice": diff.NewPrice.String(), "dryRun": dryRun}).Info("price adjusted")
+		result.Items = append(result.Items, diff)
+	}
+
+	kind := "adjust-prices"
+	if dryRun {
+		kind = "adjust-prices-dry-run"
+	}
+	if err := m.saveReport(ctx, kind, result.StartedAt, m.runId, result); err != nil {
+		m.log.WithError(err).Warn("could not save adjust-prices report")
+	}
+
+	m.log.WithFields(log.Fields{"categoryId": categoryId, "items": len(result.Items), "failed": result.Failed}).Info("prices adjusted")
+
+	return result, nil
+}
+
+// adjustPrice returns price changed by percent and rounded, kept within
+// floor and ceiling (0 is no ceiling) unless price itself is outside them.
+// A price is never moved against the direction of percent.
+func adjustPrice(price Price, percent float64, rounding string, floor, ceiling Price) (Price, bool) {
+	cents := float64(price) * (100 + percent) / 100
+	var adjusted Price
+	if rounding == roundingEuros {
+		adjusted = Price(math.Round(cents/100)) * 100
+	} else {
+		adjusted = Price(math.Round(cents))
+	}
+
+	clamped := false
+	if adjusted < floor {
+		adjusted, clamped = floor, true
+	}
+	if ceiling > 0 && adjusted > ceiling {
+		adjusted, clamped = ceiling, true
+	}
+	if (percent < 0 && adjusted > price) || (percent > 0 && adjusted < price) {
+		adjusted = price
+	}
+
+	return adjusted, clamped
+}
+
+// DYNAMODB
+
+// setPrice sets the price of an item and confirms the change for the price
+// guard
+func (m *monitor) setPrice(ctx context.Context, adTitle string, price Price) error {
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":price": &types.AttributeValueMemberN{Value: price.String()},
+			":true":  &types.AttributeValueMemberBOOL{Value: true},
+		},
+		Key:                 map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression:    aws.String("SET AdPrice = :price, ConfirmPriceChange = :true"),
+		ConditionExpression: aws.String("attribute_exists(AdTitle)"),
+		TableName:           aws.String(m.table),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return fmt.Errorf("item %q no longer exists", adTitle)
+	}
+
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// states of an uploaded ad, see the decision package. Blocked ads cannot be
+// detected, the state is set on the item by hand, or by a run which could
+// not tell the id of an upload, and stops all processing.
+const (
+	adStateActive  = decision.StateActive
+	adStateBlocked = decision.StateBlocked
+)
+
+// blockAd flags a blocked item as needing attention and notifies once
+func (m *monitor) blockAd(ctx context.Context, bItem *BolhaItem) error {
+	if bItem.NeedsAttention {
+		return nil
+	}
+
+	if err := m.setNeedsAttention(ctx, bItem.AdTitle); err != nil {
+		return err
+	}
+	bItem.NeedsAttention = true
+
+	n := m.itemNotification(ctx, bItem,
+		notificationAdBlocked,
+		fmt.Sprintf("%s is blocked", bItem.AdTitle),
+		fmt.Sprintf("ad %q (%d) is blocked, it will not be reuploaded until AdState is cleared", bItem.AdTitle, bItem.AdUploadedId),
+	)
+	n.Severity = severityHigh
+
+	return m.notif.Notify(ctx, n)
+}
+
+// blockUnresolvedUpload blocks bItem after bolha accepted an upload the item
+// does not record, one without an id or with missing images which could not
+// be removed. Uploading again could duplicate the ad, so the item waits for
+// the operator.
+func (m *monitor) blockUnresolvedUpload(ctx context.Context, bItem *BolhaItem, uploadErr error) error {
+	m.log.WithField("AdTitle", bItem.AdTitle).WithError(uploadErr).Error("upload unresolved, blocking item")
+
+	if err := m.setAdState(ctx, bItem.AdTitle, adStateBlocked); err != nil {
+		return err
+	}
+	bItem.AdState = adStateBlocked
+
+	if bItem.NeedsAttention {
+		return nil
+	}
+	if err := m.setNeedsAttention(ctx, bItem.AdTitle); err != nil {
+		return err
+	}
+	bItem.NeedsAttention = true
+
+	n := m.itemNotification(ctx, bItem,
+		notificationNeedsAttention,
+		fmt.Sprintf("%s needs attention", bItem.AdTitle),
+		fmt.Sprintf("upload of %q may be live but is not recorded (%v), the item is blocked until the ad is recorded or removed and AdState cleared", bItem.AdTitle, uploadErr),
+	)
+	n.Severity = severityHigh
+
+	return m.notif.Notify(ctx, n)
+}
+
+// DYNAMODB
+
+func (m *monitor) setAdState(ctx context.Context, adTitle, state string) error {
+	m.log.WithFields(log.Fields{"AdTitle": adTitle, "AdState": state}).Info("setting ad state...")
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":state": &types.AttributeValueMemberS{Value: state},
+		},
+		Key:              map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression: aws.String("SET AdState = :state"),
+		TableName:        aws.String(m.table),
+	})
+
+	return err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// An ad gone past MODERATION_GRACE is expired: its id is cleared and the
+// item uploaded as new, without removing anything
+func TestScenarioExpiredAd(t *testing.T) {
+	const title = "Gorsko kolo"
+
+	tests := []struct {
+		name      string
+		uploadErr error
+		// AdUploadedId and AdState of the item after the run
+		id    float64
+		state string
+	}{
+		{"uploaded fresh", nil, 1001, adStateActive},
+		{"upload fails", errors.New("internal server error"), 0, decision.StateExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the ad of the fixture was uploaded two hours before the run
+			t.Setenv("MODERATION_GRACE", "1h")
+			step := harness.Step{Missing: []int64{1000}}
+			if tt.uploadErr != nil {
+				step.Errors = map[string]error{"UploadAd": tt.uploadErr}
+			}
+			s := newScenario(t, "sinking", harness.Step{}, step)
+			s.next(0)
+
+			report, _ := s.run()
+			if report == nil {
+				t.Fatal("no report")
+			}
+			ir := itemReport(t, report, title)
+			if ir.AdState != decision.StateExpired || ir.Reason != decision.ReasonExpired {
+				t.Errorf("AdState %q and reason %q reported, want %q", ir.AdState, ir.Reason, decision.StateExpired)
+			}
+			if tt.uploadErr == nil && ir.Status != statusUploaded {
+				t.Errorf("status %q (error %q), want %q", ir.Status, ir.Error, statusUploaded)
+			}
+
+			it := s.item(title)
+			if id, _ := it["AdUploadedId"].(float64); id != tt.id {
+				t.Errorf("AdUploadedId %v, want %v", it["AdUploadedId"], tt.id)
+			}
+			if it["AdState"] != tt.state {
+				t.Errorf("AdState %v, want %s", it["AdState"], tt.state)
+			}
+			if n := len(s.calls("RemoveAd")); n != 0 {
+				t.Errorf("%d removals of an expired ad", n)
+			}
+			if n := len(s.calls("UploadAd")); n != 1 {
+				t.Errorf("%d uploads, want 1", n)
+			}
+		})
+	}
+}
+
+// A blocked ad needs attention, it is notified once and never touched
+// however due it is
+func TestScenarioBlockedAd(t *testing.T) {
+	const title = "Gorsko kolo"
+	due := harness.Step{Orders: map[int64]int{1000: 40}}
+	s := newScenario(t, "sinking", due, due)
+	s.update(title, map[string]interface{}{"AdState": adStateBlocked})
+
+	for run := 1; run <= 2; run++ {
+		report, err := s.run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ir := itemReport(t, report, title); ir.SkipReason != SkipBlocked {
+			t.Errorf("run %d: skip reason %q (status %q), want %q", run, ir.SkipReason, ir.Status, SkipBlocked)
+		}
+		s.next(0)
+	}
+
+	if it := s.item(title); it["NeedsAttention"] != true || it["AdState"] != adStateBlocked {
+		t.Errorf("NeedsAttention %v and AdState %v, want set and blocked", it["NeedsAttention"], it["AdState"])
+	}
+	if kinds := s.notes.kinds(); len(kinds) != 1 || kinds[0] != notificationAdBlocked {
+		t.Errorf("notifications %v, want one %s", kinds, notificationAdBlocked)
+	}
+	if calls := len(s.calls("RemoveAd")) + len(s.calls("UploadAd")); calls != 0 {
+		t.Errorf("%d removals and uploads of a blocked ad", calls)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	exportPrefix       = "exports/"
+	exportedAtMetadata = "Exported-At"
+
+	batchWriteSize     = 25
+	batchWriteAttempts = 5
+)
+
+// ExportResult describes a table export written to s3
+type ExportResult struct {
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Items      int       `json:"items"`
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// RestoreResult describes the changes a restore made (or would make in dry run)
+type RestoreResult struct {
+	Key        string    `json:"key"`
+	ExportedAt time.Time `json:"exportedAt"`
+	DryRun     bool      `json:"dryRun"`
+
+	Created      []string        `json:"created"`
+	Updated      []RestoreChange `json:"updated"`
+	SkippedNewer []string        `json:"skippedNewer"`
+	Unchanged    int             `json:"unchanged"`
+	Written      int             `json:"written"`
+}
+
+// RestoreChange lists the attributes of an item that differ from the snapshot
+type RestoreChange struct {
+	AdTitle string   `json:"adTitle"`
+	Fields  []string `json:"fields"`
+}
+
+// exportTable writes all items as newline delimited json to the backup bucket
+func (m *monitor) exportTable(ctx context.Context) (*ExportResult, error) {
+	if m.cfg.BackupBucket == "" {
+		return nil, errors.New("BACKUP_BUCKET is not configured")
+	}
+
+	m.log.Info("exporting table...")
+
+	exportedAt := m.now().UTC()
+
+	items, err := m.scanItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	for _, item := range items {
+		var row map[string]interface{}
+		if err := attributevalue.UnmarshalMap(item, &row); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(row); err != nil {
+			return nil, err
+		}
+	}
+
+	// exports of the default table keep their original location
+	key := exportPrefix + exportedAt.Format("2006-01-02T15-04-05Z") + ".jsonl"
+	if m.table != defaultTableName {
+		key = exportPrefix + m.table + "/" + exportedAt.Format("2006-01-02T15-04-05Z") + ".jsonl"
+	}
+
+	_, err = m.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(m.cfg.BackupBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buff.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+		Metadata:    map[string]string{exportedAtMetadata: storedTime(exportedAt)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.log.WithFields(log.Fields{"key": key, "items": len(items)}).Info("table exported")
+
+	return &ExportResult{
+		Bucket:     m.cfg.BackupBucket,
+		Key:        key,
+		Items:      len(items),
+		ExportedAt: exportedAt,
+	}, nil
+}
+
+// restoreTable writes the items of an export back to the table. Rows which
+// were modified (reuploaded) after the export are left alone unless force is set.
+func (m *monitor) restoreTable(ctx context.Context, key string, dryRun, force bool) (*RestoreResult, error) {
+	if m.cfg.BackupBucket == "" {
+		return nil, errors.New("BACKUP_BUCKET is not configured")
+	}
+	if key == "" {
+		return nil, errors.New("restore requires a key")
+	}
+
+	m.log.WithFields(log.Fields{"key": key, "dryRun": dryRun, "force": force}).Info("restoring table...")
+
+	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.cfg.BackupBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	exportedAtStr := obj.Metadata[exportedAtMetadata]
+	exportedAt, err := time.Parse(time.RFC3339, exportedAtStr)
+	if err != nil && !force {
+		return nil, fmt.Errorf("snapshot %s has no valid export time (%q), use force to restore anyway", key, exportedAtStr)
+	}
+
+	snapshot, err := decodeExport(obj.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// current table contents by key
+	currentItems, err := m.scanItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]map[string]interface{}, len(currentItems))
+	for _, item := range currentItems {
+		var row map[string]interface{}
+		if err := attributevalue.UnmarshalMap(item, &row); err != nil {
+			return nil, err
+		}
+		if title, ok := row["AdTitle"].(string); ok {
+			current[title] = row
+		}
+	}
+
+	result := &RestoreResult{
+		Key:          key,
+		ExportedAt:   exportedAt,
+		DryRun:       dryRun,
+		Created:      make([]string, 0),
+		Updated:      make([]RestoreChange, 0),
+		SkippedNewer: make([]string, 0),
+	}
+
+	writes := make([]map[string]types.AttributeValue, 0, len(snapshot))
+	for _, snap := range snapshot {
+		title, _ := snap["AdTitle"].(string)
+		if title == "" {
+			return nil, errors.New("snapshot contains an item without AdTitle")
+		}
+
+		cur, exists := current[title]
+		if exists {
+			fields := changedFields(cur, snap)
+			if len(fields) == 0 {
+				result.Unchanged++
+				continue
+			}
+
+			if !force && modifiedAfter(cur, exportedAt) {
+				result.SkippedNewer = append(result.SkippedNewer, title)
+				continue
+			}
+
+			result.Updated = append(result.Updated, RestoreChange{AdTitle: title, Fields: fields})
+		} else {
+			result.Created = append(result.Created, title)
+		}
+
+		item, err := attributevalue.MarshalMap(snap)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, item)
+	}
+
+	if !dryRun {
+		if err := m.batchPutItems(ctx, writes); err != nil {
+			return nil, err
+		}
+		result.Written = len(writes)
+	}
+
+	m.log.WithField("result", result).Info("table restored")
+
+	return result, nil
+}
+
+// HELPERS
+
+func decodeExport(r io.Reader) ([]map[string]interface{}, error) {
+	items := make([]map[string]interface{}, 0)
+
+	dec := json.NewDecoder(r)
+	for {
+		var row map[string]interface{}
+		if err := dec.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		items = append(items, row)
+	}
+
+	return items, nil
+}
+
+// changedFields returns the sorted names of attributes that differ between a and b
+func changedFields(a, b map[string]interface{}) []string {
+	fields := make([]string, 0)
+
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || !jsonEqual(av, bv) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, err1 := json.Marshal(a)
+	bb, err2 := json.Marshal(b)
+
+	return err1 == nil && err2 == nil && bytes.Equal(ab, bb)
+}
+
+// modifiedAfter reports whether the item was reuploaded after t
+func modifiedAfter(item map[string]interface{}, t time.Time) bool {
+	uploadedAt, _ := item["AdUploadedAt"].(string)
+
+	ut, err := time.Parse(time.RFC3339, uploadedAt)
+	if err != nil {
+		return false
+	}
+
+	return ut.After(t)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// imageBuckets holds a client per images bucket. The primary bucket uses the
+// default client, the region of every replica is looked up on first use.
+type imageBuckets struct {
+	awsCfg  aws.Config
+	primary s3API
+	log     *log.Entry
+
+	mu      sync.Mutex
+	clients map[string]s3API
+}
+
+func newImageBuckets(awsCfg aws.Config, primary s3API, logger *log.Entry) *imageBuckets {
+	return &imageBuckets{
+		awsCfg:  awsCfg,
+		primary: primary,
+		log:     logger,
+		clients: make(map[string]s3API),
+	}
+}
+
+// client returns the client of bucket, i is its position in the bucket list
+func (ib *imageBuckets) client(ctx context.Context, i int, bucket string) (s3API, error) {
+	if i == 0 {
+		return ib.primary, nil
+	}
+
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+
+	if c, ok := ib.clients[bucket]; ok {
+		return c, nil
+	}
+
+	region, err := manager.GetBucketRegion(ctx, s3.NewFromConfig(ib.awsCfg), bucket)
+	if err != nil {
+		return nil, err
+	}
+	ib.log.WithFields(log.Fields{"bucket": bucket, "region": region}).Info("images bucket region")
+
+	c := s3.NewFromConfig(ib.awsCfg, func(o *s3.Options) {
+		o.Region = region
+	})
+	ib.clients[bucket] = c
+
+	return c, nil
+}
+
+// s3 operations named by S3Error
+const (
+	s3OpGet    = "get"
+	s3OpList   = "list"
+	s3OpRead   = "read"
+	s3OpVerify = "verify"
+)
+
+// S3Error is a failed operation on an object or prefix of the images
+// buckets, it wraps the error of the sdk
+type S3Error struct {
+	Op     string
+	Bucket string
+	Key    string
+	Err    error
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3 %s s3://%s/%s: %s", e.Op, e.Bucket, e.Key, shortS3Error(e.Err))
+}
+
+func (e *S3Error) Unwrap() error {
+	return e.Err
+}
+
+// shortS3Error returns the code and message of an api error instead of the
+// whole operation, status and request ids, other errors as they are
+func shortS3Error(err error) string {
+	var aerr smithy.APIError
+	if !errors.As(err, &aerr) {
+		return err.Error()
+	}
+	if msg := aerr.ErrorMessage(); msg != "" {
+		return aerr.ErrorCode() + ": " + msg
+	}
+	return aerr.ErrorCode()
+}
+
+// failover reports whether err may be caused by the bucket's region being
+// unavailable, missing objects and denied access fail fast
+func failover(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		code := re.HTTPStatusCode()
+		return code >= http.StatusInternalServerError || code == http.StatusMovedPermanently || code == http.StatusTemporaryRedirect
+	}
+
+	// no response at all
+	return true
+}
+
+// S3
+
+// getImagesObject gets key from the first images bucket able to serve it
+func (m *monitor) getImagesObject(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+	obj, _, err := m.getImagesObjectFrom(ctx, key)
+	return obj, err
+}
+
+// getImagesObjectFrom is getImagesObject also returning the bucket which
+// served the object, errors are S3Errors of the last bucket tried
+func (m *monitor) getImagesObjectFrom(ctx context.Context, key string) (*s3.GetObjectOutput, string, error) {
+	if !m.images.allow() {
+		return nil, "", ErrImagesUnavailable
+	}
+
+	obj, bucket, err := m.getImagesObjectFailover(ctx, key)
+	m.images.record(err)
+
+	return obj, bucket, err
+}
+
+func (m *monitor) getImagesObjectFailover(ctx context.Context, key string) (*s3.GetObjectOutput, string, error) {
+	var lastErr error
+	for i, bucket := range m.cfg.ImagesBuckets {
+		c, err := m.buckets.client(ctx, i, bucket)
+		if err == nil {
+			var obj *s3.GetObjectOutput
+			in := &s3.GetObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			}
+			// returns the checksum s3 stored with the object, if any
+			if m.cfg.VerifyImageChecksums {
+				in.ChecksumMode = s3types.ChecksumModeEnabled
+			}
+			obj, err = c.GetObject(ctx, in)
+			if err == nil {
+				m.log.WithFields(log.Fields{"key": key, "bucket": bucket}).Debug("object served")
+				return obj, bucket, nil
+			}
+		}
+		if !failover(err) {
+			return nil, "", &S3Error{Op: s3OpGet, Bucket: bucket, Key: key, Err: err}
+		}
+
+		m.log.WithFields(log.Fields{"key": key, "bucket": bucket}).WithError(err).Warn("images bucket unavailable")
+		lastErr = &S3Error{Op: s3OpGet, Bucket: bucket, Key: key, Err: err}
+	}
+
+	return nil, "", lastErr
+}
+
+// listImageKeys returns the keys of all images under prefix ordered by key,
+// listing the first images bucket able to serve them
+func (m *monitor) listImageKeys(ctx context.Context, prefix string) ([]string, error) {
+	if !m.images.allow() {
+		return nil, ErrImagesUnavailable
+	}
+
+	keys, err := m.listImageKeysFailover(ctx, prefix)
+	m.images.record(err)
+
+	return keys, err
+}
+
+func (m *monitor) listImageKeysFailover(ctx context.Context, prefix string) ([]string, error) {
+	m.log.WithField("prefix", prefix).Info("listing s3 images...")
+
+	var lastErr error
+	for i, bucket := range m.cfg.ImagesBuckets {
+		keys, err := m.listBucketKeys(ctx, i, bucket, prefix)
+		if err == nil {
+			m.log.WithFields(log.Fields{"prefix": prefix, "bucket": bucket}).Debug("images listed")
+			return keys, nil
+		}
+		if !failover(err) {
+			return nil, &S3Error{Op: s3OpList, Bucket: bucket, Key: prefix, Err: err}
+		}
+
+		m.log.WithFields(log.Fields{"prefix": prefix, "bucket": bucket}).WithError(err).Warn("images bucket unavailable")
+		lastErr = &S3Error{Op: s3OpList, Bucket: bucket, Key: prefix, Err: err}
+	}
+
+	return nil, lastErr
+}
+
+func (m *monitor) listBucketKeys(ctx context.Context, i int, bucket, prefix string) ([]string, error) {
+	c, err := m.buckets.client(ctx, i, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+
+	p := s3.NewListObjectsV2Paginator(c, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			// skip "directory" placeholders
+			if strings.HasSuffix(aws.ToString(obj.Key), "/") {
+				continue
+			}
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const maxCategorySuggestions = 3
+
+// key of the cached categories in RUN_STATE_TABLE
+const categoriesCacheKey = "Meta#Categories"
+
+// categoriesCache is the last categories list read from s3
+type categoriesCache struct {
+	Table string
+	// json list as in CATEGORIES_KEY
+	Categories string
+	FetchedAt  string
+}
+
+// RefreshCategoriesResult is returned by the refresh-categories action
+type RefreshCategoriesResult struct {
+	Categories int       `json:"categories"`
+	FetchedAt  time.Time `json:"fetchedAt"`
+}
+
+// Category is a bolha ad category
+type Category struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// categorySet holds the valid bolha categories by id
+type categorySet map[int]Category
+
+// validate returns an error suggesting similar ids if id is not a known category
+func (cs categorySet) validate(id int) error {
+	if _, ok := cs[id]; ok {
+		return nil
+	}
+
+	suggestions := cs.similar(id)
+	if len(suggestions) == 0 {
+		return fmt.Errorf("unknown category %d", id)
+	}
+
+	names := make([]string, len(suggestions))
+	for i, c := range suggestions {
+		names[i] = fmt.Sprintf("%d (%s)", c.Id, c.Name)
+	}
+
+	return fmt.Errorf("unknown category %d, did you mean %s?", id, strings.Join(names, " or "))
+}
+
+// similar returns the categories whose ids are closest to id (typo-wise)
+func (cs categorySet) similar(id int) []Category {
+	s := strconv.Itoa(id)
+
+	type candidate struct {
+		c    Category
+		dist int
+	}
+	candidates := make([]candidate, 0)
+	for _, c := range cs {
+		if d := levenshtein(s, strconv.Itoa(c.Id)); d <= 2 {
+			candidates = append(candidates, candidate{c, d})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist != candidates[j].dist {
+			return candidates[i].dist < candidates[j].dist
+		}
+		return candidates[i].c.Id < candidates[j].c.Id
+	})
+
+	if len(candidates) > maxCategorySuggestions {
+		candidates = candidates[:maxCategorySuggestions]
+	}
+
+	similar := make([]Category, len(candidates))
+	for i := range candidates {
+		similar[i] = candidates[i].c
+	}
+
+	return similar
+}
+
+// loadCategories returns the list of valid categories, the bolha client has
+// no category listing so the list is maintained as a json document in s3
+// ([{"id": 1234, "name": "..."}]). With RUN_STATE_TABLE the list is cached
+// and only read from s3 again once older than CATEGORIES_TTL or with force,
+// a cached list is used with a warning if s3 fails, unless STRICT_MODE.
+// Returns nil if no list is configured, cached is true if the list was not
+// read from s3.
+func (m *monitor) loadCategories(ctx context.Context, force bool) (cs categorySet, cached bool, err error) {
+	if m.cfg.CategoriesKey == "" {
+		return nil, false, nil
+	}
+
+	cache, fetchedAt, err := m.getCachedCategories(ctx)
+	if err != nil {
+		m.log.WithError(err).Warn("could not read cached categories")
+	}
+	if cache != nil && !force && m.now().Sub(fetchedAt) < m.cfg.CategoriesTTL {
+		m.log.WithFields(log.Fields{"categories": len(cache), "fetchedAt": fetchedAt}).Info("using cached categories")
+		return cache, true, nil
+	}
+
+	cs, raw, err := m.fetchCategories(ctx)
+	if err != nil {
+		if cache == nil || m.cfg.StrictMode {
+			return nil, false, err
+		}
+		m.log.WithField("fetchedAt", fetchedAt).WithError(err).Warn("could not refresh categories, using cached categories")
+		return cache, true, nil
+	}
+
+	if err := m.putCachedCategories(ctx, raw); err != nil {
+		m.log.WithError(err).Warn("could not cache categories")
+	}
+
+	return cs, false, nil
+}
+
+// fetchCategories reads the categories from s3, returning them and the list as read
+func (m *monitor) fetchCategories(ctx context.Context) (categorySet, []byte, error) {
+	m.log.WithField("key", m.cfg.CategoriesKey).Info("loading categories...")
+
+	obj, err := m.getImagesObject(ctx, m.cfg.CategoriesKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer obj.Body.Close()
+
+	raw, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cs, err := parseCategories(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid categories list %s: %v", m.cfg.CategoriesKey, err)
+	}
+
+	m.log.WithField("categories", len(cs)).Info("categories loaded")
+
+	return cs, raw, nil
+}
+
+func parseCategories(raw []byte) (categorySet, error) {
+	var categories []Category
+	if err := json.Unmarshal(raw, &categories); err != nil {
+		return nil, err
+	}
+
+	cs := make(categorySet, len(categories))
+	for _, c := range categories {
+		cs[c.Id] = c
+	}
+
+	return cs, nil
+}
+
+// refreshCategories reads the categories from s3 regardless of the cache
+func (m *monitor) refreshCategories(ctx context.Context) (*RefreshCategoriesResult, error) {
+	if m.cfg.CategoriesKey == "" {
+		return nil, fmt.Errorf("CATEGORIES_KEY is not set")
+	}
+
+	cs, raw, err := m.fetchCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.putCachedCategories(ctx, raw); err != nil {
+		return nil, err
+	}
+
+	return &RefreshCategoriesResult{Categories: len(cs), FetchedAt: m.now()}, nil
+}
+
+// DYNAMODB
+
+// getCachedCategories returns the cached categories and when they were read
+// from s3, nil if none are cached or no RUN_STATE_TABLE is configured
+func (m *monitor) getCachedCategories(ctx context.Context) (categorySet, time.Time, error) {
+	if m.cfg.RunStateTableName == "" {
+		return nil, time.Time{}, nil
+	}
+
+	result, err := m.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(m.cfg.RunStateTableName),
+		Key:       map[string]types.AttributeValue{"Table": &types.AttributeValueMemberS{Value: categoriesCacheKey}},
+	})
+	if err != nil || result.Item == nil {
+		return nil, time.Time{}, err
+	}
+
+	var cache categoriesCache
+	if err := attributevalue.UnmarshalMap(result.Item, &cache); err != nil {
+		return nil, time.Time{}, err
+	}
+	fetchedAt, err := time.Parse(time.RFC3339, cache.FetchedAt)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid FetchedAt of cached categories: %v", err)
+	}
+	cs, err := parseCategories([]byte(cache.Categories))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid cached categories: %v", err)
+	}
+
+	return cs, fetchedAt, nil
+}
+
+func (m *monitor) putCachedCategories(ctx context.Context, raw []byte) error {
+	if m.cfg.RunStateTableName == "" {
+		return nil
+	}
+
+	item, err := attributevalue.MarshalMap(categoriesCache{
+		Table:      categoriesCacheKey,
+		Categories: string(raw),
+		FetchedAt:  storedTime(m.now()),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.cfg.RunStateTableName),
+		Item:      item,
+	})
+
+	return err
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	client "github.com/seniorescobar/bolha-client"
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// run modes, a check run only observes the uploaded ads and records their
+// order for the cached order of the next act run
+const (
+	runModeAct   = "act"
+	runModeCheck = "check"
+)
+
+// runMode returns the mode of an event, act if empty
+func runMode(mode string) (string, error) {
+	switch mode {
+	case "", runModeAct:
+		return runModeAct, nil
+	case runModeCheck:
+		return runModeCheck, nil
+	default:
+		return "", fmt.Errorf("unknown mode %q, expected %s or %s", mode, runModeAct, runModeCheck)
+	}
+}
+
+// checkItem looks at the live ad of bItem and records its order and state,
+// it never removes or uploads anything. The decision is reported as it
+// would be made with the observed order, content changes are not checked.
+func (m *monitor) checkItem(ctx context.Context, clients *userClients, writes *itemWrites, bItem *BolhaItem, ir *ItemReport) error {
+	m.log.WithField("AdTitle", bItem.AdTitle).Info("checking item...")
+
+	now := m.now()
+	dcfg := decision.Config{ModerationGrace: m.cfg.ModerationGrace}
+	ir.Status = statusChecked
+
+	if bItem.AdUploadedId == 0 {
+		ir.Reason = "not uploaded"
+		return nil
+	}
+
+	item, err := bItem.decisionItem(bItem.AdContentHash)
+	if err != nil {
+		return err
+	}
+
+	c, err := clients.get(ctx, bItem)
+	if err != nil {
+		return err
+	}
+
+	var observed decision.Observed
+	activeAd, err := c.GetActiveAd(ctx, bItem.AdUploadedId)
+	switch {
+	case err == client.ErrAdNotFound:
+		// the act run checks a missing ad itself
+		observed.Missing = true
+	case err != nil:
+		return err
+	default:
+		if bItem.AdState != adStateActive {
+			writes.set(bItem.AdTitle, "AdState", &types.AttributeValueMemberS{Value: adStateActive})
+			bItem.AdState = adStateActive
+		}
+		observed.Order = activeAd.Order
+		ir.Order = activeAd.Order
+		ir.DecisionSource = decisionSourceLive
+
+		bItem.recordObservation(writes, activeAd.Order, now, m.cfg.OrderHistorySize)
+
+		if gain, ok := bItem.recordGain(writes, activeAd.Order); ok {
+			ir.Gain = &gain
+		}
+	}
+
+	d := decision.Evaluate(item, &observed, now, dcfg)
+	m.logDecision(bItem, d, ir)
+	ir.Reason = d.Reason
+	if observed.Missing {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdUploadedId": bItem.AdUploadedId, "AdState": d.State}).Warn("ad not active")
+		ir.AdState = d.State
+	}
+
+	return nil
+}
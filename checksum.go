@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// where the expected digest of an image comes from
+const (
+	checksumSourceItem = "AdImageChecksums"
+	checksumSourceS3   = "s3 checksum"
+	checksumSourceETag = "etag"
+	checksumAlgoSHA256 = "sha256"
+	checksumAlgoMD5    = "md5"
+)
+
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ChecksumError is an image whose bytes do not match their expected digest
+type ChecksumError struct {
+	Algorithm string
+	Source    string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s mismatch (%s): expected %s, got %s", e.Algorithm, e.Source, e.Expected, e.Actual)
+}
+
+// expectedChecksum returns the digest obj must have: the hex sha256 of the
+// item if set, else the sha256 s3 stored for a single part upload, else the
+// etag if it is the md5 of the object (single part, not kms encrypted).
+// Empty if none is known.
+func expectedChecksum(itemSHA256 string, obj *s3.GetObjectOutput) (algo, source, expected string) {
+	if itemSHA256 != "" {
+		return checksumAlgoSHA256, checksumSourceItem, strings.ToLower(itemSHA256)
+	}
+
+	// multipart checksums are checksums of the parts, suffixed with -<parts>
+	if c := aws.ToString(obj.ChecksumSHA256); c != "" && !strings.Contains(c, "-") {
+		if b, err := base64.StdEncoding.DecodeString(c); err == nil {
+			return checksumAlgoSHA256, checksumSourceS3, hex.EncodeToString(b)
+		}
+	}
+
+	etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+	if md5ETag.MatchString(etag) && obj.ServerSideEncryption != s3types.ServerSideEncryptionAwsKms && obj.ServerSideEncryption != s3types.ServerSideEncryptionAwsKmsDsse {
+		return checksumAlgoMD5, checksumSourceETag, etag
+	}
+
+	return "", "", ""
+}
+
+// verifiedBody reads the body of obj and checks it against its expected
+// digest, returning the bytes to upload. The body is returned unread if no
+// digest is known.
+func verifiedBody(itemSHA256 string, obj *s3.GetObjectOutput) (io.ReadCloser, error) {
+	algo, source, expected := expectedChecksum(itemSHA256, obj)
+	if expected == "" {
+		return obj.Body, nil
+	}
+
+	b, err := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var actual string
+	if algo == checksumAlgoMD5 {
+		sum := md5.Sum(b)
+		actual = hex.EncodeToString(sum[:])
+	} else {
+		sum := sha256.Sum256(b)
+		actual = hex.EncodeToString(sum[:])
+	}
+	if actual != expected {
+		return nil, &ChecksumError{Algorithm: algo, Source: source, Expected: expected, Actual: actual}
+	}
+
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
@@ -0,0 +1,236 @@
+// Command simulate replays the reupload policy over a table export to show
+// what-if results for different reupload settings, e.g.
+//
+//	simulate -days 14 -reupload-hours 48 exports/2026-10-01T00:00:00Z.jsonl
+//
+// Orders are not known ahead of time, an ad is assumed to fall by
+// -order-rate positions per hour once uploaded.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
+)
+
+// row holds the exported item attributes the simulation uses
+type row struct {
+	AdTitle           string
+	AdUploadedId      int64
+	AdUploadedAt      string
+	AdState           string
+	PublishAt         string
+	ReuploadHours     int
+	ReuploadOrder     int
+	ReuploadPage      int
+	LastObservedOrder int
+}
+
+type event struct {
+	at       time.Time
+	adTitle  string
+	decision decision.Decision
+}
+
+func main() {
+	var (
+		from            = flag.String("from", "", "RFC3339 start of the simulation (default now)")
+		days            = flag.Int("days", 7, "number of simulated days")
+		step            = flag.Duration("step", time.Hour, "time between simulated runs")
+		reuploadHours   = flag.Int("reupload-hours", 0, "ReuploadHours of every item, 0 keeps the exported value")
+		reuploadOrder   = flag.Int("reupload-order", 0, "ReuploadOrder of every item instead of its ReuploadPage, 0 keeps the exported value")
+		adsPerPage      = flag.Int("ads-per-page", 30, "ads on a page of bolha listings (ADS_PER_PAGE)")
+		orderRate       = flag.Float64("order-rate", 1, "positions an ad falls per hour")
+		moderationGrace = flag.Duration("moderation-grace", 24*time.Hour, "how long a missing ad is pending moderation")
+		verbose         = flag.Bool("v", false, "print the decision of every item on every run")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: simulate [flags] [export.jsonl]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	start := time.Now().UTC().Truncate(time.Hour)
+	if *from != "" {
+		t, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			fatalf("invalid -from: %v", err)
+		}
+		start = t
+	}
+	if *step <= 0 {
+		fatalf("-step must be positive")
+	}
+	end := start.Add(time.Duration(*days) * 24 * time.Hour)
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	rows, err := readExport(in)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	cfg := decision.Config{ModerationGrace: *moderationGrace}
+
+	events := make([]event, 0)
+	for _, r := range rows {
+		if *reuploadHours > 0 {
+			r.ReuploadHours = *reuploadHours
+		}
+		if *reuploadOrder > 0 {
+			r.ReuploadOrder, r.ReuploadPage = *reuploadOrder, 0
+		}
+
+		evs, err := simulate(r, start, end, *step, *orderRate, *adsPerPage, cfg, *verbose)
+		if err != nil {
+			fatalf("%s: %v", r.AdTitle, err)
+		}
+		events = append(events, evs...)
+	}
+
+	printEvents(os.Stdout, events)
+	printSummary(os.Stdout, rows, events, start, end)
+}
+
+// simulate evaluates r on every step between start and end, returning the
+// uploads and reuploads it results in
+func simulate(r row, start, end time.Time, step time.Duration, orderRate float64, adsPerPage int, cfg decision.Config, verbose bool) ([]event, error) {
+	item := decision.Item{
+		UploadedId:    r.AdUploadedId,
+		State:         r.AdState,
+		ReuploadHours: r.ReuploadHours,
+		ReuploadOrder: r.ReuploadOrder,
+		ReuploadPage:  r.ReuploadPage,
+		AdsPerPage:    adsPerPage,
+	}
+	if r.PublishAt != "" {
+		t, err := time.Parse(time.RFC3339, r.PublishAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PublishAt: %v", err)
+		}
+		item.PublishAt = t
+	}
+	if item.UploadedId != 0 {
+		t, err := time.Parse(time.RFC3339, r.AdUploadedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AdUploadedAt: %v", err)
+		}
+		item.UploadedAt = t
+	}
+
+	// the order falls from the last observed one, or from the top once uploaded
+	base, baseOrder := start, r.LastObservedOrder
+	if baseOrder == 0 {
+		baseOrder = 1
+	}
+
+	events := make([]event, 0)
+	for now := start; now.Before(end); now = now.Add(step) {
+		observed := &decision.Observed{Order: baseOrder + int(orderRate*now.Sub(base).Hours())}
+
+		d := decision.Evaluate(item, observed, now, cfg)
+		if verbose {
+			fmt.Printf("%s\t%s\torder %d\t%s %s\n", now.Format(time.RFC3339), r.AdTitle, observed.Order, d.Action, d.Reason)
+		}
+
+		switch d.Action {
+		case decision.Upload, decision.Reupload:
+			events = append(events, event{at: now, adTitle: r.AdTitle, decision: d})
+
+			item.UploadedId++
+			item.UploadedAt = now
+			base, baseOrder = now, 1
+		case decision.Skip:
+			// blocked or pending moderation, nothing changes without bolha
+			return events, nil
+		}
+	}
+
+	return events, nil
+}
+
+func readExport(in io.Reader) ([]row, error) {
+	rows := make([]row, 0)
+
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		var r row
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rows = append(rows, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].AdTitle < rows[j].AdTitle })
+
+	return rows, nil
+}
+
+func printEvents(w io.Writer, events []event) {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "time\tad\taction\treason")
+	for _, e := range events {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.at.Format(time.RFC3339), e.adTitle, e.decision.Action, e.decision.Reason)
+	}
+	tw.Flush()
+}
+
+func printSummary(w io.Writer, rows []row, events []event, start, end time.Time) {
+	perItem := make(map[string]int)
+	perDay := make(map[string]int)
+	for _, e := range events {
+		if e.decision.Action == decision.Reupload {
+			perItem[e.adTitle]++
+			perDay[e.at.Format("2006-01-02")]++
+		}
+	}
+
+	days := end.Sub(start).Hours() / 24
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ad\treuploads\tper day")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\n", r.AdTitle, perItem[r.AdTitle], float64(perItem[r.AdTitle])/days)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "day\treuploads")
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		fmt.Fprintf(tw, "%s\t%d\n", day.Format("2006-01-02"), perDay[day.Format("2006-01-02")])
+	}
+	tw.Flush()
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "simulate: "+format+"\n", args...)
+	os.Exit(1)
+}
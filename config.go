@@ -0,0 +1,649 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	reuploadModeFast = "fast"
+	reuploadModeSafe = "safe"
+)
+
+const (
+	emptyImagesReject = "reject"
+	emptyImagesAllow  = "allow"
+)
+
+// ItemConfig is the part of the configuration items can override
+type ItemConfig struct {
+	// "fast" removes and uploads in parallel, "safe" uploads once the old ad is removed
+	ReuploadMode string
+
+	// number of consecutive failed runs after which an item needs attention
+	FailAlertThreshold int
+
+	// what is done with an item without images, "reject" (default) fails
+	// its validation, "allow" uploads it without images and flags it
+	EmptyImagesPolicy string
+}
+
+// itemConfigKeys maps the env var names items can override to their setters
+var itemConfigKeys = map[string]func(ic *ItemConfig, v string) error{
+	"REUPLOAD_MODE": func(ic *ItemConfig, v string) error {
+		if v != reuploadModeFast && v != reuploadModeSafe {
+			return fmt.Errorf("REUPLOAD_MODE must be %q or %q, got %q", reuploadModeFast, reuploadModeSafe, v)
+		}
+		ic.ReuploadMode = v
+		return nil
+	},
+	"FAIL_ALERT_THRESHOLD": func(ic *ItemConfig, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid FAIL_ALERT_THRESHOLD: %v", err)
+		}
+		ic.FailAlertThreshold = i
+		return nil
+	},
+	"EMPTY_IMAGES_POLICY": func(ic *ItemConfig, v string) error {
+		if v != emptyImagesReject && v != emptyImagesAllow {
+			return fmt.Errorf("EMPTY_IMAGES_POLICY must be %q or %q, got %q", emptyImagesReject, emptyImagesAllow, v)
+		}
+		ic.EmptyImagesPolicy = v
+		return nil
+	},
+}
+
+// Config holds the runtime configuration read from the environment
+type Config struct {
+	ItemConfig
+
+	// sns topic notifications are published to, notifications are only logged if empty
+	NotifyTopicArn string
+	// channels notifications are routed to instead, see notifyroute.go
+	NotifyRoutes *NotifyRoutes
+	// only log notifications
+	NotifyMute bool
+
+	// notification kinds sent right away instead of in the digest at the end of a run
+	NotifyImmediate []string
+	// items listed per kind in a digest
+	NotifyDigestExamples int
+
+	// s3 destination of the html status page, disabled if either is empty
+	StatusPageBucket string
+	StatusPageKey    string
+
+	// bucket table exports are written to and restored from
+	BackupBucket string
+
+	// optional table holding user account data
+	UsersTableName string
+
+	// kms key credentials are encrypted with, decryption also checks it if set
+	CredentialsKeyId string
+
+	// key of the list of valid categories in the images bucket, category
+	// validation is skipped if empty
+	CategoriesKey string
+
+	// how long the categories cached in RUN_STATE_TABLE are used before
+	// they are read from CATEGORIES_KEY again
+	CategoriesTTL time.Duration
+
+	// key of a json object in the images bucket overriding the default validation rules
+	ValidationRulesKey string
+
+	// default maximum number of active ads per user, 0 is unlimited
+	MaxActiveAds int
+
+	// maximum number of items processed per run, 0 is unlimited
+	MaxItemsPerRun int
+
+	// number of ads on a page of bolha listings, see ReuploadPage
+	AdsPerPage int
+
+	// users whose items run at the same time, 0 is unlimited, and the
+	// minimum time between two items of a user which touched bolha
+	MaxConcurrentUsers int
+	UserPacing         time.Duration
+
+	// how long the last observed order of an ad is used instead of a live
+	// check, 0 always checks live
+	OrderFreshness time.Duration
+
+	// how long an uploaded ad missing from the active ads is considered
+	// pending moderation before it is considered expired
+	ModerationGrace time.Duration
+
+	// items tables, each run processes all of them
+	TableNames []string
+
+	// images bucket followed by its replicas, tried in order when a bucket
+	// is unavailable
+	ImagesBuckets []string
+
+	// check images against their digest before upload, see checksum.go
+	VerifyImageChecksums bool
+
+	// strip html tags from descriptions before upload
+	StripDescriptionHTML bool
+
+	// minimum age of unreferenced images removed by gc-images
+	GCImagesMinAge time.Duration
+
+	// public url of an ad, %d is replaced by the uploaded id
+	AdURLPattern string
+
+	// zone times are shown to humans in and PublishAt times without a
+	// zone are read in, times are always stored in UTC
+	DisplayLocation *time.Location
+
+	// expiry of pre-signed image links in notifications, 0 disables them
+	ImageURLExpiry time.Duration
+
+	// largest price change since the last upload in percent which does not
+	// need ConfirmPriceChange on the item, 0 disables the check
+	PriceChangeMaxPercent int
+
+	// age of an unfinished upload phase after which the item needs attention
+	PhaseStaleAfter time.Duration
+
+	// largest random delay before a run starts
+	StartJitter time.Duration
+
+	// attempts to upload an ad once its old ad was removed
+	UploadAttempts int
+
+	// lowercase parts of upload errors telling a duplicate rejection, and
+	// how long a rejected item is left alone, see duplicate.go
+	DuplicateRejectionPatterns []string
+	DuplicateCooldown          time.Duration
+
+	// upload times further in the future or before the epoch are treated
+	// as unknown, see uploadedat.go
+	UploadedAtTolerance time.Duration
+	UploadedAtEpoch     time.Time
+
+	// consecutive images outages after which uploads are deferred for the
+	// rest of the invocation, never if 0, see imagebreaker.go
+	ImagesBreakerThreshold int
+
+	// rate deferred writes are spread over a run at and the number of
+	// items queued at most, all are written at its end if 0, see writes.go
+	DeferredWritesPerSecond float64
+	DeferredWritesQueue     int
+
+	// bolha requests per second across all users, 0 is unlimited, and the
+	// number of requests allowed at once
+	BolhaRequestsPerSecond float64
+	BolhaRequestBurst      int
+
+	// retry mode of aws calls, "standard" or "adaptive" which also rate
+	// limits calls once throttled
+	RetryMode        string
+	RetryMaxAttempts int
+
+	// how the items of a run bound by MaxItemsPerRun are picked, see
+	// selection.go
+	SelectionStrategy string
+
+	// what is done with a live ad edited on bolha, only "manual" which
+	// blocks the item, see sync.go
+	SyncDirection string
+
+	// key item webhooks are signed with, and the timeout of a single call
+	WebhookSecret  string
+	WebhookTimeout time.Duration
+
+	// token HTTP requests must carry, see httpapi.go, HTTP requests are
+	// refused if empty
+	HTTPToken string
+
+	// timeout of a single reupload hook invocation
+	HookTimeout time.Duration
+
+	// continue a run in a new invocation of FunctionName once less than
+	// ContinuationMargin of the invocation is left, at most
+	// ContinuationMaxDepth times
+	SelfContinuation     bool
+	ContinuationMargin   time.Duration
+	ContinuationMaxDepth int
+	FunctionName         string
+
+	// items per scanned page, 0 scans the whole table before running it.
+	// The progress of paged scans is kept in RunStateTableName, an
+	// incomplete scan is resumed by the next run with AutoResumeScan.
+	ScanPageSize      int
+	RunStateTableName string
+	AutoResumeScan    bool
+
+	// create missing items tables instead of failing their run
+	CreateTableIfMissing bool
+
+	// ttl attribute of the items tables, items past it are skipped
+	TTLAttribute string
+
+	// bucket run and reconcile reports are saved to, reports are only logged if empty
+	ReportBucket string
+
+	// item sizes above which a warning is logged and the description is
+	// moved to OffloadBucket, see offload.go
+	ItemSizeWarnBytes    int
+	ItemSizeOffloadBytes int
+	OffloadBucket        string
+
+	// observed orders kept per item for the status page, none if 0
+	OrderHistorySize int
+
+	// age of a stored session from which it is flagged and the user logs
+	// in with credentials instead, never if 0, see session.go
+	SessionWarnAge time.Duration
+
+	// how long the token confirming a destructive action is valid, see
+	// confirm.go
+	ConfirmTokenValidity time.Duration
+
+	// age from which a queued ad removal that keeps failing is notified,
+	// see removals.go
+	RemovalEscalateAfter time.Duration
+
+	// bucket the ad of every upload is saved to, none if empty, and the
+	// number kept per item, all if 0, see payload.go
+	PayloadBucket   string
+	PayloadsPerItem int
+
+	// title similarity from 0 to 1 and price difference in percent from
+	// which find-duplicates suspects two items, see similar.go
+	DuplicateMinSimilarity  float64
+	DuplicatePriceTolerance float64
+
+	// data issues which are otherwise only warned about make items
+	// invalid, see strict.go
+	StrictMode bool
+}
+
+func loadConfig() (*Config, error) {
+	var cfg Config
+
+	cfg.ItemConfig = ItemConfig{ReuploadMode: reuploadModeFast, FailAlertThreshold: 5, EmptyImagesPolicy: emptyImagesReject}
+	for name, set := range itemConfigKeys {
+		if v := os.Getenv(name); v != "" {
+			if err := set(&cfg.ItemConfig, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cfg.NotifyTopicArn = os.Getenv("NOTIFY_TOPIC_ARN")
+	cfg.StatusPageBucket = os.Getenv("STATUS_PAGE_BUCKET")
+	cfg.StatusPageKey = os.Getenv("STATUS_PAGE_KEY")
+	cfg.BackupBucket = os.Getenv("BACKUP_BUCKET")
+	cfg.UsersTableName = os.Getenv("USERS_TABLE")
+	cfg.CredentialsKeyId = os.Getenv("CREDENTIALS_KMS_KEY_ID")
+	cfg.CategoriesKey = os.Getenv("CATEGORIES_KEY")
+	cfg.ValidationRulesKey = os.Getenv("VALIDATION_RULES_KEY")
+	cfg.ReportBucket = os.Getenv("REPORT_BUCKET")
+	cfg.OffloadBucket = os.Getenv("OFFLOAD_BUCKET")
+	cfg.PayloadBucket = os.Getenv("PAYLOAD_BUCKET")
+	cfg.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	cfg.HTTPToken = os.Getenv("HTTP_TOKEN")
+	cfg.TTLAttribute = os.Getenv("TTL_ATTRIBUTE")
+	cfg.FunctionName = os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	cfg.RunStateTableName = os.Getenv("RUN_STATE_TABLE")
+
+	cfg.TableNames = []string{defaultTableName}
+	if v := os.Getenv("BOLHA_TABLE_NAMES"); v != "" {
+		cfg.TableNames = cfg.TableNames[:0]
+		seen := make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" && !seen[t] {
+				seen[t] = true
+				cfg.TableNames = append(cfg.TableNames, t)
+			}
+		}
+		if len(cfg.TableNames) == 0 {
+			return nil, fmt.Errorf("BOLHA_TABLE_NAMES lists no table")
+		}
+	}
+
+	cfg.ImagesBuckets = []string{s3ImagesBucket}
+	cfg.NotifyImmediate = []string{notificationUploadPending, notificationUploadFailed}
+	if v := os.Getenv("NOTIFY_IMMEDIATE"); v != "" {
+		cfg.NotifyImmediate = cfg.NotifyImmediate[:0]
+		for _, kind := range strings.Split(v, ",") {
+			if kind = strings.TrimSpace(kind); kind != "" {
+				cfg.NotifyImmediate = append(cfg.NotifyImmediate, kind)
+			}
+		}
+	}
+
+	if v := os.Getenv("NOTIFY_ROUTES"); v != "" {
+		routes, err := parseNotifyRoutes(v)
+		if err != nil {
+			return nil, err
+		}
+		cfg.NotifyRoutes = routes
+	}
+
+	cfg.DuplicateRejectionPatterns = []string{"duplicate", "podvojen"}
+	if v := os.Getenv("DUPLICATE_REJECTION_PATTERNS"); v != "" {
+		cfg.DuplicateRejectionPatterns = cfg.DuplicateRejectionPatterns[:0]
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+				cfg.DuplicateRejectionPatterns = append(cfg.DuplicateRejectionPatterns, p)
+			}
+		}
+	}
+
+	if v := os.Getenv("BOLHA_IMAGES_BUCKETS"); v != "" {
+		cfg.ImagesBuckets = cfg.ImagesBuckets[:0]
+		for _, b := range strings.Split(v, ",") {
+			if b = strings.TrimSpace(b); b != "" {
+				cfg.ImagesBuckets = append(cfg.ImagesBuckets, b)
+			}
+		}
+		if len(cfg.ImagesBuckets) == 0 {
+			return nil, fmt.Errorf("BOLHA_IMAGES_BUCKETS lists no bucket")
+		}
+	}
+
+	cfg.AdURLPattern = defaultAdURLPattern
+	if v := os.Getenv("AD_URL_PATTERN"); v != "" {
+		if strings.Count(v, "%d") != 1 {
+			return nil, fmt.Errorf("AD_URL_PATTERN must contain %%d exactly once, got %q", v)
+		}
+		cfg.AdURLPattern = v
+	}
+
+	cfg.DisplayLocation = time.UTC
+	if v := os.Getenv("DISPLAY_TIMEZONE"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DISPLAY_TIMEZONE %q: %v", v, err)
+		}
+		cfg.DisplayLocation = loc
+	}
+
+	cfg.UploadedAtEpoch = defaultUploadedAtEpoch
+	if v := os.Getenv("UPLOADED_AT_EPOCH"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UPLOADED_AT_EPOCH %q: %v", v, err)
+		}
+		cfg.UploadedAtEpoch = t
+	}
+
+	cfg.SelectionStrategy = selectionStrictPriority
+	if v := os.Getenv("SELECTION_STRATEGY"); v != "" {
+		if v != selectionStrictPriority && v != selectionWeightedRandom {
+			return nil, fmt.Errorf("SELECTION_STRATEGY must be %q or %q, got %q", selectionStrictPriority, selectionWeightedRandom, v)
+		}
+		cfg.SelectionStrategy = v
+	}
+
+	cfg.SyncDirection = syncManual
+	if v := os.Getenv("SYNC_DIRECTION"); v != "" {
+		switch v {
+		case syncManual:
+		case syncPull, syncPush:
+			return nil, fmt.Errorf("SYNC_DIRECTION %q is unsupported: the bolha client only reads the id and order of live ads, so their content can neither be pulled nor compared, use %q", v, syncManual)
+		default:
+			return nil, fmt.Errorf("SYNC_DIRECTION must be %q, got %q", syncManual, v)
+		}
+		cfg.SyncDirection = v
+	}
+
+	cfg.RetryMode = retryModeAdaptive
+	if v := os.Getenv("RETRY_MODE"); v != "" {
+		if v != retryModeStandard && v != retryModeAdaptive {
+			return nil, fmt.Errorf("RETRY_MODE must be %q or %q, got %q", retryModeStandard, retryModeAdaptive, v)
+		}
+		cfg.RetryMode = v
+	}
+
+	var err error
+	if cfg.MaxActiveAds, err = envInt("MAX_ACTIVE_ADS", 0); err != nil {
+		return nil, err
+	}
+	if cfg.NotifyDigestExamples, err = envInt("NOTIFY_DIGEST_EXAMPLES", 5); err != nil {
+		return nil, err
+	}
+	if cfg.MaxItemsPerRun, err = envInt("MAX_ITEMS_PER_RUN", 0); err != nil {
+		return nil, err
+	}
+	if cfg.AdsPerPage, err = envInt("ADS_PER_PAGE", 30); err != nil {
+		return nil, err
+	}
+	if cfg.AdsPerPage < 1 {
+		return nil, fmt.Errorf("ADS_PER_PAGE must be positive, got %d", cfg.AdsPerPage)
+	}
+	if cfg.MaxConcurrentUsers, err = envInt("MAX_CONCURRENT_USERS", 0); err != nil {
+		return nil, err
+	}
+	if cfg.UserPacing, err = envDuration("USER_PACING", 0); err != nil {
+		return nil, err
+	}
+	if cfg.RetryMaxAttempts, err = envInt("RETRY_MAX_ATTEMPTS", 8); err != nil {
+		return nil, err
+	}
+	if cfg.OrderFreshness, err = envDuration("ORDER_FRESHNESS", 2*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ModerationGrace, err = envDuration("MODERATION_GRACE", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ImageURLExpiry, err = envDuration("IMAGE_URL_EXPIRY", 0); err != nil {
+		return nil, err
+	}
+	if cfg.PriceChangeMaxPercent, err = envInt("PRICE_CHANGE_MAX_PERCENT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.NotifyMute, err = envBool("NOTIFY_MUTE", false); err != nil {
+		return nil, err
+	}
+	if cfg.VerifyImageChecksums, err = envBool("VERIFY_IMAGE_CHECKSUMS", true); err != nil {
+		return nil, err
+	}
+	if cfg.StripDescriptionHTML, err = envBool("STRIP_DESCRIPTION_HTML", false); err != nil {
+		return nil, err
+	}
+	if cfg.GCImagesMinAge, err = envDuration("GC_IMAGES_MIN_AGE", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.PhaseStaleAfter, err = envDuration("PHASE_STALE_AFTER", 6*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.UploadAttempts, err = envInt("UPLOAD_ATTEMPTS", 3); err != nil {
+		return nil, err
+	}
+	if cfg.DuplicateCooldown, err = envDuration("DUPLICATE_COOLDOWN", 48*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.DeferredWritesPerSecond, err = envFloat("DEFERRED_WRITES_PER_SECOND", 5); err != nil {
+		return nil, err
+	}
+	if cfg.DeferredWritesQueue, err = envInt("DEFERRED_WRITES_QUEUE", 100); err != nil {
+		return nil, err
+	}
+	if cfg.ImagesBreakerThreshold, err = envInt("IMAGES_BREAKER_THRESHOLD", 3); err != nil {
+		return nil, err
+	}
+	if cfg.UploadedAtTolerance, err = envDuration("UPLOADED_AT_TOLERANCE", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.ItemSizeWarnBytes, err = envInt("ITEM_SIZE_WARN_BYTES", 300<<10); err != nil {
+		return nil, err
+	}
+	if cfg.ItemSizeOffloadBytes, err = envInt("ITEM_SIZE_OFFLOAD_BYTES", 350<<10); err != nil {
+		return nil, err
+	}
+	if cfg.OrderHistorySize, err = envInt("ORDER_HISTORY_SIZE", 50); err != nil {
+		return nil, err
+	}
+	if cfg.PayloadsPerItem, err = envInt("PAYLOADS_PER_ITEM", 10); err != nil {
+		return nil, err
+	}
+	if cfg.SessionWarnAge, err = envDuration("SESSION_WARN_AGE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.ConfirmTokenValidity, err = envDuration("CONFIRM_TOKEN_VALIDITY", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.RemovalEscalateAfter, err = envDuration("REMOVAL_ESCALATE_AFTER", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.BolhaRequestsPerSecond, err = envFloat("BOLHA_REQUESTS_PER_SECOND", 1); err != nil {
+		return nil, err
+	}
+	if cfg.BolhaRequestBurst, err = envInt("BOLHA_REQUEST_BURST", 2); err != nil {
+		return nil, err
+	}
+	jitter, err := envInt("START_JITTER_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	cfg.StartJitter = time.Duration(jitter) * time.Second
+	if cfg.ScanPageSize, err = envInt("SCAN_PAGE_SIZE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.AutoResumeScan, err = envBool("AUTO_RESUME_SCAN", false); err != nil {
+		return nil, err
+	}
+	if cfg.CreateTableIfMissing, err = envBool("CREATE_TABLE_IF_MISSING", false); err != nil {
+		return nil, err
+	}
+	if cfg.SelfContinuation, err = envBool("SELF_CONTINUATION", false); err != nil {
+		return nil, err
+	}
+	if cfg.ContinuationMargin, err = envDuration("CONTINUATION_MARGIN", 2*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.ContinuationMaxDepth, err = envInt("CONTINUATION_MAX_DEPTH", 5); err != nil {
+		return nil, err
+	}
+	if cfg.WebhookTimeout, err = envDuration("WEBHOOK_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.CategoriesTTL, err = envDuration("CATEGORIES_TTL", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.HookTimeout, err = envDuration("HOOK_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.DuplicateMinSimilarity, err = envFloat("DUPLICATE_MIN_SIMILARITY", 0.8); err != nil {
+		return nil, err
+	}
+	if cfg.DuplicateMinSimilarity <= 0 || cfg.DuplicateMinSimilarity > 1 {
+		return nil, fmt.Errorf("DUPLICATE_MIN_SIMILARITY must be above 0 and at most 1, got %v", cfg.DuplicateMinSimilarity)
+	}
+	if cfg.DuplicatePriceTolerance, err = envFloat("DUPLICATE_PRICE_TOLERANCE", 10); err != nil {
+		return nil, err
+	}
+	if cfg.DuplicatePriceTolerance < 0 {
+		return nil, fmt.Errorf("DUPLICATE_PRICE_TOLERANCE must not be negative, got %v", cfg.DuplicatePriceTolerance)
+	}
+	if cfg.StrictMode, err = envBool("STRICT_MODE", false); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// hasTable reports whether table is one of BOLHA_TABLE_NAMES
+func (c *Config) hasTable(table string) bool {
+	for _, t := range c.TableNames {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// forItem merges overrides over the global item configuration, unknown keys
+// are returned separately so they can be logged
+func (c *Config) forItem(overrides map[string]string) (ItemConfig, []string, error) {
+	ic := c.ItemConfig
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	unknown := make([]string, 0)
+	for _, name := range names {
+		set, ok := itemConfigKeys[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if err := set(&ic, overrides[name]); err != nil {
+			return ic, unknown, err
+		}
+	}
+
+	return ic, unknown, nil
+}
+
+// HELPERS
+
+func envInt(name string, def int) (int, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return i, nil
+}
+
+func envBool(name string, def bool) (bool, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return b, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return f, nil
+}
+
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return d, nil
+}
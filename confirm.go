@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Destructive actions (remove-all, restore and gc-images unless dry runs)
+// are run in two steps. Sent without a confirmToken they change nothing
+// and answer with a token and a summary of what they would do, sent again
+// with the exact same parameters and that token within
+// CONFIRM_TOKEN_VALIDITY they run. The token hashes the action, its
+// parameters and the time it was issued, it guards against mistakes and
+// is not a secret.
+
+// ConfirmationRequired is the answer to a destructive action sent without
+// a confirmToken
+type ConfirmationRequired struct {
+	Action       string      `json:"action"`
+	Table        string      `json:"table"`
+	Summary      string      `json:"summary"`
+	ConfirmToken string      `json:"confirmToken"`
+	ExpiresAt    time.Time   `json:"expiresAt"`
+	Version      VersionInfo `json:"version"`
+}
+
+// errConfirmToken is wrapped by errors of tokens that do not confirm an
+// action
+var errConfirmToken = errors.New("invalid confirmToken")
+
+// destructive reports whether event needs a confirmToken
+func destructive(event Event) bool {
+	switch event.Action {
+	case actionRemoveAll:
+		return true
+	case actionRestore, actionGCImages:
+		return !event.DryRun
+	}
+	return false
+}
+
+// confirmation returns what event would do and a token confirming it if
+// event has no confirmToken, nil if its confirmToken confirms it
+func (m *monitor) confirmation(ctx context.Context, event Event, now time.Time) (*ConfirmationRequired, error) {
+	params, err := m.confirmParams(event)
+	if err != nil {
+		return nil, err
+	}
+
+	if event.ConfirmToken != "" {
+		if err := checkConfirmToken(event.ConfirmToken, params, now, m.cfg.ConfirmTokenValidity); err != nil {
+			return nil, err
+		}
+		m.log.WithFields(log.Fields{"action": event.Action, "table": m.table}).Warn("destructive action confirmed")
+		return nil, nil
+	}
+
+	summary, err := m.confirmSummary(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	m.log.WithFields(log.Fields{"action": event.Action, "table": m.table, "summary": summary}).Info("destructive action needs confirmation")
+
+	return &ConfirmationRequired{
+		Action:       event.Action,
+		Table:        m.table,
+		Summary:      summary,
+		ConfirmToken: confirmToken(params, now),
+		ExpiresAt:    now.Add(m.cfg.ConfirmTokenValidity).UTC(),
+		Version:      version,
+	}, nil
+}
+
+// confirmSummary describes what event would do, dry running it where the
+// action can be
+func (m *monitor) confirmSummary(ctx context.Context, event Event) (string, error) {
+	switch event.Action {
+	case actionRestore:
+		r, err := m.restoreTable(ctx, event.Key, true, event.Force)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("restore of %s would create %d and update %d items of %s, %d newer items are kept and %d are unchanged",
+			event.Key, len(r.Created), len(r.Updated), m.table, len(r.SkippedNewer), r.Unchanged), nil
+
+	case actionGCImages:
+		r, err := m.gcImages(ctx, true)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("gc-images would delete %d of %d objects of %s", len(r.Removed), r.Scanned, r.Bucket), nil
+
+	case actionRemoveAll:
+		if event.UserId == "" {
+			return "", fmt.Errorf("remove-all needs a userId")
+		}
+		bItems, err := m.getBolhaItems(ctx)
+		if err != nil {
+			return "", err
+		}
+		items, ads := 0, 0
+		for _, bItem := range bItems {
+			if bItem.UserId != event.UserId {
+				continue
+			}
+			items++
+			if bItem.AdUploadedId != 0 {
+				ads++
+			}
+		}
+		return fmt.Sprintf("remove-all would take %d ads of user %s offline and disable its %d items of %s", ads, event.UserId, items, m.table), nil
+	}
+
+	return "", fmt.Errorf("%s needs no confirmation", event.Action)
+}
+
+// confirmParams are the parameters a token of event is derived from, the
+// whole event but its token with the table resolved
+func (m *monitor) confirmParams(event Event) ([]byte, error) {
+	event.ConfirmToken = ""
+	event.Table = m.table
+	return json.Marshal(event)
+}
+
+// confirmToken returns the token confirming the action with params issued
+// at issued, "<unix seconds>-<hash>"
+func confirmToken(params []byte, issued time.Time) string {
+	sec := issued.Unix()
+	return strconv.FormatInt(sec, 10) + "-" + confirmHash(params, sec)
+}
+
+// checkConfirmToken returns an error wrapping errConfirmToken unless token
+// was issued for params at most validity before now
+func checkConfirmToken(token string, params []byte, now time.Time, validity time.Duration) error {
+	at, hash, ok := strings.Cut(token, "-")
+	sec, err := strconv.ParseInt(at, 10, 64)
+	if !ok || err != nil {
+		return fmt.Errorf("%w: malformed", errConfirmToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(confirmHash(params, sec))) != 1 {
+		return fmt.Errorf("%w: not issued for these parameters", errConfirmToken)
+	}
+
+	issued := time.Unix(sec, 0)
+	switch {
+	case issued.After(now):
+		return fmt.Errorf("%w: issued in the future", errConfirmToken)
+	case now.Sub(issued) > validity:
+		return fmt.Errorf("%w: expired at %s", errConfirmToken, issued.Add(validity).UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// HELPERS
+
+func confirmHash(params []byte, sec int64) string {
+	h := sha256.New()
+	h.Write(params)
+	h.Write([]byte{0})
+	h.Write([]byte(time.Unix(sec, 0).UTC().Format(time.RFC3339)))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckConfirmToken(t *testing.T) {
+	const validity = 10 * time.Minute
+	issued := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	params := []byte(`{"action":"remove-all","table":"items","userId":"u1"}`)
+	token := confirmToken(params, issued)
+
+	tests := []struct {
+		name   string
+		token  string
+		params []byte
+		now    time.Time
+		// part of the error, empty if the token confirms
+		err string
+	}{
+		{"just issued", token, params, issued, ""},
+		{"within its validity", token, params, issued.Add(validity - time.Second), ""},
+		{"exactly at its validity", token, params, issued.Add(validity), ""},
+		{"past its validity", token, params, issued.Add(validity + time.Second), "expired at 2026-10-01T12:10:00Z"},
+		{"other parameters", token, []byte(`{"action":"remove-all","table":"items","userId":"u2"}`), issued, "not issued for these parameters"},
+		{"other table", token, []byte(`{"action":"remove-all","table":"other","userId":"u1"}`), issued, "not issued for these parameters"},
+		{"issued in the future", token, params, issued.Add(-time.Second), "issued in the future"},
+		{"issued at another time", confirmToken(params, issued.Add(time.Second))[:10] + token[10:], params, issued.Add(time.Minute), "not issued for these parameters"},
+		{"empty", "", params, issued, "malformed"},
+		{"no hash", "1790856000", params, issued, "malformed"},
+		{"time not a number", "soon-" + strings.SplitN(token, "-", 2)[1], params, issued, "malformed"},
+		{"hash cut short", token[:len(token)-1], params, issued, "not issued for these parameters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkConfirmToken(tt.token, tt.params, tt.now, validity)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("token refused: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errConfirmToken) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want %v: ...%s", err, errConfirmToken, tt.err)
+			}
+		})
+	}
+}
+
+func TestConfirmToken(t *testing.T) {
+	params := []byte(`{"action":"gc-images"}`)
+	issued := time.Date(2026, 10, 1, 12, 0, 0, 500, time.UTC)
+
+	token := confirmToken(params, issued)
+	if !strings.HasPrefix(token, "1790856000-") || len(token) != len("1790856000-")+16 {
+		t.Errorf("token %q, want <unix seconds>-<16 hex digits>", token)
+	}
+	if again := confirmToken(params, issued.Truncate(time.Second)); again != token {
+		t.Errorf("token of the same second %q, want %q", again, token)
+	}
+	if other := confirmToken([]byte(`{"action":"restore"}`), issued); other == token {
+		t.Error("tokens of different parameters are equal")
+	}
+}
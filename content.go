@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxDescriptionBytes = 64 << 10
+
+// resolveDescription returns the normalized description of bItem, the one of
+// its selected language if set, downloading it from s3 if AdDescriptionKey
+// is set and falling back to the inline AdDescription. The hash covers the normalized description so a cleanup
+// refreshes the ad once.
+func (m *monitor) resolveDescription(ctx context.Context, bItem *BolhaItem) (string, error) {
+	if bItem.descriptionResolved {
+		return bItem.description, nil
+	}
+
+	description := bItem.AdDescription
+	if text, ok := bItem.localized(); ok && text.Description != "" {
+		description = text.Description
+	} else if bItem.AdDescriptionKey != "" {
+		d, err := m.downloadDescription(ctx, bItem.AdDescriptionKey)
+		switch {
+		case err == nil:
+			description = d
+		case bItem.AdDescription != "":
+			bItem.descriptionFallback = err
+			m.log.WithField("AdDescriptionKey", bItem.AdDescriptionKey).WithError(err).Warn("falling back to inline description")
+		default:
+			return "", err
+		}
+	} else if description == "" && bItem.AdDescriptionOffloadKey != "" {
+		d, err := m.downloadOffloaded(ctx, bItem.AdDescriptionOffloadKey)
+		if err != nil {
+			return "", err
+		}
+		description = d
+	}
+
+	bItem.description = normalizeDescription(description, m.cfg.StripDescriptionHTML)
+	bItem.descriptionResolved = true
+
+	return bItem.description, nil
+}
+
+// contentField is a named input of the content hash
+type contentField struct {
+	name  string
+	value string
+}
+
+// contentFields returns the content an ad is uploaded with, in hash order
+func (m *monitor) contentFields(ctx context.Context, bItem *BolhaItem) ([]contentField, error) {
+	description, err := m.resolveDescription(ctx, bItem)
+	if err != nil {
+		return nil, err
+	}
+	images, err := m.resolveImages(ctx, bItem)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []contentField{
+		{"title", bItem.uploadTitle()},
+		{"description", description},
+		{"price", bItem.AdPrice.String()},
+		{"category", fmt.Sprint(bItem.AdCategoryId)},
+		{"images", strings.Join(images, "\n")},
+		{"condition", bItem.AdCondition},
+		{"shipping", strings.Join(bItem.AdShipping, "\n")},
+		{"location", bItem.AdLocation},
+	}
+	// fields added later are only hashed when set so existing hashes stay valid
+	if pt := bItem.priceType(); pt != priceTypeFixed {
+		fields = append(fields, contentField{"priceType", pt})
+	}
+	if bItem.AdVideo != "" {
+		fields = append(fields, contentField{"video", bItem.AdVideo})
+	}
+	if bItem.AdLanguage != "" {
+		fields = append(fields, contentField{"language", bItem.AdLanguage})
+	}
+
+	return fields, nil
+}
+
+// contentHash identifies the content an ad was uploaded with, a changed hash
+// means the live ad is outdated
+func (m *monitor) contentHash(ctx context.Context, bItem *BolhaItem) (string, error) {
+	fields, err := m.contentFields(ctx, bItem)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, f := range fields {
+		// length prefix so that moving text between fields changes the hash
+		fmt.Fprintf(h, "%d:%s", len(f.value), f.value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// contentFieldHashes hashes every content field on its own so that a later
+// upload can tell which fields changed
+func (m *monitor) contentFieldHashes(ctx context.Context, bItem *BolhaItem) (map[string]string, error) {
+	fields, err := m.contentFields(ctx, bItem)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string, len(fields))
+	for _, f := range fields {
+		sum := sha256.Sum256([]byte(f.value))
+		hashes[f.name] = hex.EncodeToString(sum[:8])
+	}
+
+	return hashes, nil
+}
+
+// changedContent returns the names of the fields whose hash differs from the
+// hashes of the last upload, nil if those are unknown
+func changedContent(uploaded, current map[string]string) []string {
+	if len(uploaded) == 0 {
+		return nil
+	}
+
+	changed := make([]string, 0)
+	for name, h := range current {
+		if uploaded[name] != h {
+			changed = append(changed, name)
+		}
+	}
+	for name := range uploaded {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
+
+// S3
+
+func (m *monitor) downloadDescription(ctx context.Context, key string) (string, error) {
+	m.log.WithField("key", key).Info("downloading description...")
+
+	obj, err := m.getImagesObject(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(obj.Body, maxDescriptionBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxDescriptionBytes {
+		return "", fmt.Errorf("description %s exceeds %d bytes", key, maxDescriptionBytes)
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("description %s is not valid utf-8", key)
+	}
+
+	return string(b), nil
+}
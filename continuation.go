@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Continuation is the part of a run a previous invocation left to the next
+// one, see SELF_CONTINUATION
+type Continuation struct {
+	// number of invocations of the run before this one
+	Depth int `json:"depth"`
+	// start and run id of the first invocation, all invocations share its
+	// report
+	StartedAt time.Time `json:"startedAt"`
+	RunId     string    `json:"runId,omitempty"`
+	// titles of the items left per table, an empty list leaves the whole
+	// table, tables not listed are done
+	Items map[string][]string `json:"items"`
+	// time the users (reportUser) left items were owed, their queues
+	// start first, see fairshare.go
+	Deficits map[string]time.Duration `json:"deficits,omitempty"`
+}
+
+// runChain tracks the time budget of an invocation taking part in a self
+// continued run, a nil chain runs everything
+type runChain struct {
+	m *monitor
+
+	depth     int
+	startedAt time.Time
+	runId     string
+
+	// no item is started after budget, a time of the clock of m
+	budget time.Time
+
+	// items to run per table, nil runs every table, a nil set a whole table
+	only map[string]map[string]bool
+	// time users were owed by the previous invocation
+	deficits map[string]time.Duration
+
+	mu        sync.Mutex
+	remaining map[string][]string
+	owed      map[string]time.Duration
+}
+
+// newRunChain returns nil unless SELF_CONTINUATION is on and the invocation
+// has a deadline
+func (m *monitor) newRunChain(ctx context.Context, cont *Continuation, startedAt time.Time) (*runChain, error) {
+	if !m.cfg.SelfContinuation {
+		if cont != nil {
+			return nil, fmt.Errorf("continuation of the run started at %s but SELF_CONTINUATION is off", cont.StartedAt)
+		}
+		return nil, nil
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, nil
+	}
+
+	rc := &runChain{
+		m:         m,
+		startedAt: startedAt,
+		runId:     m.runId,
+		budget:    m.now().Add(time.Until(deadline) - m.cfg.ContinuationMargin),
+		remaining: make(map[string][]string),
+		owed:      make(map[string]time.Duration),
+	}
+	if cont != nil {
+		rc.depth = cont.Depth
+		rc.startedAt = cont.StartedAt
+		if cont.RunId != "" {
+			rc.runId = cont.RunId
+		}
+		rc.deficits = cont.Deficits
+		rc.only = make(map[string]map[string]bool, len(cont.Items))
+		for table, titles := range cont.Items {
+			var set map[string]bool
+			if len(titles) > 0 {
+				set = make(map[string]bool, len(titles))
+				for _, title := range titles {
+					set[title] = true
+				}
+			}
+			rc.only[table] = set
+		}
+	}
+
+	m.log.WithFields(log.Fields{"depth": rc.depth, "budget": rc.budget}).Info("run may continue in a new invocation")
+
+	return rc, nil
+}
+
+// continued reports whether this invocation continues an earlier one
+func (rc *runChain) continued() bool {
+	return rc != nil && rc.depth > 0
+}
+
+// runsTable reports whether table has items left to run
+func (rc *runChain) runsTable(table string) bool {
+	if rc == nil || rc.only == nil {
+		return true
+	}
+	_, ok := rc.only[table]
+	return ok
+}
+
+// includes reports whether the item is run by this invocation
+func (rc *runChain) includes(table, title string) bool {
+	if rc == nil || rc.only == nil {
+		return true
+	}
+	set, ok := rc.only[table]
+	return ok && (set == nil || set[title])
+}
+
+// pastBudget reports whether no more items may be started
+func (rc *runChain) pastBudget() bool {
+	return rc != nil && !rc.m.now().Before(rc.budget)
+}
+
+// start reports whether an item may still be started. Starting an item
+// takes a bolha request token so items start no faster than the shared
+// limiter admits requests and the wait never passes the budget.
+func (rc *runChain) start(ctx context.Context) bool {
+	if rc == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rc.budget.Sub(rc.m.now()))
+	defer cancel()
+
+	return rc.m.limiter.Wait(ctx) == nil && !rc.pastBudget()
+}
+
+// deferTable leaves every item of table to the next invocation
+func (rc *runChain) deferTable(table string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.remaining[table] = []string{}
+}
+
+// deferItem leaves a single item to the next invocation
+func (rc *runChain) deferItem(table, title string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.remaining[table] = append(rc.remaining[table], title)
+}
+
+// owe records that user was left items d short of its time slice
+func (rc *runChain) owe(user string, d time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.owed[user] += d
+}
+
+// deficit returns the time user was owed by the previous invocation
+func (rc *runChain) deficit(user string) time.Duration {
+	if rc == nil {
+		return 0
+	}
+	return rc.deficits[user]
+}
+
+// next returns the continuation of the items left, nil if none are left
+func (rc *runChain) next() *Continuation {
+	if rc == nil || len(rc.remaining) == 0 {
+		return nil
+	}
+
+	items := make(map[string][]string, len(rc.remaining))
+	for table, titles := range rc.remaining {
+		titles = append([]string{}, titles...)
+		sort.Strings(titles)
+		items[table] = titles
+	}
+
+	next := &Continuation{Depth: rc.depth + 1, StartedAt: rc.startedAt, RunId: rc.runId, Items: items}
+	if len(rc.owed) > 0 {
+		next.Deficits = make(map[string]time.Duration, len(rc.owed))
+		for user, d := range rc.owed {
+			next.Deficits[user] = d
+		}
+	}
+
+	return next
+}
+
+// continueRun invokes the function asynchronously with the items left,
+// a chain longer than CONTINUATION_MAX_DEPTH is not continued
+func (m *monitor) continueRun(ctx context.Context, next *Continuation) error {
+	if next.Depth > m.cfg.ContinuationMaxDepth {
+		return fmt.Errorf("run started at %s reached CONTINUATION_MAX_DEPTH %d", next.StartedAt, m.cfg.ContinuationMaxDepth)
+	}
+	if m.cfg.FunctionName == "" {
+		return fmt.Errorf("AWS_LAMBDA_FUNCTION_NAME is not set")
+	}
+
+	payload, err := json.Marshal(Event{Action: actionRun, Continuation: next})
+	if err != nil {
+		return err
+	}
+
+	m.log.WithFields(log.Fields{"function": m.cfg.FunctionName, "depth": next.Depth, "items": next.Items}).Info("continuing run...")
+
+	_, err = m.lambda.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(m.cfg.FunctionName),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	})
+
+	return err
+}
+
+// mergeReport adds the items of report to the report the earlier
+// invocations of the run saved, items run again replace their earlier
+// outcome
+func (m *monitor) mergeReport(ctx context.Context, report *Report) error {
+	prev, err := m.loadReport(ctx, "run", report.StartedAt, report.RunId)
+	if err != nil {
+		return err
+	}
+
+	key := func(table, title string) [2]string { return [2]string{table, title} }
+	seen := make(map[[2]string]bool, len(report.Items))
+	for _, ir := range report.Items {
+		seen[key(ir.Table, ir.AdTitle)] = true
+	}
+
+	items := make([]ItemReport, 0, len(prev.Items)+len(report.Items))
+	for _, ir := range prev.Items {
+		if !seen[key(ir.Table, ir.AdTitle)] {
+			items = append(items, ir)
+		}
+	}
+	report.Items = append(items, report.Items...)
+
+	for _, na := range prev.NeedsAttention {
+		if !seen[key(na.Table, na.AdTitle)] {
+			report.NeedsAttention = append(report.NeedsAttention, na)
+		}
+	}
+	for _, s := range prev.Scheduled {
+		if !seen[key(s.Table, s.AdTitle)] {
+			report.Scheduled = append(report.Scheduled, s)
+		}
+	}
+	report.Expired += prev.Expired
+	report.Invocations = append(prev.Invocations, report.Invocations...)
+	report.addRemovals(prev.Removals)
+	for _, pu := range prev.Users {
+		u := report.userReport(pu.User)
+		if u.Session == "" {
+			u.Session = pu.Session
+		}
+		if u.MaxActiveAds == 0 {
+			u.MaxActiveAds = pu.MaxActiveAds
+		}
+	}
+	if report.Usage != nil {
+		report.Usage.add(prev.Usage)
+	}
+
+	// failures of earlier invocations are only known from their report
+	failed := make([]*ItemError, 0)
+	for _, ir := range report.Items {
+		if ir.Error != "" {
+			failed = append(failed, &ItemError{AdTitle: ir.AdTitle, AdID: ir.AdUploadedId, Class: ir.ErrorClass, Err: fmt.Errorf("%s", ir.Error)})
+		}
+	}
+	report.Errors = newRunError(len(report.Items), failed)
+
+	return nil
+}
+
+// S3
+
+// loadReport reads the report of kind saved at at by run runId, its errors
+// are left out as they are rebuilt from the items
+func (m *monitor) loadReport(ctx context.Context, kind string, at time.Time, runId string) (*Report, error) {
+	return m.loadReportKey(ctx, reportKey(kind, at, runId))
+}
+
+// loadReportKey loads the report saved at key of REPORT_BUCKET
+func (m *monitor) loadReportKey(ctx context.Context, key string) (*Report, error) {
+	if m.cfg.ReportBucket == "" {
+		return nil, fmt.Errorf("REPORT_BUCKET is not set")
+	}
+
+	m.log.WithFields(log.Fields{"bucket": m.cfg.ReportBucket, "key": key}).Info("loading report...")
+
+	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.cfg.ReportBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(obj.Body); err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %v", key, err)
+	}
+	delete(fields, "errors")
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %v", key, err)
+	}
+
+	return &report, nil
+}
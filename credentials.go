@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	client "github.com/seniorescobar/bolha-client"
+)
+
+// EncryptCredentialsResult describes credentials encrypted onto a user, it
+// never contains the credentials
+type EncryptCredentialsResult struct {
+	UserId      string    `json:"userId"`
+	KeyId       string    `json:"keyId"`
+	EncryptedAt time.Time `json:"encryptedAt"`
+}
+
+// credentialsCache holds decrypted credentials for a single invocation
+type credentialsCache struct {
+	mu    sync.Mutex
+	users map[string]*client.User
+}
+
+func newCredentialsCache() *credentialsCache {
+	return &credentialsCache{users: make(map[string]*client.User)}
+}
+
+// credentialsContext binds the ciphertext to its user, it cannot be copied
+// onto another user
+func credentialsContext(userId string) map[string]string {
+	return map[string]string{"UserId": userId}
+}
+
+// decryptCredentials decrypts the UserCredentialsEncrypted of user once per
+// invocation. The credentials are never logged, errors do not contain them.
+func (m *monitor) decryptCredentials(ctx context.Context, user *BolhaUser) (*client.User, error) {
+	m.creds.mu.Lock()
+	defer m.creds.mu.Unlock()
+
+	if creds, ok := m.creds.users[user.UserId]; ok {
+		return creds, nil
+	}
+
+	m.log.WithField("UserId", user.UserId).Info("decrypting credentials...")
+
+	input := &kms.DecryptInput{
+		CiphertextBlob:    user.UserCredentialsEncrypted,
+		EncryptionContext: credentialsContext(user.UserId),
+	}
+	if m.cfg.CredentialsKeyId != "" {
+		input.KeyId = aws.String(m.cfg.CredentialsKeyId)
+	}
+	result, err := m.kms.Decrypt(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(result.Plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("invalid encrypted credentials of user %q", user.UserId)
+	}
+
+	m.creds.users[user.UserId] = &client.User{Username: creds.Username, Password: creds.Password}
+
+	return m.creds.users[user.UserId], nil
+}
+
+// encryptCredentials encrypts username and password with CREDENTIALS_KMS_KEY_ID
+// and stores them as the UserCredentialsEncrypted of an existing user. The
+// event carrying them is never logged.
+func (m *monitor) encryptCredentials(ctx context.Context, userId, username, password string) (*EncryptCredentialsResult, error) {
+	if m.cfg.UsersTableName == "" {
+		return nil, errors.New("USERS_TABLE is not configured")
+	}
+	if m.cfg.CredentialsKeyId == "" {
+		return nil, errors.New("CREDENTIALS_KMS_KEY_ID is not configured")
+	}
+	if userId == "" || username == "" || password == "" {
+		return nil, errors.New("userId, username and password are required")
+	}
+
+	m.log.WithField("UserId", userId).Info("encrypting credentials...")
+
+	plaintext, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := m.kms.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(m.cfg.CredentialsKeyId),
+		Plaintext:         plaintext,
+		EncryptionContext: credentialsContext(userId),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.setUserCredentialsEncrypted(ctx, userId, result.CiphertextBlob); err != nil {
+		return nil, err
+	}
+
+	m.log.WithField("UserId", userId).Info("credentials encrypted")
+
+	return &EncryptCredentialsResult{
+		UserId:      userId,
+		KeyId:       aws.ToString(result.KeyId),
+		EncryptedAt: m.now(),
+	}, nil
+}
+
+// DYNAMODB
+
+func (m *monitor) setUserCredentialsEncrypted(ctx context.Context, userId string, ciphertext []byte) error {
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ciphertext": &types.AttributeValueMemberB{Value: ciphertext},
+		},
+		Key:                 map[string]types.AttributeValue{"UserId": &types.AttributeValueMemberS{Value: userId}},
+		UpdateExpression:    aws.String("SET UserCredentialsEncrypted = :ciphertext"),
+		ConditionExpression: aws.String("attribute_exists(UserId)"),
+		TableName:           aws.String(m.cfg.UsersTableName),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return fmt.Errorf("unknown user %q", userId)
+	}
+
+	return err
+}
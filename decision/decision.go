@@ -0,0 +1,365 @@
+// Package decision decides what the monitor does with an item on a run. It
+// is pure, it neither talks to bolha nor to aws, so the lambda and local
+// simulations share the exact same policy.
+package decision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// states of an uploaded ad. The bolha client only reports whether an ad is
+// among the active ads, so a missing ad is pending moderation while it is
+// younger than the moderation grace and expired afterwards. Blocked ads
+// cannot be detected, the state is set on the item by hand.
+const (
+	StateActive            = "active"
+	StatePendingModeration = "pending moderation"
+	StateExpired           = "expired"
+	StateBlocked           = "blocked"
+)
+
+// reasons of an upload or reupload, new ads are uploaded without a reason.
+// The order and the age trigger are told apart: an ad which fell below the
+// threshold while fresh is reuploaded for its order, one above the threshold
+// but old for its age, one which is both for both.
+const (
+	ReasonOrder          = "order"
+	ReasonAge            = "age"
+	ReasonOrderAndAge    = "order and age"
+	ReasonMaxAge         = "max age"
+	ReasonContentChanged = "content changed"
+	ReasonExpired        = "expired"
+	ReasonForced         = "forced"
+)
+
+// ReasonMinAge is the reason an ad is kept although its order triggers a
+// reupload, it is younger than NeverReuploadBeforeHours
+const ReasonMinAge = "min age"
+
+// Action is what should be done with an item
+type Action string
+
+const (
+	// the publish time of a new ad has not been reached
+	Wait Action = "wait"
+	// the ad is blocked or pending moderation and must not be touched
+	Skip Action = "skip"
+	// the order of the live ad is needed to decide
+	Observe Action = "observe"
+	// upload a new ad
+	Upload Action = "upload"
+	// remove the active ad and upload it again
+	Reupload Action = "reupload"
+	// the active ad is fine as it is
+	Keep Action = "keep"
+)
+
+// Item is the part of an item the decision depends on
+type Item struct {
+	// zero if the ad was never uploaded
+	UploadedId int64
+	// when bolha accepted the upload, not when it was recorded, the age of
+	// the ad is measured from it
+	UploadedAt time.Time
+
+	// a new ad is not uploaded before PublishAt, ignored if zero
+	PublishAt time.Time
+
+	// last known state of the uploaded ad
+	State string
+
+	// hash of the current content and of the content the active ad was
+	// uploaded with, an empty uploaded hash is assumed to be up to date
+	ContentHash         string
+	UploadedContentHash string
+
+	ReuploadHours int
+	ReuploadOrder int
+
+	// hard limits on the age of the active ad, ignored if 0. At
+	// ForceReuploadAfterHours the ad is reuploaded whatever its order, before
+	// NeverReuploadBeforeHours its order does not trigger a reupload.
+	ForceReuploadAfterHours  int
+	NeverReuploadBeforeHours int
+
+	// the ad is reuploaded once it falls off page ReuploadPage of
+	// AdsPerPage ads, ReuploadOrder is ignored if set
+	ReuploadPage int
+	AdsPerPage   int
+
+	// reupload the active ad even if nothing else calls for it
+	Force bool
+}
+
+// Observed is what is known about the live ad
+type Observed struct {
+	// order of the active ad
+	Order int
+	// the ad is not among the active ads
+	Missing bool
+}
+
+// Config is the configuration the decision depends on
+type Config struct {
+	// how long a missing ad is considered pending moderation
+	ModerationGrace time.Duration
+}
+
+// Decision is the outcome of Evaluate
+type Decision struct {
+	Action Action
+	// why the ad is (re)uploaded, empty for new ads, or why an ad whose
+	// order calls for a reupload is kept
+	Reason string
+	// state of the ad implied by the observation, empty if unknown
+	State string
+
+	// what the decision was based on
+	Inputs Inputs
+}
+
+// Inputs are the values Evaluate compared to reach a decision
+type Inputs struct {
+	UploadedAt time.Time
+	// age of the uploaded ad and the age after which it is reuploaded
+	Age      time.Duration
+	Interval time.Duration
+
+	// the live ad was looked at, its order and the order after which it is
+	// reuploaded
+	Observed       bool
+	Missing        bool
+	Order          int
+	OrderThreshold int
+	// page the threshold was derived from, zero if it is ReuploadOrder
+	ReuploadPage int
+
+	// which of the order and the age trigger fired, only set for observed
+	// active ads. The order trigger is reported even if the minimum age
+	// held it back.
+	OrderTriggered bool
+	AgeTriggered   bool
+
+	// the hard age limits, zero if not set
+	MaxAge time.Duration
+	MinAge time.Duration
+
+	ContentChanged bool
+}
+
+// Fields returns the decision and its inputs keyed by name, for
+// structured logging
+func (d Decision) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"action":   string(d.Action),
+		"interval": d.Inputs.Interval.String(),
+	}
+	if d.Reason != "" {
+		fields["reason"] = d.Reason
+	}
+	if d.State != "" {
+		fields["state"] = d.State
+	}
+	if !d.Inputs.UploadedAt.IsZero() {
+		fields["uploadedAt"] = d.Inputs.UploadedAt.Format(time.RFC3339)
+		fields["age"] = d.Inputs.Age.String()
+		fields["contentChanged"] = d.Inputs.ContentChanged
+	}
+	if d.Inputs.Observed {
+		fields["missing"] = d.Inputs.Missing
+		fields["orderThreshold"] = d.Inputs.OrderThreshold
+		if d.Inputs.ReuploadPage > 0 {
+			fields["reuploadPage"] = d.Inputs.ReuploadPage
+		}
+		if !d.Inputs.Missing {
+			fields["order"] = d.Inputs.Order
+			fields["orderTriggered"] = d.Inputs.OrderTriggered
+			fields["ageTriggered"] = d.Inputs.AgeTriggered
+		}
+	}
+	if d.Inputs.MaxAge > 0 {
+		fields["maxAge"] = d.Inputs.MaxAge.String()
+	}
+	if d.Inputs.MinAge > 0 {
+		fields["minAge"] = d.Inputs.MinAge.String()
+	}
+
+	return fields
+}
+
+// Explain returns the fields of the decision as a single line of
+// key=value pairs, the action first and the rest ordered by key
+func (d Decision) Explain() string {
+	fields := d.Fields()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != "action" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(fields))
+	parts = append(parts, fmt.Sprintf("action=%v", fields["action"]))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, fields[k]))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Scheduled reports whether item is a new ad whose publish time is after now
+func (item Item) Scheduled(now time.Time) bool {
+	return item.UploadedId == 0 && !item.PublishAt.IsZero() && now.Before(item.PublishAt)
+}
+
+// AgeDueAt returns the time after which the age of the uploaded ad of item
+// triggers a reupload, by ReuploadHours or ForceReuploadAfterHours whichever
+// is earlier. It is the zero time if nothing is uploaded or the upload time
+// is unknown. The order may trigger one at any run before that.
+func (item Item) AgeDueAt() time.Time {
+	if item.UploadedId == 0 || item.UploadedAt.IsZero() {
+		return time.Time{}
+	}
+	limit := time.Duration(item.ReuploadHours) * time.Hour
+	if maxAge := time.Duration(item.ForceReuploadAfterHours) * time.Hour; maxAge > 0 && maxAge < limit {
+		limit = maxAge
+	}
+	return item.UploadedAt.Add(limit)
+}
+
+// Overdue reports whether the uploaded ad of item reached
+// ForceReuploadAfterHours at now. An overdue ad is reuploaded whatever its
+// order and the monitor does not hold it back for its limits.
+func (item Item) Overdue(now time.Time) bool {
+	return item.UploadedId != 0 && item.ForceReuploadAfterHours > 0 &&
+		now.Sub(item.UploadedAt) >= time.Duration(item.ForceReuploadAfterHours)*time.Hour
+}
+
+// tooYoung reports whether the uploaded ad of item is younger than
+// NeverReuploadBeforeHours at now
+func (item Item) tooYoung(now time.Time) bool {
+	return item.NeverReuploadBeforeHours > 0 &&
+		now.Sub(item.UploadedAt) < time.Duration(item.NeverReuploadBeforeHours)*time.Hour
+}
+
+// OrderThreshold returns the order after which the ad is reuploaded
+func (item Item) OrderThreshold() int {
+	if item.ReuploadPage > 0 {
+		return item.ReuploadPage * item.AdsPerPage
+	}
+	return item.ReuploadOrder
+}
+
+// MissingState returns the state of an uploaded ad which is not among the
+// active ads
+func MissingState(now, uploadedAt time.Time, grace time.Duration) string {
+	if now.Sub(uploadedAt) < grace {
+		return StatePendingModeration
+	}
+	return StateExpired
+}
+
+// Evaluate decides what to do with item at now. Observed is nil until the
+// live ad was looked at, in which case an uploaded item is decided with
+// Observe and has to be evaluated again once observed.
+func Evaluate(item Item, observed *Observed, now time.Time, cfg Config) Decision {
+	d := evaluate(item, observed, now, cfg)
+
+	d.Inputs = Inputs{
+		Interval:       time.Duration(item.ReuploadHours) * time.Hour,
+		OrderThreshold: item.OrderThreshold(),
+		ReuploadPage:   item.ReuploadPage,
+		MaxAge:         time.Duration(item.ForceReuploadAfterHours) * time.Hour,
+		MinAge:         time.Duration(item.NeverReuploadBeforeHours) * time.Hour,
+	}
+	if item.UploadedId != 0 {
+		d.Inputs.UploadedAt = item.UploadedAt
+		d.Inputs.Age = now.Sub(item.UploadedAt)
+		d.Inputs.ContentChanged = item.UploadedContentHash != "" && item.ContentHash != item.UploadedContentHash
+	}
+	if observed != nil {
+		d.Inputs.Observed = true
+		d.Inputs.Missing = observed.Missing
+		d.Inputs.Order = observed.Order
+		if item.UploadedId != 0 && !observed.Missing {
+			d.Inputs.OrderTriggered = orderTriggered(item, observed)
+			d.Inputs.AgeTriggered = ageTriggered(item, now)
+		}
+	}
+
+	return d
+}
+
+func evaluate(item Item, observed *Observed, now time.Time, cfg Config) Decision {
+	if item.Scheduled(now) {
+		return Decision{Action: Wait}
+	}
+
+	// blocked ads are never touched
+	if item.State == StateBlocked {
+		return Decision{Action: Skip, State: StateBlocked}
+	}
+
+	if item.UploadedId == 0 {
+		return Decision{Action: Upload}
+	}
+
+	if observed == nil {
+		return Decision{Action: Observe}
+	}
+
+	if observed.Missing {
+		state := MissingState(now, item.UploadedAt, cfg.ModerationGrace)
+		if state == StatePendingModeration {
+			return Decision{Action: Skip, State: state}
+		}
+
+		// expired, the old ad is forgotten and uploaded as new
+		return Decision{Action: Upload, Reason: ReasonExpired, State: state}
+	}
+
+	// the hard limits win over the triggers
+	order := orderTriggered(item, observed)
+	heldBack := order && item.tooYoung(now)
+	if heldBack {
+		order = false
+	}
+	age := ageTriggered(item, now)
+
+	d := Decision{Action: Reupload, State: StateActive}
+	switch {
+	case item.Overdue(now):
+		d.Reason = ReasonMaxAge
+	case order && age:
+		d.Reason = ReasonOrderAndAge
+	case order:
+		d.Reason = ReasonOrder
+	case age:
+		d.Reason = ReasonAge
+	case item.UploadedContentHash != "" && item.ContentHash != item.UploadedContentHash:
+		d.Reason = ReasonContentChanged
+	case item.Force:
+		d.Reason = ReasonForced
+	case heldBack:
+		d.Action = Keep
+		d.Reason = ReasonMinAge
+	default:
+		d.Action = Keep
+	}
+
+	return d
+}
+
+// orderTriggered reports whether the observed ad fell past the threshold
+func orderTriggered(item Item, observed *Observed) bool {
+	return observed.Order > item.OrderThreshold()
+}
+
+// ageTriggered reports whether the ad is older than ReuploadHours at now
+func ageTriggered(item Item, now time.Time) bool {
+	return now.Sub(item.UploadedAt) > time.Duration(item.ReuploadHours)*time.Hour
+}
@@ -0,0 +1,406 @@
+package decision
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+var cfg = Config{ModerationGrace: 24 * time.Hour}
+
+// uploaded returns an active ad uploaded hours ago, reuploaded after a
+// week or past order 30
+func uploaded(hours int) Item {
+	return Item{
+		UploadedId:    1000,
+		UploadedAt:    now.Add(-time.Duration(hours) * time.Hour),
+		State:         StateActive,
+		ReuploadHours: 168,
+		ReuploadOrder: 30,
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     Item
+		observed *Observed
+		action   Action
+		reason   string
+		state    string
+	}{
+		{
+			name:   "new ad before its publish time waits",
+			item:   Item{PublishAt: now.Add(time.Hour)},
+			action: Wait,
+		},
+		{
+			name:   "new ad at its publish time is uploaded",
+			item:   Item{PublishAt: now},
+			action: Upload,
+		},
+		{
+			name:   "new ad is uploaded",
+			item:   Item{},
+			action: Upload,
+		},
+		{
+			name:     "blocked ad is skipped",
+			item:     func() Item { it := uploaded(200); it.State = StateBlocked; return it }(),
+			observed: &Observed{Order: 100},
+			action:   Skip,
+			state:    StateBlocked,
+		},
+		{
+			name:   "blocked ad is skipped before it is observed",
+			item:   func() Item { it := uploaded(1); it.State = StateBlocked; return it }(),
+			action: Skip,
+			state:  StateBlocked,
+		},
+		{
+			name:   "uploaded ad is observed first",
+			item:   uploaded(1),
+			action: Observe,
+		},
+		{
+			name:     "missing ad within the grace is pending moderation",
+			item:     uploaded(2),
+			observed: &Observed{Missing: true},
+			action:   Skip,
+			state:    StatePendingModeration,
+		},
+		{
+			name:     "missing ad past the grace is expired and uploaded as new",
+			item:     uploaded(48),
+			observed: &Observed{Missing: true},
+			action:   Upload,
+			reason:   ReasonExpired,
+			state:    StateExpired,
+		},
+		{
+			name:     "fresh ad high up is kept",
+			item:     uploaded(1),
+			observed: &Observed{Order: 5},
+			action:   Keep,
+			state:    StateActive,
+		},
+		{
+			name:     "ad past its order is reuploaded",
+			item:     uploaded(1),
+			observed: &Observed{Order: 31},
+			action:   Reupload,
+			reason:   ReasonOrder,
+			state:    StateActive,
+		},
+		{
+			name:     "old ad is reuploaded",
+			item:     uploaded(169),
+			observed: &Observed{Order: 5},
+			action:   Reupload,
+			reason:   ReasonAge,
+			state:    StateActive,
+		},
+		{
+			name:     "changed content is reuploaded",
+			item:     func() Item { it := uploaded(1); it.ContentHash, it.UploadedContentHash = "b", "a"; return it }(),
+			observed: &Observed{Order: 5},
+			action:   Reupload,
+			reason:   ReasonContentChanged,
+			state:    StateActive,
+		},
+		{
+			name:     "unknown uploaded content is assumed up to date",
+			item:     func() Item { it := uploaded(1); it.ContentHash = "b"; return it }(),
+			observed: &Observed{Order: 5},
+			action:   Keep,
+			state:    StateActive,
+		},
+		{
+			name:     "forced ad is reuploaded",
+			item:     func() Item { it := uploaded(1); it.Force = true; return it }(),
+			observed: &Observed{Order: 5},
+			action:   Reupload,
+			reason:   ReasonForced,
+			state:    StateActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Evaluate(tt.item, tt.observed, now, cfg)
+			if d.Action != tt.action || d.Reason != tt.reason || d.State != tt.state {
+				t.Errorf("got %s (reason %q, state %q), want %s (reason %q, state %q)", d.Action, d.Reason, d.State, tt.action, tt.reason, tt.state)
+			}
+		})
+	}
+}
+
+// The order and the age trigger once past their threshold, an ad exactly
+// at one is kept for it
+func TestEvaluateTriggers(t *testing.T) {
+	ages := []struct {
+		name      string
+		age       time.Duration
+		triggered bool
+	}{
+		{"younger", 168*time.Hour - time.Second, false},
+		{"exactly ReuploadHours old", 168 * time.Hour, false},
+		{"older", 168*time.Hour + time.Second, true},
+	}
+	orders := []struct {
+		name      string
+		order     int
+		triggered bool
+	}{
+		{"above", 29, false},
+		{"exactly at ReuploadOrder", 30, false},
+		{"below", 31, true},
+	}
+
+	for _, age := range ages {
+		for _, order := range orders {
+			t.Run(age.name+", "+order.name, func(t *testing.T) {
+				item := uploaded(0)
+				item.UploadedAt = now.Add(-age.age)
+
+				d := Evaluate(item, &Observed{Order: order.order}, now, cfg)
+
+				action, reason := Reupload, ""
+				switch {
+				case age.triggered && order.triggered:
+					reason = ReasonOrderAndAge
+				case order.triggered:
+					reason = ReasonOrder
+				case age.triggered:
+					reason = ReasonAge
+				default:
+					action = Keep
+				}
+				if d.Action != action || d.Reason != reason {
+					t.Errorf("got %s (reason %q), want %s (reason %q)", d.Action, d.Reason, action, reason)
+				}
+				if d.Inputs.AgeTriggered != age.triggered || d.Inputs.OrderTriggered != order.triggered {
+					t.Errorf("age triggered %v, order triggered %v, want %v and %v", d.Inputs.AgeTriggered, d.Inputs.OrderTriggered, age.triggered, order.triggered)
+				}
+			})
+		}
+	}
+}
+
+// The hard age limits hold at exactly their hours: an ad is overdue at
+// ForceReuploadAfterHours and no longer too young at NeverReuploadBeforeHours
+func TestEvaluateAgeLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		age    time.Duration
+		order  int
+		force  int
+		never  int
+		action Action
+		reason string
+	}{
+		{"before the max age", 72*time.Hour - time.Second, 5, 72, 0, Keep, ""},
+		{"exactly at the max age", 72 * time.Hour, 5, 72, 0, Reupload, ReasonMaxAge},
+		{"past the max age", 72*time.Hour + time.Second, 5, 72, 0, Reupload, ReasonMaxAge},
+		{"max age wins over the order", 72 * time.Hour, 31, 72, 0, Reupload, ReasonMaxAge},
+		{"max age past ReuploadHours", 200 * time.Hour, 5, 200, 0, Reupload, ReasonMaxAge},
+		{"before the min age past the order", 12*time.Hour - time.Second, 31, 0, 12, Keep, ReasonMinAge},
+		{"exactly at the min age past the order", 12 * time.Hour, 31, 0, 12, Reupload, ReasonOrder},
+		{"past the min age past the order", 12*time.Hour + time.Second, 31, 0, 12, Reupload, ReasonOrder},
+		{"before the min age above the order", 12*time.Hour - time.Second, 5, 0, 12, Keep, ""},
+		{"min age does not hold back the age", 168*time.Hour + time.Second, 5, 0, 200, Reupload, ReasonAge},
+		{"min age holds back the order of an old ad", 168*time.Hour + time.Second, 31, 0, 200, Reupload, ReasonAge},
+		{"max age wins over the min age", 24 * time.Hour, 5, 24, 48, Reupload, ReasonMaxAge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := uploaded(0)
+			item.UploadedAt = now.Add(-tt.age)
+			item.ForceReuploadAfterHours = tt.force
+			item.NeverReuploadBeforeHours = tt.never
+
+			d := Evaluate(item, &Observed{Order: tt.order}, now, cfg)
+			if d.Action != tt.action || d.Reason != tt.reason {
+				t.Errorf("got %s (reason %q), want %s (reason %q)", d.Action, d.Reason, tt.action, tt.reason)
+			}
+			if overdue := item.Overdue(now); overdue != (tt.reason == ReasonMaxAge) {
+				t.Errorf("Overdue = %v", overdue)
+			}
+		})
+	}
+}
+
+// The age is due by the earlier of ReuploadHours and ForceReuploadAfterHours,
+// an ad is never reuploaded for its age before AgeDueAt
+func TestAgeDueAt(t *testing.T) {
+	tests := []struct {
+		name  string
+		force int
+		due   time.Duration
+	}{
+		{"no max age", 0, 168 * time.Hour},
+		{"max age before ReuploadHours", 72, 72 * time.Hour},
+		{"max age after ReuploadHours", 200, 168 * time.Hour},
+		{"max age at ReuploadHours", 168, 168 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := uploaded(0)
+			item.ForceReuploadAfterHours = tt.force
+
+			dueAt := item.AgeDueAt()
+			if want := item.UploadedAt.Add(tt.due); !dueAt.Equal(want) {
+				t.Fatalf("due at %s, want %s", dueAt, want)
+			}
+
+			// above the order threshold only the age reuploads the ad
+			before := Evaluate(item, &Observed{Order: 5}, dueAt.Add(-time.Second), cfg)
+			if before.Action != Keep {
+				t.Errorf("a second before: %s (reason %q), want %s", before.Action, before.Reason, Keep)
+			}
+			after := Evaluate(item, &Observed{Order: 5}, dueAt.Add(time.Second), cfg)
+			if after.Action != Reupload {
+				t.Errorf("a second after: %s, want %s", after.Action, Reupload)
+			}
+		})
+	}
+
+	if dueAt := (Item{ReuploadHours: 168, ForceReuploadAfterHours: 72}).AgeDueAt(); !dueAt.IsZero() {
+		t.Errorf("due at %s without an upload, want the zero time", dueAt)
+	}
+}
+
+// A missing ad is pending moderation until exactly MODERATION_GRACE after
+// its upload and expired from then on
+func TestMissingState(t *testing.T) {
+	tests := []struct {
+		name  string
+		age   time.Duration
+		state string
+	}{
+		{"just uploaded", 0, StatePendingModeration},
+		{"just within the grace", 24*time.Hour - time.Second, StatePendingModeration},
+		{"exactly at the grace", 24 * time.Hour, StateExpired},
+		{"past the grace", 24*time.Hour + time.Second, StateExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if state := MissingState(now, now.Add(-tt.age), cfg.ModerationGrace); state != tt.state {
+				t.Errorf("MissingState = %q, want %q", state, tt.state)
+			}
+
+			// Evaluate skips what is pending and uploads what expired
+			item := uploaded(0)
+			item.UploadedAt = now.Add(-tt.age)
+			d := Evaluate(item, &Observed{Missing: true}, now, cfg)
+			action := Upload
+			if tt.state == StatePendingModeration {
+				action = Skip
+			}
+			if d.Action != action || d.State != tt.state {
+				t.Errorf("got %s (state %q), want %s (state %q)", d.Action, d.State, action, tt.state)
+			}
+		})
+	}
+}
+
+// A blocked ad is skipped whatever is observed, even missing past the grace
+func TestBlockedSkipped(t *testing.T) {
+	observations := map[string]*Observed{
+		"not observed":           nil,
+		"high up":                {Order: 1},
+		"past its order":         {Order: 100},
+		"missing past the grace": {Missing: true},
+	}
+
+	for name, observed := range observations {
+		t.Run(name, func(t *testing.T) {
+			item := uploaded(500)
+			item.State = StateBlocked
+			item.Force = true
+
+			d := Evaluate(item, observed, now, cfg)
+			if d.Action != Skip || d.State != StateBlocked || d.Reason != "" {
+				t.Errorf("got %s (reason %q, state %q), want %s of a %s ad", d.Action, d.Reason, d.State, Skip, StateBlocked)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	minAge := uploaded(6)
+	minAge.NeverReuploadBeforeHours = 12
+	paged := uploaded(200)
+	paged.ReuploadPage, paged.AdsPerPage = 2, 25
+
+	tests := []struct {
+		name     string
+		item     Item
+		observed *Observed
+		explain  string
+	}{
+		{
+			name:    "new ad",
+			item:    Item{},
+			explain: "action=upload interval=0s",
+		},
+		{
+			name:    "not observed yet",
+			item:    uploaded(24),
+			explain: "action=observe age=24h0m0s contentChanged=false interval=168h0m0s uploadedAt=2026-09-30T12:00:00Z",
+		},
+		{
+			name:     "past its order",
+			item:     uploaded(24),
+			observed: &Observed{Order: 31},
+			explain:  "action=reupload age=24h0m0s ageTriggered=false contentChanged=false interval=168h0m0s missing=false order=31 orderThreshold=30 orderTriggered=true reason=order state=active uploadedAt=2026-09-30T12:00:00Z",
+		},
+		{
+			name:     "held back by the min age",
+			item:     minAge,
+			observed: &Observed{Order: 31},
+			explain:  "action=keep age=6h0m0s ageTriggered=false contentChanged=false interval=168h0m0s minAge=12h0m0s missing=false order=31 orderThreshold=30 orderTriggered=true reason=min age state=active uploadedAt=2026-10-01T06:00:00Z",
+		},
+		{
+			name:     "past its page",
+			item:     paged,
+			observed: &Observed{Order: 60},
+			explain:  "action=reupload age=200h0m0s ageTriggered=true contentChanged=false interval=168h0m0s missing=false order=60 orderThreshold=50 orderTriggered=true reason=order and age reuploadPage=2 state=active uploadedAt=2026-09-23T04:00:00Z",
+		},
+		{
+			name:     "missing while fresh",
+			item:     uploaded(2),
+			observed: &Observed{Missing: true},
+			explain:  "action=skip age=2h0m0s contentChanged=false interval=168h0m0s missing=true orderThreshold=30 state=pending moderation uploadedAt=2026-10-01T10:00:00Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Evaluate(tt.item, tt.observed, now, cfg)
+			got := d.Explain()
+			if got != tt.explain {
+				t.Errorf("explained\n%s\nwant\n%s", got, tt.explain)
+			}
+
+			// the explanation is the decision, not a summary of it
+			if !strings.HasPrefix(got, "action="+string(d.Action)) {
+				t.Errorf("explanation %q does not start with action %s", got, d.Action)
+			}
+			for k, v := range d.Fields() {
+				if pair := fmt.Sprintf("%s=%v", k, v); !strings.Contains(got, pair) {
+					t.Errorf("explanation %q misses %s", got, pair)
+				}
+			}
+			if d.Reason != "" && !strings.Contains(got, "reason="+d.Reason) {
+				t.Errorf("explanation %q misses reason %q", got, d.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	client "github.com/seniorescobar/bolha-client"
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
+)
+
+// history entries listed by describe
+const describeHistory = 20
+
+// ItemDescription explains what a run would do with a single item and why
+type ItemDescription struct {
+	Table   string `json:"table"`
+	AdTitle string `json:"adTitle"`
+
+	// every attribute as stored
+	Raw map[string]interface{} `json:"raw"`
+
+	Derived DerivedValues `json:"derived"`
+
+	// configuration after the item's overrides
+	Config           ItemConfig `json:"config"`
+	UnknownOverrides []string   `json:"unknownOverrides,omitempty"`
+
+	// status the item is held with before anything else, empty if not held
+	Held string `json:"held,omitempty"`
+	// validation error, categories are not checked
+	Invalid string `json:"invalid,omitempty"`
+	// AdUploadedAt is implausible, the decision treats it as unknown
+	UploadedAtAnomaly string `json:"uploadedAtAnomaly,omitempty"`
+
+	// the decision right now, see decision.Decision.Explain. Without live
+	// an uploaded item is only decided on a cached order, "observe" if none
+	Decision       string                 `json:"decision"`
+	DecisionFields map[string]interface{} `json:"decisionFields"`
+	DecisionSource string                 `json:"decisionSource,omitempty"`
+
+	// fresh GetActiveAd of the uploaded ad, live only
+	Live *LiveAd `json:"live,omitempty"`
+
+	// ids of the last runs which wrote the item, newest first, see runid.go
+	RecentRuns []string `json:"recentRuns"`
+
+	// newest first
+	History []HistoryEntry `json:"history"`
+}
+
+// DerivedValues are the values runs derive from the attributes of an item
+type DerivedValues struct {
+	Enabled        bool   `json:"enabled"`
+	Scheduled      bool   `json:"scheduled"`
+	PublishAt      string `json:"publishAt,omitempty"`
+	ExpiresAt      string `json:"expiresAt,omitempty"`
+	UploadedAt     string `json:"uploadedAt,omitempty"`
+	Age            string `json:"age,omitempty"`
+	NextEligibleAt string `json:"nextEligibleAt,omitempty"`
+	OrderThreshold int    `json:"orderThreshold"`
+	CooldownUntil  string `json:"cooldownUntil,omitempty"`
+	PriceType      string `json:"priceType"`
+	AdURL          string `json:"adUrl,omitempty"`
+
+	ContentHash    string   `json:"contentHash,omitempty"`
+	ContentChanged bool     `json:"contentChanged"`
+	Images         []string `json:"images"`
+}
+
+// LiveAd is what bolha reports about the uploaded ad
+type LiveAd struct {
+	Order   int    `json:"order,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HistoryEntry is a recorded event of an item
+type HistoryEntry struct {
+	At     string `json:"at"`
+	Event  string `json:"event"`
+	Detail string `json:"detail,omitempty"`
+
+	at time.Time
+}
+
+// describe explains the item adId of the table, adId is its title or the
+// id of its uploaded ad. Nothing is written and bolha is only asked with
+// live.
+func (m *monitor) describe(ctx context.Context, adId string, live bool) (*ItemDescription, error) {
+	if adId == "" {
+		return nil, fmt.Errorf("adId is required")
+	}
+
+	raw, err := m.findRawItem(ctx, adId)
+	if err != nil {
+		return nil, err
+	}
+	bItems, err := m.bolhaItems([]map[string]types.AttributeValue{raw})
+	if err != nil {
+		return nil, err
+	}
+	bItem := &bItems[0]
+
+	users, err := m.getUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applyUserImagePrefixes(bItems, users)
+
+	desc := &ItemDescription{Table: m.table, AdTitle: bItem.AdTitle}
+	if err := attributevalue.UnmarshalMap(raw, &desc.Raw); err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	switch {
+	case bItem.expired(now):
+		desc.Held = statusExpired
+	case !bItem.enabled():
+		desc.Held = statusDisabled
+	case users[bItem.UserId].paused():
+		desc.Held = statusUserPaused
+	case bItem.coolingDown(now):
+		desc.Held = statusCoolingDown
+	}
+
+	// categories are left out, checking them may refresh their cache
+	rules, err := m.loadValidationRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v := &validator{m: m, rules: rules}
+	if err := v.validate(ctx, bItem); err != nil {
+		desc.Invalid = err.Error()
+	}
+	desc.UploadedAtAnomaly = bItem.uploadedAtAnomaly
+	desc.Config, desc.UnknownOverrides, _ = m.cfg.forItem(bItem.Overrides)
+
+	hash, err := m.contentHash(ctx, bItem)
+	if err != nil {
+		m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("could not hash content")
+	}
+	item, err := bItem.decisionItem(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	desc.Derived = DerivedValues{
+		Enabled:        bItem.enabled(),
+		Scheduled:      bItem.scheduled(now),
+		NextEligibleAt: bItem.nextEligibleAt(now),
+		OrderThreshold: item.OrderThreshold(),
+		PriceType:      bItem.priceType(),
+		AdURL:          bItem.adURL(m.cfg),
+		ContentHash:    hash,
+		ContentChanged: hash != "" && bItem.AdContentHash != "" && hash != bItem.AdContentHash,
+		Images:         bItem.imageKeys,
+	}
+	if !item.PublishAt.IsZero() {
+		desc.Derived.PublishAt = bItem.displayRFC3339(item.PublishAt)
+	}
+	if !bItem.expiresAt.IsZero() {
+		desc.Derived.ExpiresAt = bItem.displayRFC3339(bItem.expiresAt)
+	}
+	if !item.UploadedAt.IsZero() {
+		desc.Derived.UploadedAt = bItem.displayRFC3339(item.UploadedAt)
+		desc.Derived.Age = now.Sub(item.UploadedAt).Round(time.Minute).String()
+	}
+	if until, ok := bItem.cooldownUntil(); ok {
+		desc.Derived.CooldownUntil = bItem.displayRFC3339(until)
+	}
+
+	dcfg := decision.Config{ModerationGrace: m.cfg.ModerationGrace}
+	d := decision.Evaluate(item, nil, now, dcfg)
+	if d.Action == decision.Observe || (live && item.UploadedId != 0) {
+		var observed *decision.Observed
+		if live {
+			desc.Live = m.describeLive(ctx, users, bItem)
+			if desc.Live.Error == "" {
+				observed = &decision.Observed{Order: desc.Live.Order, Missing: desc.Live.Missing}
+				desc.DecisionSource = decisionSourceLive
+			}
+		} else if order, ok := bItem.cachedOrder(now, item, m.cfg.OrderFreshness); ok {
+			observed = &decision.Observed{Order: order}
+			desc.DecisionSource = decisionSourceCache
+		}
+		if observed != nil {
+			d = decision.Evaluate(item, observed, now, dcfg)
+		}
+	}
+	desc.Decision = d.Explain()
+	desc.DecisionFields = d.Fields()
+
+	desc.RecentRuns = bItem.recentRuns()
+	desc.History = bItem.history(describeHistory)
+
+	return desc, nil
+}
+
+// findRawItem returns the raw item titled adId, or else the item whose
+// uploaded ad has the id adId
+func (m *monitor) findRawItem(ctx context.Context, adId string) (map[string]types.AttributeValue, error) {
+	item, err := m.getRawItem(ctx, adId)
+	var notFound *ItemNotFoundError
+	if err == nil || !errors.As(err, &notFound) {
+		return item, err
+	}
+	if _, perr := strconv.ParseInt(adId, 10, 64); perr != nil {
+		return nil, err
+	}
+
+	p := dynamodb.NewScanPaginator(m.ddb, &dynamodb.ScanInput{
+		TableName:                 aws.String(m.table),
+		FilterExpression:          aws.String("AdUploadedId = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":id": &types.AttributeValueMemberN{Value: adId}},
+	})
+	for p.HasMorePages() {
+		page, perr := p.NextPage(ctx)
+		if perr != nil {
+			return nil, m.tableError(perr)
+		}
+		if len(page.Items) > 0 {
+			return page.Items[0], nil
+		}
+	}
+
+	return nil, err
+}
+
+// describeLive asks bolha for the uploaded ad of bItem
+func (m *monitor) describeLive(ctx context.Context, users map[string]*BolhaUser, bItem *BolhaItem) *LiveAd {
+	if bItem.AdUploadedId == 0 {
+		return &LiveAd{Missing: true}
+	}
+
+	c, err := m.newUserClients(users).get(ctx, bItem)
+	if err != nil {
+		return &LiveAd{Error: err.Error()}
+	}
+	activeAd, err := c.GetActiveAd(ctx, bItem.AdUploadedId)
+	switch {
+	case err == client.ErrAdNotFound:
+		return &LiveAd{Missing: true}
+	case err != nil:
+		return &LiveAd{Error: err.Error()}
+	default:
+		return &LiveAd{Order: activeAd.Order}
+	}
+}
+
+// history returns the at most n latest events recorded on bItem
+func (bItem *BolhaItem) history(n int) []HistoryEntry {
+	entries := make([]HistoryEntry, 0)
+	add := func(at, event, detail string) {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return
+		}
+		entries = append(entries, HistoryEntry{At: bItem.displayRFC3339(t), Event: event, Detail: detail, at: t})
+	}
+
+	if bItem.AdUploadedId != 0 {
+		detail := fmt.Sprintf("ad %d", bItem.AdUploadedId)
+		if key := bItem.payloadKeyOf(bItem.AdUploadedId); key != "" {
+			detail += ", sent " + key
+		}
+		add(bItem.AdUploadedAt, "uploaded", detail)
+		add(bItem.AdUploadedRecordedAt, "upload recorded", fmt.Sprintf("ad %d", bItem.AdUploadedId))
+	}
+	for _, t := range bItem.ReuploadTimes {
+		add(t, "reuploaded", "")
+	}
+	add(bItem.LastCheckedAt, "checked", fmt.Sprintf("order %d", bItem.LastObservedOrder))
+	if bItem.ReuploadPhase != "" {
+		add(bItem.ReuploadPhaseAt, "phase", bItem.ReuploadPhase)
+	}
+	add(bItem.CooldownUntil, "cooldown ends", "rejected as duplicate")
+	for _, e := range bItem.RecentRuns {
+		if sec, runId, ok := strings.Cut(e, ":"); ok {
+			if n, err := strconv.ParseInt(sec, 10, 64); err == nil {
+				add(storedTime(time.Unix(n, 0)), "run", runId)
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].at.After(entries[b].at)
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	return entries
+}
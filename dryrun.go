@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	client "github.com/seniorescobar/bolha-client"
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
+)
+
+// PlannedAd is the ad an upload would send, listing image keys instead of images
+type PlannedAd struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Price       int      `json:"price"`
+	CategoryId  int      `json:"categoryId"`
+	Images      []string `json:"images"`
+}
+
+// planItem decides what processItem would do with bItem without changing
+// anything, the live ad is only looked at. Unfinished uploads are reported
+// as they would be resumed, resuming cannot be planned without bolha.
+func (m *monitor) planItem(ctx context.Context, clients *userClients, bItem *BolhaItem, ir *ItemReport) error {
+	m.log.WithField("AdTitle", bItem.AdTitle).Info("planning item...")
+
+	now := m.now()
+	dcfg := decision.Config{ModerationGrace: m.cfg.ModerationGrace}
+
+	item, err := bItem.decisionItem("")
+	if err != nil {
+		return err
+	}
+
+	d := decision.Evaluate(item, nil, now, dcfg)
+	switch d.Action {
+	case decision.Wait:
+		ir.Status = statusScheduled
+		return nil
+	case decision.Skip:
+		ir.Status = statusBlocked
+		return nil
+	}
+
+	if err := checkPrice(bItem, m.cfg.PriceChangeMaxPercent); err != nil {
+		ir.Status = statusPriceBlocked
+		return err
+	}
+
+	hash, err := m.contentHash(ctx, bItem)
+	if err != nil {
+		return err
+	}
+	item.ContentHash = hash
+
+	if bItem.ReuploadPhase != "" {
+		ir.ResumedPhase = bItem.ReuploadPhase
+		ir.Status = statusWouldResume
+		return nil
+	}
+
+	if d.Action == decision.Observe {
+		c, err := clients.get(ctx, bItem)
+		if err != nil {
+			return err
+		}
+
+		observed, err := m.observe(ctx, c, bItem, item, now, ir)
+		if err != nil {
+			return err
+		}
+		d = decision.Evaluate(item, observed, now, dcfg)
+		if observed.Missing {
+			ir.AdState = d.State
+		}
+	}
+
+	switch d.Action {
+	case decision.Skip:
+		ir.Status = statusPendingModeration
+		return nil
+	case decision.Upload:
+		ir.Status = statusWouldUpload
+	case decision.Reupload:
+		ir.Status = statusWouldReupload
+	default:
+		ir.Status = statusUnchanged
+		return nil
+	}
+	ir.Reason = d.Reason
+
+	fieldHashes, err := m.contentFieldHashes(ctx, bItem)
+	if err != nil {
+		return err
+	}
+	ir.Changes = changedContent(bItem.AdContentFieldHashes, fieldHashes)
+
+	ad := m.newClientAd(bItem, nil)
+	ir.PlannedAd = &PlannedAd{
+		Title:       ad.Title,
+		Description: ad.Description,
+		Price:       ad.Price,
+		CategoryId:  ad.CategoryId,
+		Images:      bItem.imageKeys,
+	}
+
+	return nil
+}
+
+// observe returns the cached or live order of the uploaded ad of bItem
+// without recording it
+func (m *monitor) observe(ctx context.Context, c *bolhaClient, bItem *BolhaItem, item decision.Item, now time.Time, ir *ItemReport) (*decision.Observed, error) {
+	if order, ok := bItem.cachedOrder(now, item, m.cfg.OrderFreshness); ok {
+		ir.Order = order
+		ir.DecisionSource = decisionSourceCache
+		return &decision.Observed{Order: order}, nil
+	}
+
+	activeAd, err := c.GetActiveAd(ctx, bItem.AdUploadedId)
+	if err == client.ErrAdNotFound {
+		return &decision.Observed{Missing: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ir.Order = activeAd.Order
+	ir.DecisionSource = decisionSourceLive
+
+	return &decision.Observed{Order: activeAd.Order}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Bolha rejects a repost it finds too similar to, or too soon after, an
+// earlier ad. Retrying only makes the account look worse, so the rejection
+// is never retried and the item cools down for DUPLICATE_COOLDOWN instead.
+// The bolha client does not type its upload errors, a rejection is told
+// by its message matching one of DUPLICATE_REJECTION_PATTERNS.
+
+// duplicateRejection returns err as an ErrDuplicateRejected if it is a
+// duplicate rejection, else err as is
+func (m *monitor) duplicateRejection(err error) error {
+	msg := strings.ToLower(err.Error())
+	for _, p := range m.cfg.DuplicateRejectionPatterns {
+		if strings.Contains(msg, p) {
+			return fmt.Errorf("%w: %v", ErrDuplicateRejected, err)
+		}
+	}
+	return err
+}
+
+// coolingDown reports whether bItem is not uploaded before its cooldown ends
+func (bItem *BolhaItem) coolingDown(now time.Time) bool {
+	until, ok := bItem.cooldownUntil()
+	return ok && now.Before(until)
+}
+
+// cooldownUntil returns the parsed CooldownUntil, false if unset or invalid
+func (bItem *BolhaItem) cooldownUntil() (time.Time, bool) {
+	if bItem.CooldownUntil == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, bItem.CooldownUntil)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// coolDown leaves bItem alone for DUPLICATE_COOLDOWN after bolha rejected it
+// as a duplicate and notifies so the ad can be spaced out more
+func (m *monitor) coolDown(ctx context.Context, writes *itemWrites, bItem *BolhaItem, ir *ItemReport, rejectErr error) {
+	until := m.now().Add(m.cfg.DuplicateCooldown)
+	m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "cooldownUntil": until}).WithError(rejectErr).Warn("ad rejected as duplicate, cooling down")
+
+	writes.set(bItem.AdTitle, "CooldownUntil", &types.AttributeValueMemberS{Value: storedTime(until)})
+	bItem.CooldownUntil = storedTime(until)
+
+	note := fmt.Sprintf("bolha rejected the ad as a duplicate, it is not uploaded again before %s, consider spacing it out more (ReuploadHours %d)",
+		m.cfg.displayTime(until), bItem.ReuploadHours)
+	ir.CooldownUntil = m.cfg.displayTime(until)
+	ir.Note = note
+
+	n := m.itemNotification(ctx, bItem,
+		notificationDuplicate,
+		fmt.Sprintf("%s rejected as duplicate", bItem.AdTitle),
+		note,
+	)
+	if err := m.notif.Notify(ctx, n); err != nil {
+		m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("could not notify duplicate rejection")
+	}
+}
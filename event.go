@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Events are checked against the fields each action reads before anything
+// runs: unknown fields (json field names are matched exactly, "dryrun" is
+// not "dryRun"), fields of other actions, unknown actions, modes and
+// roundings and fields that cannot be combined are all reported at once.
+// STRICT_EVENTS=false decodes events as before. Scheduled EventBridge
+// events and warmup pings are never checked, they run as they always did.
+
+// EventError lists every problem of an invalid event
+type EventError struct {
+	Status   int      `json:"status"`
+	Problems []string `json:"problems"`
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("invalid event: %s", strings.Join(e.Problems, "; "))
+}
+
+// eventFields are the fields each action reads besides action and table
+var eventFields = map[string][]string{
+	actionRun:               {"adTitle", "force", "dryRun", "canary", "mode", "resume", "continuation"},
+	actionVersion:           {},
+	actionExport:            {},
+	actionRestore:           {"key", "dryRun", "force", "confirmToken"},
+	actionImport:            {"bucket", "key"},
+	actionReconcile:         {"repair"},
+	actionSelfCheck:         {"bolha"},
+	actionGCImages:          {"dryRun", "confirmToken"},
+	actionEncrypt:           {"userId", "username", "password"},
+	actionRemoveAll:         {"userId", "confirm", "confirmToken"},
+	actionRefreshCategories: {},
+	actionAdjustPrices:      {"categoryId", "percent", "rounding", "dryRun"},
+	actionDescribe:          {"adId", "live"},
+	actionRetryFailed:       {"dryRun", "mode"},
+	actionFindDuplicates:    {},
+}
+
+// decodeEvent decodes payload into an Event, with strict it returns an
+// *EventError unless payload is a valid event
+func decodeEvent(payload json.RawMessage, strict bool) (Event, error) {
+	var event Event
+
+	var fields map[string]json.RawMessage
+	if !strict || json.Unmarshal(payload, &fields) != nil || passthroughEvent(fields) {
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return event, fmt.Errorf("invalid event: %v", err)
+		}
+		return event, nil
+	}
+
+	var problems []string
+
+	// fields are decoded one by one so every bad field is reported
+	v := reflect.ValueOf(&event).Elem()
+	index := eventFieldIndex()
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		i, ok := index[name]
+		if !ok {
+			problems = append(problems, unknownField(name, index))
+			continue
+		}
+		if err := json.Unmarshal(fields[name], v.Field(i).Addr().Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %v", name, err))
+		}
+	}
+
+	problems = append(problems, event.problems(v, index)...)
+
+	if len(problems) > 0 {
+		return event, &EventError{Status: http.StatusBadRequest, Problems: problems}
+	}
+	return event, nil
+}
+
+// problems returns what is wrong with the decoded fields of event
+func (event Event) problems(v reflect.Value, index map[string]int) []string {
+	action := event.Action
+	if action == "" {
+		action = actionRun
+	}
+	allowed, ok := eventFields[action]
+	if !ok {
+		actions := make([]string, 0, len(eventFields))
+		for a := range eventFields {
+			actions = append(actions, a)
+		}
+		sort.Strings(actions)
+		return []string{fmt.Sprintf("unknown action %q, expected one of %s", event.Action, strings.Join(actions, ", "))}
+	}
+
+	var problems []string
+
+	// a field left at its zero value is as good as not set
+	for name, i := range index {
+		if name == "action" || name == "table" || v.Field(i).IsZero() || slices.Contains(allowed, name) {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("%s is not used by %s", name, action))
+	}
+	sort.Strings(problems)
+
+	switch action {
+	case actionRetryFailed:
+		if _, err := runMode(event.Mode); err != nil {
+			problems = append(problems, err.Error())
+		}
+	case actionRun:
+		if _, err := runMode(event.Mode); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if event.Mode == runModeCheck && (event.Canary || event.DryRun) {
+			problems = append(problems, "check runs cannot be canary or dry runs")
+		}
+		if event.AdTitle != "" && (event.Canary || event.Continuation != nil) {
+			problems = append(problems, "runs of a single item cannot be canary runs or continued")
+		}
+		if event.Force && event.AdTitle == "" {
+			problems = append(problems, "force needs an adTitle")
+		}
+	case actionAdjustPrices:
+		if event.Rounding != "" && event.Rounding != roundingCents && event.Rounding != roundingEuros {
+			problems = append(problems, fmt.Sprintf("unknown rounding %q, expected %s or %s", event.Rounding, roundingCents, roundingEuros))
+		}
+	}
+
+	return problems
+}
+
+// passthroughEvent reports whether fields are those of a scheduled
+// EventBridge event or a warmup ping
+func passthroughEvent(fields map[string]json.RawMessage) bool {
+	var source, detailType string
+	json.Unmarshal(fields["source"], &source)
+	json.Unmarshal(fields["detail-type"], &detailType)
+
+	switch {
+	case source == "aws.events" && detailType == "Scheduled Event":
+		return true
+	case source == "serverless-plugin-warmup":
+		return true
+	}
+	_, warmup := fields["warmup"]
+	return warmup
+}
+
+// HELPERS
+
+// eventFieldIndex maps the json names of the fields of Event to their index
+func eventFieldIndex() map[string]int {
+	t := reflect.TypeOf(Event{})
+	index := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		index[name] = i
+	}
+	return index
+}
+
+// unknownField describes the unknown field name, suggesting the field it
+// differs from only in case
+func unknownField(name string, index map[string]int) string {
+	for known := range index {
+		if strings.EqualFold(name, known) {
+			return fmt.Sprintf("unknown field %q, did you mean %q", name, known)
+		}
+	}
+	return fmt.Sprintf("unknown field %q", name)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// With a time budget (SELF_CONTINUATION) the users of a table share what is
+// left of it, so a user with many items cannot starve the others. Every
+// user gets a slice in proportion to the TimeWeight of its user row. A user
+// whose items used up its slice starts no more items while other users
+// still have items within their slice, its items are left to the next
+// invocation. Users left items before they got their slice are owed the
+// difference, the next invocation starts their queues first.
+
+// timeSlices tracks the time the users of a table get and use, a nil
+// timeSlices lets every user start every item
+type timeSlices struct {
+	mu      sync.Mutex
+	slice   map[string]time.Duration
+	used    map[string]time.Duration
+	pending map[string]int
+}
+
+// newTimeSlices divides what is left of the budget of chain among the users
+// of queues, the queues run on at most workers goroutines, all at once if 0
+func (m *monitor) newTimeSlices(chain *runChain, bItems []BolhaItem, queues [][]int, users map[string]*BolhaUser, workers int) *timeSlices {
+	if chain == nil || len(queues) == 0 {
+		return nil
+	}
+	left := chain.budget.Sub(m.now())
+	if left <= 0 {
+		return nil
+	}
+
+	// queues run in parallel, every worker spends what is left
+	if workers <= 0 || workers > len(queues) {
+		workers = len(queues)
+	}
+	total := left * time.Duration(workers)
+
+	ts := &timeSlices{
+		slice:   make(map[string]time.Duration, len(queues)),
+		used:    make(map[string]time.Duration, len(queues)),
+		pending: make(map[string]int, len(queues)),
+	}
+	weights := make(map[string]int, len(queues))
+	sum := 0
+	for _, queue := range queues {
+		bItem := &bItems[queue[0]]
+		user := bItem.reportUser()
+		// queues are not keyed by the report user, count every queue of a user
+		if _, ok := weights[user]; !ok {
+			w := users[bItem.UserId].timeWeight()
+			weights[user] = w
+			sum += w
+		}
+		ts.pending[user] += len(queue)
+	}
+	for user, w := range weights {
+		ts.slice[user] = total * time.Duration(w) / time.Duration(sum)
+	}
+
+	m.log.WithFields(log.Fields{"left": left.String(), "workers": workers, "slices": ts.slice}).Info("divided time budget among users")
+
+	return ts
+}
+
+// start reports whether user may start another item, time no other user
+// is waiting for is not held back
+func (ts *timeSlices) start(user string) bool {
+	if ts == nil {
+		return true
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.used[user] < ts.slice[user] {
+		return true
+	}
+	for other, n := range ts.pending {
+		if other != user && n > 0 && ts.used[other] < ts.slice[other] {
+			return false
+		}
+	}
+	return true
+}
+
+// done records an item of user as run, it took d
+func (ts *timeSlices) done(user string, d time.Duration) {
+	if ts == nil {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.used[user] += d
+	ts.pending[user]--
+}
+
+// shortfall returns what is left of the slice of user
+func (ts *timeSlices) shortfall(user string) time.Duration {
+	if ts == nil {
+		return 0
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if d := ts.slice[user] - ts.used[user]; d > 0 {
+		return d
+	}
+	return 0
+}
+
+// timeWeight returns the share of the time budget of user relative to the
+// other users, 1 for unknown users (nil) and users without a TimeWeight
+func (user *BolhaUser) timeWeight() int {
+	if user == nil || user.TimeWeight <= 0 {
+		return 1
+	}
+	return user.TimeWeight
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// The small user's items are split over two queues around the heavy user's,
+// the heavy user is held back until all of them ran, so none is left to the
+// next invocation
+func TestTimeSlicesSmallUserProcessed(t *testing.T) {
+	const item = time.Minute
+
+	bItems := make([]BolhaItem, 0)
+	queue := func(user string, n int) []int {
+		q := make([]int, 0, n)
+		for i := 0; i < n; i++ {
+			q = append(q, len(bItems))
+			bItems = append(bItems, BolhaItem{AdTitle: fmt.Sprintf("%s %d", user, len(q)), UserId: user})
+		}
+		return q
+	}
+	queues := [][]int{queue("small", 3), queue("heavy", 50), queue("small", 1)}
+
+	// room for 10 items on a single worker, 5 per user
+	m := &monitor{log: runLogger("run-1"), clock: harness.NewClock(scenarioStart)}
+	chain := &runChain{budget: scenarioStart.Add(10 * item)}
+	slices := m.newTimeSlices(chain, bItems, queues, nil, 1)
+
+	ran := make(map[string]int)
+	left := make(map[string]int)
+	spent := time.Duration(0)
+	for _, q := range queues {
+		for _, i := range q {
+			user := bItems[i].reportUser()
+			if !slices.start(user) || spent+item > 10*item {
+				left[user]++
+				continue
+			}
+			spent += item
+			ran[user]++
+			slices.done(user, item)
+		}
+	}
+
+	if ran["small"] != 4 || left["small"] != 0 {
+		t.Errorf("small user ran %d items and left %d, want all 4 run", ran["small"], left["small"])
+	}
+	if ran["heavy"] != 5 {
+		t.Errorf("heavy user ran %d items, want its slice of 5", ran["heavy"])
+	}
+	if d := slices.shortfall("small"); d <= 0 {
+		t.Error("small user is owed nothing, want what it did not use of its slice")
+	}
+}
+
+// Every user gets one share of the budget however many queues it has
+func TestTimeSlicesShares(t *testing.T) {
+	bItems := []BolhaItem{
+		{AdTitle: "a1", UserId: "a"},
+		{AdTitle: "a2", UserId: "a"},
+		{AdTitle: "b1", UserId: "b"},
+	}
+	users := map[string]*BolhaUser{"b": {TimeWeight: 2}}
+	m := &monitor{log: runLogger("run-1"), clock: harness.NewClock(scenarioStart)}
+	chain := &runChain{budget: scenarioStart.Add(time.Hour)}
+
+	slices := m.newTimeSlices(chain, bItems, [][]int{{0}, {1}, {2}}, users, 1)
+	a, b := slices.shortfall("a"), slices.shortfall("b")
+	if a <= 19*time.Minute || a > 20*time.Minute || b <= 39*time.Minute || b > 40*time.Minute {
+		t.Errorf("slices of %s and %s, want a third and two thirds of an hour", a, b)
+	}
+	if slices.pending["a"] != 2 {
+		t.Errorf("a has %d pending items, want 2", slices.pending["a"])
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// window of the reupload count shown next to the average gain
+const reuploadStatsWindow = 30 * 24 * time.Hour
+
+// recordGain compares the order of a reuploaded ad at its first live check
+// with its order right before the removal, a positive gain means the ad
+// moved up. It reports false if no reupload is waiting to be measured.
+func (bItem *BolhaItem) recordGain(writes *itemWrites, order int) (int, bool) {
+	if bItem.OrderBeforeReupload <= 0 {
+		return 0, false
+	}
+
+	gain := bItem.OrderBeforeReupload - order
+	bItem.ReuploadGainSum += gain
+	bItem.ReuploadGainCount++
+	bItem.OrderBeforeReupload = 0
+
+	writes.add(bItem.AdTitle, "ReuploadGainSum", gain)
+	writes.add(bItem.AdTitle, "ReuploadGainCount", 1)
+	writes.set(bItem.AdTitle, "OrderBeforeReupload", &types.AttributeValueMemberN{Value: "0"})
+
+	writes.m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "gain": gain, "averageGain": bItem.averageGain()}).Info("reupload gain")
+
+	return gain, true
+}
+
+// recordReupload remembers the order of the removed ad and the time of the
+// reupload, reupload times older than the stats window are dropped
+func (bItem *BolhaItem) recordReupload(writes *itemWrites, order int, now time.Time) {
+	bItem.OrderBeforeReupload = order
+
+	times := make([]string, 0, len(bItem.ReuploadTimes)+1)
+	for _, t := range bItem.ReuploadTimes {
+		if at, err := time.Parse(time.RFC3339, t); err == nil && now.Sub(at) <= reuploadStatsWindow {
+			times = append(times, t)
+		}
+	}
+	times = append(times, storedTime(now))
+	bItem.ReuploadTimes = times
+
+	list := make([]types.AttributeValue, len(times))
+	for i, t := range times {
+		list[i] = &types.AttributeValueMemberS{Value: t}
+	}
+
+	writes.set(bItem.AdTitle, "OrderBeforeReupload", &types.AttributeValueMemberN{Value: strconv.Itoa(order)})
+	writes.set(bItem.AdTitle, "ReuploadTimes", &types.AttributeValueMemberL{Value: list})
+}
+
+// averageGain is the average gain of all measured reuploads
+func (bItem *BolhaItem) averageGain() float64 {
+	if bItem.ReuploadGainCount == 0 {
+		return 0
+	}
+	return float64(bItem.ReuploadGainSum) / float64(bItem.ReuploadGainCount)
+}
+
+// recentReuploads counts the reuploads within the stats window before now
+func (bItem *BolhaItem) recentReuploads(now time.Time) int {
+	n := 0
+	for _, t := range bItem.ReuploadTimes {
+		if at, err := time.Parse(time.RFC3339, t); err == nil && now.Sub(at) <= reuploadStatsWindow {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DeleteObjects accepts at most 1000 keys
+const deleteObjectsBatchSize = 1000
+
+// GCImagesResult lists the unreferenced objects removed from the images bucket
+type GCImagesResult struct {
+	StartedAt time.Time `json:"startedAt"`
+	Bucket    string    `json:"bucket"`
+	DryRun    bool      `json:"dryRun"`
+	MinAge    string    `json:"minAge"`
+
+	Scanned int `json:"scanned"`
+	// unreferenced objects which were (or with dryRun would be) removed
+	Removed []string `json:"removed"`
+	// unreferenced objects kept because they are younger than MinAge
+	TooYoung int `json:"tooYoung"`
+
+	Errors  []string    `json:"errors"`
+	Version VersionInfo `json:"version"`
+}
+
+// gcImages removes the objects of the primary images bucket which no item of
+// any table references and which are older than GC_IMAGES_MIN_AGE. Image
+// prefixes are referenced as a whole, as are descriptions and the configured
+// categories and validation rules objects.
+func (m *monitor) gcImages(ctx context.Context, dryRun bool) (*GCImagesResult, error) {
+	bucket := m.cfg.ImagesBuckets[0]
+
+	m.log.WithFields(log.Fields{"bucket": bucket, "dryRun": dryRun}).Info("collecting unreferenced images...")
+
+	result := &GCImagesResult{
+		StartedAt: m.now(),
+		Bucket:    bucket,
+		DryRun:    dryRun,
+		MinAge:    m.cfg.GCImagesMinAge.String(),
+		Removed:   make([]string, 0),
+		Errors:    make([]string, 0),
+		Version:   version,
+	}
+
+	// the tables share the images bucket
+	bItems := make([]BolhaItem, 0)
+	for _, t := range m.cfg.TableNames {
+		tItems, err := m.forTable(t).getBolhaItems(ctx)
+		if err != nil {
+			return nil, err
+		}
+		bItems = append(bItems, tItems...)
+	}
+	// empty tables would make every object unreferenced
+	if len(bItems) == 0 {
+		return nil, errors.New("refusing to collect images of empty tables")
+	}
+	// and so would the images of items which did not unmarshal
+	for _, bItem := range bItems {
+		if bItem.unmarshalErr != nil {
+			return nil, fmt.Errorf("refusing to collect images: %v", bItem.unmarshalErr)
+		}
+	}
+
+	users, err := m.getUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applyUserImagePrefixes(bItems, users)
+
+	keys := make(map[string]bool)
+	prefixes := make([]string, 0)
+	for _, k := range []string{m.cfg.CategoriesKey, m.cfg.ValidationRulesKey} {
+		if k != "" {
+			keys[k] = true
+		}
+	}
+	for _, bItem := range bItems {
+		for _, entry := range bItem.AdImages {
+			k, err := bItem.imageKey(entry)
+			if err != nil {
+				// an unresolvable image never protects anything
+				m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "image": entry}).WithError(err).Warn("ignoring invalid image")
+				continue
+			}
+			keys[k] = true
+		}
+		if bItem.AdImagesPrefix != "" {
+			prefixes = append(prefixes, bItem.AdImagesPrefix)
+		}
+		if bItem.AdDescriptionKey != "" {
+			keys[bItem.AdDescriptionKey] = true
+		}
+	}
+	referenced := func(key string) bool {
+		if keys[key] {
+			return true
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(key, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cutoff := result.StartedAt.Add(-m.cfg.GCImagesMinAge)
+
+	p := s3.NewListObjectsV2Paginator(m.s3, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			result.Scanned++
+
+			key := aws.ToString(obj.Key)
+			if referenced(key) {
+				continue
+			}
+			if obj.LastModified != nil && obj.LastModified.After(cutoff) {
+				result.TooYoung++
+				continue
+			}
+			result.Removed = append(result.Removed, key)
+		}
+	}
+	sort.Strings(result.Removed)
+
+	if !dryRun {
+		failed, err := m.deleteObjects(ctx, bucket, result.Removed)
+		if err != nil {
+			return nil, err
+		}
+		if len(failed) > 0 {
+			removed := make([]string, 0, len(result.Removed))
+			for _, key := range result.Removed {
+				if msg, ok := failed[key]; ok {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", key, msg))
+					continue
+				}
+				removed = append(removed, key)
+			}
+			result.Removed = removed
+		}
+	}
+
+	if err := m.saveReport(ctx, "gc-images", result.StartedAt, m.runId, result); err != nil {
+		m.log.WithError(err).Warn("could not save gc-images report")
+	}
+
+	m.log.WithFields(log.Fields{
+		"scanned":  result.Scanned,
+		"removed":  len(result.Removed),
+		"tooYoung": result.TooYoung,
+		"errors":   len(result.Errors),
+		"dryRun":   dryRun,
+	}).Info("images collected")
+
+	return result, nil
+}
+
+// S3
+
+// deleteObjects deletes keys in batches, returning the error message of
+// every key which could not be deleted
+func (m *monitor) deleteObjects(ctx context.Context, bucket string, keys []string) (map[string]string, error) {
+	failed := make(map[string]string)
+
+	for start := 0; start < len(keys); start += deleteObjectsBatchSize {
+		end := start + deleteObjectsBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		m.log.WithFields(log.Fields{"bucket": bucket, "objects": len(objects)}).Info("deleting objects...")
+
+		out, err := m.s3.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range out.Errors {
+			failed[aws.ToString(e.Key)] = aws.ToString(e.Message)
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errDuplicateAction is returned for a second destructive bolha action on
+// the same ad or item within an invocation
+var errDuplicateAction = errors.New("duplicate destructive action")
+
+// actionGuard remembers the destructive bolha actions of an invocation,
+// every removal of an ad and every upload of an item is claimed right
+// before the request is made. It is a last line of defence against bugs in
+// the run logic and is always on.
+type actionGuard struct {
+	mu sync.Mutex
+	// code path of the claim by action
+	claimed map[string]string
+	log     *log.Entry
+}
+
+func newActionGuard(logger *log.Entry) *actionGuard {
+	return &actionGuard{claimed: make(map[string]string), log: logger}
+}
+
+// claim reserves action, an action claimed before is refused. A failed
+// action must be released so it can be retried.
+func (g *actionGuard) claim(action string) error {
+	path := callerPath()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if first, ok := g.claimed[action]; ok {
+		g.log.WithFields(log.Fields{"action": action, "path": path, "firstPath": first}).Error("REFUSING DUPLICATE DESTRUCTIVE ACTION")
+		return fmt.Errorf("%w: %s already done by %s", errDuplicateAction, action, first)
+	}
+	g.claimed[action] = path
+
+	return nil
+}
+
+// release forgets a claim whose action failed
+func (g *actionGuard) release(action string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.claimed, action)
+}
+
+func removeAction(id int64) string {
+	return fmt.Sprintf("remove ad %d", id)
+}
+
+func uploadAction(bItem *BolhaItem) string {
+	return fmt.Sprintf("upload %s/%s", bItem.table, bItem.AdTitle)
+}
+
+// callerPath returns the functions which led to a claim, innermost first
+func callerPath() string {
+	pcs := make([]uintptr, 4)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	names := make([]string, 0, n)
+	for {
+		f, more := frames.Next()
+		names = append(names, strings.TrimPrefix(f.Function, "main."))
+		if !more {
+			break
+		}
+	}
+
+	return strings.Join(names, " < ")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	hookPhasePre  = "pre-reupload"
+	hookPhasePost = "post-reupload"
+)
+
+// HookPayload is sent to the reupload hooks of an item
+type HookPayload struct {
+	Phase         string    `json:"phase"`
+	Table         string    `json:"table"`
+	AdTitle       string    `json:"adTitle"`
+	OldUploadedId int64     `json:"oldUploadedId,omitempty"`
+	NewUploadedId int64     `json:"newUploadedId,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	RunId         string    `json:"runId"`
+}
+
+// hookFunctionName returns the function name of a function name or arn,
+// without its qualifier
+func hookFunctionName(arn string) string {
+	name := arn
+	if strings.HasPrefix(arn, "arn:") {
+		// arn:partition:lambda:region:account:function:name[:qualifier]
+		parts := strings.Split(arn, ":")
+		if len(parts) < 7 {
+			return ""
+		}
+		name = parts[6]
+	} else if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// validateHook rejects empty names and the monitor itself, a hook invoking
+// the monitor would run it recursively
+func validateHook(arn, self string) error {
+	name := hookFunctionName(arn)
+	if name == "" {
+		return fmt.Errorf("%q is not a function name or arn", arn)
+	}
+	if self != "" && name == self {
+		return fmt.Errorf("%q is the monitor itself", arn)
+	}
+	return nil
+}
+
+// callHook invokes the hook function arn synchronously within HOOK_TIMEOUT,
+// a function error fails the hook
+func (m *monitor) callHook(ctx context.Context, arn string, payload HookPayload) error {
+	if err := validateHook(arn, m.cfg.FunctionName); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	m.log.WithFields(log.Fields{"AdTitle": payload.AdTitle, "phase": payload.Phase, "function": arn}).Info("calling hook...")
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.HookTimeout)
+	defer cancel()
+
+	out, err := m.lambda.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(arn),
+		InvocationType: lambdatypes.InvocationTypeRequestResponse,
+		Payload:        b,
+	})
+	if err != nil {
+		return err
+	}
+	if out.FunctionError != nil {
+		return fmt.Errorf("hook %s failed: %s: %s", arn, aws.ToString(out.FunctionError), out.Payload)
+	}
+
+	m.log.WithFields(log.Fields{"AdTitle": payload.AdTitle, "phase": payload.Phase}).Info("hook called")
+
+	return nil
+}
+
+// preReuploadHook calls the pre-reupload hook of bItem, if any. The item
+// must not be reuploaded if it fails.
+func (m *monitor) preReuploadHook(ctx context.Context, bItem *BolhaItem) error {
+	if bItem.PreReuploadLambdaArn == "" {
+		return nil
+	}
+
+	return m.callHook(ctx, bItem.PreReuploadLambdaArn, HookPayload{
+		Phase:         hookPhasePre,
+		Table:         bItem.table,
+		AdTitle:       bItem.AdTitle,
+		OldUploadedId: bItem.AdUploadedId,
+		Timestamp:     m.now(),
+		RunId:         m.runId,
+	})
+}
+
+// postReuploadHook calls the post-reupload hook of bItem, if any. Failures
+// are logged and reported but never fail the item.
+func (m *monitor) postReuploadHook(ctx context.Context, bItem *BolhaItem, oldId, newId int64, ir *ItemReport) {
+	if bItem.PostReuploadLambdaArn == "" {
+		return
+	}
+
+	err := m.callHook(ctx, bItem.PostReuploadLambdaArn, HookPayload{
+		Phase:         hookPhasePost,
+		Table:         bItem.table,
+		AdTitle:       bItem.AdTitle,
+		OldUploadedId: oldId,
+		NewUploadedId: newId,
+		Timestamp:     m.now(),
+		RunId:         m.runId,
+	})
+	if err != nil {
+		m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("post-reupload hook failed")
+		ir.HookError = err.Error()
+	}
+}
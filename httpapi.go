@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The handler also answers HTTP requests of a Lambda function URL or an API
+// Gateway HTTP API (payload format 2.0):
+//
+//	POST /ads/{title}/refresh  runs only the item title, ?force=true
+//	                           reuploads it even if it is not due,
+//	                           ?dryRun=true only plans it, ?table= picks
+//	                           the table of BOLHA_TABLE_NAMES
+//	GET  /report/latest        the last report, ?kind= picks its kind
+//	GET  /version
+//
+// Requests must carry HTTP_TOKEN in an X-Monitor-Token header or as a
+// bearer token, other requests are answered with 401 before anything is
+// read.
+
+// HTTPRequest is the part of a payload format 2.0 request the handler
+// uses, aws-lambda-go has no type for it in the version this module is on
+type HTTPRequest struct {
+	Version               string             `json:"version"`
+	RawPath               string             `json:"rawPath"`
+	Headers               map[string]string  `json:"headers"`
+	QueryStringParameters map[string]string  `json:"queryStringParameters"`
+	RequestContext        HTTPRequestContext `json:"requestContext"`
+}
+
+type HTTPRequestContext struct {
+	RequestId string `json:"requestId"`
+	// only set for HTTP requests
+	HTTP *HTTPRequestDescription `json:"http"`
+}
+
+type HTTPRequestDescription struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	SourceIp string `json:"sourceIp"`
+}
+
+// HTTPResponse is a payload format 2.0 response
+type HTTPResponse struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// httpStatusError is answered with its status code
+type httpStatusError struct {
+	status int
+	msg    string
+}
+
+func (e *httpStatusError) Error() string {
+	return e.msg
+}
+
+// httpRequest returns payload as an HTTP request, false for other events
+func httpRequest(payload json.RawMessage) (*HTTPRequest, bool) {
+	var req HTTPRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, false
+	}
+	if req.RequestContext.HTTP == nil || req.RawPath == "" {
+		return nil, false
+	}
+	return &req, true
+}
+
+// handleHTTP answers req, errors are answered and never returned so the
+// caller gets a response instead of a bare 502
+func handleHTTP(ctx context.Context, req *HTTPRequest, svc *services, retries *retryCounts, usage *usageCounts, metrics *metricSet) *HTTPResponse {
+	method := req.RequestContext.HTTP.Method
+	logger := runLogger(runIdFrom(ctx)).WithFields(log.Fields{"method": method, "path": req.RawPath, "requestId": req.RequestContext.RequestId})
+	logger.Info("handling http request...")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.WithError(err).Error("invalid configuration")
+		return httpErrorResponse(http.StatusInternalServerError, err, nil)
+	}
+
+	// checked before the monitor is created, refused requests touch nothing
+	if !httpAuthorized(cfg, req) {
+		logger.WithField("sourceIp", req.RequestContext.HTTP.SourceIp).Warn("unauthorized http request")
+		return httpErrorResponse(http.StatusUnauthorized, errors.New("unauthorized"), nil)
+	}
+
+	out, err := routeHTTP(ctx, cfg, method, req.RawPath, req.QueryStringParameters, svc, retries, usage, metrics)
+	if err != nil {
+		// only the report of a run with failed items is worth returning
+		var runErr *RunError
+		if !errors.As(err, &runErr) {
+			out = nil
+		}
+		status := httpStatus(err)
+		logger.WithField("status", status).WithError(err).Error("http request failed")
+		return httpErrorResponse(status, err, out)
+	}
+
+	logger.Info("http request done")
+	return httpJSONResponse(http.StatusOK, out)
+}
+
+// httpAuthorized reports whether req carries HTTP_TOKEN, always false if
+// HTTP_TOKEN is not set
+func httpAuthorized(cfg *Config, req *HTTPRequest) bool {
+	if cfg.HTTPToken == "" {
+		return false
+	}
+
+	var token string
+	for name, v := range req.Headers {
+		switch {
+		case strings.EqualFold(name, "X-Monitor-Token"):
+			token = v
+		case strings.EqualFold(name, "Authorization") && token == "":
+			if len(v) > len("Bearer ") && strings.EqualFold(v[:len("Bearer ")], "Bearer ") {
+				token = v[len("Bearer "):]
+			}
+		}
+	}
+
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.HTTPToken)) == 1
+}
+
+// routeHTTP maps a request onto the actions of handle
+func routeHTTP(ctx context.Context, cfg *Config, method, path string, query map[string]string, svc *services, retries *retryCounts, usage *usageCounts, metrics *metricSet) (interface{}, error) {
+	switch {
+	case path == "/version":
+		if method != http.MethodGet {
+			return nil, errMethodNotAllowed(method, path)
+		}
+		return handle(ctx, Event{Action: actionVersion}, svc, retries, usage, metrics)
+
+	case path == "/report/latest":
+		if method != http.MethodGet {
+			return nil, errMethodNotAllowed(method, path)
+		}
+		kind := query["kind"]
+		if kind == "" {
+			kind = "run"
+		}
+		if strings.ContainsAny(kind, "/.") {
+			return nil, &httpStatusError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid report kind %q", kind)}
+		}
+		if cfg.ReportBucket == "" {
+			return nil, &httpStatusError{status: http.StatusNotFound, msg: "REPORT_BUCKET is not set"}
+		}
+		m, err := newMonitor(ctx, svc, retries, usage, metrics)
+		if err != nil {
+			return nil, err
+		}
+		return m.loadReportKey(ctx, latestReportKey(kind))
+
+	case strings.HasPrefix(path, "/ads/") && strings.HasSuffix(path, "/refresh"):
+		if method != http.MethodPost {
+			return nil, errMethodNotAllowed(method, path)
+		}
+		title, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "/ads/"), "/refresh"))
+		if err != nil || title == "" {
+			return nil, &httpStatusError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid ad title in %q", path)}
+		}
+		event := Event{Action: actionRun, Table: query["table"], AdTitle: title}
+		if event.Table != "" && !cfg.hasTable(event.Table) {
+			return nil, &httpStatusError{status: http.StatusBadRequest, msg: fmt.Sprintf("table %q is not in BOLHA_TABLE_NAMES", event.Table)}
+		}
+		if event.Force, err = queryBool(query, "force"); err != nil {
+			return nil, err
+		}
+		if event.DryRun, err = queryBool(query, "dryRun"); err != nil {
+			return nil, err
+		}
+		return handle(ctx, event, svc, retries, usage, metrics)
+
+	default:
+		return nil, &httpStatusError{status: http.StatusNotFound, msg: fmt.Sprintf("no route for %s %s", method, path)}
+	}
+}
+
+// HELPERS
+
+func errMethodNotAllowed(method, path string) error {
+	return &httpStatusError{status: http.StatusMethodNotAllowed, msg: fmt.Sprintf("%s is not allowed on %s", method, path)}
+}
+
+func queryBool(query map[string]string, name string) (bool, error) {
+	v, ok := query[name]
+	if !ok || v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &httpStatusError{status: http.StatusBadRequest, msg: fmt.Sprintf("invalid %s %q", name, v)}
+	}
+	return b, nil
+}
+
+// httpStatus is the status code err is answered with, failed items are a
+// failure of bolha and answered with 502
+func httpStatus(err error) int {
+	var statusErr *httpStatusError
+	var notFound *ItemNotFoundError
+	var runErr *RunError
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.status
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &runErr):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// httpErrorResponse answers err, out is added unless nil
+func httpErrorResponse(status int, err error, out interface{}) *HTTPResponse {
+	body := map[string]interface{}{
+		"error": err.Error(),
+		"build": version.String(),
+	}
+	if out != nil {
+		body["result"] = out
+	}
+	return httpJSONResponse(status, body)
+}
+
+func httpJSONResponse(status int, v interface{}) *HTTPResponse {
+	b, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return &HTTPResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(b),
+	}
+}
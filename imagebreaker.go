@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// When the images buckets fail but bolha works, items are still observed and
+// their state persisted, only uploads are held back. After
+// IMAGES_BREAKER_THRESHOLD consecutive outages of every images bucket the
+// breaker opens and images are not asked for again in the invocation, items
+// which would upload are deferred with statusDeferredImages instead.
+
+// ErrImagesUnavailable is returned for images while the breaker is open
+var ErrImagesUnavailable = errors.New("images unavailable")
+
+// imagesBreaker counts consecutive images outages of an invocation, a nil
+// imagesBreaker never opens
+type imagesBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	open      bool
+	log       *log.Entry
+}
+
+func newImagesBreaker(threshold int, logger *log.Entry) *imagesBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &imagesBreaker{threshold: threshold, log: logger}
+}
+
+// allow reports whether images may be asked for
+func (b *imagesBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.open
+}
+
+// record counts the outcome of asking for images, only outages count as
+// failures, an answer of any bucket resets the count
+func (b *imagesBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !imagesOutage(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if !b.open && b.failures >= b.threshold {
+		b.open = true
+		b.log.WithField("failures", b.failures).WithError(err).Warn("images unavailable, deferring uploads for the rest of the invocation")
+	}
+}
+
+// imagesOutage reports whether err means the images buckets are unavailable
+// rather than an object is missing or denied
+func imagesOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrImagesUnavailable) {
+		return true
+	}
+	var s3Err *S3Error
+	return errors.As(err, &s3Err) && (s3Err.Op == s3OpGet || s3Err.Op == s3OpList) && failover(s3Err.Err)
+}
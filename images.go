@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ImageError is a failed s3 operation on an image of an ad, it wraps an
+// S3Error so the error of the sdk is still reachable with errors.As
+type ImageError struct {
+	AdTitle string
+	// uploaded id of the ad, 0 if none
+	AdID int64
+	// position of the image in the list of the ad, from 1
+	Position int
+	Count    int
+	Err      error
+}
+
+func (e *ImageError) Error() string {
+	ad := fmt.Sprintf("ad %q", e.AdTitle)
+	if e.AdID != 0 {
+		ad += fmt.Sprintf(" (id %d)", e.AdID)
+	}
+	return fmt.Sprintf("%s image %d of %d: %v", ad, e.Position, e.Count, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+// s3Image streams the body of an image object to the bolha client, which
+// buffers it once more while building its request. The first read error is
+// kept since the client ignores failed image uploads. A body can only be
+// read once, every upload attempt opens its images anew.
+type s3Image struct {
+	key    string
+	bucket string
+	body   io.ReadCloser
+	err    error
+	once   sync.Once
+
+	// size of the object, -1 if s3 did not tell
+	length int64
+
+	// bytes read so far and their digest, see payload.go
+	size   int64
+	digest hash.Hash
+
+	// wraps errors with the ad and position of the image
+	wrap func(err error) error
+}
+
+func (img *s3Image) Read(p []byte) (int, error) {
+	n, err := img.body.Read(p)
+	img.size += int64(n)
+	img.digest.Write(p[:n])
+	if err != nil && err != io.EOF && img.err == nil {
+		img.err = img.wrap(&S3Error{Op: s3OpRead, Bucket: img.bucket, Key: img.key, Err: err})
+	}
+	return n, err
+}
+
+// Close closes the body, it is safe to call more than once
+func (img *s3Image) Close() error {
+	var err error
+	img.once.Do(func() {
+		err = img.body.Close()
+	})
+	return err
+}
+
+// sha256 returns the hex sha256 of the bytes read so far
+func (img *s3Image) sha256() string {
+	return hex.EncodeToString(img.digest.Sum(nil))
+}
+
+// closeS3Images closes all opened images
+func (m *monitor) closeS3Images(images []*s3Image) {
+	for _, img := range images {
+		if img == nil {
+			continue
+		}
+		if err := img.Close(); err != nil {
+			m.log.WithField("imgKey", img.key).WithError(err).Warn("could not close s3 image")
+		}
+	}
+}
+
+// s3ImagesErr returns the first error encountered while streaming images,
+// an image the client read only part of counts as failed
+func (m *monitor) s3ImagesErr(images []*s3Image) error {
+	for _, img := range images {
+		if img.err != nil {
+			return img.err
+		}
+		if img.length >= 0 && img.size != img.length {
+			m.log.WithFields(log.Fields{"imgKey": img.key, "streamed": img.size, "length": img.length}).Warn("image streamed incompletely")
+			return img.wrap(&S3Error{Op: s3OpRead, Bucket: img.bucket, Key: img.key, Err: fmt.Errorf("streamed %d of %d bytes", img.size, img.length)})
+		}
+	}
+	return nil
+}
+
+// imagePrefix returns the prefix relative image entries are joined with,
+// the prefix of the item before the one of its user
+func (bItem *BolhaItem) imagePrefix() string {
+	if bItem.ImagePrefix != "" {
+		return bItem.ImagePrefix
+	}
+	return bItem.userImagePrefix
+}
+
+// imageKey resolves an AdImages entry to a key of the images bucket.
+// Entries starting with / or s3:// are absolute, all others are relative to
+// the image prefix. Entries and prefixes must not contain "..".
+func (bItem *BolhaItem) imageKey(entry string) (string, error) {
+	var key string
+	switch {
+	case strings.HasPrefix(entry, "s3://"):
+		// s3://bucket/key, the bucket is always the images bucket
+		i := strings.Index(entry[len("s3://"):], "/")
+		if i < 0 {
+			return "", fmt.Errorf("image %q has no key", entry)
+		}
+		key = entry[len("s3://")+i+1:]
+	case strings.HasPrefix(entry, "/"):
+		key = strings.TrimLeft(entry, "/")
+	default:
+		key = entry
+		if prefix := bItem.imagePrefix(); prefix != "" {
+			if hasDotDot(prefix) {
+				return "", fmt.Errorf("image prefix %q contains ..", prefix)
+			}
+			key = strings.TrimSuffix(prefix, "/") + "/" + entry
+		}
+	}
+
+	if key == "" {
+		return "", fmt.Errorf("image %q has no key", entry)
+	}
+	if hasDotDot(key) {
+		return "", fmt.Errorf("image %q contains ..", entry)
+	}
+
+	return key, nil
+}
+
+// hasDotDot reports whether a path has a .. segment
+func hasDotDot(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// applyUserImagePrefixes gives the items the image prefix of their user
+func applyUserImagePrefixes(bItems []BolhaItem, users map[string]*BolhaUser) {
+	for i := range bItems {
+		if u, ok := users[bItems[i].UserId]; ok {
+			bItems[i].userImagePrefix = u.ImagePrefix
+		}
+	}
+}
+
+// S3
+
+// openS3Images opens all images in their initial order, if any of them
+// cannot be opened the others are closed again
+func (m *monitor) openS3Images(ctx context.Context, bItem *BolhaItem, images []string) ([]*s3Image, error) {
+	m.log.WithField("images", images).Info("opening s3 images...")
+
+	var wg sync.WaitGroup
+
+	errChan := make(chan error, len(images))
+
+	s3Images := make([]*s3Image, len(images))
+	for i, imgKey := range images {
+		i1, imgKey1 := i, imgKey
+		wrap := func(err error) error {
+			return &ImageError{AdTitle: bItem.AdTitle, AdID: bItem.AdUploadedId, Position: i1 + 1, Count: len(images), Err: err}
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			img, err := m.openS3Image(ctx, imgKey1, bItem.AdImageChecksums[imgKey1])
+			if err != nil {
+				errChan <- wrap(err)
+				return
+			}
+			img.wrap = wrap
+
+			s3Images[i1] = img
+		}()
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	for err := range errChan {
+		m.closeS3Images(s3Images)
+		return nil, err
+	}
+
+	return s3Images, nil
+}
+
+// openS3Image opens an image, with VERIFY_IMAGE_CHECKSUMS an image with a
+// known digest is read and verified before it is handed to the client
+func (m *monitor) openS3Image(ctx context.Context, imgKey, checksum string) (*s3Image, error) {
+	m.log.WithField("imgKey", imgKey).Info("opening s3 image...")
+
+	obj, bucket, err := m.getImagesObjectFrom(ctx, imgKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body := obj.Body
+	if m.cfg.VerifyImageChecksums {
+		if body, err = verifiedBody(checksum, obj); err != nil {
+			return nil, &S3Error{Op: s3OpVerify, Bucket: bucket, Key: imgKey, Err: err}
+		}
+	}
+
+	length := int64(-1)
+	if obj.ContentLength != nil {
+		length = *obj.ContentLength
+	}
+
+	return &s3Image{key: imgKey, bucket: bucket, body: body, length: length, digest: sha256.New()}, nil
+}
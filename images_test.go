@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// brokenImage fails getting key with err, the other objects are served
+type brokenImage struct {
+	*harness.Objects
+	key string
+	err error
+}
+
+func (o brokenImage) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if aws.ToString(params.Key) == o.key {
+		return nil, o.err
+	}
+	return o.Objects.GetObject(ctx, params, optFns...)
+}
+
+// s3ResponseError is err as the sdk returns it for a response with status
+func s3ResponseError(status int, err error) error {
+	return &smithy.OperationError{
+		ServiceID:     "S3",
+		OperationName: "GetObject",
+		Err: &awshttp.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+				Err:      err,
+			},
+			RequestID: "4442587FB7D0A2F9",
+		},
+	}
+}
+
+// A failed image names the ad, the image and the object, with the code and
+// message of the sdk error in place of the whole operation
+func TestImageErrorMessages(t *testing.T) {
+	const title = "Gorsko kolo"
+	const prefix = `ad "Gorsko kolo" (id 1000) image 2 of 2: s3 get s3://bolha-images/kolo/2.jpg: `
+
+	tests := []struct {
+		name  string
+		err   error
+		want  string
+		class string
+	}{
+		{
+			"no such key",
+			s3ResponseError(http.StatusNotFound, &s3types.NoSuchKey{Message: aws.String("The specified key does not exist.")}),
+			prefix + "NoSuchKey: The specified key does not exist.",
+			ClassAWS,
+		},
+		{
+			"no such bucket",
+			s3ResponseError(http.StatusNotFound, &s3types.NoSuchBucket{Message: aws.String("The specified bucket does not exist")}),
+			prefix + "NoSuchBucket: The specified bucket does not exist",
+			ClassAWS,
+		},
+		{
+			"access denied",
+			s3ResponseError(http.StatusForbidden, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}),
+			prefix + "AccessDenied: Access Denied",
+			ClassAWS,
+		},
+		{
+			"slow down",
+			s3ResponseError(http.StatusServiceUnavailable, &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}),
+			prefix + "SlowDown: Please reduce your request rate.",
+			ClassAWS,
+		},
+		{
+			"no code",
+			s3ResponseError(http.StatusForbidden, &smithy.GenericAPIError{Code: "Forbidden"}),
+			prefix + "Forbidden",
+			ClassAWS,
+		},
+		{
+			"no response",
+			errors.New("dial tcp: i/o timeout"),
+			prefix + "dial tcp: i/o timeout",
+			ClassOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScenario(t, "sinking", harness.Step{Orders: map[int64]int{1000: 40}})
+			svc := s.services()
+			svc.s3 = brokenImage{Objects: s.objects, key: "kolo/2.jpg", err: tt.err}
+
+			report, _ := s.runEvent(context.Background(), svc, "{}")
+			if report == nil {
+				t.Fatal("no report")
+			}
+			ir := itemReport(t, report, title)
+			if ir.Error != tt.want {
+				t.Errorf("error\n%s\nwant\n%s", ir.Error, tt.want)
+			}
+			if ir.ErrorClass != tt.class {
+				t.Errorf("error class %q, want %q", ir.ErrorClass, tt.class)
+			}
+		})
+	}
+}
+
+// The sdk error is still reachable through ImageError and S3Error
+func TestImageErrorUnwrap(t *testing.T) {
+	sdkErr := s3ResponseError(http.StatusNotFound, &s3types.NoSuchKey{Message: aws.String("The specified key does not exist.")})
+	var err error = &ImageError{
+		AdTitle:  "Gorsko kolo",
+		Position: 1,
+		Count:    6,
+		Err:      &S3Error{Op: s3OpGet, Bucket: "bolha-images", Key: "kolo/1.jpg", Err: sdkErr},
+	}
+
+	if want := `ad "Gorsko kolo" image 1 of 6: s3 get s3://bolha-images/kolo/1.jpg: NoSuchKey: The specified key does not exist.`; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+
+	var nsk *s3types.NoSuchKey
+	if !errors.As(err, &nsk) {
+		t.Error("NoSuchKey not reachable")
+	}
+	var re *awshttp.ResponseError
+	if !errors.As(err, &re) || re.HTTPStatusCode() != http.StatusNotFound {
+		t.Error("response error not reachable")
+	}
+	var s3err *S3Error
+	if !errors.As(err, &s3err) || s3err.Key != "kolo/1.jpg" {
+		t.Error("S3Error not reachable")
+	}
+}
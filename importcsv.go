@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// default column order used when the csv has no header row
+var csvColumns = []string{
+	"title",
+	"description",
+	"price",
+	"categoryId",
+	"images",
+	"reuploadHours",
+	"reuploadOrder",
+	"userRef",
+}
+
+// ImportResult summarizes a csv import
+type ImportResult struct {
+	Created []string         `json:"created"`
+	Skipped []ImportSkip     `json:"skipped"`
+	Invalid []ImportRowError `json:"invalid"`
+}
+
+// ImportSkip describes a valid row which was not written
+type ImportSkip struct {
+	Line    int    `json:"line"`
+	AdTitle string `json:"adTitle"`
+	Reason  string `json:"reason"`
+}
+
+// ImportRowError lists all validation errors of a single row
+type ImportRowError struct {
+	Line   int      `json:"line"`
+	Errors []string `json:"errors"`
+}
+
+// importCSV creates new items from the rows of a csv file in s3. The images
+// column holds either a prefix (ending with "/") or ";" separated image keys.
+func (m *monitor) importCSV(ctx context.Context, bucket, key string) (*ImportResult, error) {
+	if bucket == "" || key == "" {
+		return nil, errors.New("import-csv requires bucket and key")
+	}
+
+	m.log.WithFields(log.Fields{"bucket": bucket, "key": key}).Info("importing csv...")
+
+	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	r := csv.NewReader(obj.Body)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	result := &ImportResult{
+		Created: make([]string, 0),
+		Skipped: make([]ImportSkip, 0),
+		Invalid: make([]ImportRowError, 0),
+	}
+
+	columns := csvColumns
+	seen := make(map[string]bool)
+
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+
+		// header row defines the column order
+		if first && len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "title") {
+			if columns, err = csvHeader(record); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		bItem, errs := parseCSVRow(columns, record, m.cfg.UsersTableName != "")
+		if len(errs) > 0 {
+			result.Invalid = append(result.Invalid, ImportRowError{Line: line, Errors: errs})
+			continue
+		}
+
+		if seen[bItem.AdTitle] {
+			result.Skipped = append(result.Skipped, ImportSkip{Line: line, AdTitle: bItem.AdTitle, Reason: "duplicate title in csv"})
+			continue
+		}
+		seen[bItem.AdTitle] = true
+
+		created, err := m.putNewItem(ctx, bItem)
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			result.Skipped = append(result.Skipped, ImportSkip{Line: line, AdTitle: bItem.AdTitle, Reason: "item already exists"})
+			continue
+		}
+
+		result.Created = append(result.Created, bItem.AdTitle)
+	}
+
+	m.log.WithFields(log.Fields{
+		"created": len(result.Created),
+		"skipped": len(result.Skipped),
+		"invalid": len(result.Invalid),
+	}).Info("csv imported")
+
+	return result, nil
+}
+
+// HELPERS
+
+func csvHeader(record []string) ([]string, error) {
+	known := make(map[string]string, len(csvColumns))
+	for _, c := range csvColumns {
+		known[strings.ToLower(c)] = c
+	}
+
+	columns := make([]string, len(record))
+	for i, h := range record {
+		c, ok := known[strings.ToLower(strings.TrimSpace(h))]
+		if !ok {
+			return nil, fmt.Errorf("unknown csv column %q", h)
+		}
+		columns[i] = c
+	}
+
+	return columns, nil
+}
+
+// parseCSVRow converts a csv record into a new item, collecting all validation errors
+func parseCSVRow(columns, record []string, userIds bool) (*BolhaItem, []string) {
+	errs := make([]string, 0)
+
+	if len(record) != len(columns) {
+		errs = append(errs, fmt.Sprintf("expected %d fields, got %d", len(columns), len(record)))
+		return nil, errs
+	}
+
+	values := make(map[string]string, len(columns))
+	for i, c := range columns {
+		values[c] = strings.TrimSpace(record[i])
+	}
+
+	required := func(name string) string {
+		v := values[name]
+		if v == "" {
+			errs = append(errs, fmt.Sprintf("%s is required", name))
+		}
+		return v
+	}
+	number := func(name string, min int) int {
+		v := required(name)
+		if v == "" {
+			return 0
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %q is not a number", name, v))
+			return 0
+		}
+		if i < min {
+			errs = append(errs, fmt.Sprintf("%s must be at least %d", name, min))
+		}
+		return i
+	}
+
+	price := func(name string) Price {
+		v := required(name)
+		if v == "" {
+			return 0
+		}
+		p, err := parsePrice(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			return 0
+		}
+		return p
+	}
+
+	bItem := &BolhaItem{
+		AdTitle:       required("title"),
+		AdDescription: required("description"),
+		AdPrice:       price("price"),
+		AdCategoryId:  number("categoryId", 1),
+		ReuploadHours: number("reuploadHours", 1),
+		ReuploadOrder: number("reuploadOrder", 0),
+	}
+
+	// userRef is a user id when the users table is in use, a session id otherwise
+	if userRef := required("userRef"); userIds {
+		bItem.UserId = userRef
+	} else {
+		bItem.UserSessionId = userRef
+	}
+
+	if images := required("images"); strings.HasSuffix(images, "/") {
+		bItem.AdImagesPrefix = images
+	} else if images != "" {
+		for _, img := range strings.Split(images, ";") {
+			if img = strings.TrimSpace(img); img != "" {
+				bItem.AdImages = append(bItem.AdImages, img)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return bItem, nil
+}
+
+// putNewItem writes bItem unless an item with the same title already exists
+func (m *monitor) putNewItem(ctx context.Context, bItem *BolhaItem) (bool, error) {
+	item, err := attributevalue.MarshalMap(bItem)
+	if err != nil {
+		return false, err
+	}
+	if err := m.fitItem(ctx, item); err != nil {
+		return false, err
+	}
+
+	_, err = m.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(AdTitle)"),
+		TableName:           aws.String(m.table),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
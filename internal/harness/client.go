@@ -0,0 +1,236 @@
+package harness
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	client "github.com/seniorescobar/bolha-client"
+)
+
+// ErrSessionExpired is what a scripted step returns for an expired session
+var ErrSessionExpired = errors.New("session expired")
+
+// first id the Client gives an uploaded ad
+const firstAdId = 1000
+
+// Step scripts what bolha answers until the next step
+type Step struct {
+	// lists ads at these orders, listed ads not in Orders keep theirs
+	Orders map[int64]int
+
+	// active ads bolha stops listing, e.g. removed by moderation
+	Missing []int64
+
+	// error every call of a method returns, keyed by method name
+	// (GetActiveAd, GetActiveAds, UploadAd, RemoveAd)
+	Errors map[string]error
+
+	// errors the calls of a method return in turn before it answers as
+	// usual, a nil entry answers as usual
+	Sequence map[string][]error
+
+	// UploadAd publishes the ad but answers id 0, like a client which
+	// could not parse the answer of bolha
+	NoId bool
+
+	// every call advances the clock by Latency
+	Latency time.Duration
+}
+
+// Call is a recorded call of the Client
+type Call struct {
+	Step   int
+	Method string
+	Id     int64
+	Title  string
+	Err    error
+	// bytes read from each image of an upload, a reader reused from an
+	// earlier attempt reads 0
+	ImageBytes []int64
+}
+
+// Client is a scripted bolha client, it has the methods of the bolha client
+// the monitor uses. Uploaded ads are active at order 1 until a step says
+// otherwise.
+type Client struct {
+	mu     sync.Mutex
+	clock  *Clock
+	steps  []Step
+	step   int
+	nextId int64
+	active map[int64]*client.ActiveAd
+	// calls of each method within the current step
+	stepCalls map[string]int
+
+	// every call made, in order
+	Calls []Call
+	// every ad uploaded, in order
+	Uploaded []*client.Ad
+}
+
+// NewClient returns a Client at the first of steps, calls advance clock if
+// it is not nil
+func NewClient(clock *Clock, steps ...Step) *Client {
+	if len(steps) == 0 {
+		steps = []Step{{}}
+	}
+	c := &Client{
+		clock:     clock,
+		steps:     steps,
+		nextId:    firstAdId,
+		active:    make(map[int64]*client.ActiveAd),
+		stepCalls: make(map[string]int),
+	}
+	c.apply()
+	return c
+}
+
+// Activate lists ad id at order, e.g. an ad uploaded before the scenario
+func (c *Client) Activate(id int64, order int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.active[id] = &client.ActiveAd{Id: id, Order: order}
+	if id >= c.nextId {
+		c.nextId = id + 1
+	}
+}
+
+// Next moves on to the next step, the last step is kept once reached
+func (c *Client) Next() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.step < len(c.steps)-1 {
+		c.step++
+		c.stepCalls = make(map[string]int)
+		c.apply()
+	}
+}
+
+// Active returns the ids of the active ads
+func (c *Client) Active() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]int64, 0, len(c.active))
+	for id := range c.active {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return ids
+}
+
+func (c *Client) GetActiveAds() ([]*client.ActiveAd, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetActiveAds", 0, ""); err != nil {
+		return nil, err
+	}
+
+	ads := make([]*client.ActiveAd, 0, len(c.active))
+	for _, ad := range c.active {
+		ad1 := *ad
+		ads = append(ads, &ad1)
+	}
+	sort.Slice(ads, func(a, b int) bool { return ads[a].Order < ads[b].Order })
+	return ads, nil
+}
+
+func (c *Client) GetActiveAd(id int64) (*client.ActiveAd, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetActiveAd", id, ""); err != nil {
+		return nil, err
+	}
+
+	ad, ok := c.active[id]
+	if !ok {
+		return nil, client.ErrAdNotFound
+	}
+	ad1 := *ad
+	return &ad1, nil
+}
+
+// UploadAd reads every image before it answers, like bolha whose images
+// are uploaded before the ad is published
+func (c *Client) UploadAd(ad *client.Ad) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	imageBytes := make([]int64, len(ad.Images))
+	for i, img := range ad.Images {
+		imageBytes[i], _ = io.Copy(io.Discard, img)
+	}
+
+	err := c.call("UploadAd", 0, ad.Title)
+	c.Calls[len(c.Calls)-1].ImageBytes = imageBytes
+	if err != nil {
+		return 0, err
+	}
+
+	id := c.nextId
+	c.nextId++
+	c.active[id] = &client.ActiveAd{Id: id, Order: 1}
+	c.Uploaded = append(c.Uploaded, ad)
+	c.Calls[len(c.Calls)-1].Id = id
+
+	if c.steps[c.step].NoId {
+		return 0, nil
+	}
+	return id, nil
+}
+
+func (c *Client) RemoveAd(id int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RemoveAd", id, ""); err != nil {
+		return err
+	}
+
+	if _, ok := c.active[id]; !ok {
+		return client.ErrAdNotFound
+	}
+	delete(c.active, id)
+
+	return nil
+}
+
+// HELPERS
+
+// apply applies the orders and missing ads of the current step
+func (c *Client) apply() {
+	s := c.steps[c.step]
+	for id, order := range s.Orders {
+		c.active[id] = &client.ActiveAd{Id: id, Order: order}
+		if id >= c.nextId {
+			c.nextId = id + 1
+		}
+	}
+	for _, id := range s.Missing {
+		delete(c.active, id)
+	}
+}
+
+// call records a call of method and returns the error the step scripts
+func (c *Client) call(method string, id int64, title string) error {
+	s := c.steps[c.step]
+	if c.clock != nil && s.Latency > 0 {
+		c.clock.Advance(s.Latency)
+	}
+
+	err := s.Errors[method]
+	if n := c.stepCalls[method]; n < len(s.Sequence[method]) && err == nil {
+		err = s.Sequence[method][n]
+	}
+	c.stepCalls[method]++
+	c.Calls = append(c.Calls, Call{Step: c.step, Method: method, Id: id, Title: title, Err: err})
+
+	return err
+}
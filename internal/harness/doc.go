@@ -0,0 +1,23 @@
+// Package harness has in-memory stand-ins for what the monitor talks to, so
+// whole runs can be driven without aws or bolha: a Store serving the
+// dynamodb calls of the monitor, Objects serving its s3 calls, a scripted
+// Client in place of the bolha client and a Clock. The monitor is handed
+// them through its services, see scenario_test.go.
+//
+// The Store only understands the expressions the monitor writes: SET,
+// REMOVE and ADD of top-level attributes, and conditions and filters made
+// of attribute_exists, attribute_not_exists, = and <> joined by AND or OR.
+// Anything else fails loudly rather than silently doing something else.
+//
+// A scenario loads a yaml fixture into a Store, scripts the Client step by step
+// and runs the monitor once per step, advancing the Clock in between:
+//
+//	store := harness.NewStore()
+//	store.LoadFixture(f)
+//	clock := harness.NewClock(start)
+//	c := harness.NewClient(clock,
+//		harness.Step{Orders: map[int64]int{1000: 20}},
+//		harness.Step{Orders: map[int64]int{1000: 45}},
+//		harness.Step{Errors: map[string]error{"GetActiveAds": harness.ErrSessionExpired}},
+//	)
+package harness
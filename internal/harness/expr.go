@@ -0,0 +1,363 @@
+package harness
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var clauseRe = regexp.MustCompile(`\b(SET|REMOVE|ADD|DELETE)\b`)
+
+// expr evaluates the expressions of a single request
+type expr struct {
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+// check returns a ConditionalCheckFailedException if it is not nil and
+// cond does not hold for it, an empty cond always holds
+func (e *expr) check(cond string, it item) error {
+	ok, err := e.eval(cond, it)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	return nil
+}
+
+// eval reports whether cond holds for it, a missing item has no attributes
+func (e *expr) eval(cond string, it item) (bool, error) {
+	if strings.TrimSpace(cond) == "" {
+		return true, nil
+	}
+
+	for _, or := range splitTop(cond, " OR ") {
+		all := true
+		for _, term := range splitTop(or, " AND ") {
+			ok, err := e.term(strings.TrimSpace(term), it)
+			if err != nil {
+				return false, err
+			}
+			all = all && ok
+		}
+		if all {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (e *expr) term(term string, it item) (bool, error) {
+	if fn, arg, ok := call(term); ok {
+		name, err := e.path(arg)
+		if err != nil {
+			return false, err
+		}
+		_, exists := it[name]
+		switch fn {
+		case "attribute_exists":
+			return exists, nil
+		case "attribute_not_exists":
+			return !exists, nil
+		}
+		return false, fmt.Errorf("harness: unsupported function %s", fn)
+	}
+
+	for _, op := range []string{"<>", "="} {
+		if i := strings.Index(term, op); i >= 0 {
+			a, err := e.operand(strings.TrimSpace(term[:i]), it)
+			if err != nil {
+				return false, err
+			}
+			b, err := e.operand(strings.TrimSpace(term[i+len(op):]), it)
+			if err != nil {
+				return false, err
+			}
+			if op == "=" {
+				return equal(a, b), nil
+			}
+			return !equal(a, b), nil
+		}
+	}
+
+	return false, fmt.Errorf("harness: unsupported condition %q", term)
+}
+
+// update applies the update expression to it and returns the names of the
+// attributes it set or added to
+func (e *expr) update(expression string, it item) ([]string, error) {
+	var changed []string
+
+	locs := clauseRe.FindAllStringIndex(expression, -1)
+	if len(locs) == 0 || strings.TrimSpace(expression[:locs[0][0]]) != "" {
+		return nil, fmt.Errorf("harness: unsupported update expression %q", expression)
+	}
+
+	for i, loc := range locs {
+		end := len(expression)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		keyword := expression[loc[0]:loc[1]]
+
+		for _, action := range splitTop(expression[loc[1]:end], ",") {
+			action = strings.TrimSpace(action)
+
+			switch keyword {
+			case "SET":
+				eq := strings.Index(action, "=")
+				if eq < 0 {
+					return nil, fmt.Errorf("harness: unsupported set action %q", action)
+				}
+				name, err := e.path(strings.TrimSpace(action[:eq]))
+				if err != nil {
+					return nil, err
+				}
+				v, err := e.value(strings.TrimSpace(action[eq+1:]), it)
+				if err != nil {
+					return nil, err
+				}
+				it[name] = v
+				changed = append(changed, name)
+
+			case "REMOVE":
+				name, err := e.path(action)
+				if err != nil {
+					return nil, err
+				}
+				delete(it, name)
+
+			case "ADD":
+				fields := strings.Fields(action)
+				if len(fields) != 2 {
+					return nil, fmt.Errorf("harness: unsupported add action %q", action)
+				}
+				name, err := e.path(fields[0])
+				if err != nil {
+					return nil, err
+				}
+				delta, err := e.operand(fields[1], it)
+				if err != nil {
+					return nil, err
+				}
+				v, err := add(it[name], delta)
+				if err != nil {
+					return nil, fmt.Errorf("harness: add to %s: %v", name, err)
+				}
+				it[name] = v
+				changed = append(changed, name)
+
+			default:
+				return nil, fmt.Errorf("harness: unsupported clause %s", keyword)
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+// value evaluates the right hand side of a set action: an operand,
+// if_not_exists, list_append or a sum or difference of two of them
+func (e *expr) value(s string, it item) (types.AttributeValue, error) {
+	if i := indexTop(s, " + "); i >= 0 {
+		return e.arith(s[:i], s[i+3:], 1, it)
+	}
+	if i := indexTop(s, " - "); i >= 0 {
+		return e.arith(s[:i], s[i+3:], -1, it)
+	}
+
+	fn, args, ok := call(s)
+	if !ok {
+		return e.operand(s, it)
+	}
+	parts := splitTop(args, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("harness: unsupported %q", s)
+	}
+	a, err := e.value(strings.TrimSpace(parts[0]), it)
+	if err != nil {
+		return nil, err
+	}
+	b, err := e.value(strings.TrimSpace(parts[1]), it)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fn {
+	case "if_not_exists":
+		if a != nil {
+			return a, nil
+		}
+		return b, nil
+	case "list_append":
+		la, aok := a.(*types.AttributeValueMemberL)
+		lb, bok := b.(*types.AttributeValueMemberL)
+		if !aok || !bok {
+			return nil, fmt.Errorf("harness: list_append of a non list")
+		}
+		l := append(append([]types.AttributeValue{}, la.Value...), lb.Value...)
+		return &types.AttributeValueMemberL{Value: l}, nil
+	}
+
+	return nil, fmt.Errorf("harness: unsupported function %s", fn)
+}
+
+func (e *expr) arith(a, b string, sign int, it item) (types.AttributeValue, error) {
+	va, err := e.value(strings.TrimSpace(a), it)
+	if err != nil {
+		return nil, err
+	}
+	vb, err := e.value(strings.TrimSpace(b), it)
+	if err != nil {
+		return nil, err
+	}
+	na, aok := number(va)
+	nb, bok := number(vb)
+	if !aok || !bok {
+		return nil, fmt.Errorf("harness: arithmetic on a non number")
+	}
+	return formatNumber(na + float64(sign)*nb), nil
+}
+
+// operand returns the value of a placeholder or the attribute of it named by
+// a path, nil if the item does not have it
+func (e *expr) operand(s string, it item) (types.AttributeValue, error) {
+	if strings.HasPrefix(s, ":") {
+		v, ok := e.values[s]
+		if !ok {
+			return nil, fmt.Errorf("harness: missing value %s", s)
+		}
+		return v, nil
+	}
+	name, err := e.path(s)
+	if err != nil {
+		return nil, err
+	}
+	return it[name], nil
+}
+
+// path returns the attribute name of a top-level path
+func (e *expr) path(s string) (string, error) {
+	if strings.HasPrefix(s, "#") {
+		name, ok := e.names[s]
+		if !ok {
+			return "", fmt.Errorf("harness: missing name %s", s)
+		}
+		return name, nil
+	}
+	if s == "" || strings.ContainsAny(s, ".[] ()") {
+		return "", fmt.Errorf("harness: unsupported path %q", s)
+	}
+	return s, nil
+}
+
+// HELPERS
+
+// add adds delta to a number or to a set, v may be nil
+func add(v, delta types.AttributeValue) (types.AttributeValue, error) {
+	switch d := delta.(type) {
+	case *types.AttributeValueMemberN:
+		n, ok := number(v)
+		if v != nil && !ok {
+			return nil, fmt.Errorf("not a number")
+		}
+		dn, _ := number(d)
+		return formatNumber(n + dn), nil
+	case *types.AttributeValueMemberSS:
+		set := []string{}
+		if v != nil {
+			ss, ok := v.(*types.AttributeValueMemberSS)
+			if !ok {
+				return nil, fmt.Errorf("not a string set")
+			}
+			set = append(set, ss.Value...)
+		}
+		for _, s := range d.Value {
+			if !contains(set, s) {
+				set = append(set, s)
+			}
+		}
+		return &types.AttributeValueMemberSS{Value: set}, nil
+	}
+	return nil, fmt.Errorf("unsupported delta %T", delta)
+}
+
+func equal(a, b types.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if na, ok := number(a); ok {
+		nb, ok := number(b)
+		return ok && na == nb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v types.AttributeValue) (float64, bool) {
+	n, ok := v.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(n.Value, 64)
+	return f, err == nil
+}
+
+func formatNumber(f float64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatFloat(f, 'f', -1, 64)}
+}
+
+// call splits "fn(args)" into fn and args
+func call(s string) (string, string, bool) {
+	i := strings.Index(s, "(")
+	if i <= 0 || !strings.HasSuffix(s, ")") || indexTop(s, " ") >= 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1 : len(s)-1], true
+}
+
+// splitTop splits s at sep outside of parentheses
+func splitTop(s, sep string) []string {
+	var parts []string
+	for {
+		i := indexTop(s, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+len(sep):]
+	}
+}
+
+// indexTop is strings.Index outside of parentheses
+func indexTop(s, sub string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && strings.HasPrefix(s[i:], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
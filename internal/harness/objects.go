@@ -0,0 +1,168 @@
+package harness
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Objects is an in-memory s3, it has the methods of the s3 client the
+// monitor uses. Every bucket exists, listings are ordered by key and never
+// paged.
+type Objects struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// bodies of the next gets of an object end after half its bytes
+	short map[string]int
+
+	// number of gets of each object, keyed by bucket/key
+	Gets map[string]int
+}
+
+func NewObjects() *Objects {
+	return &Objects{
+		objects: make(map[string][]byte),
+		short:   make(map[string]int),
+		Gets:    make(map[string]int),
+	}
+}
+
+// Put stores body as key of bucket
+func (o *Objects) Put(bucket, key string, body []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.objects[bucket+"/"+key] = bytes.Clone(body)
+}
+
+// Get returns the object key of bucket, nil if there is none
+func (o *Objects) Get(bucket, key string) []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return bytes.Clone(o.objects[bucket+"/"+key])
+}
+
+// Keys returns the keys of bucket under prefix
+func (o *Objects) Keys(bucket, prefix string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.keys(bucket, prefix)
+}
+
+// Shorten makes the bodies of the next times gets of key end after half of
+// its bytes, like a connection dropped while streaming
+func (o *Objects) Shorten(bucket, key string, times int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.short[bucket+"/"+key] = times
+}
+
+func (o *Objects) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	name := aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key)
+	o.Gets[name]++
+	body, ok := o.objects[name]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key: " + name)}
+	}
+
+	sum := md5.Sum(body)
+	var r io.Reader = bytes.NewReader(body)
+	if o.short[name] > 0 {
+		o.short[name]--
+		r = io.MultiReader(bytes.NewReader(body[:len(body)/2]), errReader{errors.New("connection reset")})
+	}
+
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(r),
+		ContentLength: aws.Int64(int64(len(body))),
+		ETag:          aws.String(`"` + hex.EncodeToString(sum[:]) + `"`),
+	}, nil
+}
+
+func (o *Objects) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if params.Body != nil {
+		b, err := io.ReadAll(params.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	o.Put(aws.ToString(params.Bucket), aws.ToString(params.Key), body)
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (o *Objects) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	bucket := aws.ToString(params.Bucket)
+	out := &s3.ListObjectsV2Output{Name: params.Bucket}
+	for _, key := range o.keys(bucket, aws.ToString(params.Prefix)) {
+		out.Contents = append(out.Contents, types.Object{
+			Key:  aws.String(key),
+			Size: aws.Int64(int64(len(o.objects[bucket+"/"+key]))),
+		})
+	}
+	out.KeyCount = aws.Int32(int32(len(out.Contents)))
+
+	return out, nil
+}
+
+func (o *Objects) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (o *Objects) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	out := &s3.DeleteObjectsOutput{}
+	if params.Delete == nil {
+		return out, nil
+	}
+	for _, obj := range params.Delete.Objects {
+		delete(o.objects, aws.ToString(params.Bucket)+"/"+aws.ToString(obj.Key))
+		out.Deleted = append(out.Deleted, types.DeletedObject{Key: obj.Key})
+	}
+
+	return out, nil
+}
+
+// HELPERS
+
+func (o *Objects) keys(bucket, prefix string) []string {
+	keys := make([]string, 0)
+	for name := range o.objects {
+		if key, ok := strings.CutPrefix(name, bucket+"/"); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,379 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"gopkg.in/yaml.v3"
+)
+
+type item = map[string]types.AttributeValue
+
+type table struct {
+	key   string
+	items map[string]item
+}
+
+// Store is an in-memory dynamodb, it has the methods of the dynamodb client
+// the monitor uses. Scans return items ordered by key.
+type Store struct {
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+func NewStore() *Store {
+	return &Store{tables: make(map[string]*table)}
+}
+
+// AddTable creates an empty table whose items are keyed by attribute key
+func (s *Store) AddTable(name, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tables[name] = &table{key: key, items: make(map[string]item)}
+}
+
+// Fixture is the yaml a Store is loaded from, items are plain
+// maps
+type Fixture struct {
+	Tables []struct {
+		Name  string                   `yaml:"name"`
+		Key   string                   `yaml:"key"`
+		Items []map[string]interface{} `yaml:"items"`
+	} `yaml:"tables"`
+}
+
+// LoadFixture creates the tables of the fixture read from r and puts its
+// items, replacing tables of the same name
+func (s *Store) LoadFixture(r io.Reader) error {
+	var f Fixture
+	if err := yaml.NewDecoder(r).Decode(&f); err != nil {
+		return err
+	}
+
+	for _, t := range f.Tables {
+		if t.Name == "" || t.Key == "" {
+			return fmt.Errorf("fixture table needs a name and a key")
+		}
+		s.AddTable(t.Name, t.Key)
+		for _, v := range t.Items {
+			av, err := attributevalue.MarshalMap(v)
+			if err != nil {
+				return fmt.Errorf("%s: %v", t.Name, err)
+			}
+			if _, err := s.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String(t.Name), Item: av}); err != nil {
+				return fmt.Errorf("%s: %v", t.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Item returns the item of table with the key, nil if there is none
+func (s *Store) Item(tableName, key string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tables[tableName]
+	if !ok || t.items[key] == nil {
+		return nil
+	}
+	var v map[string]interface{}
+	if err := attributevalue.UnmarshalMap(t.items[key], &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// Items returns all items of table ordered by key
+func (s *Store) Items(tableName string) []map[string]interface{} {
+	s.mu.Lock()
+	keys := s.keys(tableName)
+	s.mu.Unlock()
+
+	items := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, s.Item(tableName, key))
+	}
+	return items
+}
+
+func (s *Store) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{
+		TableName:   params.TableName,
+		TableStatus: types.TableStatusActive,
+		ItemCount:   aws.Int64(int64(len(t.items))),
+		KeySchema:   []types.KeySchemaElement{{AttributeName: aws.String(t.key), KeyType: types.KeyTypeHash}},
+	}}, nil
+}
+
+func (s *Store) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	name := aws.ToString(params.TableName)
+
+	key := ""
+	for _, k := range params.KeySchema {
+		if k.KeyType == types.KeyTypeHash {
+			key = aws.ToString(k.AttributeName)
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("harness: table %s has no hash key", name)
+	}
+
+	s.mu.Lock()
+	_, exists := s.tables[name]
+	s.mu.Unlock()
+	if exists {
+		return nil, &types.ResourceInUseException{Message: aws.String("table already exists: " + name)}
+	}
+
+	s.AddTable(name, key)
+
+	return &dynamodb.CreateTableOutput{TableDescription: &types.TableDescription{TableName: params.TableName, TableStatus: types.TableStatusActive}}, nil
+}
+
+func (s *Store) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.GetItemOutput{Item: copyItem(t.items[key])}, nil
+}
+
+func (s *Store) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(params.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &expr{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+	if err := e.check(aws.ToString(params.ConditionExpression), t.items[key]); err != nil {
+		return nil, err
+	}
+
+	t.items[key] = copyItem(params.Item)
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (s *Store) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &expr{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+	if err := e.check(aws.ToString(params.ConditionExpression), t.items[key]); err != nil {
+		return nil, err
+	}
+
+	// an update creates the item if it does not exist
+	updated := copyItem(t.items[key])
+	if updated == nil {
+		updated = copyItem(params.Key)
+	}
+	changed, err := e.update(aws.ToString(params.UpdateExpression), updated)
+	if err != nil {
+		return nil, err
+	}
+	t.items[key] = updated
+
+	out := &dynamodb.UpdateItemOutput{}
+	switch params.ReturnValues {
+	case types.ReturnValueAllNew:
+		out.Attributes = copyItem(updated)
+	case types.ReturnValueUpdatedNew:
+		out.Attributes = make(item, len(changed))
+		for _, name := range changed {
+			if v, ok := updated[name]; ok {
+				out.Attributes[name] = v
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (s *Store) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, requests := range params.RequestItems {
+		t, err := s.table(aws.String(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range requests {
+			switch {
+			case r.PutRequest != nil:
+				key, err := t.keyOf(r.PutRequest.Item)
+				if err != nil {
+					return nil, err
+				}
+				t.items[key] = copyItem(r.PutRequest.Item)
+			case r.DeleteRequest != nil:
+				key, err := t.keyOf(r.DeleteRequest.Key)
+				if err != nil {
+					return nil, err
+				}
+				delete(t.items, key)
+			}
+		}
+	}
+
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (s *Store) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := &dynamodb.BatchGetItemOutput{Responses: make(map[string][]map[string]types.AttributeValue)}
+	for name, ka := range params.RequestItems {
+		t, err := s.table(aws.String(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ka.Keys {
+			key, err := t.keyOf(k)
+			if err != nil {
+				return nil, err
+			}
+			if it := t.items[key]; it != nil {
+				out.Responses[name] = append(out.Responses[name], copyItem(it))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (s *Store) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	start := ""
+	if params.ExclusiveStartKey != nil {
+		if start, err = t.keyOf(params.ExclusiveStartKey); err != nil {
+			return nil, err
+		}
+	}
+	limit := int(aws.ToInt32(params.Limit))
+
+	e := &expr{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+	filter := aws.ToString(params.FilterExpression)
+
+	out := &dynamodb.ScanOutput{}
+	for _, key := range s.keys(aws.ToString(params.TableName)) {
+		if start != "" && key <= start {
+			continue
+		}
+		// the limit counts items read, not items returned
+		if limit > 0 && int(out.ScannedCount) == limit {
+			out.LastEvaluatedKey = map[string]types.AttributeValue{t.key: t.items[start][t.key]}
+			break
+		}
+		out.ScannedCount++
+		start = key
+
+		ok, err := e.eval(filter, t.items[key])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out.Items = append(out.Items, copyItem(t.items[key]))
+		}
+	}
+	out.Count = int32(len(out.Items))
+
+	return out, nil
+}
+
+// HELPERS
+
+func (s *Store) table(name *string) (*table, error) {
+	t, ok := s.tables[aws.ToString(name)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("table not found: " + aws.ToString(name))}
+	}
+	return t, nil
+}
+
+func (s *Store) keys(tableName string) []string {
+	t, ok := s.tables[tableName]
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(t.items))
+	for key := range t.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keyOf returns the key of item, which must have the key attribute
+func (t *table) keyOf(it item) (string, error) {
+	switch v := it[t.key].(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value, nil
+	case *types.AttributeValueMemberN:
+		return v.Value, nil
+	default:
+		return "", fmt.Errorf("harness: missing key %s", t.key)
+	}
+}
+
+func copyItem(it item) item {
+	if it == nil {
+		return nil
+	}
+	c := make(item, len(it))
+	for name, v := range it {
+		c[name] = v
+	}
+	return c
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// due reports whether bItem has to be uploaded or is old enough to be
+// reuploaded. The order of live ads is only known after asking bolha, so
+// items reuploaded because of their order are not considered due.
+func (bItem *BolhaItem) due(now time.Time) bool {
+	if bItem.AdUploadedId == 0 || bItem.UploadPending || bItem.ReuploadPhase != "" || bItem.uploadedAtAnomaly != "" {
+		return true
+	}
+
+	uploadedAt, err := time.Parse(time.RFC3339, bItem.AdUploadedAt)
+	if err != nil {
+		return true
+	}
+	return now.Sub(uploadedAt) > time.Duration(bItem.ReuploadHours)*time.Hour
+}
+
+// overdue reports whether the uploaded ad of bItem reached its
+// ForceReuploadAfterHours, such an item is neither held back by
+// MAX_ITEMS_PER_RUN nor by the time slices of its user
+func (bItem *BolhaItem) overdue(now time.Time) bool {
+	item, err := bItem.decisionItem("")
+	return err == nil && item.Overdue(now)
+}
+
+// deferItems returns the status of every eligible item which must not be
+// processed this run. At most max items (0 is unlimited, negative none) are
+// processed, pending uploads first and the others in the order of sel, see
+// selection.go. A canary run processes only the least risky due item, the
+// one with the fewest images and no failures.
+func (m *monitor) deferItems(bItems []BolhaItem, max int, canary bool, sel *itemSelector, eligible func(i int) bool, now time.Time) []string {
+	deferred := make([]string, len(bItems))
+
+	idxs := make([]int, 0, len(bItems))
+	for i := range bItems {
+		if eligible(i) {
+			idxs = append(idxs, i)
+		}
+	}
+
+	if canary {
+		pick := -1
+		for _, i := range idxs {
+			bItem := &bItems[i]
+			if !bItem.due(now) || bItem.FailCount > 0 || bItem.NeedsAttention {
+				continue
+			}
+			if pick == -1 || canaryLess(bItem, &bItems[pick]) {
+				pick = i
+			}
+		}
+
+		for _, i := range idxs {
+			if i != pick {
+				deferred[i] = statusDeferredCanary
+			}
+		}
+		if pick == -1 {
+			m.log.Warn("no item qualifies for the canary run")
+		} else {
+			m.log.WithField("AdTitle", bItems[pick].AdTitle).Info("canary item")
+		}
+
+		return deferred
+	}
+
+	if max == 0 || len(idxs) <= max {
+		return deferred
+	}
+	if max < 0 {
+		max = 0
+	}
+
+	sel.order(bItems, idxs, now)
+
+	for _, i := range idxs[max:] {
+		if bItems[i].overdue(now) {
+			m.log.WithField("AdTitle", bItems[i].AdTitle).Info("item past its maximum age, not deferred")
+			continue
+		}
+		deferred[i] = statusDeferredItemLimit
+		m.log.WithFields(log.Fields{"AdTitle": bItems[i].AdTitle, "maxItemsPerRun": max}).Info("item deferred")
+	}
+
+	return deferred
+}
+
+// canaryLess orders canary candidates by the number of images, then by title
+func canaryLess(a, b *BolhaItem) bool {
+	if len(a.imageKeys) != len(b.imageKeys) {
+		return len(a.imageKeys) < len(b.imageKeys)
+	}
+	return a.AdTitle < b.AdTitle
+}
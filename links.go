@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const defaultAdURLPattern = "https://www.bolha.com/?ad=%d"
+
+// adURL returns the public url of an uploaded ad, empty if not uploaded
+func (c *Config) adURL(adUploadedId int64) string {
+	if adUploadedId == 0 {
+		return ""
+	}
+	return fmt.Sprintf(c.AdURLPattern, adUploadedId)
+}
+
+// adURL returns the recorded url of the ad of bItem, the one built from
+// AD_URL_PATTERN for rows without one
+func (bItem *BolhaItem) adURL(c *Config) string {
+	if bItem.AdUploadedId != 0 && bItem.AdUploadedUrl != "" {
+		return bItem.AdUploadedUrl
+	}
+	return c.adURL(bItem.AdUploadedId)
+}
+
+// imageURL returns a pre-signed url of the first image of bItem, empty if
+// disabled or the item has no images
+func (m *monitor) imageURL(ctx context.Context, bItem *BolhaItem) string {
+	if m.presign == nil {
+		return ""
+	}
+
+	images, err := m.resolveImages(ctx, bItem)
+	if err != nil || len(images) == 0 {
+		return ""
+	}
+
+	req, err := m.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.cfg.ImagesBuckets[0]),
+		Key:    aws.String(images[0]),
+	}, s3.WithPresignExpires(m.cfg.ImageURLExpiry))
+	if err != nil {
+		m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("could not pre-sign image url")
+		return ""
+	}
+
+	return req.URL
+}
+
+// itemNotification is a notification about bItem linking its ad and first image
+func (m *monitor) itemNotification(ctx context.Context, bItem *BolhaItem, kind, subject, message string) Notification {
+	n := newNotification(kind, subject, message)
+	n.AdURL = bItem.adURL(m.cfg)
+	n.ImageURL = m.imageURL(ctx, bItem)
+	n.User = bItem.reportUser()
+
+	return n
+}
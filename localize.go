@@ -0,0 +1,31 @@
+package main
+
+// Items listed in more than one language keep their texts in Localized by
+// language, AdLanguage selects the one uploaded. An empty title or
+// description of the selected language falls back to AdTitle and the
+// description of the item, AdTitle stays the key of the item whatever
+// language it is uploaded in.
+
+// LocalizedText is the text of an item in one language
+type LocalizedText struct {
+	Title       string `dynamodbav:"title" json:"title"`
+	Description string `dynamodbav:"description" json:"description"`
+}
+
+// localized returns the text of the selected language, false if no
+// language is selected or the item lacks it
+func (bItem *BolhaItem) localized() (LocalizedText, bool) {
+	if bItem.AdLanguage == "" {
+		return LocalizedText{}, false
+	}
+	text, ok := bItem.Localized[bItem.AdLanguage]
+	return text, ok
+}
+
+// uploadTitle is the title the ad of bItem is uploaded with
+func (bItem *BolhaItem) uploadTitle() string {
+	if text, ok := bItem.localized(); ok && text.Title != "" {
+		return text.Title
+	}
+	return bItem.AdTitle
+}
@@ -1,299 +1,1885 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	client "github.com/seniorescobar/bolha-client"
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
 
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	tableName      = "Bolha"
-	s3ImagesBucket = "bolha-images"
-)
+	defaultTableName = "Bolha"
+	s3ImagesBucket   = "bolha-images"
 
-var (
-	ddbc *dynamodb.DynamoDB
-	s3d  *s3manager.Downloader
+	uploadRetryDelay = 2 * time.Second
 )
 
 type BolhaItem struct {
 	AdTitle       string
 	AdDescription string
-	AdPrice       int
+	AdPrice       Price
 	AdCategoryId  int
 	AdImages      []string
 
-	AdUploadedId int64
-	AdUploadedAt string
+	// s3 key of the description, preferred over AdDescription if set
+	AdDescriptionKey string
+	// key in OFFLOAD_BUCKET of a description too large for the item, used
+	// while AdDescription is empty, see offload.go
+	AdDescriptionOffloadKey string
+
+	// texts by language and the language uploaded, the top-level title and
+	// description if empty, see localize.go
+	Localized  map[string]LocalizedText
+	AdLanguage string
+
+	// upload a variation of the description every time, ending with the
+	// next of DescriptionClosings, see mutate.go
+	MutateDescription   bool
+	DescriptionClosings []string
+
+	// "fixed" (default), "negotiable" or "free"
+	AdPriceType string
+
+	// used instead of AdImages if set, all objects under the prefix ordered by key
+	AdImagesPrefix string
+	// optional hex sha256 of images by image key, verified before upload
+	AdImageChecksums map[string]string
+
+	// key of an mp4 clip in the images bucket, the bolha client cannot
+	// attach videos yet so items with one are invalid
+	AdVideo string
+
+	// prefix relative AdImages entries are joined with, the user's
+	// ImagePrefix if empty, see images.go
+	ImagePrefix string
 
+	// optional listing details, "new" or "used"
+	AdCondition string
+	AdShipping  []string
+	AdLocation  string
+
+	AdUploadedId int64
+	// public url of the uploaded ad, preferred over AD_URL_PATTERN. The
+	// bolha client returns none so it is only ever set by hand, every upload
+	// removes it as it belonged to the previous ad.
+	AdUploadedUrl string
+	// when bolha accepted the upload, and when the upload was recorded
+	AdUploadedAt         string
+	AdUploadedRecordedAt string
+	// number of recorded uploads, initial and reuploads
+	AdUploadCount int
+	// hash of the content the active ad was uploaded with, and of each of its fields
+	AdContentHash        string
+	AdContentFieldHashes map[string]string
+
+	// state of the uploaded ad as last observed, "blocked" stops processing
+	AdState string
+
+	// order of the active ad at the last live check
+	LastObservedOrder int
+	LastCheckedAt     string
+	// last observed orders, see orderhistory.go
+	OrderHistory []string
+	// keys of the saved ads of the last uploads, oldest first, see payload.go
+	AdPayloadKeys []string
+
+	// order of the ad before its last reupload until the gain is measured
+	OrderBeforeReupload int
+	// sum and number of measured reupload gains
+	ReuploadGainSum   int
+	ReuploadGainCount int
+	// RFC3339 times of the reuploads within the last 30 days
+	ReuploadTimes []string
+
+	// owner of the item in the users table, legacy items use UserSessionId instead
+	UserId        string
 	UserSessionId string
 
 	ReuploadHours int
 	ReuploadOrder int
+	// reupload once the ad falls off this page instead of after ReuploadOrder
+	ReuploadPage int
+	// hard age limits of the active ad in hours, see decision.Item
+	ForceReuploadAfterHours  int
+	NeverReuploadBeforeHours int
+
+	// price of the last upload
+	AdUploadedPrice Price
+	// optional bounds guarding against typos in AdPrice
+	ExpectedPriceRange *PriceRange
+	// allows a price change above PRICE_CHANGE_MAX_PERCENT, cleared on upload
+	ConfirmPriceChange bool
+
+	// RFC3339 time before which a new ad is not uploaded
+	PublishAt string
+
+	// items with higher priority get free slots first
+	Priority int
+	// RFC3339 time the item was last picked over others, see selection.go
+	LastSelectedAt string
+
+	// run id of the last write and "<unix seconds>:<run id>" of the last
+	// runs which wrote the item, see runid.go
+	LastModifiedByRun string
+	RecentRuns        []string
+
+	FailCount      int
+	NeedsAttention bool
+
+	// RFC3339 time before which the item is left alone after bolha rejected
+	// it as a duplicate, see duplicate.go
+	CooldownUntil string
+
+	// set when the ad was removed but could not be uploaded again, the hash
+	// is the content hash of the failed upload
+	UploadPending     bool
+	UploadPendingHash string
+
+	// phase of an unfinished upload, empty when idle, see phase.go
+	ReuploadPhase   string
+	ReuploadPhaseAt string
+	// ad being replaced until it is removed, and the uploaded ad until it is recorded
+	ReuploadOldId int64
+	ReuploadNewId int64
+
+	// config keys (env var names) overriding the global configuration for this item
+	Overrides map[string]string
+
+	// optional url notified of every new uploaded id, see webhook.go
+	WebhookURL string
+
+	// optional functions invoked before and after a reupload, see hooks.go
+	PreReuploadLambdaArn  string
+	PostReuploadLambdaArn string
+
+	// runs leave disabled items alone, unset is enabled, see remove-all
+	Enabled *bool
+
+	// how an old ad is refreshed, "repost" (default) or "bump". The bolha
+	// client cannot promote ads, bump items are only run with
+	// FallbackToRepost and reposted.
+	RefreshStrategy  string
+	FallbackToRepost bool
+
+	// table the item was read from
+	table string
+	// ADS_PER_PAGE of the table's configuration
+	adsPerPage int
+	// DISPLAY_TIMEZONE, PublishAt without a zone is read in it
+	loc *time.Location
+	// reupload the active ad even if it is not due, see target.go
+	force bool
+
+	// set if the item could not be unmarshaled, only AdTitle is set then
+	unmarshalErr error
+
+	// estimated size of the stored item, see itemSize
+	size int
+
+	// time of the ttl attribute (TTL_ATTRIBUTE), zero if unset
+	expiresAt time.Time
+
+	// ImagePrefix of the user of the item
+	userImagePrefix string
+	// image keys resolved from AdImages or AdImagesPrefix
+	imageKeys []string
+	// the item has no images and EMPTY_IMAGES_POLICY allows it
+	noImages bool
+	// why AdUploadedAt is treated as unknown, see uploadedat.go
+	uploadedAtAnomaly string
+	// attributes coerced to unmarshal the item, see unmarshal.go
+	coerced []string
+	// why AdDescriptionKey could not be read when AdDescription was used
+	// instead
+	descriptionFallback error
+	// the content could not be resolved because the images buckets are
+	// unavailable, see imagebreaker.go
+	imagesErr error
+	// description resolved from Localized, AdDescriptionKey or AdDescription
+	description         string
+	descriptionResolved bool
+	// effective configuration with overrides applied
+	cfg ItemConfig
 }
 
-func Handler(ctx context.Context) error {
-	sess := session.Must(session.NewSession())
+const (
+	refreshRepost = "repost"
+	refreshBump   = "bump"
+)
 
-	// initialize aws service clients
-	ddbc = dynamodb.New(sess)
-	s3d = s3manager.NewDownloader(sess)
+// kinds of upload, a failed initial upload leaves the item without an ad
+const (
+	uploadKindInitial  = "initial"
+	uploadKindReupload = "reupload"
+)
 
-	// get all items
-	bItems, err := getBolhaItems()
+const (
+	priceTypeFixed      = "fixed"
+	priceTypeNegotiable = "negotiable"
+	priceTypeFree       = "free"
+)
+
+// enabled reports whether runs process bItem
+func (bItem *BolhaItem) enabled() bool {
+	return bItem.Enabled == nil || *bItem.Enabled
+}
+
+// scheduled reports whether bItem is a new ad whose publish time is after now
+func (bItem *BolhaItem) scheduled(now time.Time) bool {
+	return decision.Item{UploadedId: bItem.AdUploadedId, PublishAt: bItem.publishAt()}.Scheduled(now)
+}
+
+// publishAt returns the parsed PublishAt, zero if unset or invalid
+func (bItem *BolhaItem) publishAt() time.Time {
+	if bItem.PublishAt == "" {
+		return time.Time{}
+	}
+	t, _ := parseLocalTime(bItem.PublishAt, bItem.loc)
+	return t
+}
+
+// decisionItem returns what the decision of bItem depends on, hash is the
+// current content hash
+func (bItem *BolhaItem) decisionItem(hash string) (decision.Item, error) {
+	item := decision.Item{
+		UploadedId:          bItem.AdUploadedId,
+		PublishAt:           bItem.publishAt(),
+		State:               bItem.AdState,
+		ContentHash:         hash,
+		UploadedContentHash: bItem.AdContentHash,
+		ReuploadHours:       bItem.ReuploadHours,
+		ReuploadOrder:       bItem.ReuploadOrder,
+		ReuploadPage:        bItem.ReuploadPage,
+		AdsPerPage:          bItem.adsPerPage,
+		Force:               bItem.force,
+
+		ForceReuploadAfterHours:  bItem.ForceReuploadAfterHours,
+		NeverReuploadBeforeHours: bItem.NeverReuploadBeforeHours,
+	}
+	// an implausible upload time is unknown, the ad is due by age
+	if bItem.AdUploadedId != 0 && bItem.uploadedAtAnomaly == "" {
+		t, err := time.Parse(time.RFC3339, bItem.AdUploadedAt)
+		if err != nil {
+			return item, err
+		}
+		item.UploadedAt = t
+	}
+
+	return item, nil
+}
+
+// nextEligibleAt forecasts when bItem is next uploaded: the publish time of
+// a scheduled ad, the time its age triggers a reupload, or empty for new and
+// blocked ads which do not wait for anything
+func (bItem *BolhaItem) nextEligibleAt(now time.Time) string {
+	item, err := bItem.decisionItem("")
+	if err != nil || item.State == decision.StateBlocked {
+		return ""
+	}
+	if item.Scheduled(now) {
+		return bItem.displayRFC3339(item.PublishAt)
+	}
+	if at := item.AgeDueAt(); !at.IsZero() {
+		return bItem.displayRFC3339(at)
+	}
+	return ""
+}
+
+// cachedOrder returns the last observed order of the active ad of item if
+// it was checked within freshness and the ad is not close to its age
+// threshold
+func (bItem *BolhaItem) cachedOrder(now time.Time, item decision.Item, freshness time.Duration) (int, bool) {
+	if freshness <= 0 || bItem.LastCheckedAt == "" {
+		return 0, false
+	}
+
+	checkedAt, err := time.Parse(time.RFC3339, bItem.LastCheckedAt)
+	if err != nil || !checkedAt.After(item.UploadedAt) || now.Sub(checkedAt) > freshness {
+		return 0, false
+	}
+
+	// items approaching their age threshold or ForceReuploadAfterHours
+	// always get a live check
+	if item.AgeDueAt().Sub(now) < freshness {
+		return 0, false
+	}
+
+	return bItem.LastObservedOrder, true
+}
+
+// priceType returns the price type, items without one have a fixed price
+func (bItem *BolhaItem) priceType() string {
+	if bItem.AdPriceType == "" {
+		return priceTypeFixed
+	}
+	return bItem.AdPriceType
+}
+
+const (
+	actionRun               = "run"
+	actionVersion           = "version"
+	actionExport            = "export"
+	actionRestore           = "restore"
+	actionImport            = "import-csv"
+	actionReconcile         = "reconcile"
+	actionSelfCheck         = "selfcheck"
+	actionGCImages          = "gc-images"
+	actionEncrypt           = "encrypt-credentials"
+	actionRemoveAll         = "remove-all"
+	actionRefreshCategories = "refresh-categories"
+	actionAdjustPrices      = "adjust-prices"
+	actionDescribe          = "describe"
+	actionRetryFailed       = "retry-failed"
+	actionFindDuplicates    = "find-duplicates"
+)
+
+// Event is the payload the lambda is invoked with
+type Event struct {
+	Action string `json:"action"`
+
+	// export, restore, import-csv, reconcile and find-duplicates work on
+	// this table, the first of BOLHA_TABLE_NAMES if empty
+	Table string `json:"table"`
+
+	// restore, import-csv, gc-images
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Force  bool   `json:"force"`
+
+	// run only the item AdTitle of Table, with Force its active ad is
+	// reuploaded even if it is not due
+	AdTitle string `json:"adTitle"`
+
+	// run, restore, gc-images, adjust-prices: only report what would be done
+	DryRun bool `json:"dryRun"`
+
+	// reconcile
+	Repair bool `json:"repair"`
+
+	// selfcheck, also make a read-only bolha call per user
+	Bolha bool `json:"bolha"`
+
+	// run only the least risky due item
+	Canary bool `json:"canary"`
+
+	// run and retry-failed, act (default) or check, see check.go
+	Mode string `json:"mode"`
+
+	// run, start the paged scan where the last incomplete pass stopped
+	Resume bool `json:"resume"`
+
+	// run, set when an earlier invocation left items to this one
+	Continuation *Continuation `json:"continuation,omitempty"`
+
+	// encrypt-credentials, only ever sent synchronously and never logged
+	UserId   string `json:"userId"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// remove-all of UserId, must be REMOVE-ALL
+	Confirm string `json:"confirm"`
+
+	// remove-all, restore and gc-images, the token the first invocation
+	// answered with, see confirm.go
+	ConfirmToken string `json:"confirmToken"`
+
+	// adjust-prices of the items of CategoryId by Percent, rounded to
+	// cents (default) or euros
+	CategoryId int     `json:"categoryId"`
+	Percent    float64 `json:"percent"`
+	Rounding   string  `json:"rounding"`
+
+	// describe the item of Table titled AdId or uploaded as AdId, Live
+	// also asks bolha for the ad
+	AdId string `json:"adId"`
+	Live bool   `json:"live"`
+}
+
+// Handler runs an Event, or answers an HTTP request, see httpapi.go
+func Handler(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	return handlePayload(ctx, payload, nil)
+}
+
+// handlePayload is Handler talking to svc, see services
+func handlePayload(ctx context.Context, payload json.RawMessage, svc *services) (interface{}, error) {
+	metrics := newMetricSet()
+	sampler := startMemSampler()
+	retries := new(retryCounts)
+	usage := newUsageCounts()
+	start := time.Now()
+	runId := newRunId(ctx)
+	ctx = withRunId(ctx, runId)
+	logger := runLogger(runId)
+	defer func() {
+		stats := sampler.finish()
+		stats.log(logger)
+		stats.record(metrics)
+		retries.record(metrics)
+		u := usage.summary(time.Since(start))
+		u.log(logger)
+		u.record(metrics)
+		metrics.flush(logger)
+	}()
+
+	if req, ok := httpRequest(payload); ok {
+		return handleHTTP(ctx, req, svc, retries, usage, metrics), nil
+	}
+
+	strict, err := envBool("STRICT_EVENTS", true)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	event, err := decodeEvent(payload, strict)
+	if err != nil {
+		var eventErr *EventError
+		if errors.As(err, &eventErr) {
+			logger.WithFields(version.fields()).WithField("problems", eventErr.Problems).Warn("invalid event")
+		}
+		return nil, err
+	}
+
+	out, err := handle(ctx, event, svc, retries, usage, metrics)
+	if err != nil {
+		// failed items are returned as is so callers can inspect them
+		var runErr *RunError
+		if errors.As(err, &runErr) {
+			logger.WithFields(version.fields()).WithField("action", event.Action).Error(runErr.Detail())
+			return out, runErr
+		}
+
+		logger.WithFields(version.fields()).WithField("action", event.Action).WithError(err).Error("invocation failed")
+		return nil, fmt.Errorf("%v (build %s)", err, version)
+	}
+
+	return out, nil
+}
+
+func handle(ctx context.Context, event Event, svc *services, retries *retryCounts, usage *usageCounts, metrics *metricSet) (interface{}, error) {
+	if event.Action == actionVersion {
+		return version, nil
+	}
+
+	m, err := newMonitor(ctx, svc, retries, usage, metrics)
+	if err != nil {
+		return nil, err
+	}
+	if m, err = m.eventTable(event.Table); err != nil {
+		return nil, err
+	}
+
+	if destructive(event) {
+		c, err := m.confirmation(ctx, event, m.now())
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			return c, nil
+		}
+	}
+
+	switch event.Action {
+	case "", actionRun:
+		mode, err := runMode(event.Mode)
+		if err != nil {
+			return nil, err
+		}
+		var target *runTarget
+		if event.AdTitle != "" {
+			target = &runTarget{AdTitle: event.AdTitle, Force: event.Force}
+		}
+		return m.run(ctx, mode, event.Canary, event.DryRun, event.Resume, event.Continuation, target)
+	case actionExport:
+		return m.exportTable(ctx)
+	case actionRestore:
+		return m.restoreTable(ctx, event.Key, event.DryRun, event.Force)
+	case actionImport:
+		return m.importCSV(ctx, event.Bucket, event.Key)
+	case actionReconcile:
+		return m.reconcile(ctx, event.Repair)
+	case actionSelfCheck:
+		return m.selfCheck(ctx, event.Bolha)
+	case actionGCImages:
+		return m.gcImages(ctx, event.DryRun)
+	case actionEncrypt:
+		return m.encryptCredentials(ctx, event.UserId, event.Username, event.Password)
+	case actionRemoveAll:
+		return m.removeAll(ctx, event.UserId, event.Confirm)
+	case actionRefreshCategories:
+		return m.refreshCategories(ctx)
+	case actionAdjustPrices:
+		return m.adjustPrices(ctx, event.CategoryId, event.Percent, event.Rounding, event.DryRun)
+	case actionDescribe:
+		return m.describe(ctx, event.AdId, event.Live)
+	case actionRetryFailed:
+		mode, err := runMode(event.Mode)
+		if err != nil {
+			return nil, err
+		}
+		return m.retryFailed(ctx, mode, event.DryRun)
+	case actionFindDuplicates:
+		return m.findDuplicates(ctx)
+	default:
+		return nil, fmt.Errorf("unknown action %q", event.Action)
 	}
+}
 
-	var wg sync.WaitGroup
-	errChan := make(chan error)
+func (m *monitor) run(ctx context.Context, mode string, canary, dryRun, resume bool, cont *Continuation, target *runTarget) (*Report, error) {
+	check := mode == runModeCheck
+	if check && (canary || dryRun) {
+		return nil, fmt.Errorf("check runs change nothing on bolha, they cannot be canary or dry runs")
+	}
+	if target != nil && (canary || cont != nil) {
+		return nil, fmt.Errorf("runs of a single item cannot be canary runs or continued")
+	}
 
-	for _, bi := range bItems {
-		bItem := bi
+	invokedAt := m.now()
+	report := newReport(m.now())
+	report.DryRun = dryRun
+	report.Mode = mode
+
+	// dry, canary, check and targeted runs are never continued
+	var chain *runChain
+	if !dryRun && !canary && !check && target == nil {
+		var err error
+		if chain, err = m.newRunChain(ctx, cont, report.StartedAt); err != nil {
+			return nil, err
+		}
+	}
+	if chain.continued() {
+		report.StartedAt = chain.startedAt
+		report.Continuations = chain.depth
+	}
+	report.RunId = m.runId
+	if chain.continued() {
+		report.RunId = chain.runId
+	}
+	report.Invocations = []string{m.runId}
+	if target != nil {
+		report.RetryOf = target.RetryOf
+	}
+
+	// scheduled runs start at a random offset so they do not line up with
+	// other bots running on the hour, the time budget shrinks accordingly
+	if !dryRun && !canary && !check && target == nil && cont == nil && m.cfg.StartJitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(m.cfg.StartJitter) + 1)).Truncate(time.Second)
+		m.log.WithField("delay", delay.String()).Info("delaying run start...")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-m.clock.After(delay):
+		}
+		report.StartDelay = delay.String()
+	}
+
+	// notifications are sent as a single digest at the end of the run
+	defer m.flushNotifications(ctx)
+
+	// tables are run one after another, each with its own users, slots and
+	// limits, a table which cannot be run does not stop the others
+	bItems := make([]BolhaItem, 0)
+	failed := make([]*ItemError, 0)
+	tableErrs := make([]error, 0)
+	for _, table := range m.cfg.TableNames {
+		// a targeted run only reads its items
+		if target != nil {
+			if _, ok := target.Failed[table]; !ok && (target.Failed != nil || table != m.table) {
+				continue
+			}
+			tItems, tFailed, err := m.forTable(table).runTable(ctx, canary, dryRun, resume, report, chain, target)
+			if err != nil {
+				return nil, err
+			}
+			bItems = append(bItems, tItems...)
+			failed = append(failed, tFailed...)
+			continue
+		}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := processItem(&bItem); err != nil {
-				errChan <- err
-				return
+		// tables done earlier or left to the next invocation are only read
+		// for the status page
+		if !chain.runsTable(table) || chain.pastBudget() {
+			if chain.runsTable(table) {
+				chain.deferTable(table)
 			}
-		}()
+			tItems, err := m.forTable(table).getBolhaItems(ctx)
+			if err != nil {
+				m.log.WithField("table", table).WithError(err).Warn("could not read table for the status page")
+				continue
+			}
+			bItems = append(bItems, tItems...)
+			continue
+		}
+
+		tItems, tFailed, err := m.forTable(table).runTable(ctx, canary, dryRun, resume, report, chain, nil)
+		if err != nil {
+			m.log.WithField("table", table).WithError(err).Error("could not run table")
+			tableErrs = append(tableErrs, fmt.Errorf("table %s: %v", table, err))
+			continue
+		}
+		bItems = append(bItems, tItems...)
+		failed = append(failed, tFailed...)
 	}
 
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+	runErr := newRunError(len(bItems), failed)
+	report.Errors = runErr
+	report.FinishedAt = m.now()
+	report.Usage = m.usage.summary(m.now().Sub(invokedAt))
 
-	for err := range errChan {
-		return err
+	// the invocations of a continued run share a single report
+	if chain.continued() {
+		if err := m.mergeReport(ctx, report); err != nil {
+			m.log.WithError(err).Warn("could not merge report of the earlier invocations")
+		}
+	}
+	report.summarize()
+
+	// a dry, check or targeted run must not replace the state of the last
+	// act run
+	kind := "run"
+	if dryRun {
+		kind = "dry-run"
+	} else if check {
+		kind = "check"
+	} else if target == nil {
+		if err := m.uploadStatusPage(ctx, bItems, report); err != nil {
+			m.log.WithError(err).Warn("could not upload status page")
+		}
+	}
+	switch {
+	case target != nil && target.Failed != nil:
+		kind += "-retry"
+	case target != nil:
+		kind += "-item"
+	}
+	if err := m.saveReport(ctx, kind, report.StartedAt, report.RunId, report); err != nil {
+		m.log.WithError(err).Warn("could not save report")
 	}
 
-	return nil
+	// the report is saved first so the next invocation can add to it
+	if next := chain.next(); next != nil {
+		if err := m.continueRun(ctx, next); err != nil {
+			m.log.WithError(err).Error("could not continue run")
+			report.Remaining = next.Items
+			if err := m.saveReport(ctx, kind, report.StartedAt, report.RunId, report); err != nil {
+				m.log.WithError(err).Warn("could not save report")
+			}
+		}
+	}
+
+	m.log.WithFields(version.fields()).WithField("report", report).Info("run finished")
+
+	if err := errors.Join(tableErrs...); err != nil {
+		return report, err
+	}
+	if runErr != nil {
+		return report, runErr
+	}
+
+	return report, nil
 }
 
-// HELPERS
-func processItem(bItem *BolhaItem) error {
-	log.Info("processing item %s...", bItem)
+// runTable processes the items of m.table, adding them to report. A dry run
+// only plans every item without changing anything. Items the chain leaves
+// to another invocation are neither processed nor reported. With
+// SCAN_PAGE_SIZE the table is run page by page, see runstate.go, a target
+// only runs its item, see target.go.
+func (m *monitor) runTable(ctx context.Context, canary, dryRun, resume bool, report *Report, chain *runChain, target *runTarget) ([]BolhaItem, []*ItemError, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, nil, err
+	}
 
-	// create new client
-	c, err := client.NewWithSessionId(bItem.UserSessionId)
+	users, err := m.getUsers(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	m.trackSessions(ctx, users, m.now(), dryRun)
 
-	// upload if not yet uploaded
-	if bItem.AdUploadedId == 0 {
-		newUploadedId, err := uploadAd(c, bItem)
+	cats, catsCached, err := m.loadCategories(ctx, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules, err := m.loadValidationRules(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tr := &tableRun{
+		canary:   canary,
+		dryRun:   dryRun,
+		check:    report.Mode == runModeCheck,
+		report:   report,
+		chain:    chain,
+		users:    users,
+		clients:  m.newUserClients(users),
+		v:        &validator{m: m, cats: cats, catsCached: catsCached, rules: rules},
+		maxItems: m.cfg.MaxItemsPerRun,
+	}
+
+	// ads queued for removal go before any item, see removals.go
+	m.drainRemovals(ctx, tr, m.now())
+
+	if target != nil && target.Failed != nil {
+		bItems, err := m.batchGetBolhaItems(ctx, target.Failed[m.table])
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+		tr.maxItems = 0
+		failed, _ := m.runItems(ctx, tr, bItems)
+		return bItems, failed, nil
+	}
+	if target != nil {
+		bItems, err := m.getBolhaItem(ctx, target.AdTitle)
+		if err != nil {
+			return nil, nil, err
 		}
+		bItems[0].force = target.Force
+		tr.maxItems = 0
+		failed, _ := m.runItems(ctx, tr, bItems)
+		return bItems, failed, nil
+	}
 
-		// update uploaded id
-		if err := updateUploadedId(bItem.AdTitle, newUploadedId); err != nil {
-			return err
+	// a canary picks its item among all items
+	if m.cfg.ScanPageSize > 0 && !canary {
+		return m.runPages(ctx, tr, resume)
+	}
+
+	// get all items
+	bItems, err := m.getBolhaItems(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a new table is empty until its first items are added
+	if len(bItems) == 0 {
+		m.log.WithField("table", m.table).Info("table is empty, nothing to run")
+	}
+	failed, _ := m.runItems(ctx, tr, bItems)
+
+	return bItems, failed, nil
+}
+
+// tableRun holds what the items of a table are run with
+type tableRun struct {
+	canary, dryRun bool
+	// only observe, see check.go
+	check  bool
+	report *Report
+	chain  *runChain
+
+	users   map[string]*BolhaUser
+	clients *userClients
+	v       *validator
+
+	// items left to MAX_ITEMS_PER_RUN, 0 is unlimited and negative none
+	maxItems int
+}
+
+// runItems runs bItems, adding them to the report. It returns the failed
+// items and the number of items it processed.
+func (m *monitor) runItems(ctx context.Context, tr *tableRun, bItems []BolhaItem) ([]*ItemError, int) {
+	canary, dryRun, report, chain, clients := tr.canary, tr.dryRun, tr.report, tr.chain, tr.clients
+
+	included := make([]bool, len(bItems))
+	for i := range bItems {
+		included[i] = chain.includes(m.table, bItems[i].AdTitle)
+	}
+
+	// expired items waiting for deletion, disabled items and the items of
+	// paused users are held before anything else, held items neither touch
+	// bolha nor count failures
+	now := m.now()
+	expired := make([]bool, len(bItems))
+	held := make([]string, len(bItems))
+	for i := range bItems {
+		if !included[i] {
+			continue
+		}
+		switch {
+		case bItems[i].expired(now):
+			m.log.WithFields(log.Fields{"AdTitle": bItems[i].AdTitle, "expiresAt": bItems[i].expiresAt}).Info("skipping expired item")
+			expired[i] = true
+			held[i] = statusExpired
+			report.Expired++
+		case !bItems[i].enabled():
+			m.log.WithField("AdTitle", bItems[i].AdTitle).Info("skipping disabled item")
+			held[i] = statusDisabled
+		case tr.users[bItems[i].UserId].paused():
+			m.log.WithFields(log.Fields{"AdTitle": bItems[i].AdTitle, "UserId": bItems[i].UserId}).Info("skipping item of paused user")
+			held[i] = statusUserPaused
+		case bItems[i].coolingDown(now):
+			m.log.WithFields(log.Fields{"AdTitle": bItems[i].AdTitle, "CooldownUntil": bItems[i].CooldownUntil}).Info("skipping item cooling down")
+			held[i] = statusCoolingDown
 		}
+	}
 
-		return nil
+	applyUserImagePrefixes(bItems, tr.users)
+
+	// items grown close to the size limit are trimmed before they are updated
+	if !dryRun && !tr.check {
+		for i := range bItems {
+			if !included[i] {
+				continue
+			}
+			if err := m.offloadLargeItem(ctx, &bItems[i]); err != nil {
+				m.log.WithField("AdTitle", bItems[i].AdTitle).WithError(err).Warn("could not offload description")
+			}
+		}
+	}
+
+	// pre-flight validation, invalid items are never processed
+	validationErrs := make([]error, len(bItems))
+	for i := range bItems {
+		if !included[i] || held[i] != "" {
+			continue
+		}
+		if err := tr.v.validate(ctx, &bItems[i]); err != nil {
+			m.log.WithField("AdTitle", bItems[i].AdTitle).WithError(err).Warn("invalid item")
+			validationErrs[i] = err
+		}
+	}
+
+	// new items over their user's active ads cap wait for a free slot
+	waiting := m.waitingForSlot(bItems, tr.users, func(i int) bool {
+		return included[i] && held[i] == "" && validationErrs[i] == nil && !bItems[i].scheduled(now)
+	})
+
+	// items over the per run limit, or all but one in a canary run, are deferred
+	eligible := func(i int) bool {
+		return included[i] && held[i] == "" && validationErrs[i] == nil && !waiting[i] && !bItems[i].scheduled(now)
+	}
+	sel := newItemSelector(m.cfg.SelectionStrategy, now.UnixNano())
+	deferred := m.deferItems(bItems, tr.maxItems, canary, sel, eligible, now)
+
+	processed := 0
+	for i := range bItems {
+		if eligible(i) && deferred[i] == "" {
+			processed++
+		}
+	}
+	if tr.maxItems > 0 {
+		if tr.maxItems -= processed; tr.maxItems == 0 {
+			tr.maxItems = -1
+		}
+	}
+
+	writes := m.newItemWrites()
+	writes.start(ctx)
+
+	// items picked while the limit binds are penalized the next time
+	bound := false
+	for _, status := range deferred {
+		bound = bound || status == statusDeferredItemLimit
+	}
+	if bound && !dryRun && !tr.check {
+		for i := range bItems {
+			if eligible(i) && deferred[i] == "" {
+				writes.set(bItems[i].AdTitle, "LastSelectedAt", &types.AttributeValueMemberS{Value: storedTime(now)})
+				bItems[i].LastSelectedAt = storedTime(now)
+			}
+		}
+	}
+	res := newResumer(bItems)
+	itemReports := make([]ItemReport, len(bItems))
+	itemErrs := make([]*ItemError, len(bItems))
+	itemDurations := make([]time.Duration, len(bItems))
+
+	// the items of a user run in order, see scheduler.go
+	sched := newScheduler(m.cfg, m.clock)
+	sched.deficit = chain.deficit
+	queues := sched.queues(bItems, func(i int) bool { return included[i] })
+	slices := m.newTimeSlices(chain, bItems, queues, tr.users, m.cfg.MaxConcurrentUsers)
+	sched.run(ctx, queues, func(i1 int) bool {
+		bItem := &bItems[i1]
+		ir := &itemReports[i1]
+		touched := false
+		user := bItem.reportUser()
+
+		err := validationErrs[i1]
+		switch {
+		case held[i1] != "":
+			ir.Status = held[i1]
+		case err != nil:
+			ir.Status = statusInvalid
+		case waiting[i1]:
+			ir.Status = statusWaitingForSlot
+		case deferred[i1] != "":
+			ir.Status = deferred[i1]
+		case ctx.Err() != nil:
+			// the invocation is ending, the item is left to the next run
+			ir.Status = statusCanceled
+		case dryRun:
+			err = m.planItem(ctx, clients, bItem, ir)
+			touched = true
+		case tr.check:
+			err = m.checkItem(ctx, clients, writes, bItem, ir)
+			touched = true
+		case !bItem.overdue(m.now()) && !slices.start(user):
+			m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "user": user}).Info("time slice of user spent, leaving item to the next invocation")
+			ir.Status = statusDeferredSlice
+			chain.deferItem(m.table, bItem.AdTitle)
+		case !chain.start(ctx):
+			m.log.WithField("AdTitle", bItem.AdTitle).Info("time budget spent, leaving item to the next invocation")
+			ir.Status = statusDeferredBudget
+			chain.deferItem(m.table, bItem.AdTitle)
+		default:
+			start := m.now()
+			err = m.processItem(ctx, clients, writes, res, bItem, ir)
+			touched = true
+			if err != nil && ir.Status == "" {
+				ir.Status = statusFailed
+			}
+			if errors.Is(err, ErrDuplicateRejected) {
+				m.coolDown(ctx, writes, bItem, ir, err)
+			}
+			itemDurations[i1] = m.now().Sub(start)
+		}
+		if err != nil {
+			itemErrs[i1] = newItemError(bItem, err)
+			itemErrs[i1].UploadKind = ir.UploadKind
+			ir.Error = err.Error()
+			ir.ErrorClass = itemErrs[i1].Class
+		}
+		if !dryRun {
+			m.recordUpload(ir)
+		}
+
+		// a failed check is no failed upload
+		if !dryRun && !tr.check && held[i1] == "" && ir.Status != statusDeferredBudget && ir.Status != statusDeferredSlice && ir.Status != statusDeferredImages && ir.Status != statusCanceled {
+			if err := m.trackFailures(ctx, writes, bItem, itemErrs[i1]); err != nil {
+				m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("could not track failures")
+			}
+		}
+
+		ir.AdTitle = bItem.AdTitle
+		ir.Table = m.table
+		ir.User = user
+		if ir.AdState == "" {
+			ir.AdState = bItem.AdState
+		}
+		ir.PriceType = bItem.priceType()
+		ir.NoImages = bItem.noImages
+		ir.UploadedAtAnomaly = bItem.uploadedAtAnomaly
+		if until, ok := bItem.cooldownUntil(); ok && bItem.coolingDown(now) {
+			ir.CooldownUntil = m.cfg.displayTime(until)
+		}
+		ir.AdUploadedId = bItem.AdUploadedId
+		ir.AdURL = bItem.adURL(m.cfg)
+		ir.NextEligibleAt = bItem.nextEligibleAt(now)
+		m.recordSkip(ir, dryRun)
+		slices.done(user, itemDurations[i1])
+		bItem.recordRun(writes, m.runId, now)
+		writes.release(bItem.AdTitle)
+
+		return touched
+	})
+
+	// users left items by the budget before they got their slice are owed
+	// the rest of it by the next invocation
+	owed := make(map[string]bool)
+	for i := range bItems {
+		if user := bItems[i].reportUser(); itemReports[i].Status == statusDeferredBudget && !owed[user] {
+			owed[user] = true
+			if d := slices.shortfall(user); d > 0 {
+				chain.owe(user, d)
+			}
+		}
 	}
 
-	// get active (uploaded) ad
-	log.WithField("AdUploadedId", bItem.AdUploadedId).Info("getting active ad...")
-	activeAd, err := c.GetActiveAd(bItem.AdUploadedId)
+	if err := writes.flush(ctx); err != nil {
+		m.log.WithError(err).Warn("could not flush deferred writes")
+	}
+
+	for i, bItem := range bItems {
+		if !included[i] {
+			continue
+		}
+		report.Items = append(report.Items, itemReports[i])
+		report.setUser(bItem.reportUser(), m.maxActiveAds(&bItems[i], tr.users), clients.session(&bItems[i]))
+		report.setSessionAge(bItem.reportUser(), tr.users[bItem.UserId])
+		if itemReports[i].Status == statusScheduled {
+			publishAt := bItem.publishAt().In(m.cfg.location())
+			report.Scheduled = append(report.Scheduled, ScheduledReport{
+				AdTitle:   bItem.AdTitle,
+				Table:     m.table,
+				PublishAt: publishAt,
+				Wait:      publishAt.Sub(now).Round(time.Minute).String(),
+			})
+		}
+
+		if canary && report.Canary == nil && held[i] == "" && validationErrs[i] == nil && !waiting[i] && deferred[i] == "" && itemReports[i].Status != statusScheduled {
+			report.Canary = &CanaryReport{
+				Item:      itemReports[i],
+				Images:    len(bItem.imageKeys),
+				FailCount: bItem.FailCount,
+				Duration:  itemDurations[i].String(),
+			}
+		}
+
+		if bItem.NeedsAttention && !expired[i] {
+			report.NeedsAttention = append(report.NeedsAttention, NeedsAttentionReport{
+				AdTitle:   bItem.AdTitle,
+				Table:     m.table,
+				FailCount: bItem.FailCount,
+				LastError: itemReports[i].Error,
+			})
+		}
+	}
+	failed := make([]*ItemError, 0)
+	for _, ie := range itemErrs {
+		if ie != nil {
+			failed = append(failed, ie)
+		}
+	}
+
+	return failed, processed
+}
+
+// HELPERS
+func (m *monitor) processItem(ctx context.Context, clients *userClients, writes *itemWrites, res *resumer, bItem *BolhaItem, ir *ItemReport) error {
+	m.log.WithFields(log.Fields{
+		"AdTitle":     bItem.AdTitle,
+		"AdPrice":     bItem.AdPrice.String(),
+		"AdPriceType": bItem.priceType(),
+	}).Info("processing item...")
+
+	now := m.now()
+	dcfg := decision.Config{ModerationGrace: m.cfg.ModerationGrace}
+
+	item, err := bItem.decisionItem("")
 	if err != nil {
 		return err
 	}
-	log.WithField("activeAd", activeAd).Info("active ad")
 
-	adUploadedAtParsed, err := time.Parse(time.RFC3339, bItem.AdUploadedAt)
+	d := decision.Evaluate(item, nil, now, dcfg)
+	switch d.Action {
+	case decision.Wait:
+		m.logDecision(bItem, d, ir)
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "PublishAt": bItem.PublishAt}).Info("ad scheduled")
+		ir.Status = statusScheduled
+		return nil
+	case decision.Skip:
+		m.logDecision(bItem, d, ir)
+		m.log.WithField("AdTitle", bItem.AdTitle).Warn("ad blocked")
+		ir.Status = statusBlocked
+		return m.blockAd(ctx, bItem)
+	}
+
+	// likely typos in the price are never uploaded
+	if err := checkPrice(bItem, m.cfg.PriceChangeMaxPercent); err != nil {
+		if err := m.blockPrice(ctx, bItem, err); err != nil {
+			m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Error("could not block price")
+		}
+		ir.Status = statusPriceBlocked
+		return err
+	}
+
+	// without images the content is assumed unchanged, the ad is still
+	// observed but nothing is uploaded
+	hash, err := "", bItem.imagesErr
+	if err == nil {
+		hash, err = m.contentHash(ctx, bItem)
+	}
+	if imagesOutage(err) {
+		bItem.imagesErr = err
+		hash = bItem.AdContentHash
+	} else if err != nil {
+		return err
+	}
+	item.ContentHash = hash
+
+	// get user's client
+	c, err := clients.get(ctx, bItem)
 	if err != nil {
 		return err
 	}
 
-	// if ad not old
-	if activeAd.Order > bItem.ReuploadOrder || time.Since(adUploadedAtParsed) > time.Duration(bItem.ReuploadHours)*time.Hour {
-		var wg sync.WaitGroup
-		errChan := make(chan error, 2)
+	deferImages := func() bool {
+		if bItem.imagesErr == nil && m.images.allow() {
+			return false
+		}
+		m.log.WithField("AdTitle", bItem.AdTitle).Warn("images unavailable, leaving upload to the next run")
+		ir.Status = statusDeferredImages
+		return true
+	}
 
-		wg.Add(2)
+	upload := func() error {
+		if deferImages() {
+			return nil
+		}
+		ir.UploadKind = uploadKindInitial
+		if err := m.setPhase(ctx, bItem, phaseUploading, 0, 0); err != nil {
+			return err
+		}
+		newAd, err := m.uploadAd(ctx, c, bItem, uploadKindInitial)
+		if unresolvedUpload(err) {
+			if err := m.blockUnresolvedUpload(ctx, bItem, err); err != nil {
+				m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Error("could not block item")
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		newUploadedId := newAd.id
+		ir.PayloadKey = newAd.payloadKey
+		if err := m.setPhase(ctx, bItem, phaseUploadedUnrecorded, 0, newUploadedId); err != nil {
+			return err
+		}
+
+		// update uploaded id
+		if err := m.updateUploadedId(ctx, bItem, newUploadedId, newAd.at, hash); err != nil {
+			return err
+		}
+		bItem.clearPhase()
+		bItem.AdUploadedId, bItem.AdUploadedUrl = newUploadedId, ""
+		bItem.AdUploadedAt = storedTime(newAd.at)
+		bItem.AdContentHash = hash
+		bItem.AdState = adStateActive
+		bItem.AdUploadedPrice, bItem.ConfirmPriceChange = bItem.AdPrice, false
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "kind": uploadKindInitial, "AdUploadedId": newUploadedId, "adURL": m.cfg.adURL(newUploadedId)}).Info("ad uploaded")
+
+		ir.Status = statusUploaded
+		m.callWebhook(ctx, bItem, webhookUploaded, 0, newUploadedId, ir)
+		return nil
+	}
+
+	// finish what a previous run left unfinished before deciding anew
+	if bItem.ReuploadPhase != "" {
+		if deferImages() {
+			return nil
+		}
+		ir.ResumedPhase = bItem.ReuploadPhase
+		if err := m.resume(ctx, c, res, bItem, hash); err != nil {
+			return err
+		}
+		if item, err = bItem.decisionItem(hash); err != nil {
+			return err
+		}
+		d = decision.Evaluate(item, nil, now, dcfg)
+	}
 
-		var newUploadedId int64
+	// upload if not yet uploaded
+	if d.Action == decision.Upload {
+		m.logDecision(bItem, d, ir)
+		return upload()
+	}
 
-		// remove
-		go func() {
-			defer wg.Done()
-			log.WithField("AdUploadedId", bItem.AdUploadedId).Info("removing ad...")
-			if err := c.RemoveAd(bItem.AdUploadedId); err != nil {
-				errChan <- err
-				return
+	// reuse a recent order instead of asking bolha
+	var observed decision.Observed
+	if order, ok := bItem.cachedOrder(now, item, m.cfg.OrderFreshness); ok {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "order": order, "LastCheckedAt": bItem.LastCheckedAt}).Info("using cached order")
+		observed.Order = order
+		ir.Order = order
+		ir.DecisionSource = decisionSourceCache
+	} else {
+		// get active (uploaded) ad
+		m.log.WithField("AdUploadedId", bItem.AdUploadedId).Info("getting active ad...")
+		activeAd, err := c.GetActiveAd(ctx, bItem.AdUploadedId)
+		switch {
+		case err == client.ErrAdNotFound:
+			observed.Missing = true
+		case err != nil:
+			return err
+		default:
+			if edits := liveEdits(bItem, activeAd); len(edits) > 0 {
+				if err := m.resolveLiveEdits(ctx, bItem, edits); err != nil {
+					return err
+				}
+				item.State = bItem.AdState
+				break
 			}
-			log.WithField("AdUploadedId", bItem.AdUploadedId).Info("ad removed")
-		}()
+			if bItem.AdState != adStateActive {
+				writes.set(bItem.AdTitle, "AdState", &types.AttributeValueMemberS{Value: adStateActive})
+				bItem.AdState = adStateActive
+			}
+			m.log.WithField("activeAd", activeAd).Info("active ad")
+			observed.Order = activeAd.Order
+			ir.Order = activeAd.Order
+			ir.DecisionSource = decisionSourceLive
 
-		// upload
-		go func() {
-			defer wg.Done()
-			id, err := uploadAd(c, bItem)
-			if err != nil {
-				errChan <- err
-				return
+			bItem.recordObservation(writes, activeAd.Order, now, m.cfg.OrderHistorySize)
+
+			// first live check after a reupload
+			if gain, ok := bItem.recordGain(writes, activeAd.Order); ok {
+				ir.Gain = &gain
 			}
-			newUploadedId = id
-		}()
+		}
+	}
+
+	// items uploaded before content hashing was introduced are assumed up to date
+	if bItem.AdContentHash == "" && bItem.imagesErr == nil {
+		writes.set(bItem.AdTitle, "AdContentHash", &types.AttributeValueMemberS{Value: hash})
+		bItem.AdContentHash = hash
+		item.UploadedContentHash = hash
+	}
+
+	d = decision.Evaluate(item, &observed, now, dcfg)
+	m.logDecision(bItem, d, ir)
 
-		go func() {
-			wg.Wait()
-			close(errChan)
-		}()
+	if observed.Missing {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdUploadedId": bItem.AdUploadedId, "AdState": d.State}).Warn("ad not active")
+		ir.AdState = d.State
+	}
 
-		for err := range errChan {
+	switch d.Action {
+	case decision.Skip:
+		if bItem.AdState != d.State {
+			writes.set(bItem.AdTitle, "AdState", &types.AttributeValueMemberS{Value: d.State})
+		}
+		bItem.AdState = d.State
+		ir.Status = statusPendingModeration
+		return nil
+	case decision.Upload:
+		// expired, forget the old ad and upload it as new
+		if deferImages() {
+			return nil
+		}
+		if err := m.setAdState(ctx, bItem.AdTitle, d.State); err != nil {
+			return err
+		}
+		if err := m.clearUploadedId(ctx, bItem.AdTitle, bItem.AdUploadedId); err != nil {
+			return err
+		}
+		bItem.AdUploadedId, bItem.AdState = 0, d.State
+		ir.Reason = d.Reason
+
+		return upload()
+	}
+
+	// if ad old or outdated
+	if d.Action == decision.Reupload {
+		ir.Reason = d.Reason
+		if deferImages() {
+			return nil
+		}
+
+		// a failed pre-reupload hook leaves the item to the next run
+		if err := m.preReuploadHook(ctx, bItem); err != nil {
+			m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("pre-reupload hook failed, skipping item")
+			ir.Status = statusPreHookFailed
+			ir.HookError = err.Error()
+			return nil
+		}
+
+		ir.UploadKind = uploadKindReupload
+		if bItem.RefreshStrategy == refreshBump {
+			m.log.WithField("AdTitle", bItem.AdTitle).Warn("bolha client cannot bump ads, falling back to repost")
+		}
+
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "kind": uploadKindReupload, "reason": ir.Reason}).Info("reuploading ad...")
+
+		newAd, removed, err := m.reupload(ctx, c, bItem)
+		if unresolvedUpload(err) {
+			if err := m.blockUnresolvedUpload(ctx, bItem, err); err != nil {
+				m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Error("could not block item")
+			}
+			return err
+		}
+		if err != nil {
+			if removed {
+				ir.UploadPending = true
+				if err := m.markUploadPending(ctx, bItem, hash, err); err != nil {
+					m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Error("could not mark upload pending")
+				}
+			}
 			return err
 		}
 
 		// update uploaded id
-		if err := updateUploadedId(bItem.AdTitle, newUploadedId); err != nil {
+		newUploadedId := newAd.id
+		ir.PayloadKey = newAd.payloadKey
+		if err := m.updateUploadedId(ctx, bItem, newUploadedId, newAd.at, hash); err != nil {
 			return err
 		}
+		oldUploadedId := bItem.AdUploadedId
+		bItem.AdUploadedId, bItem.AdUploadedUrl = newUploadedId, ""
+		bItem.AdUploadedAt = storedTime(newAd.at)
+		bItem.AdContentHash = hash
+		bItem.AdUploadedPrice, bItem.ConfirmPriceChange = bItem.AdPrice, false
+		bItem.clearPhase()
+		bItem.recordReupload(writes, ir.Order, m.now())
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "kind": uploadKindReupload, "AdUploadedId": newUploadedId, "adURL": m.cfg.adURL(newUploadedId)}).Info("ad reuploaded")
+
+		ir.Status = statusReuploaded
+		m.callWebhook(ctx, bItem, webhookReuploaded, oldUploadedId, newUploadedId, ir)
+		m.postReuploadHook(ctx, bItem, oldUploadedId, newUploadedId, ir)
+		return nil
 	}
 
+	ir.Status = statusUnchanged
 	return nil
 }
 
-func uploadAd(c *client.Client, bItem *BolhaItem) (int64, error) {
-	log.Info("uploading ad...")
+// reupload removes the active ad and uploads it again, in parallel (fast mode)
+// or only uploading once the old ad is gone (safe mode). Once the old ad is
+// removed the upload is retried, removed reports whether the old ad is gone.
+// Every step is persisted as a phase, see phase.go.
+func (m *monitor) reupload(ctx context.Context, c *bolhaClient, bItem *BolhaItem) (newAd uploadedAd, removed bool, err error) {
+	oldId := bItem.AdUploadedId
+
+	remove := func() error {
+		m.log.WithField("AdUploadedId", oldId).Info("removing ad...")
+		if err := m.removeAd(ctx, c, oldId); err != nil {
+			return err
+		}
+		m.log.WithField("AdUploadedId", oldId).Info("ad removed")
+		return nil
+	}
+
+	if bItem.cfg.ReuploadMode == reuploadModeSafe {
+		if err := m.setPhase(ctx, bItem, phaseRemoving, oldId, 0); err != nil {
+			return uploadedAd{}, false, err
+		}
+		if err := remove(); err != nil {
+			return uploadedAd{}, false, err
+		}
+		if err := m.setPhase(ctx, bItem, phaseRemoved, 0, 0); err != nil {
+			return uploadedAd{}, true, err
+		}
+		newAd, err := m.uploadAdWithRetry(ctx, c, bItem, uploadKindReupload, m.cfg.UploadAttempts)
+		if err != nil {
+			return uploadedAd{}, true, err
+		}
+		return newAd, true, m.setPhase(ctx, bItem, phaseUploadedUnrecorded, 0, newAd.id)
+	}
+
+	if err := m.setPhase(ctx, bItem, phaseUploading, oldId, 0); err != nil {
+		return uploadedAd{}, false, err
+	}
+
+	var (
+		wg                   sync.WaitGroup
+		removeErr, uploadErr error
+	)
+
+	wg.Add(2)
+
+	// remove
+	go func() {
+		defer wg.Done()
+		removeErr = remove()
+	}()
+
+	// upload
+	go func() {
+		defer wg.Done()
+		newAd, uploadErr = m.uploadAd(ctx, c, bItem, uploadKindReupload)
+	}()
+
+	wg.Wait()
+
+	if removeErr != nil {
+		// the upload may be live under an unknown id, which outweighs the
+		// failed removal
+		if unresolvedUpload(uploadErr) {
+			m.log.WithField("AdUploadedId", oldId).WithError(removeErr).Error("could not remove ad")
+			return uploadedAd{}, false, uploadErr
+		}
+		// both ads are live, the next run removes the old one and records the new one
+		if uploadErr == nil {
+			if err := m.setPhase(ctx, bItem, phaseUploadedUnrecorded, oldId, newAd.id); err != nil {
+				m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Error("could not record unfinished reupload")
+			}
+		}
+		return uploadedAd{}, false, removeErr
+	}
+
+	// the old ad is gone, use the remaining attempts before giving up
+	if unresolvedUpload(uploadErr) || errors.Is(uploadErr, ErrDuplicateRejected) {
+		return uploadedAd{}, true, uploadErr
+	}
+	if uploadErr != nil {
+		m.log.WithField("AdTitle", bItem.AdTitle).WithError(uploadErr).Warn("upload failed after removal, retrying...")
+		if newAd, err = m.uploadAdWithRetry(ctx, c, bItem, uploadKindReupload, m.cfg.UploadAttempts-1); err != nil {
+			return uploadedAd{}, true, err
+		}
+	}
+
+	return newAd, true, m.setPhase(ctx, bItem, phaseUploadedUnrecorded, 0, newAd.id)
+}
+
+// logDecision logs the decision of bItem with everything it was based on
+// and adds it to the report, at info level if the item is acted upon
+func (m *monitor) logDecision(bItem *BolhaItem, d decision.Decision, ir *ItemReport) {
+	ir.Decision = d.Explain()
+
+	l := m.log.WithField("AdTitle", bItem.AdTitle).WithFields(log.Fields(d.Fields()))
+	if d.Action == decision.Upload || d.Action == decision.Reupload {
+		l.Info("decision")
+	} else {
+		l.Debug("decision")
+	}
+}
+
+// removeAd removes an ad, an ad which is already gone counts as removed. The
+// client does not tell a missing ad from other failures, so a failed removal
+// is checked against the active ads.
+func (m *monitor) removeAd(ctx context.Context, c *bolhaClient, id int64) error {
+	err := c.RemoveAd(ctx, id)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, errDuplicateAction) {
+		return err
+	}
+
+	if errors.Is(err, client.ErrAdNotFound) {
+		m.log.WithField("AdUploadedId", id).WithError(err).Warn("ad already removed")
+		return nil
+	}
+	if _, activeErr := c.GetActiveAd(ctx, id); activeErr == client.ErrAdNotFound {
+		m.log.WithField("AdUploadedId", id).WithError(err).Warn("ad already removed")
+		return nil
+	}
+
+	return err
+}
+
+// uploadAdWithRetry uploads bItem, making at most attempts attempts, each
+// with freshly opened images
+func (m *monitor) uploadAdWithRetry(ctx context.Context, c *bolhaClient, bItem *BolhaItem, kind string, attempts int) (uploadedAd, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "kind": kind, "attempt": attempt + 1}).WithError(err).Warn("retrying upload...")
+			<-m.clock.After(time.Duration(attempt) * uploadRetryDelay)
+		}
+
+		var newAd uploadedAd
+		if newAd, err = m.uploadAd(ctx, c, bItem, kind); err == nil {
+			return newAd, nil
+		}
+		// retrying a duplicate only makes the account look worse
+		if unresolvedUpload(err) || errors.Is(err, ErrDuplicateRejected) {
+			break
+		}
+	}
+
+	return uploadedAd{}, err
+}
+
+// markUploadPending records that the ad of bItem was removed but could not be
+// uploaded again so that the next run uploads it before anything else
+func (m *monitor) markUploadPending(ctx context.Context, bItem *BolhaItem, hash string, uploadErr error) error {
+	if err := m.setUploadPending(ctx, bItem.AdTitle, hash); err != nil {
+		return err
+	}
+	bItem.AdUploadedId = 0
+	bItem.UploadPending = true
+	bItem.UploadPendingHash = hash
+
+	n := m.itemNotification(ctx, bItem,
+		notificationUploadPending,
+		fmt.Sprintf("%s is offline", bItem.AdTitle),
+		fmt.Sprintf("ad %q was removed but could not be uploaded again, it will be uploaded first on the next run: %v", bItem.AdTitle, uploadErr),
+	)
+	n.Severity = severityHigh
+
+	return m.notif.Notify(ctx, n)
+}
+
+// trackFailures persists the number of consecutive failed runs of an item and
+// flags it as needing attention once the configured threshold is crossed
+func (m *monitor) trackFailures(ctx context.Context, writes *itemWrites, bItem *BolhaItem, procErr *ItemError) error {
+	// a successful run clears the failure state, blocked items stay flagged
+	if procErr == nil {
+		if bItem.AdState == adStateBlocked {
+			return nil
+		}
+		if bItem.FailCount == 0 && !bItem.NeedsAttention {
+			return nil
+		}
+
+		writes.set(bItem.AdTitle, "FailCount", &types.AttributeValueMemberN{Value: "0"})
+		writes.set(bItem.AdTitle, "NeedsAttention", &types.AttributeValueMemberBOOL{Value: false})
+		bItem.FailCount, bItem.NeedsAttention = 0, false
 
-	// download s3 images
-	s3Images, err := downloadS3Images(bItem.AdImages)
+		return nil
+	}
+
+	failCount, err := m.incrementCounter(ctx, bItem.AdTitle, "FailCount", 1)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	bItem.FailCount = failCount
+
+	// an item whose first upload failed has no ad at all
+	if procErr.UploadKind == uploadKindInitial {
+		n := m.itemNotification(ctx, bItem,
+			notificationUploadFailed,
+			fmt.Sprintf("%s could not be uploaded", bItem.AdTitle),
+			fmt.Sprintf("initial upload of %q failed (%s): %v", bItem.AdTitle, procErr.Class, procErr.Err),
+		)
+		n.Severity = severityHigh
+		if err := m.notif.Notify(ctx, n); err != nil {
+			m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("could not notify failed upload")
+		}
 	}
 
-	// upload ad
-	return c.UploadAd(&client.Ad{
-		Title:       bItem.AdTitle,
-		Description: bItem.AdDescription,
-		Price:       bItem.AdPrice,
-		CategoryId:  bItem.AdCategoryId,
-		Images:      s3Images,
-	})
+	// already flagged or threshold not reached
+	threshold := bItem.cfg.FailAlertThreshold
+	if bItem.NeedsAttention || threshold <= 0 || failCount < threshold {
+		return nil
+	}
+
+	if err := m.setNeedsAttention(ctx, bItem.AdTitle); err != nil {
+		return err
+	}
+	bItem.NeedsAttention = true
+
+	n := m.itemNotification(ctx, bItem,
+		notificationNeedsAttention,
+		fmt.Sprintf("%s needs attention", bItem.AdTitle),
+		fmt.Sprintf("ad %q failed %d runs in a row, last error (%s): %v", bItem.AdTitle, failCount, procErr.Class, procErr.Err),
+	)
+	if procErr.UploadKind == uploadKindInitial {
+		n.Severity = severityHigh
+	}
+
+	return m.notif.Notify(ctx, n)
+}
+
+// errInvalidUploadedId is returned for an upload bolha answered without an
+// id, the ad may be live and the upload must not be retried
+var errInvalidUploadedId = errors.New("upload returned no ad id")
+
+// errIncompleteAdLive is returned for an upload with missing images which
+// could not be removed again, retrying would leave two ads live
+var errIncompleteAdLive = errors.New("ad with missing images could not be removed")
+
+// unresolvedUpload reports whether err leaves an upload live which the item
+// does not record, see blockUnresolvedUpload
+func unresolvedUpload(err error) bool {
+	return errors.Is(err, errInvalidUploadedId) || errors.Is(err, errIncompleteAdLive)
 }
 
-func downloadS3Images(images []string) ([]io.Reader, error) {
-	log.WithField("images", images).Info("downloading s3 images...")
+// uploadedAd is an ad bolha accepted and the time it did
+type uploadedAd struct {
+	id int64
+	at time.Time
+	// key of the saved ad, empty if it was not saved
+	payloadKey string
+}
 
-	// do not use img chan because images need to maintain initial order
-	var wg sync.WaitGroup
+// uploadAd uploads bItem as a new ad, kind is only logged
+func (m *monitor) uploadAd(ctx context.Context, c *bolhaClient, bItem *BolhaItem, kind string) (uploadedAd, error) {
+	m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "kind": kind}).Info("uploading ad...")
 
-	errChan := make(chan error, len(images))
+	images, err := m.resolveImages(ctx, bItem)
+	if err != nil {
+		return uploadedAd{}, err
+	}
 
-	s3Images := make([]io.Reader, len(images))
-	for i, imgPath := range images {
-		i1, imgPath1 := i, imgPath
+	if _, err := m.resolveDescription(ctx, bItem); err != nil {
+		return uploadedAd{}, err
+	}
 
-		wg.Add(1)
+	// upload ad, at most once per invocation, claimed before the images are
+	// opened so a refused upload reads none of them
+	if err := m.guard.claim(uploadAction(bItem)); err != nil {
+		return uploadedAd{}, err
+	}
 
-		go func() {
-			defer wg.Done()
+	// open s3 images, they are streamed to the client and cannot be read
+	// twice, a retried upload calls uploadAd again to open them anew
+	s3Images, err := m.openS3Images(ctx, bItem, images)
+	if err != nil {
+		m.guard.release(uploadAction(bItem))
+		return uploadedAd{}, err
+	}
+	defer m.closeS3Images(s3Images)
 
-			img, err := downloadS3Image(imgPath1)
-			if err != nil {
-				errChan <- err
-				return
-			}
+	readers := make([]io.Reader, len(s3Images))
+	for i, img := range s3Images {
+		readers[i] = img
+	}
 
-			s3Images[i1] = img
-		}()
+	ad := m.newClientAd(bItem, readers)
+	newUploadedId, err := c.UploadAd(ctx, ad)
+	if err != nil {
+		m.guard.release(uploadAction(bItem))
+		return uploadedAd{}, m.duplicateRejection(err)
+	}
+	// a zero id would make the item look never uploaded and upload it twice,
+	// the claim is kept as the ad may be live
+	if newUploadedId <= 0 {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdUploadedId": newUploadedId}).Error("upload returned no ad id")
+		return uploadedAd{}, fmt.Errorf("%w: got %d for %q", errInvalidUploadedId, newUploadedId, bItem.AdTitle)
+	}
+	// the ad is live from now, not from when the upload is recorded
+	uploadedAt := m.now()
+
+	// the client ignores failed image uploads, never keep an ad with missing
+	// images. The claim is only released once it is gone, another upload of
+	// the item would leave two ads live.
+	if err := m.s3ImagesErr(s3Images); err != nil {
+		m.log.WithField("AdUploadedId", newUploadedId).WithError(err).Warn("image stream failed, removing uploaded ad...")
+		if removeErr := c.RemoveAd(ctx, newUploadedId); removeErr != nil {
+			m.log.WithField("AdUploadedId", newUploadedId).WithError(removeErr).Error("could not remove ad with missing images")
+			return uploadedAd{}, fmt.Errorf("%w: %d of %q (%v): %v", errIncompleteAdLive, newUploadedId, bItem.AdTitle, err, removeErr)
+		}
+		m.guard.release(uploadAction(bItem))
+		return uploadedAd{}, err
 	}
 
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+	payload := newUploadPayload(m.table, kind, bItem.AdTitle, ad, s3Images, newUploadedId, uploadedAt)
+	payload.RunId = m.runId
+	payloadKey := m.savePayload(ctx, bItem, payload, uploadedAt)
 
-	for err := range errChan {
-		return nil, err
+	return uploadedAd{id: newUploadedId, at: uploadedAt, payloadKey: payloadKey}, nil
+}
+
+// newClientAd maps bItem onto the ad the bolha client uploads
+func (m *monitor) newClientAd(bItem *BolhaItem, images []io.Reader) *client.Ad {
+	// the client does not support these listing details yet
+	if bItem.AdCondition != "" || len(bItem.AdShipping) > 0 || bItem.AdLocation != "" {
+		m.log.WithFields(log.Fields{
+			"AdTitle":     bItem.AdTitle,
+			"AdCondition": bItem.AdCondition,
+			"AdShipping":  bItem.AdShipping,
+			"AdLocation":  bItem.AdLocation,
+		}).Warn("listing details are not supported by the bolha client, uploading without them")
+	}
+
+	// the client only knows a plain price of whole euros
+	price := bItem.AdPrice.euros()
+	switch bItem.priceType() {
+	case priceTypeFree:
+		price = 0
+	case priceTypeNegotiable:
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdPrice": bItem.AdPrice.String()}).Warn("bolha client cannot mark prices negotiable, uploading as fixed price")
+	}
+	if price != 0 && eurosPrice(price) != bItem.AdPrice {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdPrice": bItem.AdPrice.String(), "uploadedPrice": price}).Warn("bolha client only accepts whole euros, uploading rounded price")
+	}
+
+	return &client.Ad{
+		Title:       bItem.uploadTitle(),
+		Description: bItem.uploadDescription(),
+		Price:       price,
+		CategoryId:  bItem.AdCategoryId,
+		Images:      images,
+	}
+}
+
+// resolveImages returns the image keys of bItem, listing its prefix once if set
+func (m *monitor) resolveImages(ctx context.Context, bItem *BolhaItem) ([]string, error) {
+	if bItem.imageKeys != nil {
+		return bItem.imageKeys, nil
+	}
+
+	images := make([]string, 0, len(bItem.AdImages))
+	if bItem.AdImagesPrefix != "" {
+		keys, err := m.listImageKeys(ctx, bItem.AdImagesPrefix)
+		if err != nil {
+			return nil, err
+		}
+		images = keys
+	} else {
+		for _, entry := range bItem.AdImages {
+			key, err := bItem.imageKey(entry)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, key)
+		}
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "prefix": bItem.imagePrefix(), "images": images}).Debug("resolved image keys")
 	}
+	bItem.imageKeys = images
 
-	return s3Images, nil
+	return images, nil
 }
 
 // DYNAMODB
 
-func getBolhaItems() ([]BolhaItem, error) {
-	log.Info("getting bolha items...")
+func (m *monitor) getBolhaItems(ctx context.Context) ([]BolhaItem, error) {
+	m.log.WithField("table", m.table).Info("getting bolha items...")
 
-	result, err := ddbc.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
+	items, err := m.scanItems(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	bItems := make([]BolhaItem, 0)
-	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &bItems); err != nil {
+	bItems, err := m.bolhaItems(items)
+	if err != nil {
 		return nil, err
 	}
 
-	log.WithField("bItems", bItems).Info("bolha items")
+	m.log.WithField("bItems", bItems).Info("bolha items")
+
+	return bItems, nil
+}
+
+// bolhaItems unmarshals raw items of the table one by one, see unmarshalItem
+func (m *monitor) bolhaItems(items []map[string]types.AttributeValue) ([]BolhaItem, error) {
+	bItems := make([]BolhaItem, len(items))
+	for i, item := range items {
+		bItems[i] = m.unmarshalItem(item)
+		bItems[i].size = itemSize(item)
+	}
+	m.setExpiry(bItems, items, m.cfg.TTLAttribute)
 
 	return bItems, nil
 }
 
-func updateUploadedId(adTitle string, adUploadedId int64) error {
-	log.Info("updating uploaded id...")
+// scanItems returns all raw items of the table
+func (m *monitor) scanItems(ctx context.Context) ([]map[string]types.AttributeValue, error) {
+	items := make([]map[string]types.AttributeValue, 0)
 
-	_, err := ddbc.UpdateItem(&dynamodb.UpdateItemInput{
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":uploadedId": {N: aws.String(strconv.FormatInt(adUploadedId, 10))},
-			":uploadedAt": {S: aws.String(time.Now().Format(time.RFC3339))},
-		},
-		Key:              map[string]*dynamodb.AttributeValue{"AdTitle": {S: aws.String(adTitle)}},
-		UpdateExpression: aws.String("SET AdUploadedId = :uploadedId, AdUploadedAt = :uploadedAt"),
-		TableName:        aws.String(tableName),
+	p := dynamodb.NewScanPaginator(m.ddb, &dynamodb.ScanInput{
+		TableName: aws.String(m.table),
 	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, m.tableError(err)
+		}
+		items = append(items, page.Items...)
+	}
 
-	log.Info("uploaded id updated")
+	return items, nil
+}
 
-	return err
+// batchPutItems writes items in batches, retrying unprocessed items
+func (m *monitor) batchPutItems(ctx context.Context, items []map[string]types.AttributeValue) error {
+	for _, item := range items {
+		if err := m.fitItem(ctx, item); err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(items); start += batchWriteSize {
+		end := start + batchWriteSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		requests := make([]types.WriteRequest, 0, end-start)
+		for _, item := range items[start:end] {
+			requests = append(requests, types.WriteRequest{
+				PutRequest: &types.PutRequest{Item: item},
+			})
+		}
+
+		for attempt := 0; len(requests) > 0; attempt++ {
+			if attempt == batchWriteAttempts {
+				return fmt.Errorf("%d items left unprocessed after %d attempts", len(requests), attempt)
+			}
+			if attempt > 0 {
+				<-m.clock.After(time.Duration(1<<uint(attempt)) * 100 * time.Millisecond)
+			}
+
+			result, err := m.ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{m.table: requests},
+			})
+			if err != nil {
+				return err
+			}
+
+			requests = result.UnprocessedItems[m.table]
+		}
+	}
+
+	return nil
 }
 
-// S3
+// updateUploadedId records a successful upload of bItem, uploadedAt is when
+// bolha accepted the upload and the decisions use it, the time it is
+// recorded is only kept for troubleshooting
+func (m *monitor) updateUploadedId(ctx context.Context, bItem *BolhaItem, adUploadedId int64, uploadedAt time.Time, contentHash string) error {
+	if adUploadedId <= 0 {
+		return fmt.Errorf("%w: refusing to record %d for %q", errInvalidUploadedId, adUploadedId, bItem.AdTitle)
+	}
 
-func downloadS3Image(imgKey string) (io.Reader, error) {
-	log.WithField("imgKey", imgKey).Info("downloading s3 image...")
+	m.log.Info("updating uploaded id...")
 
-	buff := new(aws.WriteAtBuffer)
+	fieldHashes, err := m.contentFieldHashes(ctx, bItem)
+	if err != nil {
+		return err
+	}
+	fieldHashesAv, err := attributevalue.Marshal(fieldHashes)
+	if err != nil {
+		return err
+	}
 
-	_, err := s3d.Download(buff, &s3.GetObjectInput{
-		Bucket: aws.String(s3ImagesBucket),
-		Key:    aws.String(imgKey),
+	_, err = m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":fieldHashes":   fieldHashesAv,
+			":uploadedId":    &types.AttributeValueMemberN{Value: strconv.FormatInt(adUploadedId, 10)},
+			":uploadedAt":    &types.AttributeValueMemberS{Value: storedTime(uploadedAt)},
+			":recordedAt":    &types.AttributeValueMemberS{Value: storedTime(m.now())},
+			":uploadedPrice": &types.AttributeValueMemberN{Value: bItem.AdPrice.String()},
+			":contentHash":   &types.AttributeValueMemberS{Value: contentHash},
+			":false":         &types.AttributeValueMemberBOOL{Value: false},
+			":active":        &types.AttributeValueMemberS{Value: adStateActive},
+			":one":           &types.AttributeValueMemberN{Value: "1"},
+		},
+		Key:              map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: bItem.AdTitle}},
+		UpdateExpression: aws.String("SET AdUploadedId = :uploadedId, AdUploadedAt = :uploadedAt, AdUploadedRecordedAt = :recordedAt, AdUploadedPrice = :uploadedPrice, AdContentHash = :contentHash, AdContentFieldHashes = :fieldHashes, UploadPending = :false, AdState = :active REMOVE UploadPendingHash, ConfirmPriceChange, ReuploadPhase, ReuploadPhaseAt, ReuploadOldId, ReuploadNewId, AdUploadedUrl ADD AdUploadCount :one"),
+		TableName:        aws.String(m.table),
 	})
+
 	if err != nil {
-		return nil, err
+		return err
 	}
+	bItem.AdUploadCount++
 
-	imgBytes := buff.Bytes()
+	m.log.Info("uploaded id updated")
 
-	log.WithField("imgKey", imgKey).Info("s3 image downloaded")
+	return nil
+}
+
+func (m *monitor) setUploadPending(ctx context.Context, adTitle string, contentHash string) error {
+	m.log.WithField("AdTitle", adTitle).Info("setting upload pending...")
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero":        &types.AttributeValueMemberN{Value: "0"},
+			":true":        &types.AttributeValueMemberBOOL{Value: true},
+			":contentHash": &types.AttributeValueMemberS{Value: contentHash},
+		},
+		Key:              map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression: aws.String("SET AdUploadedId = :zero, UploadPending = :true, UploadPendingHash = :contentHash"),
+		TableName:        aws.String(m.table),
+	})
 
-	return bytes.NewReader(imgBytes), nil
+	return err
+}
+
+func (m *monitor) setNeedsAttention(ctx context.Context, adTitle string) error {
+	m.log.WithField("AdTitle", adTitle).Info("setting needs attention...")
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+		Key:              map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression: aws.String("SET NeedsAttention = :true"),
+		TableName:        aws.String(m.table),
+	})
+
+	return err
 }
 
 func main() {
+	log.WithFields(version.fields()).Info("cold start")
+
 	lambda.Start(Handler)
 }
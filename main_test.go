@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/seniorescobar/bolha-client"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// Invocations share nothing but the process, two of them running at once
+// against their own stand-ins must each see only theirs and log their own
+// run id. Run with -race.
+func TestConcurrentHandlers(t *testing.T) {
+	hook := test.NewGlobal()
+	sinking := newScenario(t, "sinking", harness.Step{Orders: map[int64]int{1000: 40}})
+	session := newScenario(t, "session", harness.Step{})
+
+	var wg sync.WaitGroup
+	reports := make([]*Report, 2)
+	errs := make([]error, 2)
+	for i, s := range []*scenario{sinking, session} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i], errs[i] = s.run()
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("invocation %d: %v", i, err)
+		}
+	}
+	if reports[0].RunId == reports[1].RunId {
+		t.Errorf("both invocations have run id %s", reports[0].RunId)
+	}
+
+	if n := len(reports[0].Items); n != 1 {
+		t.Errorf("first invocation reported %d items, want 1", n)
+	} else if ir := reports[0].Items[0]; ir.Status != statusReuploaded {
+		t.Errorf("first invocation: %q %s, want %s", ir.AdTitle, ir.Status, statusReuploaded)
+	}
+	if n := len(reports[1].Items); n != 2 {
+		t.Errorf("second invocation reported %d items, want 2", n)
+	}
+	for _, ir := range reports[1].Items {
+		if ir.Status != statusUnchanged {
+			t.Errorf("second invocation: %q %s, want %s", ir.AdTitle, ir.Status, statusUnchanged)
+		}
+	}
+
+	if got := sinking.client.Active(); len(got) != 1 || got[0] != 1001 {
+		t.Errorf("active ads of the first invocation %v, want [1001]", got)
+	}
+	if n := len(session.calls("UploadAd")); n != 0 {
+		t.Errorf("second invocation uploaded %d ads, want none", n)
+	}
+	if at := sinking.item("Gorsko kolo")["AdUploadedAt"]; at != scenarioStart.Format(time.RFC3339) {
+		t.Errorf("AdUploadedAt = %v, want the time of the first invocation's clock", at)
+	}
+
+	runIds := map[string]bool{reports[0].RunId: true, reports[1].RunId: true}
+	for _, e := range hook.AllEntries() {
+		runId, _ := e.Data["runId"].(string)
+		if !runIds[runId] {
+			t.Errorf("%q logged with run id %q", e.Message, runId)
+		} else if e.Message == "uploading ad..." && runId != reports[0].RunId {
+			t.Errorf("upload of the first invocation logged with run id %s", runId)
+		}
+	}
+}
+
+// An upload bolha answers without an id may be live, the item is blocked
+// instead of uploaded again
+func TestUploadWithoutIdBlocksItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		steps   []harness.Step
+		// AdUploadedId left in the table
+		uploadedId float64
+	}{
+		{
+			name:    "initial upload answers 0",
+			fixture: "new",
+			steps:   []harness.Step{{NoId: true}, {}},
+		},
+		{
+			name:       "reupload answers 0 after the removal",
+			fixture:    "sinking",
+			steps:      []harness.Step{{Orders: map[int64]int{1000: 40}, NoId: true}, {}},
+			uploadedId: 1000,
+		},
+		{
+			name:    "reupload fails and its retry answers 0 after the removal",
+			fixture: "sinking",
+			steps: []harness.Step{{
+				Orders:   map[int64]int{1000: 40},
+				Sequence: map[string][]error{"UploadAd": {errors.New("bad gateway")}},
+				NoId:     true,
+			}, {}},
+			uploadedId: 1000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const title = "Gorsko kolo"
+			s := newScenario(t, tt.fixture, tt.steps...)
+
+			_, err := s.run()
+			if !errors.Is(err, errInvalidUploadedId) {
+				t.Fatalf("run 1: %v, want %v", err, errInvalidUploadedId)
+			}
+			uploads := len(s.calls("UploadAd"))
+
+			it := s.item(title)
+			if id, _ := it["AdUploadedId"].(float64); id != tt.uploadedId {
+				t.Errorf("AdUploadedId = %v, want %v", id, tt.uploadedId)
+			}
+			if pending, _ := it["UploadPending"].(bool); pending {
+				t.Error("item marked upload pending")
+			}
+			if attention, _ := it["NeedsAttention"].(bool); !attention {
+				t.Error("item not flagged as needing attention")
+			}
+			if state := it["AdState"]; state != adStateBlocked {
+				t.Errorf("AdState = %v, want %s", state, adStateBlocked)
+			}
+
+			s.next(3 * time.Hour)
+			report, err := s.run()
+			if err != nil {
+				t.Fatalf("run 2: %v", err)
+			}
+			if ir := itemReport(t, report, title); ir.Status != statusBlocked {
+				t.Errorf("run 2: %s, want %s", ir.Status, statusBlocked)
+			}
+			if n := len(s.calls("UploadAd")); n != uploads {
+				t.Errorf("run 2 uploaded %d more ads, want none", n-uploads)
+			}
+		})
+	}
+}
+
+// An ad with missing images which could not be removed keeps the upload of
+// its item claimed, a second worker given the item must not upload it again
+func TestIncompleteAdKeepsClaim(t *testing.T) {
+	// verified images are read whole before the upload, only streamed ones
+	// can end short
+	t.Setenv("VERIFY_IMAGE_CHECKSUMS", "false")
+	s := newScenario(t, "new", harness.Step{Errors: map[string]error{"RemoveAd": errors.New("bad gateway")}})
+	s.objects.Shorten(s3ImagesBucket, "kolo/2.jpg", 1)
+
+	m, bItems := s.monitor()
+	c, err := m.newBolhaSessionClient("session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range errs {
+		bItem := bItems[0]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = m.uploadAd(context.Background(), c, &bItem, uploadKindInitial)
+		}()
+	}
+	wg.Wait()
+
+	if n := len(s.calls("UploadAd")); n != 1 {
+		t.Fatalf("%d uploads, want 1", n)
+	}
+	incomplete, duplicate := 0, 0
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, errIncompleteAdLive):
+			incomplete++
+		case errors.Is(err, errDuplicateAction):
+			duplicate++
+		default:
+			t.Errorf("worker failed with %v", err)
+		}
+	}
+	if incomplete != 1 || duplicate != 1 {
+		t.Errorf("%d incomplete and %d refused uploads, want 1 of each", incomplete, duplicate)
+	}
+	if !unresolvedUpload(errs[0]) && !unresolvedUpload(errs[1]) {
+		t.Error("no worker reported the upload unresolved")
+	}
+
+	// the worker which uploaded is done, the item stays claimed
+	bItem := bItems[0]
+	if _, err := m.uploadAd(context.Background(), c, &bItem, uploadKindInitial); !errors.Is(err, errDuplicateAction) {
+		t.Errorf("third upload: %v, want %v", err, errDuplicateAction)
+	}
+	if got := s.client.Active(); len(got) != 1 {
+		t.Errorf("active ads %v, want the incomplete one", got)
+	}
+}
+
+// A whole run blocks the item instead of retrying the upload
+func TestIncompleteAdBlocksItem(t *testing.T) {
+	const title = "Gorsko kolo"
+	t.Setenv("VERIFY_IMAGE_CHECKSUMS", "false")
+	s := newScenario(t, "new", harness.Step{Errors: map[string]error{"RemoveAd": errors.New("bad gateway")}})
+	s.objects.Shorten(s3ImagesBucket, "kolo/2.jpg", 1)
+
+	if _, err := s.run(); !errors.Is(err, errIncompleteAdLive) {
+		t.Fatalf("run: %v, want %v", err, errIncompleteAdLive)
+	}
+	if n := len(s.calls("UploadAd")); n != 1 {
+		t.Errorf("%d uploads, want 1", n)
+	}
+	it := s.item(title)
+	if attention, _ := it["NeedsAttention"].(bool); !attention {
+		t.Error("item not flagged as needing attention")
+	}
+	if state := it["AdState"]; state != adStateBlocked {
+		t.Errorf("AdState = %v, want %s", state, adStateBlocked)
+	}
+}
+
+// BenchmarkUpload uploads an ad of 10 images of 5 MB each. Streamed images
+// go from the s3 body to the client as they are read, verified ones are
+// read whole first to check their digest.
+func BenchmarkUpload(b *testing.B) {
+	const (
+		images    = 10
+		imageSize = 5 << 20
+	)
+
+	for _, verify := range []bool{false, true} {
+		name := "streamed"
+		if verify {
+			name = "verified"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.Setenv("VERIFY_IMAGE_CHECKSUMS", fmt.Sprint(verify))
+			level := log.GetLevel()
+			log.SetLevel(log.WarnLevel)
+			defer log.SetLevel(level)
+
+			s := newScenario(b, "new")
+			m, bItems := s.monitor()
+			c, err := m.newBolhaSessionClient("session-1")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			bItem := bItems[0]
+			bItem.AdImages, bItem.imageKeys = nil, nil
+			image := bytes.Repeat([]byte{0xff}, imageSize)
+			for i := 0; i < images; i++ {
+				key := fmt.Sprintf("bench/%d.jpg", i)
+				s.objects.Put(s3ImagesBucket, key, image)
+				bItem.AdImages = append(bItem.AdImages, key)
+			}
+
+			b.ReportAllocs()
+			b.SetBytes(images * imageSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.guard = newActionGuard(m.log)
+				if _, err := m.uploadAd(context.Background(), c, &bItem, uploadKindInitial); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			for _, call := range s.calls("UploadAd") {
+				for _, n := range call.ImageBytes {
+					if n != imageSize {
+						b.Fatalf("client read %d bytes of an image, want %d", n, imageSize)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Images are opened anew for every attempt of an upload, a retry sends
+// them whole whatever the failed attempt read of them
+func TestUploadRetryReopensImages(t *testing.T) {
+	tests := []struct {
+		name  string
+		step  harness.Step
+		short bool
+	}{
+		{
+			name: "upload fails",
+			step: harness.Step{Sequence: map[string][]error{"UploadAd": {errors.New("bad gateway")}}},
+		},
+		{
+			name:  "image streamed short",
+			short: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VERIFY_IMAGE_CHECKSUMS", "false")
+			s := newScenario(t, "new", tt.step)
+			if tt.short {
+				s.objects.Shorten(s3ImagesBucket, "kolo/2.jpg", 1)
+			}
+			m, bItems := s.monitor()
+			c, err := m.newBolhaSessionClient("session-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newAd, err := m.uploadAdWithRetry(context.Background(), c, &bItems[0], uploadKindReupload, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uploads := s.calls("UploadAd")
+			if len(uploads) != 2 {
+				t.Fatalf("%d uploads, want 2", len(uploads))
+			}
+			if got := s.client.Active(); len(got) != 1 || got[0] != newAd.id {
+				t.Errorf("active ads %v, want only the retried upload %d", got, newAd.id)
+			}
+			for i, key := range []string{"kolo/1.jpg", "kolo/2.jpg"} {
+				size := int64(len(s.objects.Get(s3ImagesBucket, key)))
+				if n := uploads[1].ImageBytes[i]; n != size {
+					t.Errorf("retry read %d bytes of %s, want all %d", n, key, size)
+				}
+				if n := s.objects.Gets[s3ImagesBucket+"/"+key]; n != 2 {
+					t.Errorf("%s opened %d times, want once per attempt", key, n)
+				}
+			}
+			if tt.short && uploads[0].ImageBytes[1] >= int64(len(s.objects.Get(s3ImagesBucket, "kolo/2.jpg"))) {
+				t.Error("first attempt read the whole short image")
+			}
+		})
+	}
+}
+
+// Removing an ad which is already gone counts as removed, whether bolha
+// says so or only the check of the active ads tells
+func TestRemoveAdAlreadyRemoved(t *testing.T) {
+	serverErr := errors.New("internal server error")
+
+	tests := []struct {
+		name string
+		step harness.Step
+		// GetActiveAd called to tell whether the ad is gone
+		checked bool
+		err     error
+	}{
+		{"removed", harness.Step{}, false, nil},
+		{"bolha says not found", harness.Step{Missing: []int64{1000}}, false, nil},
+		{
+			name:    "removal fails, ad not active",
+			step:    harness.Step{Missing: []int64{1000}, Errors: map[string]error{"RemoveAd": serverErr}},
+			checked: true,
+		},
+		{
+			name:    "removal fails, ad still active",
+			step:    harness.Step{Errors: map[string]error{"RemoveAd": serverErr}},
+			checked: true,
+			err:     serverErr,
+		},
+		{
+			name:    "removal and check fail",
+			step:    harness.Step{Errors: map[string]error{"RemoveAd": serverErr, "GetActiveAd": errors.New("timeout")}},
+			checked: true,
+			err:     serverErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScenario(t, "sinking", harness.Step{}, tt.step)
+			s.next(0)
+			m, _ := s.monitor()
+			c, err := m.newBolhaSessionClient("session-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.removeAd(context.Background(), c, 1000); err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+			if checked := len(s.calls("GetActiveAd")) > 0; checked != tt.checked {
+				t.Errorf("checked the active ads %v, want %v", checked, tt.checked)
+			}
+		})
+	}
+}
+
+// A reupload whose ad vanished between the order check and the removal
+// uploads the new ad all the same
+func TestReuploadAdAlreadyRemoved(t *testing.T) {
+	tests := []struct {
+		name string
+		step harness.Step
+	}{
+		{
+			name: "bolha says not found",
+			step: harness.Step{Orders: map[int64]int{1000: 40}, Sequence: map[string][]error{"RemoveAd": {client.ErrAdNotFound}}},
+		},
+		{
+			name: "removal fails, ad not active",
+			step: harness.Step{
+				Orders:   map[int64]int{1000: 40},
+				Sequence: map[string][]error{"RemoveAd": {errors.New("internal server error")}, "GetActiveAd": {nil, client.ErrAdNotFound}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const title = "Gorsko kolo"
+			s := newScenario(t, "sinking", tt.step)
+
+			report, err := s.run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ir := itemReport(t, report, title); ir.Status != statusReuploaded {
+				t.Errorf("status %q error %q, want %q", ir.Status, ir.Error, statusReuploaded)
+			}
+			if id := s.item(title)["AdUploadedId"]; id != float64(1001) {
+				t.Errorf("AdUploadedId = %v, want 1001", id)
+			}
+			if uploads := s.calls("UploadAd"); len(uploads) != 1 {
+				t.Errorf("%d uploads, want 1", len(uploads))
+			}
+		})
+	}
+}
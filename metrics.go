@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metricsNamespace = "BolhaMonitor"
+
+	unitCount     = "Count"
+	unitBytes     = "Bytes"
+	unitMegabytes = "Megabytes"
+
+	unitMilliseconds = "Milliseconds"
+)
+
+// metricSet collects values that are emitted as a single
+// CloudWatch Embedded Metric Format (EMF) log line
+type metricSet struct {
+	mu     sync.Mutex
+	units  map[string]string
+	values map[string]float64
+	props  map[string]interface{}
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		units:  make(map[string]string),
+		values: make(map[string]float64),
+		props:  make(map[string]interface{}),
+	}
+}
+
+// put sets metric name to value
+func (m *metricSet) put(name string, value float64, unit string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.units[name] = unit
+	m.values[name] = value
+}
+
+// add increments metric name by delta
+func (m *metricSet) add(name string, delta float64, unit string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.units[name] = unit
+	m.values[name] += delta
+}
+
+// property attaches a non-metric value which is searchable in logs insights
+func (m *metricSet) property(name string, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.props[name] = value
+}
+
+// recordUpload counts the upload of an item by kind, a failed initial
+// upload is worse than a failed reupload
+func (m *monitor) recordUpload(ir *ItemReport) {
+	var name string
+	switch ir.UploadKind {
+	case uploadKindInitial:
+		name = "InitialUploads"
+	case uploadKindReupload:
+		name = "Reuploads"
+	default:
+		return
+	}
+	if ir.Error != "" {
+		name += "Failed"
+	}
+
+	m.metrics.add(name, 1, unitCount)
+}
+
+// flush writes the collected metrics to stdout
+func (m *metricSet) flush(logger *log.Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.values) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(m.values))
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defs := make([]map[string]string, len(names))
+	for i, name := range names {
+		defs[i] = map[string]string{"Name": name, "Unit": m.units[name]}
+	}
+
+	out := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []interface{}{
+				map[string]interface{}{
+					"Namespace":  metricsNamespace,
+					"Dimensions": [][]string{{"FunctionName"}},
+					"Metrics":    defs,
+				},
+			},
+		},
+		"FunctionName": os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+	}
+	for k, v := range m.props {
+		out[k] = v
+	}
+	for k, v := range m.values {
+		out[k] = v
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		logger.WithError(err).Warn("could not marshal metrics")
+		return
+	}
+
+	fmt.Println(string(b))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	client "github.com/seniorescobar/bolha-client"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// dynamoDBAPI is the part of the dynamodb client the monitor uses
+type dynamoDBAPI interface {
+	dynamodb.ScanAPIClient
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// s3API is the part of the s3 client the monitor uses
+type s3API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// kmsAPI is the part of the kms client the monitor uses
+type kmsAPI interface {
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+	Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
+}
+
+// lambdaAPI is the part of the lambda client the monitor uses
+type lambdaAPI interface {
+	Invoke(ctx context.Context, params *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error)
+}
+
+// ssmAPI is the part of the ssm client the monitor uses
+type ssmAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// clock tells the time decisions and recorded times are based on, and
+// paces what waits on it
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// services are what a monitor talks to, newMonitor creates the aws clients
+// and the clock left nil. Tests pass the stand-ins of internal/harness.
+type services struct {
+	clock  clock
+	ddb    dynamoDBAPI
+	s3     s3API
+	ssm    ssmAPI
+	kms    kmsAPI
+	lambda lambdaAPI
+
+	// receives the notifications of the digest instead of the configured
+	// channels
+	notif notifier
+
+	// see monitor.dialBolha
+	dialBolha func(creds *client.User, sessionId string) (adClient, error)
+}
+
+// monitor holds the configuration and service clients of a single
+// invocation, nothing is shared between invocations
+type monitor struct {
+	cfg *Config
+
+	// items table the monitor works on, one of cfg.TableNames
+	table string
+
+	// id of the invocation, see runid.go
+	runId string
+	// logs with the run id, the invocations of a warm container may run
+	// at once so it is not on the standard logger
+	log *log.Entry
+
+	clock clock
+
+	ddb   dynamoDBAPI
+	s3    s3API
+	ssm   ssmAPI
+	kms   kmsAPI
+	notif notifier
+
+	// invokes the function itself to continue a run
+	lambda lambdaAPI
+
+	// emitted once the invocation finishes
+	metrics *metricSet
+	usage   *usageCounts
+
+	// refuses a second removal or upload of the same ad or item
+	guard *actionGuard
+
+	// credentials decrypted during the invocation
+	creds *credentialsCache
+
+	// paces bolha requests of all users and tables
+	limiter *rate.Limiter
+
+	// logs in to bolha, with credentials or a session id, instead of the
+	// bolha client package if set, see internal/harness
+	dialBolha func(creds *client.User, sessionId string) (adClient, error)
+
+	// clients of the images bucket and its replicas
+	buckets *imageBuckets
+	// stops asking for images once they are unavailable, see imagebreaker.go
+	images *imagesBreaker
+
+	// nil unless pre-signed image links are enabled
+	presign *s3.PresignClient
+}
+
+// newMonitor loads the configuration and creates the service clients svc
+// does not provide, retries of aws calls are counted in retries and all
+// calls in usage
+func newMonitor(ctx context.Context, svc *services, retries *retryCounts, usage *usageCounts, metrics *metricSet) (*monitor, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if svc == nil {
+		svc = &services{}
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRetryer(newRetryer(cfg.RetryMode, cfg.RetryMaxAttempts, retries)))
+	if err != nil {
+		return nil, err
+	}
+	runId := runIdFrom(ctx)
+	logger := runLogger(runId)
+	awsCfg.APIOptions = append(awsCfg.APIOptions, usage.apiOptions()...)
+	awsCfg.APIOptions = append(awsCfg.APIOptions, stampRunOptions(runId, cfg.TableNames)...)
+
+	m := &monitor{
+		cfg:    cfg,
+		table:  cfg.TableNames[0],
+		runId:  runId,
+		log:    logger,
+		clock:  svc.clock,
+		ddb:    svc.ddb,
+		s3:     svc.s3,
+		ssm:    svc.ssm,
+		kms:    svc.kms,
+		lambda: svc.lambda,
+		creds:  newCredentialsCache(),
+
+		dialBolha: svc.dialBolha,
+
+		metrics: metrics,
+		usage:   usage,
+		guard:   newActionGuard(logger),
+		images:  newImagesBreaker(cfg.ImagesBreakerThreshold, logger),
+
+		limiter: newBolhaLimiter(cfg.BolhaRequestsPerSecond, cfg.BolhaRequestBurst),
+	}
+	if m.clock == nil {
+		m.clock = systemClock{}
+	}
+	if m.ddb == nil {
+		m.ddb = dynamodb.NewFromConfig(awsCfg)
+	}
+	if m.ssm == nil {
+		m.ssm = ssm.NewFromConfig(awsCfg)
+	}
+	if m.kms == nil {
+		m.kms = kms.NewFromConfig(awsCfg)
+	}
+	if m.lambda == nil {
+		m.lambda = lambda.NewFromConfig(awsCfg)
+	}
+	if m.s3 == nil {
+		s3c := s3.NewFromConfig(awsCfg)
+		m.s3 = s3c
+		if cfg.ImageURLExpiry > 0 {
+			m.presign = s3.NewPresignClient(s3c)
+		}
+	}
+	m.buckets = newImageBuckets(awsCfg, m.s3, m.log)
+
+	var next notifier = svc.notif
+	if next == nil {
+		next = newRoutingNotifier(cfg, sns.NewFromConfig(awsCfg), runId)
+	}
+	m.notif = newDigestNotifier(next, cfg.NotifyImmediate, cfg.NotifyDigestExamples)
+
+	return m, nil
+}
+
+// now is the time of m's clock
+func (m *monitor) now() time.Time {
+	return m.clock.Now()
+}
+
+// forTable returns a monitor sharing the clients of m working on table
+func (m *monitor) forTable(table string) *monitor {
+	tm := *m
+	tm.table = table
+	return &tm
+}
+
+// eventTable returns the monitor for the table an event names, the first
+// configured table if it names none
+func (m *monitor) eventTable(table string) (*monitor, error) {
+	if table == "" {
+		return m, nil
+	}
+	if !m.cfg.hasTable(table) {
+		return nil, fmt.Errorf("table %q is not in BOLHA_TABLE_NAMES", table)
+	}
+	return m.forTable(table), nil
+}
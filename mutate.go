@@ -0,0 +1,84 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Bolha appears to fingerprint the text of reposts. Items with
+// MutateDescription are uploaded with a harmless variation of their
+// description: the lines between shuffleStart and shuffleEnd are shuffled,
+// lines vary in one trailing space and the next of DescriptionClosings is
+// appended. The variation only depends on the title and the number of
+// uploads so far, a dry run plans the text the next upload sends, and it
+// is made after hashing so it never counts as a content change.
+const (
+	shuffleStart = "[shuffle]"
+	shuffleEnd   = "[/shuffle]"
+)
+
+// uploadDescription returns the description the next upload of bItem sends
+func (bItem *BolhaItem) uploadDescription() string {
+	if !bItem.MutateDescription {
+		return bItem.description
+	}
+	return mutateDescription(bItem.description, bItem.AdTitle, bItem.AdUploadCount, bItem.DescriptionClosings)
+}
+
+// mutateDescription returns variation n of description. Variation 0 only
+// drops the markers and appends the first closing, no variation adds,
+// drops or changes anything but whitespace, the order of the marked lines
+// and the closing.
+func mutateDescription(description, title string, n int, closings []string) string {
+	h := fnv.New64a()
+	h.Write([]byte(title))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(n)))
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	lines := strings.Split(description, "\n")
+	out := make([]string, 0, len(lines)+2)
+
+	// an unclosed block is left as it is, markers included
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != shuffleStart {
+			out = append(out, lines[i])
+			continue
+		}
+		end := -1
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == shuffleEnd {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			out = append(out, lines[i:]...)
+			break
+		}
+
+		block := append([]string{}, lines[i+1:end]...)
+		if n > 0 {
+			r.Shuffle(len(block), func(a, b int) { block[a], block[b] = block[b], block[a] })
+		}
+		out = append(out, block...)
+		i = end
+	}
+
+	// trailing spaces are not rendered, the ones of the author are kept
+	if n > 0 {
+		for i, line := range out {
+			if line != "" && r.Intn(2) == 1 {
+				out[i] = line + " "
+			}
+		}
+	}
+
+	if len(closings) > 0 {
+		out = append(out, "", closings[n%len(closings)])
+	}
+
+	return strings.Join(out, "\n")
+}
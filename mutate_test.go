@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testShuffled = `Malo rabljeno gorsko kolo.
+[shuffle]
+Okvir M.
+Zavore hidravlične.
+Gume nove.
+Sedež udoben.
+[/shuffle]
+Cena ni fiksna.
+Prevzem v Ljubljani.`
+
+var testClosings = []string{"Lep pozdrav!", "Hvala za ogled.", "Pišite."}
+
+// unclosedLines returns the lines of s without the closing and the blank
+// line before it
+func unclosedLines(s string) []string {
+	l := strings.Split(s, "\n")
+	return l[:len(l)-2]
+}
+
+// varied reports whether line is orig with at most one trailing space added
+func varied(line, orig string) bool {
+	return line == orig || line == orig+" "
+}
+
+func TestMutateDescriptionDeterministic(t *testing.T) {
+	for n := 0; n < 10; n++ {
+		a := mutateDescription(testShuffled, "Gorsko kolo", n, testClosings)
+		b := mutateDescription(testShuffled, "Gorsko kolo", n, testClosings)
+		if a != b {
+			t.Errorf("variation %d differs between calls:\n%s\n---\n%s", n, a, b)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for n := 1; n <= 10; n++ {
+		seen[mutateDescription(testShuffled, "Gorsko kolo", n, nil)] = true
+	}
+	if len(seen) < 5 {
+		t.Errorf("%d distinct texts of 10 variations", len(seen))
+	}
+}
+
+func TestMutateDescription(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		n           int
+		closings    []string
+		want        string
+	}{
+		{
+			name:        "first variation only drops the markers",
+			description: "a\n[shuffle]\nb\nc\n[/shuffle]\nd  ",
+			closings:    testClosings,
+			want:        "a\nb\nc\nd  \n\nLep pozdrav!",
+		},
+		{
+			name:        "unclosed block is left as it is",
+			description: "a\n[shuffle]\nb\nc",
+			want:        "a\n[shuffle]\nb\nc",
+		},
+		{
+			name:        "closed block before an unclosed one",
+			description: "[shuffle]\nb\n[/shuffle]\n[shuffle]\nc",
+			want:        "b\n[shuffle]\nc",
+		},
+		{
+			name:        "markers with surrounding whitespace",
+			description: "  [shuffle] \nb\n\t[/shuffle]",
+			want:        "b",
+		},
+		{
+			name:        "empty block",
+			description: "a\n[shuffle]\n[/shuffle]\nb",
+			want:        "a\nb",
+		},
+		{
+			name:        "no closings",
+			description: "a",
+			want:        "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mutateDescription(tt.description, "Gorsko kolo", tt.n, tt.closings); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutateDescriptionClosings(t *testing.T) {
+	for n := 0; n < 7; n++ {
+		got := strings.Split(mutateDescription("a", "Gorsko kolo", n, testClosings), "\n")
+		want := testClosings[n%len(testClosings)]
+		if len(got) != 3 || got[1] != "" || got[2] != want {
+			t.Errorf("variation %d ends %q, want a blank line and %q", n, got[1:], want)
+		}
+	}
+}
+
+// No variation adds, drops or changes anything but one trailing space per
+// line and the order of the lines within their block
+func TestMutateDescriptionPreservesText(t *testing.T) {
+	// the description in parts, blocks are shuffled
+	parts := []struct {
+		block bool
+		lines []string
+	}{
+		{false, []string{"Malo rabljeno gorsko kolo.", ""}},
+		{true, []string{"Okvir M.", "Zavore hidravlične.", "Gume nove.", "Sedež udoben."}},
+		{false, []string{"Cena ni fiksna.  ", "\tPrevzem v Ljubljani."}},
+		{true, []string{"Barva rdeča.", "Teža 12 kg.  "}},
+		{false, []string{"", "", "Kontakt: 041 123 456", "[shuffle]", "nezaprt blok"}},
+	}
+	var orig []string
+	for _, p := range parts {
+		if p.block {
+			orig = append(orig, shuffleStart)
+		}
+		orig = append(orig, p.lines...)
+		if p.block {
+			orig = append(orig, shuffleEnd)
+		}
+	}
+	description := strings.Join(orig, "\n")
+
+	for n := 0; n < 50; n++ {
+		got := unclosedLines(mutateDescription(description, "Gorsko kolo", n, testClosings))
+
+		i := 0
+		for _, p := range parts {
+			if i+len(p.lines) > len(got) {
+				t.Fatalf("variation %d has %d lines, want more", n, len(got))
+			}
+			out := got[i : i+len(p.lines)]
+			i += len(p.lines)
+
+			if !p.block {
+				for j, l := range out {
+					if !varied(l, p.lines[j]) {
+						t.Errorf("variation %d: line %q, want %q", n, l, p.lines[j])
+					}
+				}
+				continue
+			}
+
+			// the block holds the same lines in any order
+			unvaried := make([]string, len(out))
+			for j, l := range out {
+				unvaried[j] = l
+				if !containsLine(p.lines, l) {
+					unvaried[j] = strings.TrimSuffix(l, " ")
+				}
+			}
+			want := append([]string{}, p.lines...)
+			sort.Strings(unvaried)
+			sort.Strings(want)
+			if strings.Join(unvaried, "\n") != strings.Join(want, "\n") {
+				t.Errorf("variation %d: block %q, want the lines %q", n, out, p.lines)
+			}
+		}
+		if i != len(got) {
+			t.Errorf("variation %d has %d lines, want %d", n, len(got), i)
+		}
+	}
+}
+
+func containsLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// The length limit applies to the description uploaded, a closing pushing
+// it over the limit makes the item invalid before any ad is removed
+func TestValidateUploadedDescriptionLength(t *testing.T) {
+	const title = "Gorsko kolo"
+
+	for _, mutate := range []bool{false, true} {
+		t.Run(fmt.Sprint(mutate), func(t *testing.T) {
+			// the description has 26 characters, 40 with the closing
+			t.Setenv("VALIDATION_RULES", `{"maxDescriptionLength": 30}`)
+			s := newScenario(t, "new")
+			s.update(title, map[string]interface{}{"MutateDescription": mutate, "DescriptionClosings": []string{"Lep pozdrav!"}})
+
+			report, _ := s.run()
+			if report == nil {
+				t.Fatal("no report")
+			}
+			ir := itemReport(t, report, title)
+			if !mutate {
+				if ir.Status != statusUploaded {
+					t.Errorf("status %q (error %q), want %q", ir.Status, ir.Error, statusUploaded)
+				}
+				return
+			}
+			if ir.SkipReason != SkipInvalid || !strings.Contains(ir.Error, "description has 40 characters") {
+				t.Errorf("skip reason %q, error %q, want invalid for the 40 characters uploaded", ir.SkipReason, ir.Error)
+			}
+			if n := len(s.calls("UploadAd")); n != 0 {
+				t.Errorf("%d uploads of an invalid item", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</p\s*>`)
+	htmlTagRe   = regexp.MustCompile(`<[^<>]*>`)
+)
+
+// typography replaces characters which render badly on bolha
+var typography = strings.NewReplacer(
+	"\u00a0", " ", // no-break space
+	"\u202f", " ", // narrow no-break space
+	"\u2018", "'", // smart quotes
+	"\u2019", "'",
+	"\u201a", "'",
+	"\u201c", `"`,
+	"\u201d", `"`,
+	"\u201e", `"`,
+)
+
+// normalizeDescription cleans up a pasted description: line endings become
+// LF, html tags are optionally stripped, html entities decoded, no-break
+// spaces and smart quotes replaced, control characters removed, the text
+// is NFC normalized and runs of more than two blank lines are collapsed.
+// The stored description is left as is and normalized on every run, so the
+// hash only changes once when normalization is introduced.
+func normalizeDescription(s string, stripHTML bool) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+
+	// tags are stripped before decoding so that escaped tags stay text
+	if stripHTML {
+		s = htmlBreakRe.ReplaceAllString(s, "\n")
+		s = htmlTagRe.ReplaceAllString(s, "")
+	}
+	s = html.UnescapeString(s)
+	s = typography.Replace(s)
+
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == '\ufeff' { // byte order mark
+			return -1
+		}
+		return r
+	}, s)
+
+	s = norm.NFC.String(s)
+
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	blank := 0
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			blank++
+			if blank > 2 {
+				continue
+			}
+			l = ""
+		} else {
+			blank = 0
+		}
+		out = append(out, l)
+	}
+
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	notificationNeedsAttention = "needs-attention"
+	notificationUploadPending  = "upload-pending"
+	notificationUploadFailed   = "upload-failed"
+	notificationAdBlocked      = "ad-blocked"
+	notificationPriceBlocked   = "price-blocked"
+	notificationDuplicate      = "duplicate-rejected"
+	notificationDigest         = "digest"
+	notificationSessionAging   = "session-aging"
+	notificationRemovalStuck   = "removal-stuck"
+)
+
+const (
+	severityNormal = "normal"
+	severityHigh   = "high"
+)
+
+// Notification is a message sent to the operator
+type Notification struct {
+	Kind     string      `json:"kind"`
+	Severity string      `json:"severity"`
+	Subject  string      `json:"subject"`
+	Message  string      `json:"message"`
+	Version  VersionInfo `json:"version"`
+
+	// links to the ad and a pre-signed link to its first image, if known
+	AdURL    string `json:"adUrl,omitempty"`
+	ImageURL string `json:"imageUrl,omitempty"`
+
+	// owner of the item, see reportUser
+	User string `json:"user,omitempty"`
+
+	// invocation which sent it, see runid.go
+	RunId string `json:"runId,omitempty"`
+}
+
+func newNotification(kind, subject, message string) Notification {
+	return Notification{
+		Kind:     kind,
+		Severity: severityNormal,
+		Subject:  subject,
+		Message:  message,
+		Version:  version,
+	}
+}
+
+type notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// logNotifier only logs notifications, used when no channel is configured
+type logNotifier struct {
+	log *log.Entry
+}
+
+func (ln logNotifier) Notify(ctx context.Context, n Notification) error {
+	ln.log.WithFields(log.Fields{
+		"kind":     n.Kind,
+		"severity": n.Severity,
+		"subject":  n.Subject,
+		"version":  n.Version.String(),
+		"adURL":    n.AdURL,
+	}).Warn(n.Message)
+
+	return nil
+}
+
+// snsNotifier publishes notifications to an sns topic
+type snsNotifier struct {
+	snsc     *sns.Client
+	topicArn string
+	log      *log.Entry
+}
+
+func (sn *snsNotifier) Notify(ctx context.Context, n Notification) error {
+	sn.log.WithFields(log.Fields{"kind": n.Kind, "topicArn": sn.topicArn}).Info("publishing notification...")
+
+	_, err := sn.snsc.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(sn.topicArn),
+		Subject:  aws.String(snsSubject(n.Subject)),
+		Message:  aws.String(snsMessage(n)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"kind":     {DataType: aws.String("String"), StringValue: aws.String(n.Kind)},
+			"severity": {DataType: aws.String("String"), StringValue: aws.String(n.Severity)},
+		},
+	})
+
+	return err
+}
+
+// digestNotifier collects the notifications of a run and sends them as a
+// single digest on flush, immediate kinds bypass the digest
+type digestNotifier struct {
+	next      notifier
+	immediate map[string]bool
+	// examples listed per kind
+	examples int
+
+	mu      sync.Mutex
+	pending []Notification
+}
+
+func newDigestNotifier(next notifier, immediate []string, examples int) *digestNotifier {
+	dn := &digestNotifier{
+		next:      next,
+		immediate: make(map[string]bool, len(immediate)),
+		examples:  examples,
+	}
+	for _, kind := range immediate {
+		dn.immediate[kind] = true
+	}
+
+	return dn
+}
+
+func (dn *digestNotifier) Notify(ctx context.Context, n Notification) error {
+	if dn.immediate[n.Kind] {
+		return dn.next.Notify(ctx, n)
+	}
+
+	dn.mu.Lock()
+	dn.pending = append(dn.pending, n)
+	dn.mu.Unlock()
+
+	return nil
+}
+
+// flush sends the collected notifications, a single one is sent as is
+func (dn *digestNotifier) flush(ctx context.Context) error {
+	dn.mu.Lock()
+	pending := dn.pending
+	dn.pending = nil
+	dn.mu.Unlock()
+
+	switch len(pending) {
+	case 0:
+		return nil
+	case 1:
+		return dn.next.Notify(ctx, pending[0])
+	}
+
+	return dn.next.Notify(ctx, newDigest(pending, dn.examples))
+}
+
+// newDigest groups notifications by kind, listing the count and up to
+// examples subjects of every kind. With more than one user the counts of
+// every user follow.
+func newDigest(ns []Notification, examples int) Notification {
+	groups := make(map[string][]Notification)
+	kinds := make([]string, 0)
+	byUser := make(map[string]map[string]int)
+	users := make([]string, 0)
+	severity := severityNormal
+	for _, n := range ns {
+		if _, ok := groups[n.Kind]; !ok {
+			kinds = append(kinds, n.Kind)
+		}
+		groups[n.Kind] = append(groups[n.Kind], n)
+		if n.Severity == severityHigh {
+			severity = severityHigh
+		}
+		if n.User != "" {
+			if _, ok := byUser[n.User]; !ok {
+				users = append(users, n.User)
+				byUser[n.User] = make(map[string]int)
+			}
+			byUser[n.User][n.Kind]++
+		}
+	}
+	sort.Strings(kinds)
+	sort.Strings(users)
+
+	counts := make([]string, len(kinds))
+	var msg strings.Builder
+	for i, kind := range kinds {
+		group := groups[kind]
+		counts[i] = fmt.Sprintf("%d %s", len(group), kind)
+
+		fmt.Fprintf(&msg, "%s (%d)\n", kind, len(group))
+		for j, n := range group {
+			if j == examples {
+				fmt.Fprintf(&msg, "- and %d more\n", len(group)-examples)
+				break
+			}
+			if n.AdURL != "" {
+				fmt.Fprintf(&msg, "- %s: %s\n", n.Subject, n.AdURL)
+			} else {
+				fmt.Fprintf(&msg, "- %s\n", n.Subject)
+			}
+		}
+		msg.WriteString("\n")
+	}
+
+	if len(users) > 1 {
+		msg.WriteString("by user\n")
+		for _, user := range users {
+			userCounts := make([]string, 0, len(byUser[user]))
+			for _, kind := range kinds {
+				if c := byUser[user][kind]; c > 0 {
+					userCounts = append(userCounts, fmt.Sprintf("%d %s", c, kind))
+				}
+			}
+			fmt.Fprintf(&msg, "- %s: %s\n", user, strings.Join(userCounts, ", "))
+		}
+	}
+
+	d := newNotification(notificationDigest, "bolha monitor: "+strings.Join(counts, ", "), strings.TrimSpace(msg.String()))
+	d.Severity = severity
+
+	return d
+}
+
+// flushNotifications sends the digest of the notifications collected so far
+func (m *monitor) flushNotifications(ctx context.Context) {
+	dn, ok := m.notif.(*digestNotifier)
+	if !ok {
+		return
+	}
+
+	if err := dn.flush(ctx); err != nil {
+		m.log.WithError(err).Warn("could not send notification digest")
+	}
+}
+
+// snsMessage is the message followed by the links and the build
+func snsMessage(n Notification) string {
+	msg := n.Message + "\n"
+	if n.AdURL != "" {
+		msg += "\nad: " + n.AdURL
+	}
+	if n.ImageURL != "" {
+		msg += "\nimage: " + n.ImageURL
+	}
+
+	return fmt.Sprintf("%s\nbuild: %s", msg, n.Version)
+}
+
+// snsSubject makes s a valid sns subject (ascii only, less than 100 chars)
+func snsSubject(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if len(b) == 99 {
+			break
+		}
+		if r < 0x20 || r > 0x7e {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+
+	return string(b)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func testNotification(kind, subject, user string) Notification {
+	n := newNotification(kind, subject, "")
+	n.User = user
+	return n
+}
+
+// Immediate kinds are sent at once, the others wait for the flush
+func TestDigestNotifierImmediate(t *testing.T) {
+	next := &fakeChannel{}
+	dn := newDigestNotifier(next, []string{notificationUploadPending}, 5)
+
+	for _, n := range []Notification{
+		testNotification(notificationNeedsAttention, "Gorsko kolo", ""),
+		testNotification(notificationUploadPending, "Kavč", ""),
+		testNotification(notificationAdBlocked, "Miza", ""),
+	} {
+		if err := dn.Notify(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := next.subjects(); fmt.Sprint(got) != "[Kavč]" {
+		t.Fatalf("sent %v before the flush, want only the immediate Kavč", got)
+	}
+
+	if err := dn.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.sent) != 2 || next.sent[1].Kind != notificationDigest {
+		t.Fatalf("sent %v, want the digest after Kavč", next.subjects())
+	}
+
+	// the digest took the pending notifications along
+	if err := dn.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.sent) != 2 {
+		t.Errorf("second flush sent %v", next.subjects()[2:])
+	}
+}
+
+// A single pending notification is not wrapped in a digest
+func TestDigestNotifierSingle(t *testing.T) {
+	next := &fakeChannel{}
+	dn := newDigestNotifier(next, nil, 5)
+
+	n := testNotification(notificationNeedsAttention, "Gorsko kolo", "")
+	if err := dn.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if err := dn.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.sent) != 1 || next.sent[0].Kind != notificationNeedsAttention || next.sent[0].Subject != "Gorsko kolo" {
+		t.Errorf("sent %+v, want the notification as is", next.sent)
+	}
+}
+
+func TestNewDigest(t *testing.T) {
+	a := testNotification(notificationNeedsAttention, "Gorsko kolo", "u1")
+	a.AdURL = "https://www.bolha.com/oglas-1000"
+	b := testNotification(notificationNeedsAttention, "Kavč", "u2")
+	c := testNotification(notificationNeedsAttention, "Miza", "u1")
+	d := testNotification(notificationAdBlocked, "Omara", "u2")
+	d.Severity = severityHigh
+
+	digest := newDigest([]Notification{a, b, c, d}, 2)
+
+	if digest.Kind != notificationDigest {
+		t.Errorf("kind %q, want %q", digest.Kind, notificationDigest)
+	}
+	if want := "bolha monitor: 1 ad-blocked, 3 needs-attention"; digest.Subject != want {
+		t.Errorf("subject %q, want %q", digest.Subject, want)
+	}
+	if digest.Severity != severityHigh {
+		t.Errorf("severity %q, want the highest of the group", digest.Severity)
+	}
+	want := `ad-blocked (1)
+- Omara
+
+needs-attention (3)
+- Gorsko kolo: https://www.bolha.com/oglas-1000
+- Kavč
+- and 1 more
+
+by user
+- u1: 2 needs-attention
+- u2: 1 ad-blocked, 1 needs-attention`
+	if digest.Message != want {
+		t.Errorf("message\n%s\nwant\n%s", digest.Message, want)
+	}
+
+	// a single user is not broken down
+	single := newDigest([]Notification{a, c}, 5)
+	if want := "needs-attention (2)\n- Gorsko kolo: https://www.bolha.com/oglas-1000\n- Miza"; single.Message != want {
+		t.Errorf("message of a single user\n%s\nwant\n%s", single.Message, want)
+	}
+	if single.Severity != severityNormal {
+		t.Errorf("severity %q, want %q", single.Severity, severityNormal)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"golang.org/x/time/rate"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Notifications are routed to channels by NOTIFY_ROUTES:
+//
+//	{
+//	  "channels": {
+//	    "ops":  {"type": "sns", "topicArn": "arn:aws:sns:..."},
+//	    "chat": {"type": "webhook", "url": "https://...", "perMinute": 10},
+//	    "logs": {"type": "log"}
+//	  },
+//	  "routes": [
+//	    {"kinds": ["upload-failed"], "severities": ["high"], "channels": ["ops", "chat"]},
+//	    {"kinds": ["digest"], "channels": ["chat"]}
+//	  ],
+//	  "default": ["ops"]
+//	}
+//
+// The first route whose kinds and severities (any if empty) match a
+// notification picks its channels, others go to the default channels. A
+// route without channels drops what it matches. Webhook channels post the
+// notification signed like item webhooks, channels over perMinute drop
+// notifications. Without NOTIFY_ROUTES everything goes to NOTIFY_TOPIC_ARN,
+// or to the log if it is empty. NOTIFY_MUTE only logs notifications.
+
+const (
+	channelSNS     = "sns"
+	channelWebhook = "webhook"
+	channelLog     = "log"
+)
+
+// NotifyRoutes is the routing of notifications to channels
+type NotifyRoutes struct {
+	Channels map[string]NotifyChannel `json:"channels"`
+	Routes   []NotifyRoute            `json:"routes"`
+	Default  []string                 `json:"default"`
+}
+
+// NotifyChannel is where notifications are sent
+type NotifyChannel struct {
+	Type string `json:"type"`
+	// sns, NOTIFY_TOPIC_ARN if empty
+	TopicArn string `json:"topicArn"`
+	// webhook
+	URL string `json:"url"`
+	// notifications sent at most per minute, unlimited if 0
+	PerMinute int `json:"perMinute"`
+}
+
+// NotifyRoute sends the notifications of kinds and severities to channels
+type NotifyRoute struct {
+	Kinds      []string `json:"kinds"`
+	Severities []string `json:"severities"`
+	Channels   []string `json:"channels"`
+}
+
+// parseNotifyRoutes parses and checks NOTIFY_ROUTES
+func parseNotifyRoutes(v string) (*NotifyRoutes, error) {
+	var routes NotifyRoutes
+	if err := json.Unmarshal([]byte(v), &routes); err != nil {
+		return nil, fmt.Errorf("invalid NOTIFY_ROUTES: %v", err)
+	}
+
+	for name, c := range routes.Channels {
+		switch c.Type {
+		case channelSNS, channelLog:
+		case channelWebhook:
+			if err := validateWebhookURL(c.URL); err != nil {
+				return nil, fmt.Errorf("invalid NOTIFY_ROUTES: channel %s: %v", name, err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid NOTIFY_ROUTES: channel %s has unknown type %q", name, c.Type)
+		}
+		if c.PerMinute < 0 {
+			return nil, fmt.Errorf("invalid NOTIFY_ROUTES: channel %s has a negative perMinute", name)
+		}
+	}
+
+	names := slices.Clone(routes.Default)
+	for _, r := range routes.Routes {
+		names = append(names, r.Channels...)
+	}
+	for _, name := range names {
+		if _, ok := routes.Channels[name]; !ok {
+			return nil, fmt.Errorf("invalid NOTIFY_ROUTES: unknown channel %q", name)
+		}
+	}
+
+	return &routes, nil
+}
+
+// notifyChannel is a channel and its rate limit, nil if unlimited
+type notifyChannel struct {
+	name    string
+	next    notifier
+	limiter *rate.Limiter
+}
+
+// routingNotifier sends every notification to the channels of its route
+type routingNotifier struct {
+	channels map[string]*notifyChannel
+	routes   []NotifyRoute
+	def      []string
+	muted    bool
+	runId    string
+	log      *log.Entry
+}
+
+// newRoutingNotifier creates the channels of cfg, snsc is only used by sns
+// channels, notifications are stamped with runId
+func newRoutingNotifier(cfg *Config, snsc *sns.Client, runId string) *routingNotifier {
+	routes := cfg.NotifyRoutes
+	if routes == nil {
+		c := NotifyChannel{Type: channelLog}
+		if cfg.NotifyTopicArn != "" {
+			c.Type = channelSNS
+		}
+		routes = &NotifyRoutes{Channels: map[string]NotifyChannel{"default": c}, Default: []string{"default"}}
+	}
+
+	rn := &routingNotifier{
+		channels: make(map[string]*notifyChannel, len(routes.Channels)),
+		routes:   routes.Routes,
+		def:      routes.Default,
+		muted:    cfg.NotifyMute,
+		runId:    runId,
+		log:      runLogger(runId),
+	}
+	for name, c := range routes.Channels {
+		ch := &notifyChannel{name: name}
+		switch c.Type {
+		case channelSNS:
+			topicArn := c.TopicArn
+			if topicArn == "" {
+				topicArn = cfg.NotifyTopicArn
+			}
+			ch.next = &snsNotifier{snsc: snsc, topicArn: topicArn, log: rn.log}
+		case channelWebhook:
+			ch.next = &webhookNotifier{url: c.URL, secret: cfg.WebhookSecret, timeout: cfg.WebhookTimeout, log: rn.log}
+		default:
+			ch.next = logNotifier{log: rn.log}
+		}
+		if c.PerMinute > 0 {
+			ch.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(c.PerMinute)), c.PerMinute)
+		}
+		rn.channels[name] = ch
+	}
+
+	return rn
+}
+
+// route returns the channels of n
+func (rn *routingNotifier) route(n Notification) []string {
+	for _, r := range rn.routes {
+		if len(r.Kinds) > 0 && !slices.Contains(r.Kinds, n.Kind) {
+			continue
+		}
+		if len(r.Severities) > 0 && !slices.Contains(r.Severities, n.Severity) {
+			continue
+		}
+		return r.Channels
+	}
+	return rn.def
+}
+
+// Notify sends n to all channels of its route at once, a failing channel
+// does not keep n from the others
+func (rn *routingNotifier) Notify(ctx context.Context, n Notification) error {
+	if n.RunId == "" {
+		n.RunId = rn.runId
+	}
+	if rn.muted {
+		rn.log.WithFields(log.Fields{"kind": n.Kind, "subject": n.Subject}).Info("notifications muted")
+		return nil
+	}
+
+	var wg sync.WaitGroup
+
+	names := rn.route(n)
+	errChan := make(chan error, len(names))
+
+	for _, name := range names {
+		ch := rn.channels[name]
+		if ch.limiter != nil && !ch.limiter.Allow() {
+			rn.log.WithFields(log.Fields{"channel": name, "kind": n.Kind, "subject": n.Subject}).Warn("channel rate limited, dropping notification")
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := ch.next.Notify(ctx, n); err != nil {
+				errChan <- fmt.Errorf("channel %s: %w", ch.name, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	var errs []error
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// webhookNotifier posts notifications signed with WEBHOOK_SECRET
+type webhookNotifier struct {
+	url     string
+	secret  string
+	timeout time.Duration
+	log     *log.Entry
+}
+
+func (wn *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	if wn.secret == "" {
+		return errors.New("WEBHOOK_SECRET is not set, not calling unsigned webhook")
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	wn.log.WithFields(log.Fields{"kind": n.Kind, "url": wn.url}).Info("posting notification...")
+
+	return doWebhook(ctx, wn.timeout, wn.url, body, signWebhook(wn.secret, body))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeChannel records what it is sent, failing with err if set and
+// waiting for wait to close first if set
+type fakeChannel struct {
+	mu   sync.Mutex
+	sent []Notification
+	err  error
+	wait chan struct{}
+}
+
+func (c *fakeChannel) Notify(ctx context.Context, n Notification) error {
+	if c.wait != nil {
+		select {
+		case <-c.wait:
+		case <-time.After(5 * time.Second):
+			return errors.New("waited for the other channels in vain")
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent = append(c.sent, n)
+	return c.err
+}
+
+func (c *fakeChannel) subjects() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subjects := make([]string, 0, len(c.sent))
+	for _, n := range c.sent {
+		subjects = append(subjects, n.Subject)
+	}
+	return subjects
+}
+
+const testRoutes = `{
+	"channels": {
+		"ops":  {"type": "log"},
+		"chat": {"type": "log"},
+		"logs": {"type": "log", "perMinute": 1}
+	},
+	"routes": [
+		{"kinds": ["upload-failed"], "severities": ["high"], "channels": ["ops", "chat"]},
+		{"kinds": ["digest"], "channels": ["chat"]},
+		{"kinds": ["session-aging"]},
+		{"severities": ["high"], "channels": ["ops"]}
+	],
+	"default": ["logs"]
+}`
+
+// newTestRouting returns a routing notifier of testRoutes whose channels
+// are fakes
+func newTestRouting(t *testing.T, muted bool) (*routingNotifier, map[string]*fakeChannel) {
+	t.Helper()
+
+	routes, err := parseNotifyRoutes(testRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rn := newRoutingNotifier(&Config{NotifyRoutes: routes, NotifyMute: muted}, nil, "run-1")
+
+	fakes := make(map[string]*fakeChannel)
+	for name, ch := range rn.channels {
+		fakes[name] = &fakeChannel{}
+		ch.next = fakes[name]
+	}
+	return rn, fakes
+}
+
+func TestRoutingNotifierRoutes(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		severity string
+		channels []string
+	}{
+		{"kind and severity match", notificationUploadFailed, severityHigh, []string{"chat", "ops"}},
+		{"severity does not match the first route", notificationUploadFailed, severityNormal, []string{"logs"}},
+		{"kind only route", notificationDigest, severityNormal, []string{"chat"}},
+		{"route without channels drops", notificationSessionAging, severityHigh, []string{}},
+		{"severity only route", notificationAdBlocked, severityHigh, []string{"ops"}},
+		{"default", notificationNeedsAttention, severityNormal, []string{"logs"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rn, fakes := newTestRouting(t, false)
+
+			n := newNotification(tt.kind, tt.name, "")
+			n.Severity = tt.severity
+			if err := rn.Notify(context.Background(), n); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0)
+			for name, c := range fakes {
+				for _, sent := range c.sent {
+					got = append(got, name)
+					if sent.RunId != "run-1" {
+						t.Errorf("%s got run id %q, want run-1", name, sent.RunId)
+					}
+				}
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.channels) {
+				t.Errorf("sent to %v, want %v", got, tt.channels)
+			}
+		})
+	}
+}
+
+func TestRoutingNotifierMuted(t *testing.T) {
+	rn, fakes := newTestRouting(t, true)
+
+	n := newNotification(notificationUploadFailed, "muted", "")
+	n.Severity = severityHigh
+	if err := rn.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range fakes {
+		if len(c.sent) > 0 {
+			t.Errorf("muted notification sent to %s", name)
+		}
+	}
+}
+
+func TestRoutingNotifierRateLimited(t *testing.T) {
+	rn, fakes := newTestRouting(t, false)
+
+	for i := 0; i < 3; i++ {
+		if err := rn.Notify(context.Background(), newNotification(notificationNeedsAttention, fmt.Sprint(i), "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fakes["logs"].subjects(); fmt.Sprint(got) != "[0]" {
+		t.Errorf("logs got %v, want only the first within its perMinute of 1", got)
+	}
+}
+
+// A failing channel does not keep the notification from the others, nor
+// does a slow one: the slow channel only answers once the others got it
+func TestRoutingNotifierFailingChannel(t *testing.T) {
+	rn, fakes := newTestRouting(t, false)
+	fakes["chat"].err = errors.New("chat is down")
+	fakes["ops"].wait = make(chan struct{})
+
+	go func() {
+		for {
+			if len(fakes["chat"].subjects()) > 0 {
+				close(fakes["ops"].wait)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	n := newNotification(notificationUploadFailed, "failing", "")
+	n.Severity = severityHigh
+	err := rn.Notify(context.Background(), n)
+
+	if err == nil || !strings.Contains(err.Error(), "channel chat: chat is down") {
+		t.Errorf("got %v, want the error of chat", err)
+	}
+	if strings.Contains(fmt.Sprint(err), "ops") {
+		t.Errorf("ops failed: %v", err)
+	}
+	if got := fakes["ops"].subjects(); len(got) != 1 {
+		t.Errorf("ops got %v, want the notification", got)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DynamoDB refuses items above 400 KB. The description is the only
+// attribute of an item without a bound, once an item grows past
+// ITEM_SIZE_OFFLOAD_BYTES its description is moved to OFFLOAD_BUCKET and
+// AdDescriptionOffloadKey points to it. Reads resolve the pointer, an
+// inline description written later wins over it.
+const (
+	maxItemBytes = 400 << 10
+
+	offloadPrefix = "offload/"
+
+	// offloaded descriptions were items once, they stay well below this
+	maxOffloadedBytes = 1 << 20
+)
+
+// itemSize estimates the size DynamoDB accounts an item with: attribute
+// names plus values, numbers are counted as their digits
+func itemSize(item map[string]types.AttributeValue) int {
+	size := 0
+	for name, v := range item {
+		size += len(name) + attributeSize(v)
+	}
+	return size
+}
+
+func attributeSize(v types.AttributeValue) int {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return len(v.Value)
+	case *types.AttributeValueMemberN:
+		return len(v.Value)
+	case *types.AttributeValueMemberB:
+		return len(v.Value)
+	case *types.AttributeValueMemberBOOL, *types.AttributeValueMemberNULL:
+		return 1
+	case *types.AttributeValueMemberSS:
+		size := 0
+		for _, s := range v.Value {
+			size += len(s)
+		}
+		return size
+	case *types.AttributeValueMemberNS:
+		size := 0
+		for _, s := range v.Value {
+			size += len(s)
+		}
+		return size
+	case *types.AttributeValueMemberBS:
+		size := 0
+		for _, b := range v.Value {
+			size += len(b)
+		}
+		return size
+	case *types.AttributeValueMemberL:
+		// 3 bytes for the list and 1 per element
+		size := 3
+		for _, e := range v.Value {
+			size += 1 + attributeSize(e)
+		}
+		return size
+	case *types.AttributeValueMemberM:
+		size := 3
+		for name, e := range v.Value {
+			size += 1 + len(name) + attributeSize(e)
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
+// fitItem makes item fit before it is put, offloading its description if
+// it is over ITEM_SIZE_OFFLOAD_BYTES
+func (m *monitor) fitItem(ctx context.Context, item map[string]types.AttributeValue) error {
+	title := ""
+	if v, ok := item["AdTitle"].(*types.AttributeValueMemberS); ok {
+		title = v.Value
+	}
+
+	size := itemSize(item)
+	if size > m.cfg.ItemSizeWarnBytes {
+		m.log.WithFields(log.Fields{"AdTitle": title, "size": size}).Warn("item approaching the dynamodb item size limit")
+	}
+	if size <= m.cfg.ItemSizeOffloadBytes {
+		return nil
+	}
+
+	description, ok := item["AdDescription"].(*types.AttributeValueMemberS)
+	if !ok || description.Value == "" {
+		return fmt.Errorf("item %q is %d bytes and has no description to offload", title, size)
+	}
+	key, err := m.offloadDescription(ctx, title, description.Value)
+	if err != nil {
+		return err
+	}
+	delete(item, "AdDescription")
+	item["AdDescriptionOffloadKey"] = &types.AttributeValueMemberS{Value: key}
+
+	if size = itemSize(item); size > maxItemBytes {
+		return fmt.Errorf("item %q is still %d bytes with its description offloaded", title, size)
+	}
+
+	return nil
+}
+
+// offloadLargeItem moves the description of a stored item over
+// ITEM_SIZE_OFFLOAD_BYTES to OFFLOAD_BUCKET so later updates do not fail
+func (m *monitor) offloadLargeItem(ctx context.Context, bItem *BolhaItem) error {
+	if bItem.size > m.cfg.ItemSizeWarnBytes {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "size": bItem.size}).Warn("item approaching the dynamodb item size limit")
+	}
+	if bItem.size <= m.cfg.ItemSizeOffloadBytes || bItem.AdDescription == "" {
+		return nil
+	}
+
+	key, err := m.offloadDescription(ctx, bItem.AdTitle, bItem.AdDescription)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":key": &types.AttributeValueMemberS{Value: key},
+		},
+		Key:              map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: bItem.AdTitle}},
+		UpdateExpression: aws.String("SET AdDescriptionOffloadKey = :key REMOVE AdDescription"),
+		TableName:        aws.String(m.table),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the resolved description stays as it was
+	bItem.AdDescriptionOffloadKey = key
+	m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "size": bItem.size, "key": key}).Info("description offloaded")
+
+	return nil
+}
+
+// itemSizeError explains an update refused because the item grew too large
+func itemSizeError(adTitle string, err error) error {
+	if err != nil && strings.Contains(err.Error(), "Item size has exceeded the maximum allowed size") {
+		return fmt.Errorf("item %q is over the dynamodb item size limit, set OFFLOAD_BUCKET to offload its description: %w", adTitle, err)
+	}
+	return err
+}
+
+// offloadKey is the key of the offloaded description of item adTitle
+func (m *monitor) offloadKey(adTitle string) string {
+	sum := sha256.Sum256([]byte(adTitle))
+	return offloadPrefix + m.table + "/" + hex.EncodeToString(sum[:8]) + "/AdDescription"
+}
+
+// S3
+
+func (m *monitor) offloadDescription(ctx context.Context, adTitle, description string) (string, error) {
+	if m.cfg.OffloadBucket == "" {
+		return "", fmt.Errorf("description of %q is too large for its item, set OFFLOAD_BUCKET to offload it", adTitle)
+	}
+
+	key := m.offloadKey(adTitle)
+	m.log.WithFields(log.Fields{"AdTitle": adTitle, "bucket": m.cfg.OffloadBucket, "key": key, "size": len(description)}).Info("offloading description...")
+
+	_, err := m.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(m.cfg.OffloadBucket),
+		Key:         aws.String(key),
+		Body:        strings.NewReader(description),
+		ContentType: aws.String("text/plain; charset=utf-8"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+func (m *monitor) downloadOffloaded(ctx context.Context, key string) (string, error) {
+	if m.cfg.OffloadBucket == "" {
+		return "", fmt.Errorf("description offloaded to %s but OFFLOAD_BUCKET is not set", key)
+	}
+
+	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.cfg.OffloadBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer obj.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(obj.Body, maxOffloadedBytes+1)); err != nil {
+		return "", err
+	}
+	if buf.Len() > maxOffloadedBytes {
+		return "", fmt.Errorf("offloaded description %s exceeds %d bytes", key, maxOffloadedBytes)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+const testOffloadBucket = "bolha-offload"
+
+// largeDescription returns a description of about 500 KB which
+// normalization leaves as it is
+func largeDescription() string {
+	var b strings.Builder
+	for i := 0; b.Len() < 500<<10; i++ {
+		fmt.Fprintf(&b, "%d. Malo rabljeno gorsko kolo, prevoženih nekaj kilometrov.\n", i)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// A stored item grown past ITEM_SIZE_OFFLOAD_BYTES has its description
+// moved to OFFLOAD_BUCKET, the next run reads it back from there
+func TestScenarioOffloadRoundTrip(t *testing.T) {
+	const title = "Gorsko kolo"
+	t.Setenv("OFFLOAD_BUCKET", testOffloadBucket)
+	t.Setenv("VALIDATION_RULES", `{"maxDescriptionLength": 1000000}`)
+	description := largeDescription()
+
+	s := newScenario(t, "sinking",
+		harness.Step{Orders: map[int64]int{1000: 40}},
+		harness.Step{Orders: map[int64]int{1001: 40}},
+	)
+	s.update(title, map[string]interface{}{"AdDescription": description, "ReuploadHours": 1})
+
+	report, err := s.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir := itemReport(t, report, title); ir.Status != statusReuploaded {
+		t.Fatalf("first run: status %q (error %q), want %q", ir.Status, ir.Error, statusReuploaded)
+	}
+
+	it := s.item(title)
+	if _, ok := it["AdDescription"]; ok {
+		t.Error("description still inline")
+	}
+	key, _ := it["AdDescriptionOffloadKey"].(string)
+	if !strings.HasPrefix(key, offloadPrefix+"items/") {
+		t.Fatalf("offload key %q", key)
+	}
+	if got := string(s.objects.Get(testOffloadBucket, key)); got != description {
+		t.Fatalf("offloaded %d bytes, want the %d of the description", len(got), len(description))
+	}
+	av, err := attributevalue.MarshalMap(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := itemSize(av); size >= 10<<10 {
+		t.Errorf("item is still %d bytes", size)
+	}
+
+	s.next(2 * time.Hour)
+	report, err = s.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir := itemReport(t, report, title); ir.Status != statusReuploaded {
+		t.Fatalf("second run: status %q (error %q), want %q", ir.Status, ir.Error, statusReuploaded)
+	}
+	if s.objects.Gets[testOffloadBucket+"/"+key] == 0 {
+		t.Error("offloaded description not read")
+	}
+
+	if len(s.client.Uploaded) != 2 {
+		t.Fatalf("%d uploads, want 2", len(s.client.Uploaded))
+	}
+	for i, ad := range s.client.Uploaded {
+		if ad.Description != description {
+			t.Errorf("upload %d has a description of %d bytes, want the %d of the original", i+1, len(ad.Description), len(description))
+		}
+	}
+}
+
+// An item written over ITEM_SIZE_OFFLOAD_BYTES is put with its description
+// offloaded, and fails without OFFLOAD_BUCKET
+func TestFitItem(t *testing.T) {
+	description := largeDescription()
+	newItem := func() map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"AdTitle":       &types.AttributeValueMemberS{Value: "Gorsko kolo"},
+			"AdDescription": &types.AttributeValueMemberS{Value: description},
+		}
+	}
+
+	s := newScenario(t, "sinking")
+	m, _ := s.monitor()
+	if err := m.fitItem(context.Background(), newItem()); err == nil || !strings.Contains(err.Error(), "OFFLOAD_BUCKET") {
+		t.Errorf("error %v without OFFLOAD_BUCKET", err)
+	}
+
+	t.Setenv("OFFLOAD_BUCKET", testOffloadBucket)
+	m, _ = s.monitor()
+	item := newItem()
+	if err := m.fitItem(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := item["AdDescription"]; ok {
+		t.Error("description still inline")
+	}
+	key, ok := item["AdDescriptionOffloadKey"].(*types.AttributeValueMemberS)
+	if !ok || key.Value != m.offloadKey("Gorsko kolo") {
+		t.Fatalf("offload key %v, want %s", item["AdDescriptionOffloadKey"], m.offloadKey("Gorsko kolo"))
+	}
+	got, err := m.downloadOffloaded(context.Background(), key.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != description {
+		t.Errorf("read back %d bytes, want %d", len(got), len(description))
+	}
+
+	// a small item is left alone
+	small := map[string]types.AttributeValue{
+		"AdTitle":       &types.AttributeValueMemberS{Value: "Kavč"},
+		"AdDescription": &types.AttributeValueMemberS{Value: "Rabljeno."},
+	}
+	if err := m.fitItem(context.Background(), small); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := small["AdDescriptionOffloadKey"]; ok {
+		t.Error("small item offloaded")
+	}
+}
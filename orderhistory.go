@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The last ORDER_HISTORY_SIZE observed orders of an item are kept in its
+// OrderHistory as "<unix seconds>:<order>" entries, oldest first. They are
+// written along with LastObservedOrder and trimmed on every write, the
+// status page draws them as a sparkline.
+
+const (
+	sparklineWidth  = 160
+	sparklineHeight = 30
+)
+
+// orderPoint is an observed order
+type orderPoint struct {
+	at    time.Time
+	order int
+}
+
+// recordObservation remembers the order observed at now, keeping at most
+// historySize observations
+func (bItem *BolhaItem) recordObservation(writes *itemWrites, order int, now time.Time, historySize int) {
+	writes.set(bItem.AdTitle, "LastObservedOrder", &types.AttributeValueMemberN{Value: strconv.Itoa(order)})
+	writes.set(bItem.AdTitle, "LastCheckedAt", &types.AttributeValueMemberS{Value: storedTime(now)})
+	bItem.LastObservedOrder = order
+	bItem.LastCheckedAt = storedTime(now)
+
+	if historySize <= 0 {
+		return
+	}
+
+	history := append(bItem.OrderHistory, fmt.Sprintf("%d:%d", now.Unix(), order))
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	bItem.OrderHistory = history
+
+	list := make([]types.AttributeValue, len(history))
+	for i, e := range history {
+		list[i] = &types.AttributeValueMemberS{Value: e}
+	}
+	writes.set(bItem.AdTitle, "OrderHistory", &types.AttributeValueMemberL{Value: list})
+}
+
+// orderPoints parses the order history, invalid entries are left out
+func (bItem *BolhaItem) orderPoints() []orderPoint {
+	points := make([]orderPoint, 0, len(bItem.OrderHistory))
+	for _, e := range bItem.OrderHistory {
+		at, order, ok := strings.Cut(e, ":")
+		if !ok {
+			continue
+		}
+		sec, err := strconv.ParseInt(at, 10, 64)
+		if err != nil {
+			continue
+		}
+		o, err := strconv.Atoi(order)
+		if err != nil || o <= 0 {
+			continue
+		}
+		points = append(points, orderPoint{at: time.Unix(sec, 0), order: o})
+	}
+	return points
+}
+
+// orderSparkline draws the order history of bItem as an inline svg, the top
+// of the list (order 1) at the top, reuploads marked by vertical lines. It
+// is empty with fewer than two observations.
+func (bItem *BolhaItem) orderSparkline() template.HTML {
+	points := bItem.orderPoints()
+	if len(points) < 2 {
+		return ""
+	}
+
+	from, to := points[0].at, points[len(points)-1].at
+	span := to.Sub(from).Seconds()
+	maxOrder := 1
+	for _, p := range points {
+		if p.order > maxOrder {
+			maxOrder = p.order
+		}
+	}
+
+	x := func(t time.Time) float64 {
+		if span <= 0 {
+			return 0
+		}
+		return t.Sub(from).Seconds() / span * sparklineWidth
+	}
+	y := func(order int) float64 {
+		if maxOrder == 1 {
+			return 1
+		}
+		return 1 + float64(order-1)/float64(maxOrder-1)*(sparklineHeight-2)
+	}
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg width="%d" height="%d" viewBox="0 0 %d %d">`, sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight)
+
+	reuploads := append([]string{bItem.AdUploadedAt}, bItem.ReuploadTimes...)
+	for _, r := range reuploads {
+		at, err := time.Parse(time.RFC3339, r)
+		if err != nil || at.Before(from) || at.After(to) {
+			continue
+		}
+		fmt.Fprintf(&svg, `<line x1="%.1f" y1="0" x2="%.1f" y2="%d" stroke="#e07b00" stroke-dasharray="2,2"/>`, x(at), x(at), sparklineHeight)
+	}
+
+	coords := make([]string, len(points))
+	for i, p := range points {
+		coords[i] = fmt.Sprintf("%.1f,%.1f", x(p.at), y(p.order))
+	}
+	fmt.Fprintf(&svg, `<polyline points="%s" fill="none" stroke="#2a6ebb" stroke-width="1.5"/>`, strings.Join(coords, " "))
+	fmt.Fprintf(&svg, `<title>order %d to %d</title></svg>`, points[0].order, points[len(points)-1].order)
+
+	// only numbers and fixed markup go into the svg
+	return template.HTML(svg.String())
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	client "github.com/seniorescobar/bolha-client"
+	log "github.com/sirupsen/logrus"
+)
+
+// With PAYLOAD_BUCKET every successful upload leaves the ad it sent to
+// bolha under payloads/<ad id>/<upload time>.json, images by key, size and
+// sha256 of the bytes streamed. The keys are kept in AdPayloadKeys of the
+// item, the oldest beyond PAYLOADS_PER_ITEM are deleted, a lifecycle rule
+// on the prefix can expire the rest.
+
+const payloadPrefix = "payloads/"
+
+// UploadPayload is the ad an upload sent
+type UploadPayload struct {
+	AdId        int64          `json:"adId"`
+	AdTitle     string         `json:"adTitle"`
+	Table       string         `json:"table"`
+	UploadedAt  string         `json:"uploadedAt"`
+	Kind        string         `json:"kind"`
+	RunId       string         `json:"runId"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Price       int            `json:"price"`
+	CategoryId  int            `json:"categoryId"`
+	Images      []PayloadImage `json:"images"`
+}
+
+// PayloadImage is an image an upload streamed
+type PayloadImage struct {
+	Key    string `json:"key"`
+	Bucket string `json:"bucket"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// newUploadPayload describes ad uploaded as adId, images are the images it
+// streamed
+func newUploadPayload(table, kind, adTitle string, ad *client.Ad, images []*s3Image, adId int64, uploadedAt time.Time) *UploadPayload {
+	p := &UploadPayload{
+		AdId:        adId,
+		AdTitle:     adTitle,
+		Table:       table,
+		UploadedAt:  uploadedAt.UTC().Format(time.RFC3339),
+		Kind:        kind,
+		Title:       ad.Title,
+		Description: ad.Description,
+		Price:       ad.Price,
+		CategoryId:  ad.CategoryId,
+		Images:      make([]PayloadImage, len(images)),
+	}
+	for i, img := range images {
+		p.Images[i] = PayloadImage{Key: img.key, Bucket: img.bucket, Size: img.size, SHA256: img.sha256()}
+	}
+	return p
+}
+
+// payloadKey is the key the payload of the upload of adId at uploadedAt is
+// saved under
+func payloadKey(adId int64, uploadedAt time.Time) string {
+	return fmt.Sprintf("%s%d/%s.json", payloadPrefix, adId, uploadedAt.UTC().Format("20060102T150405.000Z"))
+}
+
+// payloadKeyOf returns the latest saved payload of the ad adId, empty if
+// there is none
+func (bItem *BolhaItem) payloadKeyOf(adId int64) string {
+	prefix := fmt.Sprintf("%s%d/", payloadPrefix, adId)
+	for i := len(bItem.AdPayloadKeys) - 1; i >= 0; i-- {
+		if strings.HasPrefix(bItem.AdPayloadKeys[i], prefix) {
+			return bItem.AdPayloadKeys[i]
+		}
+	}
+	return ""
+}
+
+// savePayload saves payload and records its key on bItem, dropping the
+// oldest payloads beyond PAYLOADS_PER_ITEM. The upload already happened, so
+// failures are only logged and an empty key is returned.
+func (m *monitor) savePayload(ctx context.Context, bItem *BolhaItem, payload *UploadPayload, uploadedAt time.Time) string {
+	if m.cfg.PayloadBucket == "" {
+		return ""
+	}
+
+	key := payloadKey(payload.AdId, uploadedAt)
+	logger := m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "bucket": m.cfg.PayloadBucket, "key": key})
+
+	if err := m.putPayload(ctx, key, payload); err != nil {
+		logger.WithError(err).Warn("could not save upload payload")
+		return ""
+	}
+
+	keys := append(append([]string{}, bItem.AdPayloadKeys...), key)
+	var dropped []string
+	if max := m.cfg.PayloadsPerItem; max > 0 && len(keys) > max {
+		dropped, keys = keys[:len(keys)-max], keys[len(keys)-max:]
+	}
+
+	if err := m.setPayloadKeys(ctx, bItem.AdTitle, keys); err != nil {
+		logger.WithError(err).Warn("could not record upload payload")
+		return key
+	}
+	bItem.AdPayloadKeys = keys
+
+	if len(dropped) > 0 {
+		failed, err := m.deleteObjects(ctx, m.cfg.PayloadBucket, dropped)
+		if err != nil || len(failed) > 0 {
+			logger.WithError(err).WithField("failed", failed).Warn("could not delete old upload payloads")
+		}
+	}
+
+	logger.Info("upload payload saved")
+
+	return key
+}
+
+// S3
+
+func (m *monitor) putPayload(ctx context.Context, key string, payload *UploadPayload) error {
+	b, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = m.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(m.cfg.PayloadBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+
+	return err
+}
+
+// DYNAMODB
+
+func (m *monitor) setPayloadKeys(ctx context.Context, adTitle string, keys []string) error {
+	list := make([]types.AttributeValue, len(keys))
+	for i, key := range keys {
+		list[i] = &types.AttributeValueMemberS{Value: key}
+	}
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":keys": &types.AttributeValueMemberL{Value: list},
+		},
+		Key:              map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression: aws.String("SET AdPayloadKeys = :keys"),
+		TableName:        aws.String(m.table),
+	})
+
+	return err
+}
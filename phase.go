@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// phases of an upload, persisted before every step so that a run which dies
+// between removing, uploading and recording an ad (e.g. a lambda timeout) is
+// finished by the next one. An idle item has no phase.
+//
+//	new ad:    uploading -> uploaded-unrecorded -> idle
+//	safe mode: removing -> removed -> uploaded-unrecorded -> idle
+//	fast mode: uploading -> uploaded-unrecorded -> idle
+const (
+	phaseRemoving           = "removing"
+	phaseRemoved            = "removed"
+	phaseUploading          = "uploading"
+	phaseUploadedUnrecorded = "uploaded-unrecorded"
+)
+
+// resumer holds what resuming needs to know about the other items of a run
+type resumer struct {
+	// ad ids claimed by items, by user key
+	tracked map[string]map[int64]bool
+	// number of items left in a phase, by user key
+	unfinished map[string]int
+}
+
+func newResumer(bItems []BolhaItem) *resumer {
+	r := &resumer{
+		tracked:    make(map[string]map[int64]bool),
+		unfinished: make(map[string]int),
+	}
+	for _, bItem := range bItems {
+		k := bItem.userKey()
+		if r.tracked[k] == nil {
+			r.tracked[k] = make(map[int64]bool)
+		}
+		for _, id := range []int64{bItem.AdUploadedId, bItem.ReuploadOldId, bItem.ReuploadNewId} {
+			if id != 0 {
+				r.tracked[k][id] = true
+			}
+		}
+		if bItem.ReuploadPhase != "" {
+			r.unfinished[k]++
+		}
+	}
+
+	return r
+}
+
+// setPhase persists the phase of bItem along with the ad being replaced and
+// the uploaded but not yet recorded ad
+func (m *monitor) setPhase(ctx context.Context, bItem *BolhaItem, phase string, oldId, newId int64) error {
+	now := storedTime(m.now())
+	if err := m.putPhase(ctx, bItem.AdTitle, phase, now, oldId, newId); err != nil {
+		return err
+	}
+	bItem.ReuploadPhase, bItem.ReuploadPhaseAt = phase, now
+	bItem.ReuploadOldId, bItem.ReuploadNewId = oldId, newId
+
+	return nil
+}
+
+// resume finishes the upload a previous run left in a phase: an ad which was
+// about to be replaced is removed, an uploaded ad is recorded and an ad whose
+// upload may have happened is looked for among the user's untracked ads. If
+// no upload is found the uploaded id is cleared so the ad gets uploaded.
+// Phases older than PHASE_STALE_AFTER flag the item as needing attention.
+func (m *monitor) resume(ctx context.Context, c *bolhaClient, res *resumer, bItem *BolhaItem, hash string) error {
+	m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "phase": bItem.ReuploadPhase, "since": bItem.ReuploadPhaseAt}).Warn("resuming unfinished upload...")
+
+	if phaseAt, err := time.Parse(time.RFC3339, bItem.ReuploadPhaseAt); err == nil && m.now().Sub(phaseAt) > m.cfg.PhaseStaleAfter {
+		if err := m.flagStalePhase(ctx, bItem); err != nil {
+			m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Error("could not flag stale phase")
+		}
+	}
+
+	activeAds, err := c.GetActiveAds(ctx)
+	if err != nil {
+		return err
+	}
+	live := make(map[int64]bool, len(activeAds))
+	for _, ad := range activeAds {
+		live[ad.Id] = true
+	}
+
+	// the ad being replaced has to go either way
+	if id := bItem.ReuploadOldId; id != 0 && live[id] {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdUploadedId": id}).Info("removing replaced ad...")
+		if err := m.removeAd(ctx, c, id); err != nil {
+			return err
+		}
+	}
+
+	var newId int64
+	switch bItem.ReuploadPhase {
+	case phaseRemoving:
+		// nothing was uploaded yet
+	case phaseUploadedUnrecorded:
+		if live[bItem.ReuploadNewId] {
+			newId = bItem.ReuploadNewId
+			break
+		}
+		fallthrough
+	default:
+		untracked := make([]int64, 0)
+		for _, ad := range activeAds {
+			if !res.tracked[bItem.userKey()][ad.Id] {
+				untracked = append(untracked, ad.Id)
+			}
+		}
+		sort.Slice(untracked, func(i, j int) bool { return untracked[i] < untracked[j] })
+
+		switch {
+		case len(untracked) == 0:
+		case len(untracked) > 1 || res.unfinished[bItem.userKey()] > 1:
+			return fmt.Errorf("cannot tell which of the untracked ads %v is the upload of %q", untracked, bItem.AdTitle)
+		default:
+			newId = untracked[0]
+		}
+	}
+
+	if newId == 0 {
+		m.log.WithField("AdTitle", bItem.AdTitle).Info("no upload found, uploading again")
+		if err := m.setPhase(ctx, bItem, phaseRemoved, 0, 0); err != nil {
+			return err
+		}
+		bItem.AdUploadedId = 0
+		return nil
+	}
+
+	// the upload happened close to when the phase was last set
+	uploadedAt, err := time.Parse(time.RFC3339, bItem.ReuploadPhaseAt)
+	if err != nil {
+		uploadedAt = m.now()
+	}
+
+	m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdUploadedId": newId, "uploadedAt": uploadedAt}).Info("recording found upload")
+	if err := m.updateUploadedId(ctx, bItem, newId, uploadedAt, hash); err != nil {
+		return err
+	}
+	bItem.AdUploadedId, bItem.AdUploadedUrl = newId, ""
+	bItem.AdUploadedAt = storedTime(uploadedAt)
+	bItem.AdContentHash = hash
+	bItem.AdState = adStateActive
+	bItem.AdUploadedPrice, bItem.ConfirmPriceChange = bItem.AdPrice, false
+	bItem.clearPhase()
+
+	return nil
+}
+
+// clearPhase marks bItem idle, updateUploadedId does the same in the table
+func (bItem *BolhaItem) clearPhase() {
+	bItem.ReuploadPhase, bItem.ReuploadPhaseAt = "", ""
+	bItem.ReuploadOldId, bItem.ReuploadNewId = 0, 0
+}
+
+// flagStalePhase flags an item stuck in a phase as needing attention and notifies once
+func (m *monitor) flagStalePhase(ctx context.Context, bItem *BolhaItem) error {
+	if bItem.NeedsAttention {
+		return nil
+	}
+
+	if err := m.setNeedsAttention(ctx, bItem.AdTitle); err != nil {
+		return err
+	}
+	bItem.NeedsAttention = true
+
+	n := m.itemNotification(ctx, bItem,
+		notificationNeedsAttention,
+		fmt.Sprintf("%s is stuck", bItem.AdTitle),
+		fmt.Sprintf("the upload of %q has been %s since %s", bItem.AdTitle, bItem.ReuploadPhase, m.cfg.displayStored(bItem.ReuploadPhaseAt)),
+	)
+	n.Severity = severityHigh
+
+	return m.notif.Notify(ctx, n)
+}
+
+// DYNAMODB
+
+func (m *monitor) putPhase(ctx context.Context, adTitle, phase, phaseAt string, oldId, newId int64) error {
+	m.log.WithFields(log.Fields{"AdTitle": adTitle, "phase": phase}).Info("setting phase...")
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":phase":   &types.AttributeValueMemberS{Value: phase},
+			":phaseAt": &types.AttributeValueMemberS{Value: phaseAt},
+			":oldId":   &types.AttributeValueMemberN{Value: strconv.FormatInt(oldId, 10)},
+			":newId":   &types.AttributeValueMemberN{Value: strconv.FormatInt(newId, 10)},
+		},
+		Key:              map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression: aws.String("SET ReuploadPhase = :phase, ReuploadPhaseAt = :phaseAt, ReuploadOldId = :oldId, ReuploadNewId = :newId"),
+		TableName:        aws.String(m.table),
+	})
+
+	return err
+}
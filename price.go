@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Price is an amount in cents. In the table it is a number, or a string, of
+// euros with at most two decimals, legacy items hold whole euros.
+type Price int64
+
+// invalidPrice is read for a stored price which cannot be parsed, the item
+// is rejected by validation instead of failing the whole scan
+const invalidPrice Price = math.MinInt64
+
+// eurosPrice returns the price of whole euros
+func eurosPrice(euros int) Price {
+	return Price(euros) * 100
+}
+
+// parsePrice parses a non-negative amount of euros with at most two
+// decimals, a decimal comma is accepted as well
+func parsePrice(s string) (Price, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", 1)
+	if s == "" {
+		return 0, errors.New("price is empty")
+	}
+	if strings.HasPrefix(s, "-") {
+		return 0, fmt.Errorf("price %s is negative", s)
+	}
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("price %s has more than two decimals", s)
+	}
+	if whole == "" || (hasFrac && frac == "") || strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, fmt.Errorf("price %q is not an amount of euros", s)
+	}
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("price %s is too large", s)
+	}
+	cents := w * 100
+	if frac != "" {
+		f, _ := strconv.ParseInt(frac+strings.Repeat("0", 2-len(frac)), 10, 64)
+		cents += f
+	}
+
+	return Price(cents), nil
+}
+
+// String formats whole euros without decimals, as legacy items stored them
+func (p Price) String() string {
+	if p == invalidPrice {
+		return "invalid"
+	}
+	if p%100 == 0 {
+		return strconv.FormatInt(int64(p/100), 10)
+	}
+	return fmt.Sprintf("%d.%02d", p/100, p%100)
+}
+
+// euros rounds p half up to whole euros
+func (p Price) euros() int {
+	return int((p + 50) / 100)
+}
+
+func (p Price) MarshalJSON() ([]byte, error) {
+	if p == invalidPrice {
+		return []byte("null"), nil
+	}
+	return []byte(p.String()), nil
+}
+
+func (p Price) MarshalDynamoDBAttributeValue() (types.AttributeValue, error) {
+	if p == invalidPrice {
+		return nil, errors.New("cannot store an invalid price")
+	}
+	return &types.AttributeValueMemberN{Value: p.String()}, nil
+}
+
+func (p *Price) UnmarshalDynamoDBAttributeValue(av types.AttributeValue) error {
+	var s string
+	switch v := av.(type) {
+	case *types.AttributeValueMemberN:
+		s = v.Value
+	case *types.AttributeValueMemberS:
+		s = v.Value
+	case *types.AttributeValueMemberNULL:
+		*p = 0
+		return nil
+	default:
+		*p = invalidPrice
+		return nil
+	}
+
+	price, err := parsePrice(s)
+	if err != nil {
+		*p = invalidPrice
+		return nil
+	}
+	*p = price
+
+	return nil
+}
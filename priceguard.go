@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PriceRange is the price an item is expected to sell for, 0 means no bound
+type PriceRange struct {
+	Min Price
+	Max Price
+}
+
+// PriceGuardError is returned for items whose price looks like a typo
+type PriceGuardError struct {
+	AdTitle  string
+	Problems []string
+}
+
+func (e *PriceGuardError) Error() string {
+	return fmt.Sprintf("price of %q blocked: %s", e.AdTitle, strings.Join(e.Problems, "; "))
+}
+
+// checkPrice compares the price of bItem with its expected range and with
+// the price of its last upload, a change of more than maxChangePercent needs
+// ConfirmPriceChange on the item
+func checkPrice(bItem *BolhaItem, maxChangePercent int) error {
+	problems := make([]string, 0)
+
+	if r := bItem.ExpectedPriceRange; r != nil {
+		if r.Min > 0 && bItem.AdPrice < r.Min {
+			problems = append(problems, fmt.Sprintf("price %s is below the expected %s", bItem.AdPrice, r.Min))
+		}
+		if r.Max > 0 && bItem.AdPrice > r.Max {
+			problems = append(problems, fmt.Sprintf("price %s is above the expected %s", bItem.AdPrice, r.Max))
+		}
+	}
+
+	// items uploaded before the price was stored have nothing to compare with
+	if last := bItem.AdUploadedPrice; maxChangePercent > 0 && last > 0 && !bItem.ConfirmPriceChange {
+		change := bItem.AdPrice - last
+		if change < 0 {
+			change = -change
+		}
+		if change*100 > last*Price(maxChangePercent) {
+			problems = append(problems, fmt.Sprintf("price changed from %s to %s, more than %d%%, set ConfirmPriceChange to upload it", last, bItem.AdPrice, maxChangePercent))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &PriceGuardError{AdTitle: bItem.AdTitle, Problems: problems}
+	}
+
+	return nil
+}
+
+// blockPrice flags an item whose price was refused as needing attention and
+// notifies once
+func (m *monitor) blockPrice(ctx context.Context, bItem *BolhaItem, priceErr error) error {
+	m.log.WithField("AdTitle", bItem.AdTitle).WithError(priceErr).Warn("price blocked")
+
+	if bItem.NeedsAttention {
+		return nil
+	}
+
+	if err := m.setNeedsAttention(ctx, bItem.AdTitle); err != nil {
+		return err
+	}
+	bItem.NeedsAttention = true
+
+	n := m.itemNotification(ctx, bItem,
+		notificationPriceBlocked,
+		fmt.Sprintf("price of %s blocked", bItem.AdTitle),
+		fmt.Sprintf("%v, the ad is not uploaded until the price is fixed or confirmed", priceErr),
+	)
+	n.Severity = severityHigh
+
+	return m.notif.Notify(ctx, n)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+
+	client "github.com/seniorescobar/bolha-client"
+	"golang.org/x/time/rate"
+)
+
+// newBolhaLimiter returns the limiter all bolha requests of an invocation
+// share, rps <= 0 does not limit
+func newBolhaLimiter(rps float64, burst int) *rate.Limiter {
+	if rps <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+// adClient is the part of the bolha client the monitor uses
+type adClient interface {
+	GetActiveAd(id int64) (*client.ActiveAd, error)
+	GetActiveAds() ([]*client.ActiveAd, error)
+	UploadAd(ad *client.Ad) (int64, error)
+	RemoveAd(id int64) error
+}
+
+// bolhaClient is a bolha client whose requests wait for the limiter shared by
+// all users, the requests of parallel users all come from the same address
+type bolhaClient struct {
+	c       adClient
+	limiter *rate.Limiter
+	usage   *usageCounts
+	guard   *actionGuard
+}
+
+func (bc *bolhaClient) GetActiveAd(ctx context.Context, id int64) (*client.ActiveAd, error) {
+	if err := bc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	bc.usage.addBolha(bolhaCallGetActiveAd)
+	return bc.c.GetActiveAd(id)
+}
+
+func (bc *bolhaClient) GetActiveAds(ctx context.Context) ([]*client.ActiveAd, error) {
+	if err := bc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	bc.usage.addBolha(bolhaCallGetActiveAds)
+	return bc.c.GetActiveAds()
+}
+
+func (bc *bolhaClient) UploadAd(ctx context.Context, ad *client.Ad) (int64, error) {
+	if err := bc.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	bc.usage.addBolha(bolhaCallUploadAd)
+	return bc.c.UploadAd(ad)
+}
+
+// RemoveAd removes ad id, at most once per invocation
+func (bc *bolhaClient) RemoveAd(ctx context.Context, id int64) error {
+	if err := bc.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	if err := bc.guard.claim(removeAction(id)); err != nil {
+		return err
+	}
+	bc.usage.addBolha(bolhaCallRemoveAd)
+	if err := bc.c.RemoveAd(id); err != nil {
+		bc.guard.release(removeAction(id))
+		return err
+	}
+	return nil
+}
+
+// newBolhaClient logs in with creds, the login counts as a request
+func (m *monitor) newBolhaClient(ctx context.Context, creds *client.User) (*bolhaClient, error) {
+	if err := m.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	m.usage.addBolha(bolhaCallLogin)
+	c, err := m.dialAdClient(creds, "")
+	if err != nil {
+		return nil, err
+	}
+	return &bolhaClient{c: c, limiter: m.limiter, usage: m.usage, guard: m.guard}, nil
+}
+
+// newBolhaSessionClient uses an existing session, the client makes no
+// request until it is used
+func (m *monitor) newBolhaSessionClient(sessionId string) (*bolhaClient, error) {
+	c, err := m.dialAdClient(nil, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return &bolhaClient{c: c, limiter: m.limiter, usage: m.usage, guard: m.guard}, nil
+}
+
+// dialAdClient logs in with creds, or uses sessionId if creds is nil
+func (m *monitor) dialAdClient(creds *client.User, sessionId string) (adClient, error) {
+	if m.dialBolha != nil {
+		return m.dialBolha(creds, sessionId)
+	}
+	if creds != nil {
+		return client.New(creds)
+	}
+	return client.NewWithSessionId(sessionId)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/seniorescobar/bolha-client"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// timingClient records when it is called
+type timingClient struct {
+	*harness.Client
+
+	mu    *sync.Mutex
+	calls *[]time.Time
+}
+
+func (c timingClient) GetActiveAd(id int64) (*client.ActiveAd, error) {
+	c.mu.Lock()
+	*c.calls = append(*c.calls, time.Now())
+	c.mu.Unlock()
+
+	return c.Client.GetActiveAd(id)
+}
+
+// The clients of all users share the limiter of the monitor, their calls
+// together keep to its rate whatever number of users run in parallel
+func TestBolhaLimiterSharedByUsers(t *testing.T) {
+	const (
+		users   = 5
+		perUser = 6
+		rps     = 50
+	)
+	interval := time.Second / rps
+
+	var (
+		mu    sync.Mutex
+		calls []time.Time
+	)
+	m := &monitor{
+		limiter: newBolhaLimiter(rps, 1),
+		usage:   newUsageCounts(),
+		guard:   newActionGuard(runLogger("run-1")),
+		dialBolha: func(creds *client.User, sessionId string) (adClient, error) {
+			return timingClient{Client: harness.NewClient(nil), mu: &mu, calls: &calls}, nil
+		},
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		c, err := m.newBolhaSessionClient("session")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perUser; i++ {
+				if _, err := c.GetActiveAd(context.Background(), 1000); !errors.Is(err, client.ErrAdNotFound) {
+					t.Errorf("GetActiveAd: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(calls) != users*perUser {
+		t.Fatalf("%d calls, want %d", len(calls), users*perUser)
+	}
+	// the limiter hands out one call per interval, the k-th call cannot
+	// come before k intervals passed however late the earlier ones woke up
+	sort.Slice(calls, func(a, b int) bool { return calls[a].Before(calls[b]) })
+	for k, at := range calls {
+		if earliest := start.Add(time.Duration(k) * interval); at.Before(earliest.Add(-time.Millisecond)) {
+			t.Errorf("call %d after %s, want no earlier than %s", k, at.Sub(start), earliest.Sub(start))
+		}
+	}
+	if n := m.usage.summary(0).BolhaCalls[bolhaCallGetActiveAd]; n != users*perUser {
+		t.Errorf("%d calls counted, want %d", n, users*perUser)
+	}
+}
+
+// A caller waiting for the limiter gives up once its context is done
+func TestBolhaLimiterCanceled(t *testing.T) {
+	m := &monitor{
+		limiter: newBolhaLimiter(0.1, 1),
+		usage:   newUsageCounts(),
+		guard:   newActionGuard(runLogger("run-1")),
+		dialBolha: func(creds *client.User, sessionId string) (adClient, error) {
+			return harness.NewClient(nil), nil
+		},
+	}
+	c, err := m.newBolhaSessionClient("session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the burst is used up, the next call would wait 10 seconds
+	if _, err := c.GetActiveAds(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.GetActiveAds(ctx); err == nil {
+		t.Error("call went through, want it to wait past the deadline")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("gave up after %s, want at the deadline", d)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileResult is the difference between the table and the live ads of every user
+type ReconcileResult struct {
+	StartedAt time.Time       `json:"startedAt"`
+	Repair    bool            `json:"repair"`
+	Users     []UserReconcile `json:"users"`
+	Version   VersionInfo     `json:"version"`
+}
+
+// UserReconcile is the difference between the table and the live ads of a single user
+type UserReconcile struct {
+	// user id, or the title of an item for legacy items without one
+	User string `json:"user"`
+
+	// live ads not tracked by any item
+	Untracked []int64 `json:"untracked"`
+	// items whose uploaded ad is not live
+	NotLive []ReconcileItem `json:"notLive"`
+	// items whose dead uploaded id was cleared (repair only)
+	Repaired []string `json:"repaired"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ReconcileItem is an item tracking an ad which is not live
+type ReconcileItem struct {
+	AdTitle      string `json:"adTitle"`
+	AdUploadedId int64  `json:"adUploadedId"`
+}
+
+// reconcile compares the live ads of every user with the table. The bolha
+// client only exposes ad ids and their order, so only the presence of ads is
+// compared. It never removes or uploads ads, with repair it clears the
+// uploaded ids of items whose ad is gone so they get uploaded again.
+func (m *monitor) reconcile(ctx context.Context, repair bool) (*ReconcileResult, error) {
+	m.log.WithField("repair", repair).Info("reconciling...")
+
+	result := &ReconcileResult{
+		StartedAt: m.now(),
+		Repair:    repair,
+		Users:     make([]UserReconcile, 0),
+		Version:   version,
+	}
+
+	bItems, err := m.getBolhaItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := m.getUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clients := m.newUserClients(users)
+
+	groups := make(map[string][]*BolhaItem)
+	keys := make([]string, 0)
+	for i := range bItems {
+		// the owner of an item which did not unmarshal is unknown, its ad
+		// shows up as untracked
+		if bItems[i].unmarshalErr != nil {
+			m.log.WithField("AdTitle", bItems[i].AdTitle).WithError(bItems[i].unmarshalErr).Warn("skipping item")
+			continue
+		}
+		k := bItems[i].userKey()
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], &bItems[i])
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		result.Users = append(result.Users, m.reconcileUser(ctx, clients, groups[k], repair))
+	}
+
+	if err := m.saveReport(ctx, "reconcile", result.StartedAt, m.runId, result); err != nil {
+		m.log.WithError(err).Warn("could not save reconcile report")
+	}
+
+	m.log.WithField("result", result).Info("reconciled")
+
+	return result, nil
+}
+
+func (m *monitor) reconcileUser(ctx context.Context, clients *userClients, bItems []*BolhaItem, repair bool) UserReconcile {
+	ur := UserReconcile{
+		User:      bItems[0].UserId,
+		Untracked: make([]int64, 0),
+		NotLive:   make([]ReconcileItem, 0),
+		Repaired:  make([]string, 0),
+	}
+	if ur.User == "" {
+		// never expose the session id
+		ur.User = fmt.Sprintf("legacy (%s)", bItems[0].AdTitle)
+	}
+
+	c, err := clients.get(ctx, bItems[0])
+	if err != nil {
+		ur.Error = err.Error()
+		return ur
+	}
+
+	activeAds, err := c.GetActiveAds(ctx)
+	if err != nil {
+		ur.Error = err.Error()
+		return ur
+	}
+
+	live := make(map[int64]bool, len(activeAds))
+	for _, ad := range activeAds {
+		live[ad.Id] = true
+	}
+
+	tracked := make(map[int64]bool, len(bItems))
+	for _, bItem := range bItems {
+		if bItem.AdUploadedId == 0 {
+			continue
+		}
+		tracked[bItem.AdUploadedId] = true
+
+		if live[bItem.AdUploadedId] {
+			continue
+		}
+		ur.NotLive = append(ur.NotLive, ReconcileItem{AdTitle: bItem.AdTitle, AdUploadedId: bItem.AdUploadedId})
+
+		if repair {
+			if err := m.clearUploadedId(ctx, bItem.AdTitle, bItem.AdUploadedId); err != nil {
+				m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("could not clear uploaded id")
+				continue
+			}
+			ur.Repaired = append(ur.Repaired, bItem.AdTitle)
+		}
+	}
+
+	for _, ad := range activeAds {
+		if !tracked[ad.Id] {
+			ur.Untracked = append(ur.Untracked, ad.Id)
+		}
+	}
+
+	return ur
+}
+
+// DYNAMODB
+
+// clearUploadedId resets the uploaded id of an item, unless it changed in the meantime
+func (m *monitor) clearUploadedId(ctx context.Context, adTitle string, adUploadedId int64) error {
+	m.log.WithFields(log.Fields{"AdTitle": adTitle, "AdUploadedId": adUploadedId}).Info("clearing uploaded id...")
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero":       &types.AttributeValueMemberN{Value: "0"},
+			":uploadedId": &types.AttributeValueMemberN{Value: strconv.FormatInt(adUploadedId, 10)},
+		},
+		Key:                 map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression:    aws.String("SET AdUploadedId = :zero"),
+		ConditionExpression: aws.String("AdUploadedId = :uploadedId"),
+		TableName:           aws.String(m.table),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return fmt.Errorf("uploaded id of %q changed", adTitle)
+	}
+
+	return err
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// An ad remove-all fails to remove is queued in PendingRemovals of its
+// user and its item is disabled all the same. Every run of the table drains
+// the queue before running items, an entry is only cleared once its ad is
+// gone and the queue is only written back if nobody changed it meanwhile.
+// Entries still failing REMOVAL_ESCALATE_AFTER after they were queued are
+// notified once. The queue of a paused user is left pending until the user
+// is resumed. Legacy items without a user have no queue, their failures are
+// only reported.
+
+// PendingRemoval is an ad whose removal failed
+type PendingRemoval struct {
+	AdUploadedId int64
+	AdTitle      string
+	Table        string
+	QueuedAt     string
+	Attempts     int
+	LastError    string
+	EscalatedAt  string
+}
+
+// RemovalsReport counts the queued removals of a run
+type RemovalsReport struct {
+	Removed int `json:"removed"`
+	Pending int `json:"pending"`
+	// pending removals of paused users, not retried
+	Paused int `json:"paused,omitempty"`
+}
+
+// addRemovals adds the removals of the previous invocation of a continued
+// run, the pending ones are those of the last invocation
+func (r *Report) addRemovals(prev *RemovalsReport) {
+	if prev == nil {
+		return
+	}
+	if r.Removals == nil {
+		r.Removals = &RemovalsReport{Pending: prev.Pending, Paused: prev.Paused}
+	}
+	r.Removals.Removed += prev.Removed
+}
+
+// drainRemovals retries the queued removals of the ads of m.table, a dry
+// or check run only counts them and those of paused users are only counted
+func (m *monitor) drainRemovals(ctx context.Context, tr *tableRun, now time.Time) {
+	for _, user := range tr.users {
+		queued := 0
+		for _, r := range user.PendingRemovals {
+			if r.Table == m.table {
+				queued++
+			}
+		}
+		if queued == 0 {
+			continue
+		}
+		if tr.report.Removals == nil {
+			tr.report.Removals = &RemovalsReport{}
+		}
+		if user.paused() {
+			m.log.WithFields(log.Fields{"UserId": user.UserId, "removals": queued}).Info("user paused, leaving pending removals")
+			tr.report.Removals.Pending += queued
+			tr.report.Removals.Paused += queued
+			continue
+		}
+		if tr.dryRun || tr.check {
+			tr.report.Removals.Pending += queued
+			continue
+		}
+
+		m.log.WithFields(log.Fields{"UserId": user.UserId, "removals": queued}).Info("draining pending removals...")
+
+		// removals of other tables are kept as they are
+		pending := make([]PendingRemoval, 0, len(user.PendingRemovals))
+		for _, r := range user.PendingRemovals {
+			if r.Table != m.table {
+				pending = append(pending, r)
+				continue
+			}
+
+			err := m.removeQueued(ctx, tr.clients, user.UserId, &r)
+			if err == nil {
+				m.log.WithFields(log.Fields{"AdTitle": r.AdTitle, "AdUploadedId": r.AdUploadedId}).Info("pending removal done")
+				tr.report.Removals.Removed++
+				continue
+			}
+
+			m.log.WithFields(log.Fields{"AdTitle": r.AdTitle, "AdUploadedId": r.AdUploadedId}).WithError(err).Warn("pending removal failed")
+			r.Attempts++
+			r.LastError = err.Error()
+			m.escalateRemoval(ctx, user.UserId, &r, now)
+			pending = append(pending, r)
+			tr.report.Removals.Pending++
+		}
+
+		if err := m.updatePendingRemovals(ctx, user.UserId, user.PendingRemovals, pending); err != nil {
+			m.log.WithField("UserId", user.UserId).WithError(err).Warn("could not update pending removals")
+			continue
+		}
+		user.PendingRemovals = pending
+	}
+}
+
+// removeQueued removes the ad of r with the client of userId
+func (m *monitor) removeQueued(ctx context.Context, clients *userClients, userId string, r *PendingRemoval) error {
+	c, err := clients.get(ctx, &BolhaItem{AdTitle: r.AdTitle, UserId: userId})
+	if err != nil {
+		return err
+	}
+	return m.removeAdWithRetry(ctx, c, r.AdTitle, r.AdUploadedId)
+}
+
+// escalateRemoval notifies r once it has been failing for
+// REMOVAL_ESCALATE_AFTER
+func (m *monitor) escalateRemoval(ctx context.Context, userId string, r *PendingRemoval, now time.Time) {
+	queuedAt, err := time.Parse(time.RFC3339, r.QueuedAt)
+	if r.EscalatedAt != "" || err != nil || now.Sub(queuedAt) < m.cfg.RemovalEscalateAfter {
+		return
+	}
+
+	n := newNotification(notificationRemovalStuck,
+		fmt.Sprintf("ad of %s is not removed", r.AdTitle),
+		fmt.Sprintf("removing ad %d of %q failed %d times since %s, last with: %s", r.AdUploadedId, r.AdTitle, r.Attempts, r.QueuedAt, r.LastError),
+	)
+	n.Severity = severityHigh
+	n.User = userId
+	if err := m.notif.Notify(ctx, n); err != nil {
+		m.log.WithField("AdTitle", r.AdTitle).WithError(err).Warn("could not notify stuck removal")
+		return
+	}
+	r.EscalatedAt = storedTime(now)
+}
+
+// queueRemoval queues the removal of the ad of bItem which failed with
+// removeErr, it returns an error if bItem has no user to queue it with
+func (m *monitor) queueRemoval(ctx context.Context, bItem *BolhaItem, removeErr error, now time.Time) error {
+	if bItem.UserId == "" || m.cfg.UsersTableName == "" {
+		return errors.New("item has no user to queue its removal with")
+	}
+
+	m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdUploadedId": bItem.AdUploadedId}).Info("queueing removal...")
+
+	return m.appendPendingRemoval(ctx, bItem.UserId, PendingRemoval{
+		AdUploadedId: bItem.AdUploadedId,
+		AdTitle:      bItem.AdTitle,
+		Table:        m.table,
+		QueuedAt:     storedTime(now),
+		Attempts:     1,
+		LastError:    removeErr.Error(),
+	})
+}
+
+// DYNAMODB
+
+func (m *monitor) appendPendingRemoval(ctx context.Context, userId string, r PendingRemoval) error {
+	av, err := attributevalue.Marshal([]PendingRemoval{r})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":r":     av,
+			":empty": &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+		},
+		Key:                 map[string]types.AttributeValue{"UserId": &types.AttributeValueMemberS{Value: userId}},
+		UpdateExpression:    aws.String("SET PendingRemovals = list_append(if_not_exists(PendingRemovals, :empty), :r)"),
+		ConditionExpression: aws.String("attribute_exists(UserId)"),
+		TableName:           aws.String(m.cfg.UsersTableName),
+	})
+
+	return err
+}
+
+// updatePendingRemovals replaces the queue of userId with pending, as long
+// as it is still old, the queue read at the start of the run
+func (m *monitor) updatePendingRemovals(ctx context.Context, userId string, old []PendingRemoval, pending []PendingRemoval) error {
+	oldAv, err := attributevalue.Marshal(old)
+	if err != nil {
+		return err
+	}
+	values := map[string]types.AttributeValue{":old": oldAv}
+	update := "REMOVE PendingRemovals"
+	if len(pending) > 0 {
+		if values[":pending"], err = attributevalue.Marshal(pending); err != nil {
+			return err
+		}
+		update = "SET PendingRemovals = :pending"
+	}
+
+	_, err = m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: values,
+		Key:                       map[string]types.AttributeValue{"UserId": &types.AttributeValueMemberS{Value: userId}},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("PendingRemovals = :old"),
+		TableName:                 aws.String(m.cfg.UsersTableName),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return fmt.Errorf("pending removals of %q changed meanwhile", userId)
+	}
+
+	return err
+}
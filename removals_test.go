@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A queued removal is retried by the next run of its table, unless its
+// user is paused: then it is left pending and only reported
+func TestScenarioDrainRemovals(t *testing.T) {
+	tests := []struct {
+		name    string
+		paused  bool
+		removed bool
+		report  RemovalsReport
+	}{
+		{"active user", false, true, RemovalsReport{Removed: 1}},
+		{"paused user", true, false, RemovalsReport{Pending: 1, Paused: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("USERS_TABLE", "users")
+			s := newScenario(t, "sinking")
+			s.store.AddTable("users", "UserId")
+			s.put("users", map[string]interface{}{
+				"UserId":    "u1",
+				"SessionId": "session-1",
+				"Paused":    tt.paused,
+				"PendingRemovals": []PendingRemoval{{
+					AdUploadedId: 2000,
+					AdTitle:      "Kavč",
+					Table:        "items",
+					QueuedAt:     storedTime(scenarioStart.Add(-time.Hour)),
+					Attempts:     1,
+					LastError:    "internal server error",
+				}},
+			})
+			s.client.Activate(2000, 1)
+
+			report, err := s.run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Removals == nil || *report.Removals != tt.report {
+				t.Errorf("removals %+v, want %+v", report.Removals, tt.report)
+			}
+
+			removals := s.calls("RemoveAd")
+			if removed := len(removals) == 1 && removals[0].Id == 2000; removed != tt.removed {
+				t.Errorf("removal calls %+v, want the ad removed %v", removals, tt.removed)
+			}
+			queue, _ := s.store.Item("users", "u1")["PendingRemovals"].([]interface{})
+			if pending := len(queue) == 1; pending == tt.removed {
+				t.Errorf("queue %v, want it pending %v", queue, !tt.removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// removeAllConfirm must be sent with remove-all, the action takes every ad
+// of a user offline
+const removeAllConfirm = "REMOVE-ALL"
+
+// RemoveAllResult lists the ads remove-all took offline
+type RemoveAllResult struct {
+	StartedAt time.Time         `json:"startedAt"`
+	UserId    string            `json:"userId"`
+	Ads       []RemoveAllAdInfo `json:"ads"`
+	Failed    int               `json:"failed"`
+	// ads left to the runs, see removals.go
+	Queued  int         `json:"queued"`
+	Version VersionInfo `json:"version"`
+}
+
+// RemoveAllAdInfo is the outcome of removing the ad of a single item
+type RemoveAllAdInfo struct {
+	AdTitle      string `json:"adTitle"`
+	AdUploadedId int64  `json:"adUploadedId"`
+	Removed      bool   `json:"removed"`
+	Queued       bool   `json:"queued,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// removeAll removes the live ad of every item of userId, clears the
+// uploaded ids and disables the items so runs leave them alone until they
+// are enabled again. Ads it fails to remove are queued for the runs. Items without an ad are disabled as well. It needs the
+// confirm token and never runs without a user.
+func (m *monitor) removeAll(ctx context.Context, userId, confirm string) (*RemoveAllResult, error) {
+	if userId == "" {
+		return nil, fmt.Errorf("remove-all needs a userId")
+	}
+	if confirm != removeAllConfirm {
+		return nil, fmt.Errorf("remove-all needs confirm %q", removeAllConfirm)
+	}
+
+	m.log.WithFields(log.Fields{"table": m.table, "userId": userId}).Warn("removing all ads of user...")
+
+	result := &RemoveAllResult{
+		StartedAt: m.now(),
+		UserId:    userId,
+		Ads:       make([]RemoveAllAdInfo, 0),
+		Version:   version,
+	}
+
+	bItems, err := m.getBolhaItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := m.getUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := users[userId]; !ok {
+		return nil, fmt.Errorf("unknown user %q", userId)
+	}
+	clients := m.newUserClients(users)
+
+	for i := range bItems {
+		bItem := &bItems[i]
+		if bItem.unmarshalErr != nil {
+			m.log.WithField("AdTitle", bItem.AdTitle).WithError(bItem.unmarshalErr).Warn("skipping item of unknown user")
+			continue
+		}
+		if bItem.UserId != userId {
+			continue
+		}
+
+		if bItem.AdUploadedId == 0 {
+			if err := m.disableItem(ctx, bItem.AdTitle, 0); err != nil {
+				m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("could not disable item")
+			}
+			continue
+		}
+
+		info := RemoveAllAdInfo{AdTitle: bItem.AdTitle, AdUploadedId: bItem.AdUploadedId}
+		queued, err := m.removeItemAd(ctx, clients, bItem)
+		switch {
+		case err != nil:
+			m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "AdUploadedId": bItem.AdUploadedId}).WithError(err).Error("could not remove ad")
+			info.Error = err.Error()
+			result.Failed++
+		case queued:
+			info.Queued = true
+			result.Queued++
+		default:
+			info.Removed = true
+		}
+		result.Ads = append(result.Ads, info)
+	}
+
+	if err := m.saveReport(ctx, "remove-all", result.StartedAt, m.runId, result); err != nil {
+		m.log.WithError(err).Warn("could not save remove-all report")
+	}
+
+	m.log.WithFields(log.Fields{"userId": userId, "ads": len(result.Ads), "queued": result.Queued, "failed": result.Failed}).Info("removed all ads of user")
+
+	return result, nil
+}
+
+// removeItemAd removes the ad of bItem with UPLOAD_ATTEMPTS attempts, then
+// clears its uploaded id and disables it. An ad which is not removed is
+// queued, its item is disabled all the same and queued is set.
+func (m *monitor) removeItemAd(ctx context.Context, clients *userClients, bItem *BolhaItem) (queued bool, err error) {
+	c, err := clients.get(ctx, bItem)
+	if err == nil {
+		err = m.removeAdWithRetry(ctx, c, bItem.AdTitle, bItem.AdUploadedId)
+	}
+	if err != nil {
+		if qerr := m.queueRemoval(ctx, bItem, err, m.now()); qerr != nil {
+			m.log.WithField("AdTitle", bItem.AdTitle).WithError(qerr).Warn("could not queue removal")
+			return false, err
+		}
+		queued = true
+	}
+
+	return queued, m.disableItem(ctx, bItem.AdTitle, bItem.AdUploadedId)
+}
+
+// removeAdWithRetry removes ad id of adTitle with UPLOAD_ATTEMPTS attempts
+func (m *monitor) removeAdWithRetry(ctx context.Context, c *bolhaClient, adTitle string, id int64) error {
+	attempts := m.cfg.UploadAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			m.log.WithFields(log.Fields{"AdTitle": adTitle, "attempt": attempt + 1}).WithError(err).Warn("retrying removal...")
+			<-m.clock.After(time.Duration(attempt) * uploadRetryDelay)
+		}
+		if err = m.removeAd(ctx, c, id); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// DYNAMODB
+
+// disableItem sets Enabled to false and clears the uploaded id, as long as
+// it is still adUploadedId (0 also matches items never uploaded)
+func (m *monitor) disableItem(ctx context.Context, adTitle string, adUploadedId int64) error {
+	m.log.WithFields(log.Fields{"AdTitle": adTitle, "AdUploadedId": adUploadedId}).Info("disabling item...")
+
+	cond := "AdUploadedId = :uploadedId"
+	if adUploadedId == 0 {
+		cond = "attribute_not_exists(AdUploadedId) OR " + cond
+	}
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero":       &types.AttributeValueMemberN{Value: "0"},
+			":false":      &types.AttributeValueMemberBOOL{Value: false},
+			":uploadedId": &types.AttributeValueMemberN{Value: strconv.FormatInt(adUploadedId, 10)},
+		},
+		Key:                 map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression:    aws.String("SET AdUploadedId = :zero, Enabled = :false"),
+		ConditionExpression: aws.String(cond),
+		TableName:           aws.String(m.table),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return fmt.Errorf("uploaded id of %q changed", adTitle)
+	}
+
+	return err
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const reportPrefix = "reports/"
+
+const (
+	statusUploaded   = "uploaded"
+	statusReuploaded = "reuploaded"
+	statusUnchanged  = "unchanged"
+	statusFailed     = "failed"
+	statusInvalid    = "invalid"
+	statusScheduled  = "scheduled"
+	statusExpired    = "expired (pending deletion)"
+	statusDisabled   = "disabled"
+	statusUserPaused = "skipped: user paused"
+
+	statusPreHookFailed = "skipped: pre-reupload hook failed"
+
+	statusChecked = "checked"
+
+	statusWouldUpload   = "would upload"
+	statusWouldReupload = "would reupload"
+	statusWouldResume   = "would resume"
+
+	statusPendingModeration = "pending moderation"
+	statusBlocked           = "blocked"
+	statusPriceBlocked      = "price blocked"
+
+	statusWaitingForSlot    = "waiting for slot"
+	statusDeferredItemLimit = "deferred: item limit"
+	statusDeferredCanary    = "deferred: canary"
+	statusDeferredBudget    = "deferred: time budget"
+	statusDeferredSlice     = "deferred: time slice"
+	statusDeferredImages    = "deferred: images unavailable"
+	statusCoolingDown       = "cooling down"
+	statusCanceled          = "deferred: canceled"
+)
+
+// where the order a decision is based on comes from
+const (
+	decisionSourceLive  = "live check"
+	decisionSourceCache = "cache"
+)
+
+// Report summarizes a single monitor run
+type Report struct {
+	Version VersionInfo `json:"version"`
+	DryRun  bool        `json:"dryRun,omitempty"`
+	// act or check, see check.go
+	Mode string `json:"mode"`
+	// id of the run, see runid.go, and of the run a retry-failed run
+	// retried the failed items of
+	RunId string `json:"runId"`
+	// run ids of all invocations of a self continued run, see runid.go
+	Invocations []string  `json:"invocations,omitempty"`
+	RetryOf     string    `json:"retryOf,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+
+	// counts of all items and per user, see userreport.go
+	Totals OutcomeCounts `json:"totals"`
+	Users  []UserReport  `json:"users"`
+
+	Items []ItemReport `json:"items"`
+
+	// random delay before the run started, see START_JITTER_SECONDS
+	StartDelay string `json:"startDelay,omitempty"`
+
+	// items which failed too many runs in a row
+	NeedsAttention []NeedsAttentionReport `json:"needsAttention"`
+
+	// new items waiting for their publish time
+	Scheduled []ScheduledReport `json:"scheduled"`
+
+	// items skipped because their ttl passed, dynamodb has yet to delete them
+	Expired int `json:"expired"`
+
+	// queued ad removals drained and left, see removals.go
+	Removals *RemovalsReport `json:"removals,omitempty"`
+
+	// invocations after the first of a self continued run, and the items it
+	// could not continue with
+	Continuations int                 `json:"continuations,omitempty"`
+	Remaining     map[string][]string `json:"remaining,omitempty"`
+
+	// failed items, nil if none failed
+	Errors *RunError `json:"errors,omitempty"`
+
+	// estimated cost of the run, of all its invocations if continued
+	Usage *Usage `json:"usage,omitempty"`
+
+	// the single item processed by a canary run
+	Canary *CanaryReport `json:"canary,omitempty"`
+}
+
+// ItemReport describes the outcome of processing a single item
+type ItemReport struct {
+	AdTitle      string `json:"adTitle"`
+	Table        string `json:"table"`
+	User         string `json:"user,omitempty"`
+	AdUploadedId int64  `json:"adUploadedId,omitempty"`
+	AdURL        string `json:"adUrl,omitempty"`
+	Order        int    `json:"order,omitempty"`
+	PriceType    string `json:"priceType"`
+	Status       string `json:"status"`
+	Reason       string `json:"reason,omitempty"`
+
+	// when the item is next uploaded if not earlier because of its order,
+	// empty if it does not wait for anything
+	NextEligibleAt string `json:"nextEligibleAt,omitempty"`
+
+	// why the item was left alone, see skip.go
+	SkipReason SkipReason `json:"skipReason,omitempty"`
+
+	DecisionSource string `json:"decisionSource,omitempty"`
+	// the decision and its inputs, see decision.Decision.Explain
+	Decision string `json:"decision,omitempty"`
+
+	// order gained by the previous reupload, measured at the first live check after it
+	Gain *int `json:"gain,omitempty"`
+
+	// state of the uploaded ad
+	AdState string `json:"adState,omitempty"`
+
+	// initial or reupload if the item was uploaded
+	UploadKind string `json:"uploadKind,omitempty"`
+	// key of the ad the upload sent in PAYLOAD_BUCKET
+	PayloadKey string `json:"payloadKey,omitempty"`
+	// the item has no images and EMPTY_IMAGES_POLICY allows it
+	NoImages bool `json:"noImages,omitempty"`
+	// AdUploadedAt is implausible and treated as unknown
+	UploadedAtAnomaly string `json:"uploadedAtAnomaly,omitempty"`
+	// bolha rejected the item as a duplicate, it is left alone until then
+	CooldownUntil string `json:"cooldownUntil,omitempty"`
+	// what to do about the outcome, if anything
+	Note string `json:"note,omitempty"`
+
+	// the ad was removed but not uploaded again
+	UploadPending bool `json:"uploadPending,omitempty"`
+	// phase of an unfinished upload a previous run left behind
+	ResumedPhase string `json:"resumedPhase,omitempty"`
+
+	// the webhook of the item failed, the item itself did not
+	WebhookError string `json:"webhookError,omitempty"`
+	// a reupload hook of the item failed, see hooks.go
+	HookError string `json:"hookError,omitempty"`
+
+	// dry run only, the ad an upload would send and the content fields
+	// changed since the last upload (unknown for ads uploaded before field
+	// hashes were recorded)
+	PlannedAd *PlannedAd `json:"plannedAd,omitempty"`
+	Changes   []string   `json:"changes,omitempty"`
+
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+// NeedsAttentionReport describes an item flagged as needing attention
+type NeedsAttentionReport struct {
+	AdTitle   string `json:"adTitle"`
+	Table     string `json:"table"`
+	FailCount int    `json:"failCount"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// ScheduledReport describes a new item waiting to be published
+type ScheduledReport struct {
+	AdTitle   string    `json:"adTitle"`
+	Table     string    `json:"table"`
+	PublishAt time.Time `json:"publishAt"`
+	Wait      string    `json:"wait"`
+}
+
+// CanaryReport describes the item processed by a canary run, it is nil if
+// no item qualified
+type CanaryReport struct {
+	Item      ItemReport `json:"item"`
+	Images    int        `json:"images"`
+	FailCount int        `json:"failCount"`
+	Duration  string     `json:"duration"`
+}
+
+func newReport(startedAt time.Time) *Report {
+	return &Report{
+		Version:        version,
+		StartedAt:      startedAt,
+		Users:          make([]UserReport, 0),
+		Items:          make([]ItemReport, 0),
+		NeedsAttention: make([]NeedsAttentionReport, 0),
+		Scheduled:      make([]ScheduledReport, 0),
+	}
+}
+
+// reportKey is the key of the report of kind saved at at by run runId
+func reportKey(kind string, at time.Time, runId string) string {
+	name := at.UTC().Format("2006-01-02T15-04-05Z")
+	if runId != "" {
+		name += "_" + runId
+	}
+	return reportPrefix + kind + "/" + name + ".json"
+}
+
+// latestReportKey is the key of the last report of kind
+func latestReportKey(kind string) string {
+	return reportPrefix + kind + "/latest.json"
+}
+
+// saveReport writes v to the report bucket as
+// reports/<kind>/<timestamp>_<run id>.json and reports/<kind>/latest.json,
+// it is a no-op if no bucket is configured
+func (m *monitor) saveReport(ctx context.Context, kind string, at time.Time, runId string, v interface{}) error {
+	if m.cfg.ReportBucket == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range []string{
+		reportKey(kind, at, runId),
+		latestReportKey(kind),
+	} {
+		m.log.WithFields(log.Fields{"bucket": m.cfg.ReportBucket, "key": key}).Info("saving report...")
+
+		_, err := m.s3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(m.cfg.ReportBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(b),
+			ContentType: aws.String("application/json"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
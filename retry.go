@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+const (
+	retryModeStandard = "standard"
+	retryModeAdaptive = "adaptive"
+)
+
+// retryCounts counts the retried aws calls of an invocation
+type retryCounts struct {
+	retries   int64
+	throttled int64
+}
+
+// record writes the retry counts of the invocation to m
+func (rc *retryCounts) record(m *metricSet) {
+	m.put("AWSRetries", float64(atomic.LoadInt64(&rc.retries)), unitCount)
+	m.put("AWSThrottledRetries", float64(atomic.LoadInt64(&rc.throttled)), unitCount)
+}
+
+// countingRetryer counts every retry of the wrapped retryer
+type countingRetryer struct {
+	aws.RetryerV2
+	counts *retryCounts
+}
+
+func (r countingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	atomic.AddInt64(&r.counts.retries, 1)
+	if throttled(err) {
+		atomic.AddInt64(&r.counts.throttled, 1)
+	}
+
+	return r.RetryerV2.RetryDelay(attempt, err)
+}
+
+// throttled reports whether err is aws throttling a call
+func throttled(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// newRetryer returns the retryer of all aws clients, adaptive mode
+// additionally rate limits the client once it gets throttled
+func newRetryer(mode string, maxAttempts int, counts *retryCounts) func() aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxAttempts
+	}
+
+	return func() aws.Retryer {
+		if mode == retryModeAdaptive {
+			return countingRetryer{retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+				o.StandardOptions = append(o.StandardOptions, standard)
+			}), counts}
+		}
+		return countingRetryer{retry.NewStandard(standard), counts}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// retry-failed runs only the items which failed in the last run, as listed
+// by its report in REPORT_BUCKET. They are read with BatchGetItem and run
+// like in any other run, the report is saved as run-retry with RetryOf
+// set to the id of the run they failed in.
+
+// BatchGetItem reads at most 100 keys
+const batchGetSize = 100
+
+// failedItems returns the titles of the failed items of report per table
+func (report *Report) failedItems() map[string][]string {
+	failed := make(map[string][]string)
+	for _, ir := range report.Items {
+		if ir.Error != "" {
+			failed[ir.Table] = append(failed[ir.Table], ir.AdTitle)
+		}
+	}
+	for table := range failed {
+		sort.Strings(failed[table])
+	}
+	return failed
+}
+
+// retryFailed runs the items which failed in the last run again
+func (m *monitor) retryFailed(ctx context.Context, mode string, dryRun bool) (*Report, error) {
+	last, err := m.loadReportKey(ctx, latestReportKey("run"))
+	if err != nil {
+		return nil, fmt.Errorf("could not load the last run: %v", err)
+	}
+
+	id := last.RunId
+	if id == "" {
+		id = legacyRunId(last.StartedAt)
+	}
+	failed := last.failedItems()
+
+	m.log.WithFields(log.Fields{"retryOf": id, "failed": failed}).Info("retrying failed items...")
+
+	if len(failed) == 0 {
+		m.log.WithField("retryOf", id).Info("no failed items to retry")
+	}
+
+	return m.run(ctx, mode, false, dryRun, false, nil, &runTarget{Failed: failed, RetryOf: id})
+}
+
+// DYNAMODB
+
+// batchGetBolhaItems returns the items of the table titled titles, titles
+// without an item are left out
+func (m *monitor) batchGetBolhaItems(ctx context.Context, titles []string) ([]BolhaItem, error) {
+	m.log.WithFields(log.Fields{"table": m.table, "items": len(titles)}).Info("getting bolha items...")
+
+	items := make([]map[string]types.AttributeValue, 0, len(titles))
+	for start := 0; start < len(titles); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(titles) {
+			end = len(titles)
+		}
+
+		keys := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, title := range titles[start:end] {
+			keys = append(keys, map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: title}})
+		}
+
+		for attempt := 0; len(keys) > 0; attempt++ {
+			if attempt == batchWriteAttempts {
+				return nil, fmt.Errorf("%d items left unprocessed after %d attempts", len(keys), attempt)
+			}
+			if attempt > 0 {
+				<-m.clock.After(time.Duration(1<<uint(attempt)) * 100 * time.Millisecond)
+			}
+
+			result, err := m.ddb.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]types.KeysAndAttributes{m.table: {Keys: keys, ConsistentRead: aws.Bool(true)}},
+			})
+			if err != nil {
+				return nil, m.tableError(err)
+			}
+
+			items = append(items, result.Responses[m.table]...)
+			keys = result.UnprocessedKeys[m.table].Keys
+		}
+	}
+
+	// batches answer in any order
+	sort.Slice(items, func(a, b int) bool {
+		return itemTitle(items[a]) < itemTitle(items[b])
+	})
+
+	return m.bolhaItems(items)
+}
+
+// HELPERS
+
+func itemTitle(item map[string]types.AttributeValue) string {
+	if s, ok := item["AdTitle"].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	client "github.com/seniorescobar/bolha-client"
+)
+
+// error classes of failed items
+const (
+	ClassInvalid    = "invalid"
+	ClassAdNotFound = "ad not found"
+	ClassAWS        = "aws"
+	ClassPriceGuard = "price guard"
+	ClassDuplicate  = "duplicate rejected"
+	ClassOther      = "other"
+)
+
+// sentinels matching item errors of a class with errors.Is
+var (
+	ErrInvalid    = errors.New(ClassInvalid)
+	ErrAdNotFound = errors.New(ClassAdNotFound)
+	ErrAWS        = errors.New(ClassAWS)
+	ErrPriceGuard = errors.New(ClassPriceGuard)
+	// bolha rejected the upload as a duplicate, see duplicate.go
+	ErrDuplicateRejected = errors.New(ClassDuplicate)
+	ErrOther             = errors.New(ClassOther)
+)
+
+var classSentinels = map[string]error{
+	ClassInvalid:    ErrInvalid,
+	ClassAdNotFound: ErrAdNotFound,
+	ClassAWS:        ErrAWS,
+	ClassPriceGuard: ErrPriceGuard,
+	ClassDuplicate:  ErrDuplicateRejected,
+	ClassOther:      ErrOther,
+}
+
+// ItemError is the error of a single failed item
+type ItemError struct {
+	AdTitle string
+	// uploaded id of the ad, 0 if none
+	AdID  int64
+	Class string
+	Err   error
+
+	// kind of the upload which failed, empty if the item failed otherwise
+	UploadKind string
+}
+
+func newItemError(bItem *BolhaItem, err error) *ItemError {
+	return &ItemError{
+		AdTitle: bItem.AdTitle,
+		AdID:    bItem.AdUploadedId,
+		Class:   errorClass(err),
+		Err:     err,
+	}
+}
+
+// errorClass classifies err, errors of the bolha client are not typed so
+// apart from missing ads and duplicate rejections they are "other"
+func errorClass(err error) string {
+	var (
+		verr *ValidationError
+		perr *PriceGuardError
+		aerr smithy.APIError
+	)
+	switch {
+	case errors.As(err, &verr):
+		return ClassInvalid
+	case errors.As(err, &perr):
+		return ClassPriceGuard
+	case errors.Is(err, client.ErrAdNotFound):
+		return ClassAdNotFound
+	case errors.Is(err, ErrDuplicateRejected):
+		return ClassDuplicate
+	case errors.As(err, &aerr):
+		return ClassAWS
+	default:
+		return ClassOther
+	}
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("%s: %v", e.AdTitle, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel of the error class
+func (e *ItemError) Is(target error) bool {
+	return classSentinels[e.Class] == target
+}
+
+func (e *ItemError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		AdTitle    string `json:"adTitle"`
+		AdID       int64  `json:"adId,omitempty"`
+		Class      string `json:"class"`
+		UploadKind string `json:"uploadKind,omitempty"`
+		Error      string `json:"error"`
+	}{e.AdTitle, e.AdID, e.Class, e.UploadKind, e.Err.Error()})
+}
+
+// RunError is returned by a run in which at least one item failed
+type RunError struct {
+	// number of items in the run
+	Total   int
+	Items   []*ItemError
+	Version VersionInfo
+}
+
+// newRunError returns nil if no item failed
+func newRunError(total int, items []*ItemError) *RunError {
+	if len(items) == 0 {
+		return nil
+	}
+	return &RunError{Total: total, Items: items, Version: version}
+}
+
+// Error is a one line summary, Detail lists every item
+func (e *RunError) Error() string {
+	return fmt.Sprintf("%d of %d items failed (%s) (build %s)", len(e.Items), e.Total, e.classCounts(), e.Version)
+}
+
+// Detail lists every failed item on its own line
+func (e *RunError) Detail() string {
+	var b strings.Builder
+	b.WriteString(e.Error())
+	for _, ie := range e.Items {
+		fmt.Fprintf(&b, "\n  %s [%s]", ie.Error(), ie.Class)
+	}
+	return b.String()
+}
+
+// Is reports whether any item error matches target
+func (e *RunError) Is(target error) bool {
+	for _, ie := range e.Items {
+		if errors.Is(ie, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first item error matching target
+func (e *RunError) As(target interface{}) bool {
+	for _, ie := range e.Items {
+		if errors.As(ie, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *RunError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Summary string       `json:"summary"`
+		Total   int          `json:"total"`
+		Items   []*ItemError `json:"items"`
+		Version VersionInfo  `json:"version"`
+	}{e.Error(), e.Total, e.Items, e.Version})
+}
+
+// classCounts formats the number of failed items per class, e.g. "2 aws, 1 invalid"
+func (e *RunError) classCounts() string {
+	counts := make(map[string]int)
+	for _, ie := range e.Items {
+		counts[ie.Class]++
+	}
+
+	classes := make([]string, 0, len(counts))
+	for class := range counts {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%d %s", counts[class], class)
+	}
+	return strings.Join(parts, ", ")
+}
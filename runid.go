@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Every invocation gets a run id, its lambda request id (local outside
+// lambda) and a random suffix telling the invocations of a self continued
+// run apart. It is on every log line, every notification, webhook and hook
+// call, every saved upload and in the name of every report. Every write to
+// an items table stamps it as LastModifiedByRun, and the runs whose
+// deferred writes touched an item are kept in its RecentRuns as
+// "<unix seconds>:<run id>" entries, oldest first, which describe lists.
+// A continued run is named after its first invocation, its report lists
+// the ids of all of them.
+
+// run ids kept in RecentRuns
+const recentRunsKept = 5
+
+type runIdKey struct{}
+
+// newRunId returns the id of the invocation of ctx
+func newRunId(ctx context.Context) string {
+	requestId := "local"
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		requestId = lc.AwsRequestID
+	}
+
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return requestId
+	}
+	return requestId + "-" + hex.EncodeToString(suffix)
+}
+
+// withRunId returns ctx carrying runId
+func withRunId(ctx context.Context, runId string) context.Context {
+	return context.WithValue(ctx, runIdKey{}, runId)
+}
+
+// runIdFrom returns the run id ctx carries, a new one if none
+func runIdFrom(ctx context.Context) string {
+	if runId, ok := ctx.Value(runIdKey{}).(string); ok {
+		return runId
+	}
+	return newRunId(ctx)
+}
+
+// legacyRunId is the id of a run reported before run ids, the time it
+// started at
+func legacyRunId(startedAt time.Time) string {
+	return startedAt.UTC().Format("2006-01-02T15-04-05Z")
+}
+
+// runLogger returns the logger of the invocation runId, every entry carries
+// the run id, see monitor.log
+func runLogger(runId string) *log.Entry {
+	return log.WithField("runId", runId)
+}
+
+// recentRuns returns the run ids of RecentRuns, newest first
+func (bItem *BolhaItem) recentRuns() []string {
+	runs := make([]string, 0, len(bItem.RecentRuns))
+	for i := len(bItem.RecentRuns) - 1; i >= 0; i-- {
+		if _, runId, ok := strings.Cut(bItem.RecentRuns[i], ":"); ok {
+			runs = append(runs, runId)
+		}
+	}
+	return runs
+}
+
+// recordRun adds runId to RecentRuns if the run has deferred writes for
+// bItem, once per run
+func (bItem *BolhaItem) recordRun(writes *itemWrites, runId string, now time.Time) {
+	if !writes.pending(bItem.AdTitle) || slices.Contains(bItem.recentRuns(), runId) {
+		return
+	}
+
+	entries := append(slices.Clone(bItem.RecentRuns), strconv.FormatInt(now.Unix(), 10)+":"+runId)
+	if len(entries) > recentRunsKept {
+		entries = entries[len(entries)-recentRunsKept:]
+	}
+	bItem.RecentRuns = entries
+
+	list := make([]types.AttributeValue, len(entries))
+	for i, e := range entries {
+		list[i] = &types.AttributeValueMemberS{Value: e}
+	}
+	writes.set(bItem.AdTitle, "RecentRuns", &types.AttributeValueMemberL{Value: list})
+}
+
+// MIDDLEWARE
+
+// setClauseRe finds the SET clause of an update expression
+var setClauseRe = regexp.MustCompile(`\bSET\b`)
+
+// stampRunOptions returns the middleware stamping runId on every write to
+// one of tables
+func stampRunOptions(runId string, tables []string) []func(*middleware.Stack) error {
+	sorted := slices.Clone(tables)
+	sort.Strings(sorted)
+	isItems := func(table *string) bool {
+		if table == nil {
+			return false
+		}
+		_, ok := slices.BinarySearch(sorted, *table)
+		return ok
+	}
+	stamp := &types.AttributeValueMemberS{Value: runId}
+
+	mw := middleware.InitializeMiddlewareFunc("StampRun", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		switch p := in.Parameters.(type) {
+		case *dynamodb.UpdateItemInput:
+			if isItems(p.TableName) && p.UpdateExpression != nil && !strings.Contains(*p.UpdateExpression, "LastModifiedByRun") {
+				if p.ExpressionAttributeValues == nil {
+					p.ExpressionAttributeValues = make(map[string]types.AttributeValue)
+				}
+				p.ExpressionAttributeValues[":lastModifiedByRun"] = stamp
+				p.UpdateExpression = aws.String(stampUpdateExpression(*p.UpdateExpression))
+			}
+		case *dynamodb.PutItemInput:
+			if isItems(p.TableName) && p.Item != nil {
+				p.Item["LastModifiedByRun"] = stamp
+			}
+		case *dynamodb.BatchWriteItemInput:
+			for table, reqs := range p.RequestItems {
+				if !isItems(&table) {
+					continue
+				}
+				for _, req := range reqs {
+					if req.PutRequest != nil && req.PutRequest.Item != nil {
+						req.PutRequest.Item["LastModifiedByRun"] = stamp
+					}
+				}
+			}
+		}
+		return next.HandleInitialize(ctx, in)
+	})
+
+	return []func(*middleware.Stack) error{
+		func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(mw, middleware.After)
+		},
+	}
+}
+
+// stampUpdateExpression adds setting LastModifiedByRun to expr
+func stampUpdateExpression(expr string) string {
+	const set = "LastModifiedByRun = :lastModifiedByRun"
+	if loc := setClauseRe.FindStringIndex(expr); loc != nil {
+		return expr[:loc[1]] + " " + set + "," + expr[loc[1]:]
+	}
+	return "SET " + set + " " + expr
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RunState is the progress of the paged scan of an items table, kept in
+// RUN_STATE_TABLE keyed by Table
+type RunState struct {
+	Table string
+
+	// LastEvaluatedKey of the last processed page as json, empty at the
+	// start of a pass
+	Cursor string
+
+	// set while a pass is running, a pass which died keeps it
+	Incomplete bool
+
+	UpdatedAt string
+}
+
+// runPages scans the table SCAN_PAGE_SIZE items at a time, running every
+// page before reading the next one. The cursor is saved after every page,
+// a pass left incomplete is resumed with the resume flag or
+// AUTO_RESUME_SCAN, otherwise it starts over. Per user limits only see the
+// items of the page being run.
+func (m *monitor) runPages(ctx context.Context, tr *tableRun, resume bool) ([]BolhaItem, []*ItemError, error) {
+	state, err := m.getRunState(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var startKey map[string]types.AttributeValue
+	if state.Incomplete && state.Cursor != "" {
+		if resume || m.cfg.AutoResumeScan {
+			if startKey, err = decodeCursor(state.Cursor); err != nil {
+				return nil, nil, err
+			}
+			m.log.WithFields(log.Fields{"table": m.table, "since": state.UpdatedAt}).Info("resuming incomplete scan...")
+		} else {
+			m.log.WithFields(log.Fields{"table": m.table, "since": state.UpdatedAt}).Warn("previous scan is incomplete, starting over")
+		}
+	}
+
+	// a dry run leaves the progress of real runs alone
+	save := func(cursor string, incomplete bool) {
+		if tr.dryRun {
+			return
+		}
+		state.Cursor, state.Incomplete = cursor, incomplete
+		if err := m.putRunState(ctx, state); err != nil {
+			m.log.WithField("table", m.table).WithError(err).Warn("could not save run state")
+		}
+	}
+	if startKey == nil {
+		save("", true)
+	}
+
+	bItems := make([]BolhaItem, 0)
+	failed := make([]*ItemError, 0)
+
+	p := dynamodb.NewScanPaginator(m.ddb, &dynamodb.ScanInput{
+		TableName:         aws.String(m.table),
+		Limit:             aws.Int32(int32(m.cfg.ScanPageSize)),
+		ExclusiveStartKey: startKey,
+	})
+	for page := 1; p.HasMorePages(); page++ {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return bItems, failed, err
+		}
+
+		pItems, err := m.bolhaItems(out.Items)
+		if err != nil {
+			return bItems, failed, err
+		}
+		m.log.WithFields(log.Fields{"table": m.table, "page": page, "items": len(pItems)}).Info("running page...")
+
+		pFailed, _ := m.runItems(ctx, tr, pItems)
+		bItems = append(bItems, pItems...)
+		failed = append(failed, pFailed...)
+
+		if len(out.LastEvaluatedKey) > 0 {
+			cursor, err := encodeCursor(out.LastEvaluatedKey)
+			if err != nil {
+				return bItems, failed, err
+			}
+			save(cursor, true)
+		}
+	}
+
+	// a full pass clears the cursor
+	save("", false)
+
+	return bItems, failed, nil
+}
+
+// encodeCursor serializes a LastEvaluatedKey, the keys of items tables are strings
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	cursor := make(map[string]string, len(key))
+	for name, av := range key {
+		s, ok := av.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("key attribute %s is not a string", name)
+		}
+		cursor[name] = s.Value
+	}
+
+	b, err := json.Marshal(cursor)
+	return string(b), err
+}
+
+func decodeCursor(s string) (map[string]types.AttributeValue, error) {
+	var cursor map[string]string
+	if err := json.Unmarshal([]byte(s), &cursor); err != nil {
+		return nil, fmt.Errorf("invalid scan cursor %q: %v", s, err)
+	}
+
+	key := make(map[string]types.AttributeValue, len(cursor))
+	for name, v := range cursor {
+		key[name] = &types.AttributeValueMemberS{Value: v}
+	}
+
+	return key, nil
+}
+
+// DYNAMODB
+
+// getRunState returns the saved progress of m.table, an empty state if
+// there is none or no RUN_STATE_TABLE is configured
+func (m *monitor) getRunState(ctx context.Context) (*RunState, error) {
+	state := &RunState{Table: m.table}
+	if m.cfg.RunStateTableName == "" {
+		return state, nil
+	}
+
+	result, err := m.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(m.cfg.RunStateTableName),
+		Key:            map[string]types.AttributeValue{"Table": &types.AttributeValueMemberS{Value: m.table}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return state, nil
+	}
+
+	if err := attributevalue.UnmarshalMap(result.Item, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (m *monitor) putRunState(ctx context.Context, state *RunState) error {
+	if m.cfg.RunStateTableName == "" {
+		return nil
+	}
+
+	state.UpdatedAt = storedTime(m.now())
+	item, err := attributevalue.MarshalMap(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.cfg.RunStateTableName),
+		Item:      item,
+	})
+
+	return err
+}
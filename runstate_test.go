@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// crashingStore dies reading page crashAt of a paged scan of the items
+// table, like a lambda running out of time between two pages
+type crashingStore struct {
+	*harness.Store
+
+	crashAt int
+	pages   int
+}
+
+func (s *crashingStore) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if aws.ToString(params.TableName) == "items" && params.Limit != nil {
+		s.pages++
+		if s.pages == s.crashAt {
+			return nil, errors.New("invocation timed out")
+		}
+	}
+	return s.Store.Scan(ctx, params, optFns...)
+}
+
+func reportTitles(report *Report) []string {
+	titles := make([]string, 0, len(report.Items))
+	for _, ir := range report.Items {
+		titles = append(titles, ir.AdTitle)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// A run dying between pages leaves the cursor of the last page it ran, the
+// next run resumes after it and a full pass clears it
+func TestScanResumesAfterCrash(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  string
+		resume bool
+		// items of the run after the crash
+		titles []string
+	}{
+		{"resume flag", `{"resume": true}`, false, []string{"Stol"}},
+		{"auto resume", `{}`, true, []string{"Stol"}},
+		{"starts over", `{}`, false, []string{"Gorsko kolo", "Kavč", "Miza", "Omara", "Stol"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SCAN_PAGE_SIZE", "2")
+			t.Setenv("RUN_STATE_TABLE", "runstate")
+			t.Setenv("AUTO_RESUME_SCAN", fmt.Sprint(tt.resume))
+			s := newScenario(t, "pages")
+
+			// pages of Gorsko kolo and Kavč, Miza and Omara ran, Stol not
+			svc := s.services()
+			svc.ddb = &crashingStore{Store: s.store, crashAt: 3}
+			if _, err := s.runEvent(context.Background(), svc, "{}"); err == nil {
+				t.Fatal("run survived the crash")
+			}
+			state := s.store.Item("runstate", "items")
+			if state["Cursor"] != `{"AdTitle":"Omara"}` || state["Incomplete"] != true {
+				t.Fatalf("run state %v, want incomplete after Omara", state)
+			}
+			ran := make(map[int64]int)
+			for _, c := range s.calls("GetActiveAd") {
+				ran[c.Id]++
+			}
+
+			s.next(0)
+			report, err := s.runEvent(context.Background(), s.services(), tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reportTitles(report); fmt.Sprint(got) != fmt.Sprint(tt.titles) {
+				t.Errorf("ran %v, want %v", got, tt.titles)
+			}
+			if tt.resume || tt.event != "{}" {
+				for _, c := range s.calls("GetActiveAd") {
+					if ran[c.Id] > 0 && c.Step > 0 {
+						t.Errorf("ad %d of a page run before the crash checked again", c.Id)
+					}
+				}
+			}
+
+			state = s.store.Item("runstate", "items")
+			if state["Cursor"] != "" || state["Incomplete"] != false {
+				t.Errorf("run state %v, want cleared by the full pass", state)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const memSampleInterval = 100 * time.Millisecond
+
+// warm is set once the first invocation of this container finishes,
+// invocations may overlap in tests
+var warm atomic.Bool
+
+// runtimeStats describes resource usage of a single invocation
+type runtimeStats struct {
+	ColdStart          bool   `json:"coldStart"`
+	PeakHeapAlloc      uint64 `json:"peakHeapAlloc"`
+	PeakSys            uint64 `json:"peakSys"`
+	NumGC              uint32 `json:"numGC"`
+	ConfiguredMemoryMB int    `json:"configuredMemoryMB"`
+}
+
+// memSampler periodically samples runtime.MemStats to approximate peak usage
+type memSampler struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	startGC       uint32
+	peakHeapAlloc uint64
+	peakSys       uint64
+	numGC         uint32
+}
+
+func startMemSampler() *memSampler {
+	s := &memSampler{stop: make(chan struct{})}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s.startGC = ms.NumGC
+	s.sample(&ms)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		t := time.NewTicker(memSampleInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				runtime.ReadMemStats(&ms)
+				s.sample(&ms)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *memSampler) sample(ms *runtime.MemStats) {
+	if ms.HeapAlloc > s.peakHeapAlloc {
+		s.peakHeapAlloc = ms.HeapAlloc
+	}
+	if ms.Sys > s.peakSys {
+		s.peakSys = ms.Sys
+	}
+	s.numGC = ms.NumGC - s.startGC
+}
+
+// finish stops sampling and returns the stats of the invocation
+func (s *memSampler) finish() runtimeStats {
+	close(s.stop)
+	s.wg.Wait()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s.sample(&ms)
+
+	stats := runtimeStats{
+		ColdStart:     !warm.Swap(true),
+		PeakHeapAlloc: s.peakHeapAlloc,
+		PeakSys:       s.peakSys,
+		NumGC:         s.numGC,
+	}
+	if mb, err := strconv.Atoi(os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE")); err == nil {
+		stats.ConfiguredMemoryMB = mb
+	}
+
+	return stats
+}
+
+func (rs runtimeStats) log(logger *log.Entry) {
+	logger.WithFields(log.Fields{
+		"coldStart":          rs.ColdStart,
+		"peakHeapAlloc":      rs.PeakHeapAlloc,
+		"peakSys":            rs.PeakSys,
+		"numGC":              rs.NumGC,
+		"configuredMemoryMB": rs.ConfiguredMemoryMB,
+	}).Info("runtime stats")
+}
+
+func (rs runtimeStats) record(m *metricSet) {
+	var cs float64
+	if rs.ColdStart {
+		cs = 1
+	}
+	m.put("ColdStart", cs, unitCount)
+	m.put("PeakHeapAlloc", float64(rs.PeakHeapAlloc), unitBytes)
+	m.put("PeakSys", float64(rs.PeakSys), unitBytes)
+	m.put("NumGC", float64(rs.NumGC), unitCount)
+	m.put("ConfiguredMemory", float64(rs.ConfiguredMemoryMB), unitMegabytes)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	client "github.com/seniorescobar/bolha-client"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// scenarioStart is when every scenario starts, items of the fixtures are
+// uploaded before it
+var scenarioStart = time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+
+// scenario drives whole runs of Handler against the stand-ins of
+// internal/harness, one run per step of its client
+type scenario struct {
+	t       testing.TB
+	store   *harness.Store
+	objects *harness.Objects
+	clock   *harness.Clock
+	client  *harness.Client
+	notes   *recordingNotifier
+}
+
+// newScenario loads testdata/scenarios/<fixture>.yaml as the items table
+// and scripts bolha with steps
+func newScenario(t testing.TB, fixture string, steps ...harness.Step) *scenario {
+	t.Helper()
+
+	t.Setenv("AWS_REGION", "eu-central-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("BOLHA_TABLE_NAMES", "items")
+	t.Setenv("REPORT_BUCKET", "reports")
+	// bolha is not paced, nothing waits on the clock of the scenario
+	t.Setenv("BOLHA_REQUESTS_PER_SECOND", "0")
+
+	f, err := os.Open(filepath.Join("testdata", "scenarios", fixture+".yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s := &scenario{
+		t:       t,
+		store:   harness.NewStore(),
+		objects: harness.NewObjects(),
+		clock:   harness.NewClock(scenarioStart),
+		notes:   &recordingNotifier{},
+	}
+	if err := s.store.LoadFixture(f); err != nil {
+		t.Fatal(err)
+	}
+	s.client = harness.NewClient(s.clock, steps...)
+
+	// ads of the fixture the first step does not list are at order 1,
+	// their images are in the images bucket
+	listed := make(map[int64]bool)
+	for _, id := range s.client.Active() {
+		listed[id] = true
+	}
+	for _, it := range s.store.Items("items") {
+		if id, ok := it["AdUploadedId"].(float64); ok && id > 0 && !listed[int64(id)] {
+			s.client.Activate(int64(id), 1)
+		}
+		images, _ := it["AdImages"].([]interface{})
+		for _, key := range images {
+			s.objects.Put(s3ImagesBucket, key.(string), []byte("image of "+key.(string)))
+		}
+	}
+
+	return s
+}
+
+func (s *scenario) services() *services {
+	return &services{
+		clock: s.clock,
+		ddb:   s.store,
+		s3:    s.objects,
+		notif: s.notes,
+		dialBolha: func(creds *client.User, sessionId string) (adClient, error) {
+			return s.client, nil
+		},
+	}
+}
+
+// run invokes Handler once, the error is a *RunError if items failed
+func (s *scenario) run() (*Report, error) {
+	s.t.Helper()
+
+	return s.runEvent(context.Background(), s.services(), "{}")
+}
+
+// runEvent invokes Handler once with event on svc
+func (s *scenario) runEvent(ctx context.Context, svc *services, event string) (*Report, error) {
+	s.t.Helper()
+
+	out, err := handlePayload(ctx, json.RawMessage(event), svc)
+	report, _ := out.(*Report)
+	if report == nil && err == nil {
+		s.t.Fatalf("run returned %T, want *Report", out)
+	}
+	return report, err
+}
+
+// monitor returns a monitor of the scenario and the validated items of its
+// table, for driving parts of a run on their own
+func (s *scenario) monitor() (*monitor, []BolhaItem) {
+	s.t.Helper()
+
+	m, err := newMonitor(context.Background(), s.services(), new(retryCounts), newUsageCounts(), newMetricSet())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	bItems, err := m.getBolhaItems(context.Background())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for i := range bItems {
+		if bItems[i].cfg, _, err = m.cfg.forItem(bItems[i].Overrides); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	return m, bItems
+}
+
+// next moves bolha on to its next step and the clock by d
+func (s *scenario) next(d time.Duration) {
+	s.clock.Advance(d)
+	s.client.Next()
+}
+
+func (s *scenario) item(title string) map[string]interface{} {
+	s.t.Helper()
+
+	it := s.store.Item("items", title)
+	if it == nil {
+		s.t.Fatalf("item %q not in the table", title)
+	}
+	return it
+}
+
+// put puts an item of attrs into table
+func (s *scenario) put(table string, attrs map[string]interface{}) {
+	s.t.Helper()
+
+	av, err := attributevalue.MarshalMap(attrs)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if _, err := s.store.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String(table), Item: av}); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// update sets attrs of the item of the items table
+func (s *scenario) update(title string, attrs map[string]interface{}) {
+	s.t.Helper()
+
+	it := s.item(title)
+	for name, v := range attrs {
+		it[name] = v
+	}
+	s.put("items", it)
+}
+
+func (s *scenario) calls(method string) []harness.Call {
+	calls := make([]harness.Call, 0)
+	for _, c := range s.client.Calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func itemReport(t *testing.T, report *Report, title string) ItemReport {
+	t.Helper()
+
+	for _, ir := range report.Items {
+		if ir.AdTitle == title {
+			return ir
+		}
+	}
+	t.Fatalf("item %q not in the report", title)
+	return ItemReport{}
+}
+
+// recordingNotifier keeps every notification it is sent
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func (r *recordingNotifier) kinds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kinds := make([]string, 0, len(r.sent))
+	for _, n := range r.sent {
+		kinds = append(kinds, n.Kind)
+	}
+	return kinds
+}
+
+func TestScenarioAdSinksThenReuploaded(t *testing.T) {
+	const title = "Gorsko kolo"
+
+	// the live check is cached for ORDER_FRESHNESS, the runs are further apart
+	s := newScenario(t, "sinking",
+		harness.Step{Orders: map[int64]int{1000: 10}},
+		harness.Step{Orders: map[int64]int{1000: 25}},
+		harness.Step{Orders: map[int64]int{1000: 40}},
+	)
+
+	for i, order := range []int{10, 25} {
+		report, err := s.run()
+		if err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		ir := itemReport(t, report, title)
+		if ir.Status != statusUnchanged || ir.Order != order {
+			t.Fatalf("run %d: status %q at order %d, want %q at %d", i+1, ir.Status, ir.Order, statusUnchanged, order)
+		}
+		s.next(3 * time.Hour)
+	}
+
+	report, err := s.run()
+	if err != nil {
+		t.Fatalf("run 3: %v", err)
+	}
+	ir := itemReport(t, report, title)
+	if ir.Status != statusReuploaded || ir.Reason != "order" {
+		t.Fatalf("run 3: status %q reason %q, want %q because of the order", ir.Status, ir.Reason, statusReuploaded)
+	}
+
+	it := s.item(title)
+	if id := it["AdUploadedId"]; id != float64(1001) {
+		t.Errorf("AdUploadedId = %v, want 1001", id)
+	}
+	if at := it["AdUploadedAt"]; at != s.clock.Now().Format(time.RFC3339) {
+		t.Errorf("AdUploadedAt = %v, want %s", at, s.clock.Now().Format(time.RFC3339))
+	}
+	if count := it["AdUploadCount"]; count != float64(1) {
+		t.Errorf("AdUploadCount = %v, want 1", count)
+	}
+	if phase, _ := it["ReuploadPhase"].(string); phase != "" {
+		t.Errorf("ReuploadPhase = %q, want none", phase)
+	}
+	if got := s.client.Active(); len(got) != 1 || got[0] != 1001 {
+		t.Errorf("active ads %v, want [1001]", got)
+	}
+	if n, m := len(s.calls("RemoveAd")), len(s.calls("UploadAd")); n != 1 || m != 1 {
+		t.Errorf("%d removals and %d uploads, want 1 of each", n, m)
+	}
+	if kinds := s.notes.kinds(); len(kinds) != 0 {
+		t.Errorf("notified %v, want nothing", kinds)
+	}
+}
+
+func TestScenarioSessionExpiresMidRun(t *testing.T) {
+	// the session expires after the first live check of the second run and
+	// is valid again in the third
+	s := newScenario(t, "session",
+		harness.Step{},
+		harness.Step{Sequence: map[string][]error{"GetActiveAd": {nil, harness.ErrSessionExpired}}},
+		harness.Step{},
+	)
+
+	if _, err := s.run(); err != nil {
+		t.Fatalf("run 1: %v", err)
+	}
+	s.next(3 * time.Hour)
+
+	_, err := s.run()
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("run 2: %v, want a RunError", err)
+	}
+	if len(runErr.Items) != 1 || runErr.Total != 2 {
+		t.Fatalf("run 2: %d of %d items failed, want 1 of 2", len(runErr.Items), runErr.Total)
+	}
+	failed := runErr.Items[0].AdTitle
+	if n := s.item(failed)["FailCount"]; n != float64(1) {
+		t.Errorf("run 2: FailCount of %q = %v, want 1", failed, n)
+	}
+	s.next(3 * time.Hour)
+
+	report, err := s.run()
+	if err != nil {
+		t.Fatalf("run 3: %v", err)
+	}
+	for _, it := range s.store.Items("items") {
+		title := it["AdTitle"].(string)
+		if ir := itemReport(t, report, title); ir.Status != statusUnchanged {
+			t.Errorf("run 3: %q %s, want %s", title, ir.Status, statusUnchanged)
+		}
+		if n, _ := it["FailCount"].(float64); n != 0 {
+			t.Errorf("run 3: FailCount of %q = %v, want 0", title, n)
+		}
+	}
+	if n := len(s.calls("UploadAd")) + len(s.calls("RemoveAd")); n != 0 {
+		t.Errorf("%d uploads and removals, want none", n)
+	}
+}
+
+// The start jitter waits on the clock of the run, an hour of it takes no
+// real time and the upload is recorded after it
+func TestScenarioStartJitter(t *testing.T) {
+	t.Setenv("START_JITTER_SECONDS", "3600")
+	s := newScenario(t, "new")
+
+	report, err := s.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay, err := time.ParseDuration(report.StartDelay)
+	if err != nil {
+		t.Fatalf("start delay %q: %v", report.StartDelay, err)
+	}
+	if got, want := s.clock.Now(), scenarioStart.Add(delay); !got.Equal(want) {
+		t.Errorf("clock at %s after the run, want %s", got, want)
+	}
+	if at := s.item("Gorsko kolo")["AdUploadedAt"]; at != storedTime(scenarioStart.Add(delay)) {
+		t.Errorf("AdUploadedAt = %v, want %s after the delay", at, storedTime(scenarioStart.Add(delay)))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scheduler runs the items of a table in one queue per user. The items of
+// a user run one after another in their order, at least pacing apart once
+// they touch bolha, the queues of different users run in parallel on at
+// most workers goroutines. Bolha requests of all queues additionally share
+// the global limiter of the bolha clients.
+type scheduler struct {
+	// 0 runs every queue at once
+	workers int
+	pacing  time.Duration
+	// paces the items of a queue
+	clock clock
+
+	// time a user (reportUser) is owed, see fairshare.go
+	deficit func(user string) time.Duration
+}
+
+func newScheduler(cfg *Config, clock clock) *scheduler {
+	return &scheduler{
+		workers: cfg.MaxConcurrentUsers,
+		pacing:  cfg.UserPacing,
+		clock:   clock,
+		deficit: func(string) time.Duration { return 0 },
+	}
+}
+
+// queues groups the indexes of bItems for which include is true by user,
+// keeping their order. The queues of users owed the most time come first,
+// then the longest queues which bound the duration of the run.
+func (s *scheduler) queues(bItems []BolhaItem, include func(i int) bool) [][]int {
+	byUser := make(map[string]int)
+	queues := make([][]int, 0)
+	for i := range bItems {
+		if !include(i) {
+			continue
+		}
+		k := bItems[i].userKey()
+		q, ok := byUser[k]
+		if !ok {
+			q = len(queues)
+			byUser[k] = q
+			queues = append(queues, nil)
+		}
+		queues[q] = append(queues[q], i)
+	}
+
+	sort.SliceStable(queues, func(a, b int) bool {
+		da, db := s.deficit(bItems[queues[a][0]].reportUser()), s.deficit(bItems[queues[b][0]].reportUser())
+		if da != db {
+			return da > db
+		}
+		return len(queues[a]) > len(queues[b])
+	})
+
+	return queues
+}
+
+// run calls run for every item of queues and returns once all returned,
+// the queues start in their order. Run reports whether the item touched
+// bolha, only those are paced. Every item is run even once ctx is done so
+// it gets a status, without pacing.
+func (s *scheduler) run(ctx context.Context, queues [][]int, run func(i int) bool) {
+	workers := s.workers
+	if workers <= 0 || workers > len(queues) {
+		workers = len(queues)
+	}
+
+	next := make(chan []int, len(queues))
+	for _, queue := range queues {
+		next <- queue
+	}
+	close(next)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for queue := range next {
+				s.runQueue(ctx, queue, run)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (s *scheduler) runQueue(ctx context.Context, queue []int, run func(i int) bool) {
+	var last time.Time
+	for _, i := range queue {
+		if wait := s.pacing - s.clock.Now().Sub(last); !last.IsZero() && wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-s.clock.After(wait):
+			}
+		}
+
+		if run(i) {
+			last = s.clock.Now()
+		}
+	}
+}
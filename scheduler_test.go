@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// contended returns the items of a user with 50 items followed by five
+// users with 2 items each
+func contended() []BolhaItem {
+	bItems := make([]BolhaItem, 0, 60)
+	for i := 0; i < 50; i++ {
+		bItems = append(bItems, BolhaItem{AdTitle: fmt.Sprintf("heavy %d", i), UserId: "heavy"})
+	}
+	for u := 1; u <= 5; u++ {
+		for i := 0; i < 2; i++ {
+			bItems = append(bItems, BolhaItem{AdTitle: fmt.Sprintf("small%d %d", u, i), UserId: fmt.Sprintf("small%d", u)})
+		}
+	}
+	return bItems
+}
+
+func all(int) bool { return true }
+
+// The heavy user takes one worker, the small users share the other and are
+// not held up behind it, every queue keeps its order and pacing
+func TestSchedulerContention(t *testing.T) {
+	const pacing = time.Minute
+	clock := harness.NewClock(scenarioStart)
+	s := newScheduler(&Config{MaxConcurrentUsers: 2, UserPacing: pacing}, clock)
+
+	bItems := contended()
+	queues := s.queues(bItems, all)
+	if len(queues) != 6 || bItems[queues[0][0]].UserId != "heavy" {
+		t.Fatalf("queues %v, want the heavy user first of 6", queues)
+	}
+
+	var (
+		mu        sync.Mutex
+		ran       = make(map[string][]time.Time)
+		order     = make(map[string][]int)
+		small     int
+		smallDone = make(chan struct{})
+		stuck     bool
+	)
+	s.run(context.Background(), queues, func(i int) bool {
+		user := bItems[i].UserId
+
+		mu.Lock()
+		ran[user] = append(ran[user], clock.Now())
+		order[user] = append(order[user], i)
+		n := len(ran[user])
+		if user != "heavy" {
+			if small++; small == 10 {
+				close(smallDone)
+			}
+		}
+		mu.Unlock()
+
+		// the heavy user only gets on once every small user is done, which
+		// never happens if they queue behind it
+		if user == "heavy" && n == 3 {
+			select {
+			case <-smallDone:
+			case <-time.After(5 * time.Second):
+				stuck = true
+			}
+		}
+		return true
+	})
+
+	if stuck {
+		t.Fatal("small users did not run while the heavy user did")
+	}
+	if n := len(ran["heavy"]); n != 50 {
+		t.Errorf("heavy user ran %d items, want 50", n)
+	}
+	for user, times := range ran {
+		if user != "heavy" && len(times) != 2 {
+			t.Errorf("%s ran %d items, want 2", user, len(times))
+		}
+		for k := 1; k < len(times); k++ {
+			if d := times[k].Sub(times[k-1]); d < pacing {
+				t.Errorf("%s: items %d and %d ran %s apart, want at least %s", user, k-1, k, d, pacing)
+			}
+			if order[user][k] < order[user][k-1] {
+				t.Errorf("%s: items ran out of order %v", user, order[user])
+			}
+		}
+	}
+}
+
+// Users owed time start first, otherwise the longest queue does
+func TestSchedulerQueueOrder(t *testing.T) {
+	bItems := contended()
+
+	s := newScheduler(&Config{MaxConcurrentUsers: 1}, harness.NewClock(scenarioStart))
+	first := make([]string, 0)
+	for _, q := range s.queues(bItems, all) {
+		first = append(first, bItems[q[0]].UserId)
+	}
+	if want := "[heavy small1 small2 small3 small4 small5]"; fmt.Sprint(first) != want {
+		t.Errorf("queues of %v, want %s", first, want)
+	}
+
+	s.deficit = func(user string) time.Duration {
+		if user == "small3" {
+			return time.Minute
+		}
+		return 0
+	}
+	first = first[:0]
+	for _, q := range s.queues(bItems, all) {
+		first = append(first, bItems[q[0]].UserId)
+	}
+	if want := "[small3 heavy small1 small2 small4 small5]"; fmt.Sprint(first) != want {
+		t.Errorf("queues of %v, want %s", first, want)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// When MAX_ITEMS_PER_RUN leaves only some items to a run, pending uploads
+// come first and SELECTION_STRATEGY picks among the rest: strict-priority
+// takes the highest priorities, which starves the others as long as they
+// stay due, weighted-random draws items with a weight of priority times
+// staleness, lowered for items selected within the last day. Items picked
+// while the limit binds get LastSelectedAt.
+
+const (
+	selectionStrictPriority = "strict-priority"
+	selectionWeightedRandom = "weighted-random"
+)
+
+const (
+	// staleness of items without an ad
+	selectionNewStaleness = 7 * 24 * time.Hour
+	// items selected within the window have their weight lowered, down
+	// to selectionMinPenalty right after
+	selectionPenaltyWindow = 24 * time.Hour
+	selectionMinPenalty    = 0.1
+)
+
+// itemSelector orders the candidates of a run bound by MAX_ITEMS_PER_RUN
+type itemSelector struct {
+	strategy string
+	rng      *rand.Rand
+}
+
+func newItemSelector(strategy string, seed int64) *itemSelector {
+	return &itemSelector{strategy: strategy, rng: rand.New(rand.NewSource(seed))}
+}
+
+// order sorts idxs, the indexes of bItems, in the order they are picked
+func (s *itemSelector) order(bItems []BolhaItem, idxs []int, now time.Time) {
+	sort.SliceStable(idxs, func(a, b int) bool {
+		ia, ib := &bItems[idxs[a]], &bItems[idxs[b]]
+		if ia.UploadPending != ib.UploadPending {
+			return ia.UploadPending
+		}
+		if ia.Priority != ib.Priority {
+			return ia.Priority > ib.Priority
+		}
+		return ia.AdTitle < ib.AdTitle
+	})
+	if s == nil || s.strategy != selectionWeightedRandom {
+		return
+	}
+
+	// pending uploads stay first, the rest is a weighted sample without
+	// replacement: the smallest exponential draws divided by their weight
+	// win
+	rest := idxs
+	for len(rest) > 0 && bItems[rest[0]].UploadPending {
+		rest = rest[1:]
+	}
+	keys := make(map[int]float64, len(rest))
+	for _, i := range rest {
+		keys[i] = s.rng.ExpFloat64() / bItems[i].selectionWeight(now)
+	}
+	sort.SliceStable(rest, func(a, b int) bool {
+		return keys[rest[a]] < keys[rest[b]]
+	})
+}
+
+// selectionWeight is the priority (at least 1) times the hours since the
+// ad of bItem was uploaded, lowered if it was selected recently
+func (bItem *BolhaItem) selectionWeight(now time.Time) float64 {
+	priority := float64(bItem.Priority) + 1
+	if priority < 1 {
+		priority = 1
+	}
+
+	staleness := selectionNewStaleness
+	if uploadedAt, err := time.Parse(time.RFC3339, bItem.AdUploadedAt); err == nil && bItem.AdUploadedId != 0 {
+		staleness = now.Sub(uploadedAt)
+	}
+	if staleness < time.Hour {
+		staleness = time.Hour
+	}
+
+	weight := priority * staleness.Hours()
+
+	if selectedAt, err := time.Parse(time.RFC3339, bItem.LastSelectedAt); err == nil {
+		if since := now.Sub(selectedAt); since < selectionPenaltyWindow {
+			penalty := since.Hours() / selectionPenaltyWindow.Hours()
+			if penalty < selectionMinPenalty {
+				penalty = selectionMinPenalty
+			}
+			weight *= penalty
+		}
+	}
+
+	return weight
+}
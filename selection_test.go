@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// simulateSelection runs 200 runs 6 hours apart which each pick 4 of 10
+// items of priorities 0 to 9, the picked ones are reuploaded. It returns
+// how often each item was picked.
+func simulateSelection(strategy string) []int {
+	const (
+		runs    = 200
+		perRun  = 4
+		between = 6 * time.Hour
+	)
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	bItems := make([]BolhaItem, 10)
+	for i := range bItems {
+		bItems[i] = BolhaItem{
+			AdTitle:      fmt.Sprintf("item %d", i),
+			Priority:     i,
+			AdUploadedId: int64(1000 + i),
+			AdUploadedAt: storedTime(now.Add(-48 * time.Hour)),
+		}
+	}
+
+	sel := newItemSelector(strategy, 1)
+	picked := make([]int, len(bItems))
+	for run := 0; run < runs; run++ {
+		idxs := make([]int, len(bItems))
+		for i := range idxs {
+			idxs[i] = i
+		}
+		sel.order(bItems, idxs, now)
+
+		for _, i := range idxs[:perRun] {
+			picked[i]++
+			bItems[i].LastSelectedAt = storedTime(now)
+			bItems[i].AdUploadedAt = storedTime(now)
+		}
+		now = now.Add(between)
+	}
+
+	return picked
+}
+
+// Strict priority starves all but the highest priorities, weighted random
+// gets to every item and to the higher priorities more often
+func TestSelectionFairness(t *testing.T) {
+	strict := simulateSelection(selectionStrictPriority)
+	for i, n := range strict {
+		want := 0
+		if i >= 6 {
+			want = 200
+		}
+		if n != want {
+			t.Errorf("strict priority picked item %d %d times, want %d", i, n, want)
+		}
+	}
+
+	weighted := simulateSelection(selectionWeightedRandom)
+	total := 0
+	for i, n := range weighted {
+		total += n
+		if n < 40 {
+			t.Errorf("weighted random picked item %d only %d times of 200 runs", i, n)
+		}
+	}
+	if total != 800 {
+		t.Errorf("weighted random picked %d items, want 4 per run", total)
+	}
+	if weighted[9] <= weighted[0] {
+		t.Errorf("weighted random picked the highest priority %d times, the lowest %d times", weighted[9], weighted[0])
+	}
+	t.Logf("strict %v, weighted %v", strict, weighted)
+}
+
+// Pending uploads are picked first by either strategy
+func TestSelectionPendingFirst(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	bItems := []BolhaItem{
+		{AdTitle: "a", Priority: 9},
+		{AdTitle: "b", UploadPending: true},
+		{AdTitle: "c", Priority: 5},
+		{AdTitle: "d", UploadPending: true, Priority: 1},
+	}
+
+	for _, strategy := range []string{selectionStrictPriority, selectionWeightedRandom} {
+		for seed := int64(0); seed < 20; seed++ {
+			idxs := []int{0, 1, 2, 3}
+			newItemSelector(strategy, seed).order(bItems, idxs, now)
+			if idxs[0] != 3 || idxs[1] != 1 {
+				t.Errorf("%s (seed %d) picked %v, want the pending uploads d and b first", strategy, seed, idxs)
+			}
+		}
+	}
+}
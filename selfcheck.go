@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SelfCheckResult lists the outcome of every self check
+type SelfCheckResult struct {
+	StartedAt time.Time   `json:"startedAt"`
+	Passed    bool        `json:"passed"`
+	Checks    []CheckItem `json:"checks"`
+	Version   VersionInfo `json:"version"`
+}
+
+// CheckItem is the outcome of a single self check
+type CheckItem struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// selfCheck verifies that the function can reach everything a run needs
+// without changing anything. With bolha a read-only bolha call is made for
+// every user as well.
+func (m *monitor) selfCheck(ctx context.Context, bolha bool) (*SelfCheckResult, error) {
+	m.log.WithField("bolha", bolha).Info("running self check...")
+
+	result := &SelfCheckResult{
+		StartedAt: m.now(),
+		Passed:    true,
+		Checks:    make([]CheckItem, 0),
+		Version:   version,
+	}
+	check := func(name string, err error) {
+		c := CheckItem{Name: name, Passed: err == nil}
+		if err != nil {
+			c.Error = err.Error()
+			result.Passed = false
+		}
+		result.Checks = append(result.Checks, c)
+	}
+
+	for _, t := range m.cfg.TableNames {
+		check("dynamodb:DescribeTable "+t, m.describeTable(ctx, t))
+		check("dynamodb:Scan "+t, m.scanOne(ctx, t))
+	}
+
+	if m.cfg.UsersTableName != "" {
+		check("dynamodb:DescribeTable "+m.cfg.UsersTableName, m.describeTable(ctx, m.cfg.UsersTableName))
+	}
+
+	for i, b := range m.cfg.ImagesBuckets {
+		c, err := m.buckets.client(ctx, i, b)
+		if err == nil {
+			_, err = c.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(b)})
+		}
+		check("s3:HeadBucket "+b, err)
+	}
+
+	buckets := make(map[string]bool)
+	for _, b := range []string{m.cfg.StatusPageBucket, m.cfg.BackupBucket, m.cfg.ReportBucket} {
+		if b != "" {
+			buckets[b] = true
+		}
+	}
+	names := make([]string, 0, len(buckets))
+	for b := range buckets {
+		names = append(names, b)
+	}
+	sort.Strings(names)
+	for _, b := range names {
+		check("s3:HeadBucket "+b, m.headBucket(ctx, b))
+	}
+
+	users, err := m.getUsers(ctx)
+	check("users", err)
+
+	ids := make([]string, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		user := users[id]
+		if len(user.UserCredentialsEncrypted) > 0 {
+			_, err := m.decryptCredentials(ctx, user)
+			check("kms:Decrypt "+id, err)
+		}
+		if user.CredentialsRef != "" {
+			_, err := m.getCredentials(ctx, user.CredentialsRef)
+			check("ssm:GetParameter "+user.CredentialsRef, err)
+		}
+
+		if bolha {
+			check(fmt.Sprintf("bolha:GetActiveAds %s", id), m.checkBolhaUser(ctx, user))
+		}
+	}
+
+	if result.Passed {
+		m.log.WithField("result", result).Info("self check passed")
+	} else {
+		m.log.WithField("result", result).Error("self check failed")
+	}
+
+	return result, nil
+}
+
+func (m *monitor) checkBolhaUser(ctx context.Context, user *BolhaUser) error {
+	c, err := m.newUserClient(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.GetActiveAds(ctx)
+	return err
+}
+
+// DYNAMODB
+
+func (m *monitor) describeTable(ctx context.Context, name string) error {
+	_, err := m.ddb.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(name),
+	})
+	return err
+}
+
+func (m *monitor) scanOne(ctx context.Context, name string) error {
+	_, err := m.ddb.Scan(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(name),
+		Limit:     aws.Int32(1),
+	})
+	return err
+}
+
+// S3
+
+func (m *monitor) headBucket(ctx context.Context, bucket string) error {
+	_, err := m.s3.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	return err
+}
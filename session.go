@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Bolha sessions die after some days. A user's SessionObtainedAt is set
+// the first time a run sees its SessionId, keyed by a fingerprint of the
+// session so a session stored anew starts aging anew. Sessions older than
+// SESSION_WARN_AGE are flagged in the report and notified once, users with
+// credentials log in instead of using them. The bolha client does not
+// hand out the session of a login, so the aging session stays stored until
+// it is replaced.
+
+// sessionFingerprint identifies sessionId without revealing it
+func sessionFingerprint(sessionId string) string {
+	sum := sha256.Sum256([]byte(sessionId))
+	return hex.EncodeToString(sum[:8])
+}
+
+// hasCredentials reports whether user can log in
+func (user *BolhaUser) hasCredentials() bool {
+	return len(user.UserCredentialsEncrypted) > 0 || user.CredentialsRef != ""
+}
+
+// trackSessions records when the sessions of users were first seen and
+// flags the sessions older than SESSION_WARN_AGE, notifying each aging
+// session once. A dry run writes and notifies nothing.
+func (m *monitor) trackSessions(ctx context.Context, users map[string]*BolhaUser, now time.Time, dryRun bool) {
+	for _, user := range users {
+		if user.SessionId == "" {
+			continue
+		}
+
+		fp := sessionFingerprint(user.SessionId)
+		obtainedAt, err := time.Parse(time.RFC3339, user.SessionObtainedAt)
+		if user.SessionFingerprint != fp || err != nil {
+			obtainedAt = now
+			user.SessionObtainedAt, user.SessionFingerprint, user.SessionWarnedAt = storedTime(now), fp, ""
+			if !dryRun {
+				if err := m.setSessionObtainedAt(ctx, user.UserId, fp, now); err != nil {
+					m.log.WithField("UserId", user.UserId).WithError(err).Warn("could not record session")
+				}
+			}
+		}
+
+		user.sessionAge = now.Sub(obtainedAt)
+		user.sessionAging = m.cfg.SessionWarnAge > 0 && user.sessionAge > m.cfg.SessionWarnAge
+		if !user.sessionAging || user.SessionWarnedAt != "" || dryRun {
+			continue
+		}
+
+		m.log.WithFields(log.Fields{"UserId": user.UserId, "age": user.sessionAge.Round(time.Hour).String()}).Warn("session aging")
+
+		n := newNotification(notificationSessionAging,
+			fmt.Sprintf("session of %s is aging", user.UserId),
+			fmt.Sprintf("the bolha session of user %q was obtained %s ago, store a new one before it expires", user.UserId, user.sessionAge.Round(time.Hour)),
+		)
+		n.User = user.UserId
+		if user.hasCredentials() {
+			n.Message += ", until then runs log in with the user's credentials"
+		}
+		if err := m.notif.Notify(ctx, n); err != nil {
+			m.log.WithField("UserId", user.UserId).WithError(err).Warn("could not notify aging session")
+			continue
+		}
+		if err := m.setSessionWarnedAt(ctx, user.UserId, now); err != nil {
+			m.log.WithField("UserId", user.UserId).WithError(err).Warn("could not record session warning")
+		}
+		user.SessionWarnedAt = storedTime(now)
+	}
+}
+
+// setSessionAge flags the session of user in the report
+func (r *Report) setSessionAge(user string, bUser *BolhaUser) {
+	if bUser == nil || bUser.SessionId == "" {
+		return
+	}
+	u := r.userReport(user)
+	u.SessionAge = bUser.sessionAge.Round(time.Hour).String()
+	u.SessionAging = bUser.sessionAging
+}
+
+// DYNAMODB
+
+func (m *monitor) setSessionObtainedAt(ctx context.Context, userId, fingerprint string, at time.Time) error {
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: storedTime(at)},
+			":fp": &types.AttributeValueMemberS{Value: fingerprint},
+		},
+		Key:                 map[string]types.AttributeValue{"UserId": &types.AttributeValueMemberS{Value: userId}},
+		UpdateExpression:    aws.String("SET SessionObtainedAt = :at, SessionFingerprint = :fp REMOVE SessionWarnedAt"),
+		ConditionExpression: aws.String("attribute_exists(UserId)"),
+		TableName:           aws.String(m.cfg.UsersTableName),
+	})
+
+	return err
+}
+
+func (m *monitor) setSessionWarnedAt(ctx context.Context, userId string, at time.Time) error {
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: storedTime(at)},
+		},
+		Key:                 map[string]types.AttributeValue{"UserId": &types.AttributeValueMemberS{Value: userId}},
+		UpdateExpression:    aws.String("SET SessionWarnedAt = :at"),
+		ConditionExpression: aws.String("attribute_exists(UserId)"),
+		TableName:           aws.String(m.cfg.UsersTableName),
+	})
+
+	return err
+}
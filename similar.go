@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// find-duplicates looks for items of the same user which are likely the
+// same physical item entered twice: the same category, prices at most
+// DUPLICATE_PRICE_TOLERANCE percent apart and titles at least
+// DUPLICATE_MIN_SIMILARITY similar. Titles are compared lowercased, with
+// Slovenian diacritics folded and punctuation dropped, as the better of the
+// edit distance and the overlap of their words. Pairs are only reported,
+// merging them is left to the owner. Disabled items are left out as they
+// have no ad competing with the other.
+
+// DuplicatesResult lists the pairs of items suspected to be duplicates
+type DuplicatesResult struct {
+	StartedAt time.Time            `json:"startedAt"`
+	Table     string               `json:"table"`
+	Items     int                  `json:"items"`
+	Pairs     []SuspectedDuplicate `json:"pairs"`
+	Version   VersionInfo          `json:"version"`
+}
+
+// SuspectedDuplicate is a pair of items which look like the same item
+type SuspectedDuplicate struct {
+	User       string           `json:"user"`
+	Similarity float64          `json:"similarity"`
+	Items      [2]DuplicateItem `json:"items"`
+}
+
+// DuplicateItem is an item of a suspected duplicate
+type DuplicateItem struct {
+	AdTitle      string `json:"adTitle"`
+	AdPrice      string `json:"adPrice"`
+	AdUploadedId int64  `json:"adUploadedId,omitempty"`
+	AdURL        string `json:"adUrl,omitempty"`
+}
+
+// findDuplicates reports the suspected duplicates of m.table, it changes
+// nothing
+func (m *monitor) findDuplicates(ctx context.Context) (*DuplicatesResult, error) {
+	m.log.WithField("table", m.table).Info("finding duplicates...")
+
+	result := &DuplicatesResult{
+		StartedAt: m.now(),
+		Table:     m.table,
+		Pairs:     make([]SuspectedDuplicate, 0),
+		Version:   version,
+	}
+
+	bItems, err := m.getBolhaItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]*BolhaItem)
+	for i := range bItems {
+		bItem := &bItems[i]
+		if bItem.unmarshalErr != nil || !bItem.enabled() {
+			continue
+		}
+		groups[bItem.userKey()] = append(groups[bItem.userKey()], bItem)
+		result.Items++
+	}
+
+	for _, group := range groups {
+		titles := make([]string, len(group))
+		for i, bItem := range group {
+			titles[i] = normalizeTitle(bItem.uploadTitle())
+		}
+
+		for i := range group {
+			for j := i + 1; j < len(group); j++ {
+				a, b := group[i], group[j]
+				if a.AdCategoryId != b.AdCategoryId || !nearPrice(a.AdPrice, b.AdPrice, m.cfg.DuplicatePriceTolerance) {
+					continue
+				}
+				sim := titleSimilarity(titles[i], titles[j])
+				if sim < m.cfg.DuplicateMinSimilarity {
+					continue
+				}
+				if b.AdTitle < a.AdTitle {
+					a, b = b, a
+				}
+				result.Pairs = append(result.Pairs, SuspectedDuplicate{
+					User:       a.reportUser(),
+					Similarity: float64(int(sim*100)) / 100,
+					Items:      [2]DuplicateItem{m.duplicateItem(a), m.duplicateItem(b)},
+				})
+			}
+		}
+	}
+
+	sort.Slice(result.Pairs, func(a, b int) bool {
+		pa, pb := result.Pairs[a], result.Pairs[b]
+		if pa.Similarity != pb.Similarity {
+			return pa.Similarity > pb.Similarity
+		}
+		return pa.Items[0].AdTitle < pb.Items[0].AdTitle
+	})
+
+	if err := m.saveReport(ctx, "find-duplicates", result.StartedAt, m.runId, result); err != nil {
+		m.log.WithError(err).Warn("could not save find-duplicates report")
+	}
+
+	m.log.WithFields(log.Fields{"table": m.table, "items": result.Items, "pairs": len(result.Pairs)}).Info("found duplicates")
+
+	return result, nil
+}
+
+func (m *monitor) duplicateItem(bItem *BolhaItem) DuplicateItem {
+	return DuplicateItem{
+		AdTitle:      bItem.AdTitle,
+		AdPrice:      bItem.AdPrice.String(),
+		AdUploadedId: bItem.AdUploadedId,
+		AdURL:        bItem.adURL(m.cfg),
+	}
+}
+
+// HELPERS
+
+// foldDiacritics maps the Slovenian (and neighbouring) letters with
+// diacritics to their base letters
+var foldDiacritics = strings.NewReplacer("č", "c", "ć", "c", "š", "s", "ž", "z", "đ", "d")
+
+// normalizeTitle lowercases title, folds its diacritics and reduces it to
+// words separated by single spaces
+func normalizeTitle(title string) string {
+	title = foldDiacritics.Replace(strings.ToLower(title))
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// titleSimilarity returns the similarity of the normalized titles a and b
+// from 0 to 1, the better of their edit distance relative to the longer
+// title and the share of their words they have in common
+func titleSimilarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+
+	longer := len([]rune(a))
+	if n := len([]rune(b)); n > longer {
+		longer = n
+	}
+	edit := 1 - float64(levenshtein(a, b))/float64(longer)
+
+	wa, wb := make(map[string]bool), make(map[string]bool)
+	for _, w := range strings.Fields(a) {
+		wa[w] = true
+	}
+	for _, w := range strings.Fields(b) {
+		wb[w] = true
+	}
+	common := 0
+	for w := range wa {
+		if wb[w] {
+			common++
+		}
+	}
+	overlap := float64(common) / float64(len(wa)+len(wb)-common)
+
+	if overlap > edit {
+		return overlap
+	}
+	return edit
+}
+
+// nearPrice reports whether a and b are at most tolerance percent of the
+// higher one apart
+func nearPrice(a, b Price, tolerance float64) bool {
+	if a == invalidPrice || b == invalidPrice {
+		return false
+	}
+	diff, high := a-b, a
+	if diff < 0 {
+		diff, high = -diff, b
+	}
+	return float64(diff) <= float64(high)*tolerance/100
+}
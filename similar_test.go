@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Gorsko KOLO", "gorsko kolo"},
+		{"Kavč, usnjen!", "kavc usnjen"},
+		{"ŠTEDILNIK Gorenje", "stedilnik gorenje"},
+		{"Žaga  -  ročna", "zaga rocna"},
+		{"Đuveč lonec", "duvec lonec"},
+		{"Ćevapčići", "cevapcici"},
+		{"iPhone 12 (64GB)", "iphone 12 64gb"},
+		{"  ...  ", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeTitle(tt.title); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want float64
+	}{
+		{"same", "Gorsko kolo", "gorsko kolo", 1},
+		{"diacritics left out", "Kavč usnjen", "Kavc usnjen", 1},
+		{"diacritics of every letter", "ČŠŽĆĐ", "cszcd", 1},
+		{"words swapped", "Kavč usnjen", "usnjen kavč", 1},
+		{"punctuation", "Miza, hrastova", "Miza - hrastova!", 1},
+		{"word added, edit distance wins", "Gorsko kolo", "Gorsko kolo 26", 1 - 3.0/14},
+		{"word added, overlap wins", "Omara bela", "Omara bela stara", 2.0 / 3},
+		{"one letter", "Omara", "Omare", 0.8},
+		{"nothing in common", "Miza", "Stol", 0},
+		{"empty", "...", "Stol", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := normalizeTitle(tt.a), normalizeTitle(tt.b)
+			if got := titleSimilarity(a, b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("similarity of %q and %q = %v, want %v", a, b, got, tt.want)
+			}
+			if got, rev := titleSimilarity(a, b), titleSimilarity(b, a); got != rev {
+				t.Errorf("similarity %v one way and %v the other", got, rev)
+			}
+		})
+	}
+
+	// the edit distance counts letters, not the bytes of unfolded titles
+	if got := titleSimilarity("čaša", "caša"); got != 0.75 {
+		t.Errorf("similarity of unfolded titles = %v, want 0.75", got)
+	}
+}
+
+func TestNearPrice(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      Price
+		tolerance float64
+		want      bool
+	}{
+		{"equal", eurosPrice(100), eurosPrice(100), 10, true},
+		{"at the tolerance of the higher", eurosPrice(100), eurosPrice(110), 10, true},
+		{"higher first", eurosPrice(110), eurosPrice(100), 10, true},
+		{"past the tolerance", eurosPrice(100), eurosPrice(112), 10, false},
+		{"cents", 999, 1099, 10, true},
+		{"both free", 0, 0, 10, true},
+		{"no tolerance", eurosPrice(100), eurosPrice(100) + 1, 0, false},
+		{"no tolerance, equal", eurosPrice(100), eurosPrice(100), 0, true},
+		{"invalid", invalidPrice, eurosPrice(100), 10, false},
+		{"both invalid", invalidPrice, invalidPrice, 100, false},
+	}
+
+	for _, tt := range tests {
+		if got := nearPrice(tt.a, tt.b, tt.tolerance); got != tt.want {
+			t.Errorf("%s: nearPrice(%s, %s, %v) = %v, want %v", tt.name, tt.a, tt.b, tt.tolerance, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// SkipReason tells why an item was left alone in a run, it is the same in
+// the report, the logs and the metrics
+type SkipReason string
+
+const (
+	SkipNotDue            SkipReason = "not-due"
+	SkipScheduled         SkipReason = "scheduled"
+	SkipExpired           SkipReason = "expired"
+	SkipDisabled          SkipReason = "disabled"
+	SkipUserPaused        SkipReason = "user-paused"
+	SkipPreHook           SkipReason = "pre-hook-failed"
+	SkipInvalid           SkipReason = "invalid"
+	SkipWaitingForSlot    SkipReason = "waiting-for-slot"
+	SkipItemLimit         SkipReason = "item-limit"
+	SkipCanary            SkipReason = "canary"
+	SkipTimeBudget        SkipReason = "time-budget"
+	SkipTimeSlice         SkipReason = "time-slice"
+	SkipImagesUnavailable SkipReason = "images-unavailable"
+	SkipCooldown          SkipReason = "cooldown"
+	SkipCanceled          SkipReason = "canceled"
+	SkipBlocked           SkipReason = "blocked"
+	SkipPriceBlocked      SkipReason = "price-blocked"
+	SkipPendingModeration SkipReason = "pending-moderation"
+)
+
+// skipReasons maps the statuses of skipped items to their reason
+var skipReasons = map[string]SkipReason{
+	statusUnchanged:         SkipNotDue,
+	statusScheduled:         SkipScheduled,
+	statusExpired:           SkipExpired,
+	statusDisabled:          SkipDisabled,
+	statusUserPaused:        SkipUserPaused,
+	statusPreHookFailed:     SkipPreHook,
+	statusInvalid:           SkipInvalid,
+	statusWaitingForSlot:    SkipWaitingForSlot,
+	statusDeferredItemLimit: SkipItemLimit,
+	statusDeferredCanary:    SkipCanary,
+	statusDeferredBudget:    SkipTimeBudget,
+	statusDeferredSlice:     SkipTimeSlice,
+	statusDeferredImages:    SkipImagesUnavailable,
+	statusCoolingDown:       SkipCooldown,
+	statusCanceled:          SkipCanceled,
+	statusBlocked:           SkipBlocked,
+	statusPriceBlocked:      SkipPriceBlocked,
+	statusPendingModeration: SkipPendingModeration,
+}
+
+// recordSkip sets the skip reason of an item which was left alone, logging
+// it and counting it unless in a dry run
+func (m *monitor) recordSkip(ir *ItemReport, dryRun bool) {
+	reason, ok := skipReasons[ir.Status]
+	if !ok {
+		return
+	}
+	ir.SkipReason = reason
+
+	m.log.WithFields(log.Fields{"AdTitle": ir.AdTitle, "table": ir.Table, "skipReason": reason}).Info("item skipped")
+
+	// the metrics only have the function dimension, the reason is part of the name
+	if dryRun {
+		return
+	}
+	m.metrics.add("ItemsSkipped/"+string(reason), 1, unitCount)
+}
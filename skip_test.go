@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// failingHook fails every hook it is asked to run
+type failingHook struct{}
+
+func (failingHook) Invoke(ctx context.Context, params *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error) {
+	return &lambda.InvokeOutput{FunctionError: aws.String("Unhandled"), Payload: []byte(`{"errorMessage":"catalog is down"}`)}, nil
+}
+
+// unreachableImages cannot be reached for any object
+type unreachableImages struct {
+	*harness.Objects
+}
+
+func (unreachableImages) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return nil, errors.New("dial tcp: i/o timeout")
+}
+
+func (unreachableImages) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return nil, errors.New("dial tcp: i/o timeout")
+}
+
+// Every skip reason is reported for the item it holds back, a reason added
+// without a case here fails the test
+func TestSkipReasons(t *testing.T) {
+	const title = "Gorsko kolo"
+	due := harness.Step{Orders: map[int64]int{1000: 40}}
+
+	tests := []struct {
+		reason  SkipReason
+		fixture string
+		steps   []harness.Step
+		env     map[string]string
+		// prepares the scenario, returns the context and event of the run
+		setup func(s *scenario, svc *services) (context.Context, string)
+		// item which is held back, title if empty
+		title string
+	}{
+		{reason: SkipNotDue, fixture: "sinking"},
+		{
+			reason:  SkipScheduled,
+			fixture: "new",
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.update(title, map[string]interface{}{"PublishAt": "2026-10-02T10:00:00Z"})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipExpired,
+			fixture: "sinking",
+			env:     map[string]string{"TTL_ATTRIBUTE": "ExpiresAt"},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.update(title, map[string]interface{}{"ExpiresAt": scenarioStart.Add(-time.Hour).Unix()})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipDisabled,
+			fixture: "sinking",
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.update(title, map[string]interface{}{"Enabled": false})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipUserPaused,
+			fixture: "sinking",
+			env:     map[string]string{"USERS_TABLE": "users"},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.store.AddTable("users", "UserId")
+				s.put("users", map[string]interface{}{"UserId": "u1", "SessionId": "session-1", "Paused": true})
+				s.update(title, map[string]interface{}{"UserId": "u1"})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipPreHook,
+			fixture: "sinking",
+			steps:   []harness.Step{due},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				svc.lambda = failingHook{}
+				s.update(title, map[string]interface{}{"PreReuploadLambdaArn": "catalog-hook"})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipInvalid,
+			fixture: "new",
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.update(title, map[string]interface{}{"AdPrice": "free"})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipWaitingForSlot,
+			fixture: "sinking",
+			env:     map[string]string{"MAX_ACTIVE_ADS": "1"},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.put("items", newItem("Kavč", "session-1"))
+				return context.Background(), "{}"
+			},
+			title: "Kavč",
+		},
+		{
+			reason:  SkipItemLimit,
+			fixture: "sinking",
+			env:     map[string]string{"MAX_ITEMS_PER_RUN": "1"},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				// items go by title, Gorsko kolo first
+				s.put("items", newItem("Kavč", "session-1"))
+				return context.Background(), "{}"
+			},
+			title: "Kavč",
+		},
+		{
+			reason:  SkipCanary,
+			fixture: "sinking",
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.put("items", newItem("Kavč", "session-1"))
+				return context.Background(), `{"canary": true}`
+			},
+		},
+		{
+			reason:  SkipTimeBudget,
+			fixture: "new",
+			env: map[string]string{
+				"SELF_CONTINUATION":         "true",
+				"CONTINUATION_MARGIN":       "30m",
+				"BOLHA_REQUESTS_PER_SECOND": "0.0001",
+				"BOLHA_REQUEST_BURST":       "1",
+			},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				// the scheduled item takes the only request, the next one
+				// comes long after the budget
+				s.update(title, map[string]interface{}{"PublishAt": "2026-10-02T10:00:00Z"})
+				s.put("items", newItem("Kavč", "session-1"))
+				ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+				s.t.Cleanup(cancel)
+				return ctx, "{}"
+			},
+			title: "Kavč",
+		},
+		{
+			reason:  SkipTimeSlice,
+			fixture: "sinking",
+			// every call of bolha takes a second of the clock
+			steps: []harness.Step{{Latency: time.Second}},
+			env: map[string]string{
+				"SELF_CONTINUATION":    "true",
+				"USERS_TABLE":          "users",
+				"MAX_CONCURRENT_USERS": "1",
+			},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				// u1 has next to no share of the budget, its first item
+				// uses it up while u2 still waits for its own
+				s.store.AddTable("users", "UserId")
+				s.put("users", map[string]interface{}{"UserId": "u1", "SessionId": "session-1", "TimeWeight": 1})
+				s.put("users", map[string]interface{}{"UserId": "u2", "SessionId": "session-2", "TimeWeight": 100000000})
+				s.update(title, map[string]interface{}{"UserId": "u1"})
+				kavc := newItem("Kavč", "session-1")
+				kavc["UserId"], kavc["AdUploadedId"], kavc["AdUploadedAt"] = "u1", 1001, "2026-10-01T08:00:00Z"
+				s.put("items", kavc)
+				s.client.Activate(1001, 1)
+				miza := newItem("Miza", "session-2")
+				miza["UserId"] = "u2"
+				s.put("items", miza)
+
+				// five seconds past CONTINUATION_MARGIN, u1 gets 50ns of them
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute+5*time.Second)
+				s.t.Cleanup(cancel)
+				return ctx, "{}"
+			},
+			title: "Kavč",
+		},
+		{
+			reason:  SkipImagesUnavailable,
+			fixture: "sinking",
+			steps:   []harness.Step{due},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				// the images are listed to tell the content
+				svc.s3 = unreachableImages{s.objects}
+				it := s.item(title)
+				delete(it, "AdImages")
+				it["AdImagesPrefix"] = "kolo/"
+				s.put("items", it)
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipCooldown,
+			fixture: "sinking",
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.update(title, map[string]interface{}{"CooldownUntil": "2026-10-02T10:00:00Z"})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipCanceled,
+			fixture: "sinking",
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, "{}"
+			},
+		},
+		{
+			reason:  SkipBlocked,
+			fixture: "sinking",
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.update(title, map[string]interface{}{"AdState": adStateBlocked})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipPriceBlocked,
+			fixture: "sinking",
+			env:     map[string]string{"PRICE_CHANGE_MAX_PERCENT": "20"},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.update(title, map[string]interface{}{"AdUploadedPrice": 100})
+				return context.Background(), "{}"
+			},
+		},
+		{
+			reason:  SkipPendingModeration,
+			fixture: "sinking",
+			steps:   []harness.Step{{}, {Missing: []int64{1000}}},
+			setup: func(s *scenario, svc *services) (context.Context, string) {
+				s.next(0)
+				return context.Background(), "{}"
+			},
+		},
+	}
+
+	tested := make(map[SkipReason]bool)
+	for _, tt := range tests {
+		tested[tt.reason] = true
+		t.Run(string(tt.reason), func(t *testing.T) {
+			s := newScenario(t, tt.fixture, tt.steps...)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			svc := s.services()
+			ctx, event := context.Background(), "{}"
+			if tt.setup != nil {
+				ctx, event = tt.setup(s, svc)
+			}
+			held := tt.title
+			if held == "" {
+				held = title
+			}
+
+			report, _ := s.runEvent(ctx, svc, event)
+			if report == nil {
+				t.Fatal("no report")
+			}
+			ir := itemReport(t, report, held)
+			if ir.SkipReason != tt.reason {
+				t.Errorf("skip reason %q (status %q, error %q), want %q", ir.SkipReason, ir.Status, ir.Error, tt.reason)
+			}
+		})
+	}
+
+	for _, reason := range skipReasons {
+		if !tested[reason] {
+			t.Errorf("skip reason %q not tested", reason)
+		}
+	}
+}
+
+// newItem returns the attributes of a valid item never uploaded
+func newItem(title, session string) map[string]interface{} {
+	return map[string]interface{}{
+		"AdTitle":       title,
+		"AdDescription": "Rabljeno.",
+		"AdPrice":       50,
+		"AdCategoryId":  1,
+		"AdImages":      []string{"kolo/1.jpg"},
+		"UserSessionId": session,
+		"ReuploadHours": 168,
+		"ReuploadOrder": 30,
+	}
+}
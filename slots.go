@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// userKey groups items by account, legacy items by their session
+func (bItem *BolhaItem) userKey() string {
+	if bItem.UserId != "" {
+		return bItem.UserId
+	}
+	return "session:" + bItem.UserSessionId
+}
+
+// maxActiveAds returns the cap of active ads for the owner of bItem, 0 is unlimited
+func (m *monitor) maxActiveAds(bItem *BolhaItem, users map[string]*BolhaUser) int {
+	if u, ok := users[bItem.UserId]; ok && u.MaxActiveAds > 0 {
+		return u.MaxActiveAds
+	}
+	return m.cfg.MaxActiveAds
+}
+
+// waitingForSlot marks the new items which must not be uploaded because their
+// user already has the maximum number of active ads. Free slots go to the
+// eligible items with a pending upload first, then by highest priority.
+func (m *monitor) waitingForSlot(bItems []BolhaItem, users map[string]*BolhaUser, eligible func(i int) bool) []bool {
+	waiting := make([]bool, len(bItems))
+
+	active := make(map[string]int)
+	candidates := make(map[string][]int)
+	for i := range bItems {
+		bItem := &bItems[i]
+		if bItem.AdUploadedId != 0 {
+			active[bItem.userKey()]++
+			continue
+		}
+		if eligible(i) {
+			candidates[bItem.userKey()] = append(candidates[bItem.userKey()], i)
+		}
+	}
+
+	for user, idxs := range candidates {
+		max := m.maxActiveAds(&bItems[idxs[0]], users)
+		if max <= 0 {
+			continue
+		}
+
+		sort.SliceStable(idxs, func(a, b int) bool {
+			ia, ib := &bItems[idxs[a]], &bItems[idxs[b]]
+			// ads which went offline during a failed reupload come first
+			if ia.UploadPending != ib.UploadPending {
+				return ia.UploadPending
+			}
+			if ia.Priority != ib.Priority {
+				return ia.Priority > ib.Priority
+			}
+			return ia.AdTitle < ib.AdTitle
+		})
+
+		free := max - active[user]
+		for n, i := range idxs {
+			if n < free {
+				continue
+			}
+			waiting[i] = true
+			m.log.WithFields(log.Fields{"AdTitle": bItems[i].AdTitle, "maxActiveAds": max}).Info("ad waiting for slot")
+		}
+	}
+
+	return waiting
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var statusPageTmpl = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bolha monitor</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: .3em .8em; border-bottom: 1px solid #ddd; text-align: left; }
+.severity-0 { background: #f8d7da; }
+.severity-1 { background: #fde2c4; }
+.severity-2 { background: #d9ecff; }
+.severity-3 { background: #e2f4e2; }
+</style>
+</head>
+<body>
+<h1>bolha monitor</h1>
+<p>last run {{.FinishedAt}}, build {{.Version}}</p>
+<p>{{.Totals.Items}} items: {{.Totals.Processed}} processed, {{.Totals.Uploaded}} uploaded, {{.Totals.Reuploaded}} reuploaded, {{.Totals.Failed}} failed</p>
+{{if .MultiUser}}<table>
+<tr><th>user</th><th>items</th><th>processed</th><th>uploaded</th><th>reuploaded</th><th>failed</th><th>skipped</th><th>active ads</th><th>session</th></tr>
+{{range .Users}}<tr class="severity-{{if .Failed}}1{{else}}3{{end}}">
+<td>{{.User}}</td>
+<td>{{.Items}}</td>
+<td>{{.Processed}}</td>
+<td>{{.Uploaded}}</td>
+<td>{{.Reuploaded}}</td>
+<td>{{.Failed}}</td>
+<td>{{range $reason, $n := .Skipped}}{{$reason}}: {{$n}} {{end}}</td>
+<td>{{.ActiveAds}}{{if .MaxActiveAds}} / {{.MaxActiveAds}}{{end}}</td>
+<td>{{.Session}}</td>
+</tr>
+{{end}}</table>
+{{end}}<table>
+<tr>{{if .MultiTable}}<th>table</th>{{end}}{{if .MultiUser}}<th>user</th>{{end}}<th>ad</th><th>price</th><th>status</th><th>order</th><th>last reupload</th><th>next reupload</th><th>order history</th><th>avg. gain</th><th>reuploads (30d)</th><th>failed runs</th><th>error</th></tr>
+{{range .Rows}}<tr class="severity-{{.Severity}}">
+{{if $.MultiTable}}<td>{{.Table}}</td>{{end}}
+{{if $.MultiUser}}<td>{{.User}}</td>{{end}}
+<td>{{if .URL}}<a href="{{.URL}}">{{.AdTitle}}</a>{{else}}{{.AdTitle}}{{end}}</td>
+<td>{{.Price}} ({{.PriceType}})</td>
+<td>{{.Status}}</td>
+<td>{{if .Order}}{{.Order}}{{end}}</td>
+<td>{{.UploadedAt}}</td>
+<td>{{.NextEligibleAt}}</td>
+<td>{{.Sparkline}}</td>
+<td>{{if .GainCount}}{{printf "%.1f" .AverageGain}} ({{.GainCount}}){{end}}</td>
+<td>{{if .RecentReuploads}}{{.RecentReuploads}}{{end}}</td>
+<td>{{if .FailCount}}{{.FailCount}}{{end}}</td>
+<td>{{.Error}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// statusPageRow holds the data rendered for a single item, it must only
+// contain fields which are safe to publish (no session ids)
+type statusPageRow struct {
+	AdTitle    string
+	Table      string
+	User       string
+	URL        string
+	Price      Price
+	PriceType  string
+	Status     string
+	Order      int
+	UploadedAt string
+	FailCount  int
+
+	// age based forecast of the next reupload
+	NextEligibleAt string
+
+	// observed orders with reuploads marked, see orderhistory.go
+	Sparkline template.HTML
+
+	AverageGain     float64
+	GainCount       int
+	RecentReuploads int
+
+	Error    string
+	Severity int
+
+	uploadedAt time.Time
+}
+
+type statusPage struct {
+	FinishedAt string
+	Version    string
+	Totals     OutcomeCounts
+	Users      []UserReport
+	Rows       []statusPageRow
+	// the table column is only shown with more than one table
+	MultiTable bool
+	// the users and user column are only shown with more than one user
+	MultiUser bool
+}
+
+func newStatusPage(cfg *Config, bItems []BolhaItem, report *Report) *statusPage {
+	// titles are only unique within a table
+	reports := make(map[[2]string]ItemReport, len(report.Items))
+	for _, ir := range report.Items {
+		reports[[2]string{ir.Table, ir.AdTitle}] = ir
+	}
+
+	rows := make([]statusPageRow, len(bItems))
+	for i, bItem := range bItems {
+		ir := reports[[2]string{bItem.table, bItem.AdTitle}]
+
+		row := statusPageRow{
+			AdTitle:    bItem.AdTitle,
+			Table:      bItem.table,
+			User:       bItem.reportUser(),
+			Price:      bItem.AdPrice,
+			PriceType:  bItem.priceType(),
+			Status:     ir.Status,
+			Order:      ir.Order,
+			UploadedAt: cfg.displayStored(bItem.AdUploadedAt),
+			FailCount:  bItem.FailCount,
+			Error:      ir.Error,
+
+			NextEligibleAt: cfg.displayStored(ir.NextEligibleAt),
+			Sparkline:      bItem.orderSparkline(),
+
+			AverageGain:     bItem.averageGain(),
+			GainCount:       bItem.ReuploadGainCount,
+			RecentReuploads: bItem.recentReuploads(report.FinishedAt),
+		}
+		row.URL = bItem.adURL(cfg)
+		if t, err := time.Parse(time.RFC3339, bItem.AdUploadedAt); err == nil {
+			row.uploadedAt = t
+		}
+		if bItem.NeedsAttention {
+			row.Status = "needs attention"
+		}
+		row.Severity = statusSeverity(row.Status)
+
+		rows[i] = row
+	}
+
+	// most severe first, then stalest first
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Severity != rows[j].Severity {
+			return rows[i].Severity < rows[j].Severity
+		}
+		return rows[i].uploadedAt.Before(rows[j].uploadedAt)
+	})
+
+	return &statusPage{
+		FinishedAt: cfg.displayTime(report.FinishedAt),
+		Version:    report.Version.String(),
+		Totals:     report.Totals,
+		Users:      report.Users,
+		Rows:       rows,
+		MultiTable: len(cfg.TableNames) > 1,
+		MultiUser:  len(report.Users) > 1,
+	}
+}
+
+func (sp *statusPage) render() ([]byte, error) {
+	var buff bytes.Buffer
+	if err := statusPageTmpl.Execute(&buff, sp); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// uploadStatusPage renders the status page and uploads it to the configured
+// s3 destination, it is a no-op if no destination is configured
+func (m *monitor) uploadStatusPage(ctx context.Context, bItems []BolhaItem, report *Report) error {
+	if m.cfg.StatusPageBucket == "" || m.cfg.StatusPageKey == "" {
+		return nil
+	}
+
+	m.log.WithFields(log.Fields{"bucket": m.cfg.StatusPageBucket, "key": m.cfg.StatusPageKey}).Info("uploading status page...")
+
+	page, err := newStatusPage(m.cfg, bItems, report).render()
+	if err != nil {
+		return err
+	}
+
+	_, err = m.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(m.cfg.StatusPageBucket),
+		Key:          aws.String(m.cfg.StatusPageKey),
+		Body:         bytes.NewReader(page),
+		ContentType:  aws.String("text/html; charset=utf-8"),
+		CacheControl: aws.String("max-age=60"),
+	})
+	if err != nil {
+		return err
+	}
+
+	m.log.Info("status page uploaded")
+
+	return nil
+}
+
+func statusSeverity(status string) int {
+	switch status {
+	case "needs attention", statusBlocked:
+		return 0
+	case statusFailed, statusInvalid:
+		return 1
+	case statusUploaded, statusReuploaded:
+		return 2
+	default:
+		return 3
+	}
+}
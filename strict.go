@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A run works around some data issues and only warns about them: attributes
+// of the wrong type are coerced, an implausible AdUploadedAt is treated as
+// unknown, unknown overrides are ignored, an unreadable AdDescriptionKey
+// falls back to AdDescription, items without images are uploaded without
+// any if EMPTY_IMAGES_POLICY allows it, what the bolha client cannot upload
+// is left out or rounded and the cached categories are used if s3 fails.
+// With STRICT_MODE every one of them is a violation of the item instead, so
+// an item lists all its issues in a single ValidationError and fails the
+// run, and the categories failing to load fails the run of the table.
+
+const (
+	ruleCoercion       = "coercion"
+	ruleUploadedAt     = "uploadedAt"
+	ruleListingDetails = "listingDetails"
+)
+
+// uploadLosses are the violations of what newClientAd cannot upload as it
+// is, it only warns about them
+func (bItem *BolhaItem) uploadLosses() []Violation {
+	losses := make([]Violation, 0)
+	lose := func(rule, format string, args ...interface{}) {
+		losses = append(losses, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	details := make([]string, 0)
+	if bItem.AdCondition != "" {
+		details = append(details, "AdCondition")
+	}
+	if len(bItem.AdShipping) > 0 {
+		details = append(details, "AdShipping")
+	}
+	if bItem.AdLocation != "" {
+		details = append(details, "AdLocation")
+	}
+	if len(details) > 0 {
+		lose(ruleListingDetails, "%s not supported by the bolha client, the ad would be uploaded without", strings.Join(details, ", "))
+	}
+
+	switch bItem.priceType() {
+	case priceTypeFree:
+		return losses
+	case priceTypeNegotiable:
+		lose(rulePriceType, "bolha client cannot mark prices negotiable, the ad would be uploaded with a fixed price")
+	}
+	if price := bItem.AdPrice.euros(); bItem.AdPrice != invalidPrice && price != 0 && eurosPrice(price) != bItem.AdPrice {
+		lose(rulePrice, "bolha client only accepts whole euros, price %s would be uploaded as %d", bItem.AdPrice, price)
+	}
+
+	return losses
+}
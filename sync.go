@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	client "github.com/seniorescobar/bolha-client"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SYNC_DIRECTION, what is done with a live ad which differs from its item.
+// Only manual is available: pulling needs the live content of the ad and
+// pushing cannot tell an edit on bolha from a stale table, the bolha client
+// reads neither.
+const (
+	syncManual = "manual"
+	syncPull   = "pull"
+	syncPush   = "push"
+)
+
+// liveEdit is a field in which a live ad differs from its item
+type liveEdit struct {
+	Field string
+	Item  string
+	Live  string
+}
+
+// liveEdits compares the fields of a live ad the bolha client reads with
+// bItem. The client only reads the id and the order of an ad, its title and
+// price are not compared until the client reads them.
+func liveEdits(bItem *BolhaItem, ad *client.ActiveAd) []liveEdit {
+	edits := make([]liveEdit, 0)
+	if ad.Id != bItem.AdUploadedId {
+		edits = append(edits, liveEdit{"AdUploadedId", strconv.FormatInt(bItem.AdUploadedId, 10), strconv.FormatInt(ad.Id, 10)})
+	}
+
+	return edits
+}
+
+// resolveLiveEdits logs every edited field with its resolution. Manual
+// blocks the item since a reupload would revert the edits, and notifies
+// once.
+func (m *monitor) resolveLiveEdits(ctx context.Context, bItem *BolhaItem, edits []liveEdit) error {
+	fields := make([]string, len(edits))
+	for i, e := range edits {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "field": e.Field, "item": e.Item, "live": e.Live, "resolution": m.cfg.SyncDirection}).Warn("ad differs from its item")
+		fields[i] = e.Field
+	}
+
+	if err := m.setAdState(ctx, bItem.AdTitle, adStateBlocked); err != nil {
+		return err
+	}
+	bItem.AdState = adStateBlocked
+
+	if bItem.NeedsAttention {
+		return nil
+	}
+	if err := m.setNeedsAttention(ctx, bItem.AdTitle); err != nil {
+		return err
+	}
+	bItem.NeedsAttention = true
+
+	n := m.itemNotification(ctx, bItem,
+		notificationNeedsAttention,
+		fmt.Sprintf("%s was edited on bolha", bItem.AdTitle),
+		fmt.Sprintf("ad %q (%d) differs from its item in %s, the item is blocked so a reupload does not revert the edit until the item is updated and AdState cleared", bItem.AdTitle, bItem.AdUploadedId, strings.Join(fields, ", ")),
+	)
+	n.Severity = severityHigh
+
+	return m.notif.Notify(ctx, n)
+}
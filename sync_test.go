@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	client "github.com/seniorescobar/bolha-client"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+func TestSyncDirectionConfig(t *testing.T) {
+	tests := []struct {
+		value string
+		// error contains, empty if valid
+		err string
+	}{
+		{"", ""},
+		{"manual", ""},
+		{"pull", "unsupported"},
+		{"push", "unsupported"},
+		{"both", "must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			newScenario(t, "sinking")
+			t.Setenv("SYNC_DIRECTION", tt.value)
+
+			cfg, err := loadConfig()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.SyncDirection != syncManual {
+				t.Errorf("sync direction %q, want %q", cfg.SyncDirection, syncManual)
+			}
+		})
+	}
+}
+
+func TestLiveEdits(t *testing.T) {
+	bItem := &BolhaItem{AdTitle: "Gorsko kolo", AdUploadedId: 1000}
+
+	if edits := liveEdits(bItem, &client.ActiveAd{Id: 1000, Order: 40}); len(edits) != 0 {
+		t.Errorf("edits %v of the tracked ad", edits)
+	}
+	edits := liveEdits(bItem, &client.ActiveAd{Id: 1001, Order: 1})
+	if len(edits) != 1 || edits[0] != (liveEdit{"AdUploadedId", "1000", "1001"}) {
+		t.Errorf("edits %v, want the id", edits)
+	}
+}
+
+// Manual blocks an edited item and notifies once, later runs leave the ad
+// alone so its edits are not reverted
+func TestResolveLiveEditsManual(t *testing.T) {
+	const title = "Gorsko kolo"
+	s := newScenario(t, "sinking", harness.Step{Orders: map[int64]int{1000: 40}})
+	m, bItems := s.monitor()
+	bItem := &bItems[0]
+
+	edits := []liveEdit{{"AdTitle", title, title + " 26\""}, {"AdPrice", "150", "140"}}
+	for i := 0; i < 2; i++ {
+		if err := m.resolveLiveEdits(context.Background(), bItem, edits); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.notif.(*digestNotifier).flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	it := s.item(title)
+	if it["AdState"] != adStateBlocked || it["NeedsAttention"] != true {
+		t.Errorf("AdState %v and NeedsAttention %v, want blocked and set", it["AdState"], it["NeedsAttention"])
+	}
+	if len(s.notes.sent) != 1 {
+		t.Fatalf("%d notifications, want 1", len(s.notes.sent))
+	}
+	if n := s.notes.sent[0]; n.Kind != notificationNeedsAttention || !strings.Contains(n.Message, "AdTitle, AdPrice") {
+		t.Errorf("notification %+v, want needs-attention naming the fields", n)
+	}
+
+	// the ad is due but blocked
+	report, err := s.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir := itemReport(t, report, title); ir.SkipReason != SkipBlocked {
+		t.Errorf("skip reason %q (status %q), want %q", ir.SkipReason, ir.Status, SkipBlocked)
+	}
+	if calls := len(s.calls("RemoveAd")) + len(s.calls("UploadAd")); calls != 0 {
+		t.Errorf("%d removals and uploads of a blocked ad", calls)
+	}
+}
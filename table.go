@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// how long a created items table may take to become active
+const createTableTimeout = 2 * time.Minute
+
+// TableNotFoundError is returned for an items table which does not exist
+type TableNotFoundError struct {
+	Table string
+	Err   error
+}
+
+func (e *TableNotFoundError) Error() string {
+	return fmt.Sprintf("table %q does not exist, create it with the string partition key AdTitle, "+
+		"set CREATE_TABLE_IF_MISSING=true to have it created or list the existing tables in BOLHA_TABLE_NAMES", e.Table)
+}
+
+func (e *TableNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// tableError returns err as a TableNotFoundError if the table is missing
+func (m *monitor) tableError(err error) error {
+	var rnf *types.ResourceNotFoundException
+	if errors.As(err, &rnf) {
+		return &TableNotFoundError{Table: m.table, Err: err}
+	}
+	return err
+}
+
+// ensureTable checks that m.table exists before it is run, with
+// CREATE_TABLE_IF_MISSING a missing table is created and waited for
+func (m *monitor) ensureTable(ctx context.Context) error {
+	err := m.tableError(m.describeTable(ctx, m.table))
+	var tnf *TableNotFoundError
+	if !errors.As(err, &tnf) || !m.cfg.CreateTableIfMissing {
+		return err
+	}
+
+	return m.createTable(ctx)
+}
+
+// DYNAMODB
+
+// createTable creates m.table with the key of the items, billed on demand,
+// and waits until it is active
+func (m *monitor) createTable(ctx context.Context) error {
+	m.log.WithField("table", m.table).Warn("creating missing table...")
+
+	_, err := m.ddb.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(m.table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("AdTitle"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("AdTitle"), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	// another invocation may be creating it as well
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return fmt.Errorf("could not create table %q: %v", m.table, err)
+	}
+
+	w := dynamodb.NewTableExistsWaiter(m.ddb)
+	if err := w.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(m.table)}, createTableTimeout); err != nil {
+		return fmt.Errorf("table %q did not become active: %v", m.table, err)
+	}
+
+	m.log.WithField("table", m.table).Info("table created")
+
+	return nil
+}
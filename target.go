@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runTarget narrows a run to a single item of the event's table, the item
+// is run as in any other run unless Force reuploads it although it is not due
+type runTarget struct {
+	AdTitle string
+	Force   bool
+
+	// retry-failed runs these items per table instead, see retryfailed.go
+	Failed  map[string][]string
+	RetryOf string
+}
+
+// ItemNotFoundError is returned for a targeted item which does not exist
+type ItemNotFoundError struct {
+	Table   string
+	AdTitle string
+}
+
+func (e *ItemNotFoundError) Error() string {
+	return fmt.Sprintf("item %q not found in table %q", e.AdTitle, e.Table)
+}
+
+// getBolhaItem returns the item AdTitle of the table as the only item of
+// the slice, so it is run like the items of a whole table
+func (m *monitor) getBolhaItem(ctx context.Context, adTitle string) ([]BolhaItem, error) {
+	item, err := m.getRawItem(ctx, adTitle)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.bolhaItems([]map[string]types.AttributeValue{item})
+}
+
+// getRawItem returns the raw item AdTitle of the table
+func (m *monitor) getRawItem(ctx context.Context, adTitle string) (map[string]types.AttributeValue, error) {
+	m.log.WithFields(log.Fields{"table": m.table, "AdTitle": adTitle}).Info("getting bolha item...")
+
+	result, err := m.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(m.table),
+		Key:            map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, m.tableError(err)
+	}
+	if result.Item == nil {
+		return nil, &ItemNotFoundError{Table: m.table, AdTitle: adTitle}
+	}
+
+	return result.Item, nil
+}
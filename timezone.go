@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// the lambda runtime has no zoneinfo
+	_ "time/tzdata"
+)
+
+// layouts of times without a zone, they are read in DISPLAY_TIMEZONE
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// layout of times rendered for humans
+const displayLayout = "2006-01-02 15:04 MST"
+
+// storedTime formats t for the tables and state, always in UTC so stored
+// times do not depend on the zone the function runs in
+func storedTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseLocalTime parses an RFC3339 time or a time without a zone in loc,
+// UTC if loc is nil. Local times are converted by the zone rules of their
+// date, so they keep their wall clock across DST changes. A time skipped by
+// the change to summer time is moved an hour later, one repeated by the
+// change to winter time is read in winter time.
+func parseLocalTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339 or a local time like 2006-01-02T15:04", s)
+}
+
+// displayTime formats t in DISPLAY_TIMEZONE, empty for the zero time
+func (c *Config) displayTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(c.location()).Format(displayLayout)
+}
+
+// displayStored formats a stored RFC3339 time in DISPLAY_TIMEZONE, other
+// strings are returned as they are
+func (c *Config) displayStored(s string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return c.displayTime(t)
+}
+
+// displayRFC3339 formats t as RFC3339 in the zone of bItem, for reports
+// which are read by humans and programs
+func (bItem *BolhaItem) displayRFC3339(t time.Time) string {
+	if bItem.loc != nil {
+		t = t.In(bItem.loc)
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (c *Config) location() *time.Location {
+	if c.DisplayLocation == nil {
+		return time.UTC
+	}
+	return c.DisplayLocation
+}
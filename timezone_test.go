@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// summer time in Ljubljana starts 2026-03-29 at 02:00 and ends 2026-10-25
+// at 03:00
+func ljubljana(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Ljubljana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseLocalTimeDST(t *testing.T) {
+	loc := ljubljana(t)
+
+	tests := []struct {
+		name string
+		s    string
+		loc  *time.Location
+		want string
+	}{
+		{"before summer time", "2026-03-29T01:30", loc, "2026-03-29T00:30:00Z"},
+		{"skipped by summer time", "2026-03-29T02:30", loc, "2026-03-29T01:30:00Z"},
+		{"summer time", "2026-03-29 03:30", loc, "2026-03-29T01:30:00Z"},
+		{"summer time before the change back", "2026-10-25T01:30:00", loc, "2026-10-24T23:30:00Z"},
+		{"repeated by winter time", "2026-10-25T02:30", loc, "2026-10-25T01:30:00Z"},
+		{"winter time", "2026-10-25 03:30:00", loc, "2026-10-25T02:30:00Z"},
+		{"rfc3339 keeps its zone", "2026-03-29T02:30:00+02:00", loc, "2026-03-29T00:30:00Z"},
+		{"no zone", "2026-03-29T02:30", nil, "2026-03-29T02:30:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLocalTime(tt.s, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storedTime(got) != tt.want {
+				t.Errorf("%s is %s, want %s", tt.s, storedTime(got), tt.want)
+			}
+		})
+	}
+
+	if _, err := parseLocalTime("29.3.2026 02:30", loc); err == nil {
+		t.Error("invalid time parsed")
+	}
+}
+
+func TestDisplayTimeDST(t *testing.T) {
+	cfg := &Config{DisplayLocation: ljubljana(t)}
+
+	tests := []struct {
+		stored string
+		want   string
+	}{
+		{"2026-03-29T00:59:00Z", "2026-03-29 01:59 CET"},
+		{"2026-03-29T01:00:00Z", "2026-03-29 03:00 CEST"},
+		{"2026-10-25T00:30:00Z", "2026-10-25 02:30 CEST"},
+		{"2026-10-25T01:30:00Z", "2026-10-25 02:30 CET"},
+		{"not a time", "not a time"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := cfg.displayStored(tt.stored); got != tt.want {
+			t.Errorf("%q displayed %q, want %q", tt.stored, got, tt.want)
+		}
+	}
+
+	if got := (&Config{}).displayStored("2026-03-29T01:00:00Z"); got != "2026-03-29 01:00 UTC" {
+		t.Errorf("displayed %q without a zone, want UTC", got)
+	}
+}
+
+// The reupload interval counts absolute hours, so across the change to
+// winter time the wall clock of the next reupload moves an hour back
+func TestNextEligibleAtDST(t *testing.T) {
+	bItem := &BolhaItem{
+		AdTitle:       "Gorsko kolo",
+		AdUploadedId:  1000,
+		AdUploadedAt:  "2026-10-24T10:00:00Z",
+		ReuploadHours: 24,
+		ReuploadOrder: 30,
+		loc:           ljubljana(t),
+	}
+
+	if got, want := bItem.nextEligibleAt(time.Date(2026, 10, 24, 12, 0, 0, 0, time.UTC)), "2026-10-25T11:00:00+01:00"; got != want {
+		t.Errorf("next eligible at %s, want %s", got, want)
+	}
+}
+
+// A local PublishAt on the day of a DST change publishes the ad at the
+// instant of its wall clock in DISPLAY_TIMEZONE
+func TestScenarioPublishAtDST(t *testing.T) {
+	const title = "Gorsko kolo"
+
+	tests := []struct {
+		name      string
+		publishAt string
+		// the publish time as reported
+		want string
+	}{
+		{"skipped by summer time", "2026-03-29T02:30", "2026-03-29T03:30:00+02:00"},
+		{"repeated by winter time", "2026-10-25T02:30", "2026-10-25T02:30:00+01:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DISPLAY_TIMEZONE", "Europe/Ljubljana")
+			publishAt, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := newScenario(t, "new")
+			s.update(title, map[string]interface{}{"PublishAt": tt.publishAt})
+
+			s.clock.Set(publishAt.Add(-time.Minute))
+			report, err := s.runEvent(context.Background(), s.services(), "{}")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir := itemReport(t, report, title)
+			if ir.SkipReason != SkipScheduled {
+				t.Fatalf("a minute early: skip reason %q (status %q), want %q", ir.SkipReason, ir.Status, SkipScheduled)
+			}
+			if ir.NextEligibleAt != tt.want {
+				t.Errorf("next eligible at %s, want %s", ir.NextEligibleAt, tt.want)
+			}
+
+			s.clock.Set(publishAt)
+			report, err = s.runEvent(context.Background(), s.services(), "{}")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ir := itemReport(t, report, title); ir.Status != statusUploaded {
+				t.Errorf("at the publish time: status %q, want %q", ir.Status, statusUploaded)
+			}
+		})
+	}
+}
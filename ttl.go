@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// setExpiry reads the ttl attribute (epoch seconds) of the raw items into
+// their bolha items, dynamodb deletes expired items up to two days late
+func (m *monitor) setExpiry(bItems []BolhaItem, items []map[string]types.AttributeValue, attr string) {
+	if attr == "" {
+		return
+	}
+
+	for i, item := range items {
+		av, ok := item[attr].(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+		sec, err := strconv.ParseInt(av.Value, 10, 64)
+		if err != nil {
+			m.log.WithFields(log.Fields{"AdTitle": bItems[i].AdTitle, "attribute": attr}).WithError(err).Warn("ignoring invalid ttl")
+			continue
+		}
+		bItems[i].expiresAt = time.Unix(sec, 0)
+	}
+}
+
+// expired reports whether the ttl of bItem has passed
+func (bItem *BolhaItem) expired(now time.Time) bool {
+	return !bItem.expiresAt.IsZero() && !bItem.expiresAt.After(now)
+}
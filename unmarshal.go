@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// timestamp attributes of items, stored as RFC3339 strings
+var timestampAttributes = map[string]bool{
+	"AdUploadedAt":         true,
+	"AdUploadedRecordedAt": true,
+	"LastCheckedAt":        true,
+	"LastSelectedAt":       true,
+	"PublishAt":            true,
+	"ReuploadPhaseAt":      true,
+}
+
+// timestampLayouts are the ISO 8601 variants a timestamp is coerced from
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// itemFieldKinds maps the attributes of items to the kind of their field
+var itemFieldKinds = func() map[string]reflect.Kind {
+	kinds := make(map[string]reflect.Kind)
+	t := reflect.TypeOf(BolhaItem{})
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() {
+			kinds[f.Name] = f.Type.Kind()
+		}
+	}
+	return kinds
+}()
+
+// unmarshalItem unmarshals a raw item of the table. An item which does not
+// unmarshal is retried once with its attributes coerced, an item which still
+// does not is returned with only its title and the error set so it is
+// reported as invalid instead of failing the whole run. With STRICT_MODE a
+// coerced item is invalid as well, see strict.go.
+func (m *monitor) unmarshalItem(item map[string]types.AttributeValue) BolhaItem {
+	var bItem BolhaItem
+	err := attributevalue.UnmarshalMap(item, &bItem)
+	if err != nil {
+		coerced, changed := coerceItem(item)
+		if len(changed) > 0 {
+			bItem = BolhaItem{}
+			if err = attributevalue.UnmarshalMap(coerced, &bItem); err == nil {
+				bItem.coerced = changed
+				m.log.WithFields(log.Fields{"table": m.table, "key": itemKey(item), "attributes": changed}).Warn("coerced item attributes")
+			}
+		}
+	}
+	if err != nil {
+		m.log.WithFields(log.Fields{"table": m.table, "key": itemKey(item)}).WithError(err).Error("could not unmarshal item")
+		bItem = BolhaItem{unmarshalErr: fmt.Errorf("could not unmarshal item %s: %v", itemKey(item), err)}
+		if s, ok := item["AdTitle"].(*types.AttributeValueMemberS); ok {
+			bItem.AdTitle = s.Value
+		}
+	}
+	bItem.table = m.table
+	bItem.adsPerPage = m.cfg.AdsPerPage
+	bItem.loc = m.cfg.DisplayLocation
+
+	return bItem
+}
+
+// coerceItem returns a copy of item with numeric strings of number
+// attributes, boolean strings of bool attributes and epoch seconds or ISO
+// 8601 variants of timestamp attributes converted, and the names of the
+// attributes it converted
+func coerceItem(item map[string]types.AttributeValue) (map[string]types.AttributeValue, []string) {
+	coerced := make(map[string]types.AttributeValue, len(item))
+	changed := make([]string, 0)
+	for name, av := range item {
+		coerced[name] = av
+		if c, ok := coerceAttribute(name, av); ok {
+			coerced[name] = c
+			changed = append(changed, name)
+		}
+	}
+
+	return coerced, changed
+}
+
+func coerceAttribute(name string, av types.AttributeValue) (types.AttributeValue, bool) {
+	if timestampAttributes[name] {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberN:
+			sec, err := strconv.ParseInt(v.Value, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			return &types.AttributeValueMemberS{Value: time.Unix(sec, 0).UTC().Format(time.RFC3339)}, true
+		case *types.AttributeValueMemberS:
+			if _, err := time.Parse(time.RFC3339, v.Value); err == nil {
+				return nil, false
+			}
+			for _, layout := range timestampLayouts[1:] {
+				if t, err := time.ParseInLocation(layout, strings.TrimSpace(v.Value), time.UTC); err == nil {
+					return &types.AttributeValueMemberS{Value: t.Format(time.RFC3339)}, true
+				}
+			}
+		}
+		return nil, false
+	}
+
+	s, ok := av.(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, false
+	}
+	v := strings.TrimSpace(s.Value)
+
+	switch itemFieldKinds[name] {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return &types.AttributeValueMemberN{Value: v}, true
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return &types.AttributeValueMemberBOOL{Value: b}, true
+		}
+	}
+
+	return nil, false
+}
+
+// itemKey describes the key attributes of a raw item for errors and logs
+func itemKey(item map[string]types.AttributeValue) string {
+	if s, ok := item["AdTitle"].(*types.AttributeValueMemberS); ok {
+		return fmt.Sprintf("AdTitle=%q", s.Value)
+	}
+	return "without AdTitle"
+}
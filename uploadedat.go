@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// A hand edited AdUploadedAt in the future keeps an item from ever getting
+// old enough to be reuploaded. Upload times after now plus
+// UPLOADED_AT_TOLERANCE or before UPLOADED_AT_EPOCH are flagged and the
+// upload time is treated as unknown, which makes the ad due by age. With
+// STRICT_MODE the item is invalid instead.
+
+// defaultUploadedAtEpoch is before any ad the monitor uploaded
+var defaultUploadedAtEpoch = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// checkUploadedAt flags an implausible AdUploadedAt of bItem at now
+func (bItem *BolhaItem) checkUploadedAt(now time.Time, tolerance time.Duration, epoch time.Time) {
+	bItem.uploadedAtAnomaly = ""
+	if bItem.AdUploadedId == 0 || bItem.AdUploadedAt == "" {
+		return
+	}
+	uploadedAt, err := time.Parse(time.RFC3339, bItem.AdUploadedAt)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case uploadedAt.After(now.Add(tolerance)):
+		bItem.uploadedAtAnomaly = fmt.Sprintf("AdUploadedAt %s is %s in the future", bItem.AdUploadedAt, uploadedAt.Sub(now).Round(time.Minute))
+	case uploadedAt.Before(epoch):
+		bItem.uploadedAtAnomaly = fmt.Sprintf("AdUploadedAt %s is before %s", bItem.AdUploadedAt, epoch.Format(time.RFC3339))
+	default:
+		return
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	client "github.com/seniorescobar/bolha-client"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/decision"
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// acceptingClient notes the time of the clock when bolha accepted an upload
+type acceptingClient struct {
+	*harness.Client
+	clock *harness.Clock
+
+	acceptedAt time.Time
+}
+
+func (c *acceptingClient) UploadAd(ad *client.Ad) (int64, error) {
+	id, err := c.Client.UploadAd(ad)
+	if err == nil {
+		c.acceptedAt = c.clock.Now()
+	}
+	return id, err
+}
+
+// slowStore takes delay of the clock for every update, like a throttled
+// table retrying its writes
+type slowStore struct {
+	*harness.Store
+	clock *harness.Clock
+	delay time.Duration
+}
+
+func (s *slowStore) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	s.clock.Advance(s.delay)
+	return s.Store.UpdateItem(ctx, params, optFns...)
+}
+
+// AdUploadedAt is when bolha accepted the upload however long recording it
+// takes, AdUploadedRecordedAt when it was written
+func TestUploadRecordsAcceptedTime(t *testing.T) {
+	const title = "Gorsko kolo"
+	s := newScenario(t, "sinking", harness.Step{Orders: map[int64]int{1000: 40}})
+
+	ac := &acceptingClient{Client: s.client, clock: s.clock}
+	svc := s.services()
+	svc.ddb = &slowStore{Store: s.store, clock: s.clock, delay: 5 * time.Minute}
+	svc.dialBolha = func(creds *client.User, sessionId string) (adClient, error) {
+		return ac, nil
+	}
+
+	report, err := s.runEvent(context.Background(), svc, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir := itemReport(t, report, title); ir.Status != statusReuploaded {
+		t.Fatalf("status %q, want %q", ir.Status, statusReuploaded)
+	}
+	if ac.acceptedAt.IsZero() {
+		t.Fatal("no upload accepted")
+	}
+
+	it := s.item(title)
+	if at := it["AdUploadedAt"]; at != storedTime(ac.acceptedAt) {
+		t.Errorf("AdUploadedAt = %v, want %s when bolha accepted it", at, storedTime(ac.acceptedAt))
+	}
+	recordedAt, err := time.Parse(time.RFC3339, it["AdUploadedRecordedAt"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recordedAt.After(ac.acceptedAt) {
+		t.Errorf("AdUploadedRecordedAt = %s, want after the acceptance at %s", recordedAt, ac.acceptedAt)
+	}
+}
+
+// The age of an ad runs from AdUploadedAt, AdUploadedRecordedAt is ignored
+func TestDecisionUsesUploadedAt(t *testing.T) {
+	accepted := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	bItem := &BolhaItem{
+		AdTitle:              "Gorsko kolo",
+		AdUploadedId:         1000,
+		AdUploadedAt:         storedTime(accepted),
+		AdUploadedRecordedAt: storedTime(accepted.Add(10 * time.Minute)),
+		ReuploadHours:        1,
+		ReuploadOrder:        30,
+	}
+
+	item, err := bItem.decisionItem("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.UploadedAt.Equal(accepted) {
+		t.Fatalf("UploadedAt = %s, want AdUploadedAt %s", item.UploadedAt, accepted)
+	}
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		action decision.Action
+	}{
+		{"hour since recorded, not since accepted", accepted.Add(55 * time.Minute), decision.Keep},
+		{"hour since accepted, not since recorded", accepted.Add(65 * time.Minute), decision.Reupload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := decision.Evaluate(item, &decision.Observed{Order: 5}, tt.now, decision.Config{})
+			if d.Action != tt.action {
+				t.Errorf("action %v, want %v", d.Action, tt.action)
+			}
+			if d.Inputs.Age != tt.now.Sub(accepted) {
+				t.Errorf("age %s, want %s since the acceptance", d.Inputs.Age, tt.now.Sub(accepted))
+			}
+		})
+	}
+}
+
+// ForceReuploadAfterHours before ReuploadHours brings the next eligible time
+// forward, and an ad close to it gets a live check however fresh its order
+func TestForceReuploadDueAt(t *testing.T) {
+	uploadedAt := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	now := uploadedAt.Add(71 * time.Hour)
+	bItem := &BolhaItem{
+		AdTitle:                 "Gorsko kolo",
+		AdUploadedId:            1000,
+		AdUploadedAt:            storedTime(uploadedAt),
+		ReuploadHours:           168,
+		ReuploadOrder:           30,
+		ForceReuploadAfterHours: 72,
+		LastCheckedAt:           storedTime(now.Add(-5 * time.Minute)),
+		LastObservedOrder:       40,
+	}
+
+	if got, want := bItem.nextEligibleAt(now), "2026-10-04T08:00:00Z"; got != want {
+		t.Errorf("next eligible at %s, want %s", got, want)
+	}
+
+	item, err := bItem.decisionItem("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order, ok := bItem.cachedOrder(now, item, 2*time.Hour); ok {
+		t.Errorf("cached order %d an hour before ForceReuploadAfterHours", order)
+	}
+	if order, ok := bItem.cachedOrder(now, item, 30*time.Minute); !ok || order != 40 {
+		t.Errorf("cached order %d, %v, want 40 within the freshness", order, ok)
+	}
+}
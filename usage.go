@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bolha calls counted by usageCounts
+const (
+	bolhaCallLogin        = "Login"
+	bolhaCallGetActiveAd  = "GetActiveAd"
+	bolhaCallGetActiveAds = "GetActiveAds"
+	bolhaCallUploadAd     = "UploadAd"
+	bolhaCallRemoveAd     = "RemoveAd"
+)
+
+// Usage is a rough estimate of what an invocation cost
+type Usage struct {
+	// capacity units dynamodb reports, failed attempts consume none
+	DynamoDBReadUnits  float64 `json:"dynamoDBReadUnits"`
+	DynamoDBWriteUnits float64 `json:"dynamoDBWriteUnits"`
+	// every attempt, retries included
+	DynamoDBRequests int `json:"dynamoDBRequests"`
+
+	// every attempt of GetObject and the bytes of the objects received
+	S3Gets     int   `json:"s3Gets"`
+	S3GetBytes int64 `json:"s3GetBytes"`
+
+	// bolha calls by type, logins included
+	BolhaCalls map[string]int `json:"bolhaCalls"`
+
+	// duration times the configured memory
+	LambdaGBSeconds float64 `json:"lambdaGBSeconds"`
+}
+
+// add adds u2 to u, continued runs add up the usage of their invocations
+func (u *Usage) add(u2 *Usage) {
+	if u2 == nil {
+		return
+	}
+
+	u.DynamoDBReadUnits += u2.DynamoDBReadUnits
+	u.DynamoDBWriteUnits += u2.DynamoDBWriteUnits
+	u.DynamoDBRequests += u2.DynamoDBRequests
+	u.S3Gets += u2.S3Gets
+	u.S3GetBytes += u2.S3GetBytes
+	u.LambdaGBSeconds += u2.LambdaGBSeconds
+	if u.BolhaCalls == nil {
+		u.BolhaCalls = make(map[string]int)
+	}
+	for call, n := range u2.BolhaCalls {
+		u.BolhaCalls[call] += n
+	}
+}
+
+// usageCounts collects the usage of an invocation
+type usageCounts struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+func newUsageCounts() *usageCounts {
+	return &usageCounts{usage: Usage{BolhaCalls: make(map[string]int)}}
+}
+
+func (uc *usageCounts) addBolha(call string) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	uc.usage.BolhaCalls[call]++
+}
+
+// summary returns the usage so far, the lambda cost is estimated from
+// duration and AWS_LAMBDA_FUNCTION_MEMORY_SIZE
+func (uc *usageCounts) summary(duration time.Duration) *Usage {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	u := uc.usage
+	u.BolhaCalls = make(map[string]int, len(uc.usage.BolhaCalls))
+	for call, n := range uc.usage.BolhaCalls {
+		u.BolhaCalls[call] = n
+	}
+	if mb, err := strconv.Atoi(os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE")); err == nil {
+		u.LambdaGBSeconds = duration.Seconds() * float64(mb) / 1024
+	}
+
+	return &u
+}
+
+// record writes the usage to m
+func (u *Usage) record(m *metricSet) {
+	m.put("DynamoDBReadUnits", u.DynamoDBReadUnits, unitCount)
+	m.put("DynamoDBWriteUnits", u.DynamoDBWriteUnits, unitCount)
+	m.put("DynamoDBRequests", float64(u.DynamoDBRequests), unitCount)
+	m.put("S3Gets", float64(u.S3Gets), unitCount)
+	m.put("S3GetBytes", float64(u.S3GetBytes), unitBytes)
+	m.put("LambdaGBSeconds", u.LambdaGBSeconds, unitCount)
+
+	calls := make([]string, 0, len(u.BolhaCalls))
+	for call := range u.BolhaCalls {
+		calls = append(calls, call)
+	}
+	sort.Strings(calls)
+	for _, call := range calls {
+		m.put("BolhaCalls/"+call, float64(u.BolhaCalls[call]), unitCount)
+	}
+}
+
+func (u *Usage) log(logger *log.Entry) {
+	logger.WithFields(log.Fields{
+		"dynamoDBReadUnits":  u.DynamoDBReadUnits,
+		"dynamoDBWriteUnits": u.DynamoDBWriteUnits,
+		"dynamoDBRequests":   u.DynamoDBRequests,
+		"s3Gets":             u.S3Gets,
+		"s3GetBytes":         u.S3GetBytes,
+		"bolhaCalls":         u.BolhaCalls,
+		"lambdaGBSeconds":    u.LambdaGBSeconds,
+	}).Info("usage")
+}
+
+// MIDDLEWARE
+
+// apiOptions returns the middlewares counting the usage of all aws clients
+func (uc *usageCounts) apiOptions() []func(*middleware.Stack) error {
+	return []func(*middleware.Stack) error{
+		func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("UsageConsumedCapacity", uc.consumedCapacity), middleware.After)
+		},
+		func(stack *middleware.Stack) error {
+			// after the retry middleware every attempt passes
+			mw := middleware.FinalizeMiddlewareFunc("UsageAttempts", uc.attempt)
+			if err := stack.Finalize.Insert(mw, "Retry", middleware.After); err != nil {
+				return stack.Finalize.Add(mw, middleware.After)
+			}
+			return nil
+		},
+	}
+}
+
+// consumedCapacity asks dynamodb for the capacity a call consumes and sums it
+func (uc *usageCounts) consumedCapacity(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	switch p := in.Parameters.(type) {
+	case *dynamodb.GetItemInput:
+		p.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.ScanInput:
+		p.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.PutItemInput:
+		p.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.UpdateItemInput:
+		p.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.BatchWriteItemInput:
+		p.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+
+	out, md, err := next.HandleInitialize(ctx, in)
+	if err != nil {
+		return out, md, err
+	}
+
+	var read, write float64
+	switch r := out.Result.(type) {
+	case *dynamodb.GetItemOutput:
+		read = capacityUnits(r.ConsumedCapacity)
+	case *dynamodb.ScanOutput:
+		read = capacityUnits(r.ConsumedCapacity)
+	case *dynamodb.PutItemOutput:
+		write = capacityUnits(r.ConsumedCapacity)
+	case *dynamodb.UpdateItemOutput:
+		write = capacityUnits(r.ConsumedCapacity)
+	case *dynamodb.BatchWriteItemOutput:
+		for i := range r.ConsumedCapacity {
+			write += capacityUnits(&r.ConsumedCapacity[i])
+		}
+	default:
+		return out, md, err
+	}
+
+	uc.mu.Lock()
+	uc.usage.DynamoDBReadUnits += read
+	uc.usage.DynamoDBWriteUnits += write
+	uc.mu.Unlock()
+
+	return out, md, err
+}
+
+// attempt counts a single attempt of a dynamodb call or of an s3 GetObject
+func (uc *usageCounts) attempt(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	service, op := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+
+	out, md, err := next.HandleFinalize(ctx, in)
+
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	switch {
+	case service == dynamodb.ServiceID:
+		uc.usage.DynamoDBRequests++
+	case service == s3.ServiceID && op == "GetObject":
+		uc.usage.S3Gets++
+		if r, ok := out.Result.(*s3.GetObjectOutput); ok && err == nil {
+			uc.usage.S3GetBytes += aws.ToInt64(r.ContentLength)
+		}
+	}
+
+	return out, md, err
+}
+
+func capacityUnits(cc *types.ConsumedCapacity) float64 {
+	if cc == nil {
+		return 0
+	}
+	return aws.ToFloat64(cc.CapacityUnits)
+}
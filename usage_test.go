@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Continued runs add up the usage of their invocations
+func TestUsageAdd(t *testing.T) {
+	u := &Usage{DynamoDBReadUnits: 1.5, DynamoDBRequests: 2}
+	u.add(nil)
+	if want := (&Usage{DynamoDBReadUnits: 1.5, DynamoDBRequests: 2}); !reflect.DeepEqual(u, want) {
+		t.Fatalf("usage %+v after adding nil, want %+v", u, want)
+	}
+
+	u.add(&Usage{
+		DynamoDBReadUnits:  0.5,
+		DynamoDBWriteUnits: 3,
+		DynamoDBRequests:   4,
+		S3Gets:             2,
+		S3GetBytes:         1024,
+		BolhaCalls:         map[string]int{bolhaCallLogin: 1, bolhaCallGetActiveAd: 3},
+		LambdaGBSeconds:    0.25,
+	})
+	u.add(&Usage{
+		DynamoDBRequests: 1,
+		S3Gets:           1,
+		S3GetBytes:       512,
+		BolhaCalls:       map[string]int{bolhaCallGetActiveAd: 2, bolhaCallUploadAd: 1},
+		LambdaGBSeconds:  0.5,
+	})
+
+	want := &Usage{
+		DynamoDBReadUnits:  2,
+		DynamoDBWriteUnits: 3,
+		DynamoDBRequests:   7,
+		S3Gets:             3,
+		S3GetBytes:         1536,
+		BolhaCalls:         map[string]int{bolhaCallLogin: 1, bolhaCallGetActiveAd: 5, bolhaCallUploadAd: 1},
+		LambdaGBSeconds:    0.75,
+	}
+	if !reflect.DeepEqual(u, want) {
+		t.Errorf("usage %+v, want %+v", u, want)
+	}
+}
+
+func TestUsageCountsSummary(t *testing.T) {
+	t.Setenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE", "512")
+	uc := newUsageCounts()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				uc.addBolha(bolhaCallGetActiveAd)
+			}
+			uc.addBolha(bolhaCallLogin)
+		}()
+	}
+	wg.Wait()
+
+	u := uc.summary(4 * time.Second)
+	if want := map[string]int{bolhaCallGetActiveAd: 100, bolhaCallLogin: 10}; !reflect.DeepEqual(u.BolhaCalls, want) {
+		t.Errorf("bolha calls %v, want %v", u.BolhaCalls, want)
+	}
+	if u.LambdaGBSeconds != 2 {
+		t.Errorf("%v GB-seconds, want 2 for 4s at 512 MB", u.LambdaGBSeconds)
+	}
+
+	// the summary is a copy, a continued run adding to it leaves the counts
+	u.add(&Usage{BolhaCalls: map[string]int{bolhaCallLogin: 5}})
+	if n := uc.summary(0).BolhaCalls[bolhaCallLogin]; n != 10 {
+		t.Errorf("%d logins counted after changing the summary, want 10", n)
+	}
+
+	t.Setenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE", "")
+	if gbs := uc.summary(time.Second).LambdaGBSeconds; gbs != 0 {
+		t.Errorf("%v GB-seconds without the memory size, want 0", gbs)
+	}
+}
+
+// The capacity dynamodb reports is summed by read and write, failed calls
+// consume none
+func TestUsageConsumedCapacity(t *testing.T) {
+	units := func(u float64) *types.ConsumedCapacity {
+		return &types.ConsumedCapacity{CapacityUnits: aws.Float64(u)}
+	}
+	tests := []struct {
+		name   string
+		params interface{}
+		result interface{}
+		err    error
+		read   float64
+		write  float64
+	}{
+		{"get", &dynamodb.GetItemInput{}, &dynamodb.GetItemOutput{ConsumedCapacity: units(0.5)}, nil, 0.5, 0},
+		{"scan", &dynamodb.ScanInput{}, &dynamodb.ScanOutput{ConsumedCapacity: units(4)}, nil, 4, 0},
+		{"put", &dynamodb.PutItemInput{}, &dynamodb.PutItemOutput{ConsumedCapacity: units(1)}, nil, 0, 1},
+		{"update", &dynamodb.UpdateItemInput{}, &dynamodb.UpdateItemOutput{ConsumedCapacity: units(2)}, nil, 0, 2},
+		{"batch write", &dynamodb.BatchWriteItemInput{}, &dynamodb.BatchWriteItemOutput{ConsumedCapacity: []types.ConsumedCapacity{*units(1), *units(3)}}, nil, 0, 4},
+		{"not reported", &dynamodb.GetItemInput{}, &dynamodb.GetItemOutput{}, nil, 0, 0},
+		{"failed", &dynamodb.PutItemInput{}, &dynamodb.PutItemOutput{ConsumedCapacity: units(1)}, errors.New("throttled"), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := newUsageCounts()
+			next := middleware.InitializeHandlerFunc(func(ctx context.Context, in middleware.InitializeInput) (middleware.InitializeOutput, middleware.Metadata, error) {
+				if rcc := reflect.ValueOf(in.Parameters).Elem().FieldByName("ReturnConsumedCapacity"); rcc.Interface() != types.ReturnConsumedCapacityTotal {
+					t.Errorf("ReturnConsumedCapacity %v, want %v", rcc, types.ReturnConsumedCapacityTotal)
+				}
+				return middleware.InitializeOutput{Result: tt.result}, middleware.Metadata{}, tt.err
+			})
+
+			uc.consumedCapacity(context.Background(), middleware.InitializeInput{Parameters: tt.params}, next)
+
+			u := uc.summary(0)
+			if u.DynamoDBReadUnits != tt.read || u.DynamoDBWriteUnits != tt.write {
+				t.Errorf("%v read and %v write units, want %v and %v", u.DynamoDBReadUnits, u.DynamoDBWriteUnits, tt.read, tt.write)
+			}
+		})
+	}
+}
+
+// Every attempt of dynamodb and of s3 GetObject is counted, the bytes only
+// of the objects received
+func TestUsageAttempts(t *testing.T) {
+	uc := newUsageCounts()
+	attempt := func(service, op string, result interface{}, err error) {
+		ctx := awsmiddleware.SetServiceID(context.Background(), service)
+		ctx = awsmiddleware.SetOperationName(ctx, op)
+		next := middleware.FinalizeHandlerFunc(func(ctx context.Context, in middleware.FinalizeInput) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			return middleware.FinalizeOutput{Result: result}, middleware.Metadata{}, err
+		})
+		uc.attempt(ctx, middleware.FinalizeInput{}, next)
+	}
+
+	throttled := errors.New("throttled")
+	attempt(dynamodb.ServiceID, "Scan", nil, throttled)
+	attempt(dynamodb.ServiceID, "Scan", &dynamodb.ScanOutput{}, nil)
+	attempt(dynamodb.ServiceID, "UpdateItem", &dynamodb.UpdateItemOutput{}, nil)
+	attempt(s3.ServiceID, "GetObject", nil, throttled)
+	attempt(s3.ServiceID, "GetObject", &s3.GetObjectOutput{ContentLength: aws.Int64(2048)}, nil)
+	attempt(s3.ServiceID, "GetObject", &s3.GetObjectOutput{ContentLength: aws.Int64(100)}, nil)
+	attempt(s3.ServiceID, "ListObjectsV2", &s3.ListObjectsV2Output{}, nil)
+	attempt(s3.ServiceID, "PutObject", &s3.PutObjectOutput{}, nil)
+
+	u := uc.summary(0)
+	if u.DynamoDBRequests != 3 {
+		t.Errorf("%d dynamodb requests, want 3 with the retry", u.DynamoDBRequests)
+	}
+	if u.S3Gets != 3 {
+		t.Errorf("%d s3 gets, want 3 with the retry", u.S3Gets)
+	}
+	if u.S3GetBytes != 2148 {
+		t.Errorf("%d s3 bytes, want 2148", u.S3GetBytes)
+	}
+}
+
+func TestUsageRecord(t *testing.T) {
+	u := &Usage{
+		DynamoDBReadUnits:  2.5,
+		DynamoDBWriteUnits: 3,
+		DynamoDBRequests:   7,
+		S3Gets:             3,
+		S3GetBytes:         1536,
+		BolhaCalls:         map[string]int{bolhaCallLogin: 1, bolhaCallGetActiveAd: 5},
+		LambdaGBSeconds:    0.75,
+	}
+	m := newMetricSet()
+	u.record(m)
+
+	want := map[string]float64{
+		"DynamoDBReadUnits":      2.5,
+		"DynamoDBWriteUnits":     3,
+		"DynamoDBRequests":       7,
+		"S3Gets":                 3,
+		"S3GetBytes":             1536,
+		"LambdaGBSeconds":        0.75,
+		"BolhaCalls/Login":       1,
+		"BolhaCalls/GetActiveAd": 5,
+	}
+	if !reflect.DeepEqual(m.values, want) {
+		t.Errorf("metrics %v, want %v", m.values, want)
+	}
+	if unit := m.units["S3GetBytes"]; unit != unitBytes {
+		t.Errorf("S3GetBytes in %q, want %q", unit, unitBytes)
+	}
+}
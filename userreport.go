@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// OutcomeCounts counts the outcomes of the items of a run or of a user
+type OutcomeCounts struct {
+	Items int `json:"items"`
+	// items checked or changed in the run, i.e. not skipped for a reason
+	// other than not being due
+	Processed  int                `json:"processed"`
+	Uploaded   int                `json:"uploaded"`
+	Reuploaded int                `json:"reuploaded"`
+	Failed     int                `json:"failed"`
+	Skipped    map[SkipReason]int `json:"skipped,omitempty"`
+}
+
+func (c *OutcomeCounts) add(ir ItemReport) {
+	c.Items++
+	if ir.SkipReason == "" || ir.SkipReason == SkipNotDue {
+		c.Processed++
+	}
+	switch ir.Status {
+	case statusUploaded:
+		c.Uploaded++
+	case statusReuploaded:
+		c.Reuploaded++
+	}
+	if ir.Error != "" {
+		c.Failed++
+	}
+	if ir.SkipReason != "" {
+		if c.Skipped == nil {
+			c.Skipped = make(map[SkipReason]int)
+		}
+		c.Skipped[ir.SkipReason]++
+	}
+}
+
+// UserReport summarizes the items of a single user in a run
+type UserReport struct {
+	User string `json:"user"`
+	OutcomeCounts
+
+	// items with an active ad against the user's cap, no cap if 0
+	ActiveAds    int  `json:"activeAds"`
+	MaxActiveAds int  `json:"maxActiveAds,omitempty"`
+	SlotsLeft    *int `json:"slotsLeft,omitempty"`
+
+	// ok or the error of creating the user's bolha client, empty if the
+	// run needed none
+	Session string `json:"session,omitempty"`
+	// age of the user's stored session, aging past SESSION_WARN_AGE
+	SessionAge   string `json:"sessionAge,omitempty"`
+	SessionAging bool   `json:"sessionAging,omitempty"`
+
+	Outcomes []ItemOutcome `json:"outcomes"`
+}
+
+// ItemOutcome is the outcome of an item listed with its user
+type ItemOutcome struct {
+	AdTitle string `json:"adTitle"`
+	Table   string `json:"table"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// reportUser identifies the owner of bItem in reports and notifications,
+// unlike userKey it never contains a session id
+func (bItem *BolhaItem) reportUser() string {
+	if bItem.UserId != "" {
+		return bItem.UserId
+	}
+	sum := sha256.Sum256([]byte(bItem.UserSessionId))
+	return "session:" + hex.EncodeToString(sum[:4])
+}
+
+// userReport returns the summary of user, adding it if missing
+func (r *Report) userReport(user string) *UserReport {
+	for i := range r.Users {
+		if r.Users[i].User == user {
+			return &r.Users[i]
+		}
+	}
+	r.Users = append(r.Users, UserReport{User: user, Outcomes: make([]ItemOutcome, 0)})
+
+	return &r.Users[len(r.Users)-1]
+}
+
+// setUser records what is known of user beyond its items, a session
+// already known is kept if the run needed no client
+func (r *Report) setUser(user string, maxActiveAds int, session string) {
+	u := r.userReport(user)
+	u.MaxActiveAds = maxActiveAds
+	if session != "" {
+		u.Session = session
+	}
+}
+
+// summarize counts the items of the report, in total and per user, users
+// with failed items first
+func (r *Report) summarize() {
+	r.Totals = OutcomeCounts{}
+	for i := range r.Users {
+		u := &r.Users[i]
+		u.OutcomeCounts, u.ActiveAds, u.SlotsLeft = OutcomeCounts{}, 0, nil
+		u.Outcomes = make([]ItemOutcome, 0)
+	}
+
+	for _, ir := range r.Items {
+		r.Totals.add(ir)
+		// reports of older builds have no user
+		if ir.User == "" {
+			continue
+		}
+
+		u := r.userReport(ir.User)
+		u.add(ir)
+		if ir.AdUploadedId != 0 {
+			u.ActiveAds++
+		}
+		u.Outcomes = append(u.Outcomes, ItemOutcome{AdTitle: ir.AdTitle, Table: ir.Table, Status: ir.Status, Error: ir.Error})
+	}
+
+	for i := range r.Users {
+		u := &r.Users[i]
+		if u.MaxActiveAds > 0 {
+			left := u.MaxActiveAds - u.ActiveAds
+			if left < 0 {
+				left = 0
+			}
+			u.SlotsLeft = &left
+		}
+	}
+
+	sort.SliceStable(r.Users, func(i, j int) bool {
+		if r.Users[i].Failed != r.Users[j].Failed {
+			return r.Users[i].Failed > r.Users[j].Failed
+		}
+		return r.Users[i].User < r.Users[j].User
+	})
+}
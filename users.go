@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	client "github.com/seniorescobar/bolha-client"
+)
+
+// BolhaUser holds the account data shared by all items of a user
+type BolhaUser struct {
+	UserId string
+
+	// bolha session id, preferred over logging in with credentials
+	SessionId string
+	// when a run first saw SessionId, identified by SessionFingerprint,
+	// and when its age was notified, see session.go
+	SessionObtainedAt  string
+	SessionFingerprint string
+	SessionWarnedAt    string
+
+	// name of an ssm parameter holding {"username": "...", "password": "..."}
+	CredentialsRef string
+
+	// the same json encrypted with kms, preferred over CredentialsRef, see
+	// the encrypt-credentials action
+	UserCredentialsEncrypted []byte
+
+	DailyBudget int
+	Status      string
+
+	// maximum number of simultaneously active ads, MAX_ACTIVE_ADS if 0
+	MaxActiveAds int
+
+	// prefix relative images of the user's items are joined with
+	ImagePrefix string
+
+	// stops all automation of the user's items until cleared
+	Paused bool
+
+	// share of the time budget relative to the other users, 1 if 0, see
+	// fairshare.go
+	TimeWeight int
+
+	// ads whose removal failed, retried by every run until they are gone,
+	// see removals.go
+	PendingRemovals []PendingRemoval
+
+	// age of the session at the start of the run
+	sessionAge   time.Duration
+	sessionAging bool
+}
+
+// paused reports whether the items of user must be left alone, unknown
+// users (nil) are not paused
+func (user *BolhaUser) paused() bool {
+	return user != nil && user.Paused
+}
+
+// userClients creates at most one bolha client per user per run
+type userClients struct {
+	m       *monitor
+	mu      sync.Mutex
+	users   map[string]*BolhaUser
+	clients map[string]*bolhaClient
+
+	// ok or the error of creating the client by reportUser
+	sessions map[string]string
+}
+
+func (m *monitor) newUserClients(users map[string]*BolhaUser) *userClients {
+	return &userClients{
+		m:        m,
+		users:    users,
+		clients:  make(map[string]*bolhaClient),
+		sessions: make(map[string]string),
+	}
+}
+
+// get returns the client for the owner of bItem, items without a UserId
+// fall back to their own legacy UserSessionId
+func (uc *userClients) get(ctx context.Context, bItem *BolhaItem) (c *bolhaClient, err error) {
+	defer func() {
+		session := "ok"
+		if err != nil {
+			session = err.Error()
+		}
+		uc.mu.Lock()
+		uc.sessions[bItem.reportUser()] = session
+		uc.mu.Unlock()
+	}()
+
+	if bItem.UserId == "" {
+		return uc.m.newBolhaSessionClient(bItem.UserSessionId)
+	}
+
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	if c, ok := uc.clients[bItem.UserId]; ok {
+		return c, nil
+	}
+
+	user, ok := uc.users[bItem.UserId]
+	if !ok {
+		return nil, fmt.Errorf("unknown user %q", bItem.UserId)
+	}
+
+	c, err = uc.m.newUserClient(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	uc.clients[bItem.UserId] = c
+
+	return c, nil
+}
+
+// session returns ok or the error of the client of the owner of bItem,
+// empty if none was needed
+func (uc *userClients) session(bItem *BolhaItem) string {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	return uc.sessions[bItem.reportUser()]
+}
+
+func (m *monitor) newUserClient(ctx context.Context, user *BolhaUser) (*bolhaClient, error) {
+	if user.SessionId == "" {
+		return m.loginUser(ctx, user)
+	}
+
+	// an aging session is only used if logging in fails
+	if user.sessionAging && user.hasCredentials() {
+		c, err := m.loginUser(ctx, user)
+		if err == nil {
+			return c, nil
+		}
+		m.log.WithField("UserId", user.UserId).WithError(err).Warn("could not log in instead of aging session, using it")
+	}
+
+	return m.newBolhaSessionClient(user.SessionId)
+}
+
+// loginUser logs in with the credentials of user
+func (m *monitor) loginUser(ctx context.Context, user *BolhaUser) (*bolhaClient, error) {
+	var (
+		creds *client.User
+		err   error
+	)
+	switch {
+	case len(user.UserCredentialsEncrypted) > 0:
+		creds, err = m.decryptCredentials(ctx, user)
+	case user.CredentialsRef != "":
+		creds, err = m.getCredentials(ctx, user.CredentialsRef)
+	default:
+		return nil, fmt.Errorf("user %q has neither a session nor credentials", user.UserId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.log.WithField("UserId", user.UserId).Info("logging in...")
+
+	return m.newBolhaClient(ctx, creds)
+}
+
+// DYNAMODB
+
+// getUsers returns all users by id, an empty map if no users table is configured
+func (m *monitor) getUsers(ctx context.Context) (map[string]*BolhaUser, error) {
+	users := make(map[string]*BolhaUser)
+	if m.cfg.UsersTableName == "" {
+		return users, nil
+	}
+
+	m.log.Info("getting users...")
+
+	p := dynamodb.NewScanPaginator(m.ddb, &dynamodb.ScanInput{
+		TableName: aws.String(m.cfg.UsersTableName),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pageUsers := make([]*BolhaUser, 0, len(page.Items))
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageUsers); err != nil {
+			return nil, err
+		}
+		for _, u := range pageUsers {
+			users[u.UserId] = u
+		}
+	}
+
+	m.log.WithField("users", len(users)).Info("users")
+
+	return users, nil
+}
+
+// SSM
+
+func (m *monitor) getCredentials(ctx context.Context, ref string) (*client.User, error) {
+	result, err := m.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(ref),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal([]byte(aws.ToString(result.Parameter.Value)), &creds); err != nil {
+		return nil, fmt.Errorf("invalid credentials in %s: %v", ref, err)
+	}
+
+	return &client.User{Username: creds.Username, Password: creds.Password}, nil
+}
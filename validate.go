@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ruleRequired             = "required"
+	ruleCategory             = "category"
+	ruleMaxTitleLength       = "maxTitleLength"
+	ruleMaxDescriptionLength = "maxDescriptionLength"
+	ruleMinPrice             = "minPrice"
+	ruleMaxPrice             = "maxPrice"
+	ruleMaxImages            = "maxImages"
+	ruleImages               = "images"
+	ruleEmptyImages          = "emptyImages"
+	ruleDescription          = "description"
+	ruleCondition            = "condition"
+	ruleOverrides            = "overrides"
+	rulePublishAt            = "publishAt"
+	rulePriceType            = "priceType"
+	rulePrice                = "price"
+	ruleWebhook              = "webhook"
+	ruleRefreshStrategy      = "refreshStrategy"
+	ruleUnmarshal            = "unmarshal"
+	ruleReuploadPage         = "reuploadPage"
+	ruleAgeLimits            = "ageLimits"
+	ruleVideo                = "video"
+	ruleHooks                = "hooks"
+	ruleLanguage             = "language"
+)
+
+const (
+	conditionNew  = "new"
+	conditionUsed = "used"
+)
+
+// ValidationError lists every rule an item breaks
+type ValidationError struct {
+	AdTitle    string      `json:"adTitle"`
+	Violations []Violation `json:"violations"`
+}
+
+// Violation is a single broken validation rule
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message
+	}
+
+	return fmt.Sprintf("invalid item %q: %s", e.AdTitle, strings.Join(msgs, "; "))
+}
+
+// ValidationRules are the limits bolha enforces on ads, zero values mean no limit
+type ValidationRules struct {
+	MaxTitleLength       int `json:"maxTitleLength,omitempty"`
+	MaxDescriptionLength int `json:"maxDescriptionLength,omitempty"`
+	MinPrice             int `json:"minPrice,omitempty"`
+	MaxPrice             int `json:"maxPrice,omitempty"`
+	MaxImages            int `json:"maxImages,omitempty"`
+
+	// per category overrides of the rules above
+	Categories map[int]ValidationRules `json:"categories,omitempty"`
+}
+
+var defaultValidationRules = ValidationRules{
+	MaxTitleLength:       60,
+	MaxDescriptionLength: 5000,
+	MinPrice:             0,
+	MaxPrice:             10000000,
+	MaxImages:            10,
+}
+
+// forCategory returns the rules with the overrides of category id applied
+func (vr *ValidationRules) forCategory(id int) ValidationRules {
+	r := *vr
+	r.Categories = nil
+
+	o, ok := vr.Categories[id]
+	if !ok {
+		return r
+	}
+	if o.MaxTitleLength != 0 {
+		r.MaxTitleLength = o.MaxTitleLength
+	}
+	if o.MaxDescriptionLength != 0 {
+		r.MaxDescriptionLength = o.MaxDescriptionLength
+	}
+	if o.MinPrice != 0 {
+		r.MinPrice = o.MinPrice
+	}
+	if o.MaxPrice != 0 {
+		r.MaxPrice = o.MaxPrice
+	}
+	if o.MaxImages != 0 {
+		r.MaxImages = o.MaxImages
+	}
+
+	return r
+}
+
+// loadValidationRules returns the compiled in defaults overridden by the json
+// object in s3 (VALIDATION_RULES_KEY) and then by VALIDATION_RULES
+func (m *monitor) loadValidationRules(ctx context.Context) (*ValidationRules, error) {
+	rules := defaultValidationRules
+
+	if m.cfg.ValidationRulesKey != "" {
+		m.log.WithField("key", m.cfg.ValidationRulesKey).Info("loading validation rules...")
+
+		obj, err := m.getImagesObject(ctx, m.cfg.ValidationRulesKey)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+
+		if err := json.NewDecoder(obj.Body).Decode(&rules); err != nil {
+			return nil, fmt.Errorf("invalid validation rules %s: %v", m.cfg.ValidationRulesKey, err)
+		}
+	}
+
+	if v := os.Getenv("VALIDATION_RULES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &rules); err != nil {
+			return nil, fmt.Errorf("invalid VALIDATION_RULES: %v", err)
+		}
+	}
+
+	return &rules, nil
+}
+
+// validator checks items before any bolha call is made so that an invalid
+// item never gets its active ad removed
+type validator struct {
+	m     *monitor
+	rules *ValidationRules
+
+	mu   sync.Mutex
+	cats categorySet
+	// cats came from the cache and may miss categories added since,
+	// they are read from s3 at most once per run on an unknown category
+	catsCached    bool
+	catsRefreshed bool
+}
+
+// category validates id, reading the categories from s3 again if the
+// cached ones do not know it
+func (v *validator) category(ctx context.Context, id int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	err := v.cats.validate(id)
+	if err == nil || !v.catsCached || v.catsRefreshed {
+		return err
+	}
+	v.catsRefreshed = true
+
+	v.m.log.WithField("AdCategoryId", id).Info("unknown cached category, refreshing categories...")
+	cats, cached, lerr := v.m.loadCategories(ctx, true)
+	if lerr != nil || cached {
+		return err
+	}
+	v.cats, v.catsCached = cats, false
+
+	return v.cats.validate(id)
+}
+
+func (v *validator) validate(ctx context.Context, bItem *BolhaItem) error {
+	violations := make([]Violation, 0)
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// nothing else of an item which did not unmarshal is known
+	if bItem.unmarshalErr != nil {
+		violate(ruleUnmarshal, "%v", bItem.unmarshalErr)
+		return &ValidationError{AdTitle: bItem.AdTitle, Violations: violations}
+	}
+
+	ic, unknown, err := v.m.cfg.forItem(bItem.Overrides)
+	if err != nil {
+		violate(ruleOverrides, "invalid override: %v", err)
+	}
+	// issues a run works around are only warned about unless STRICT_MODE,
+	// see strict.go
+	strict := v.m.cfg.StrictMode
+	if len(unknown) > 0 {
+		if strict {
+			violate(ruleOverrides, "unknown overrides %s", strings.Join(unknown, ", "))
+		} else {
+			v.m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "keys": unknown}).Warn("ignoring unknown overrides")
+		}
+	}
+	bItem.cfg = ic
+	v.m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "config": ic}).Debug("effective item config")
+
+	if strict && len(bItem.coerced) > 0 {
+		violate(ruleCoercion, "attributes %s have the wrong type", strings.Join(bItem.coerced, ", "))
+	}
+
+	bItem.checkUploadedAt(v.m.now(), v.m.cfg.UploadedAtTolerance, v.m.cfg.UploadedAtEpoch)
+	if bItem.uploadedAtAnomaly != "" {
+		if strict {
+			violate(ruleUploadedAt, "implausible upload time: %s", bItem.uploadedAtAnomaly)
+		} else {
+			v.m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "anomaly": bItem.uploadedAtAnomaly}).Warn("implausible upload time, treating it as unknown")
+		}
+	}
+
+	if bItem.AdTitle == "" {
+		violate(ruleRequired, "title is empty")
+	}
+	if bItem.UserId == "" && bItem.UserSessionId == "" {
+		violate(ruleRequired, "neither UserId nor UserSessionId is set")
+	}
+	if v.cats != nil {
+		if err := v.category(ctx, bItem.AdCategoryId); err != nil {
+			violate(ruleCategory, "%v", err)
+		}
+	}
+
+	if _, ok := bItem.localized(); bItem.AdLanguage != "" && !ok {
+		violate(ruleLanguage, "AdLanguage %q is not in Localized", bItem.AdLanguage)
+	}
+
+	// uploading without the video would publish a different ad than asked for
+	if bItem.AdVideo != "" {
+		violate(ruleVideo, "video unsupported: the bolha client cannot attach AdVideo %q, remove it to upload the ad without", bItem.AdVideo)
+	}
+
+	if bItem.PublishAt != "" {
+		if _, err := parseLocalTime(bItem.PublishAt, bItem.loc); err != nil {
+			violate(rulePublishAt, "invalid PublishAt: %v", err)
+		}
+	}
+
+	for _, arn := range []string{bItem.PreReuploadLambdaArn, bItem.PostReuploadLambdaArn} {
+		if arn == "" {
+			continue
+		}
+		if err := validateHook(arn, v.m.cfg.FunctionName); err != nil {
+			violate(ruleHooks, "invalid reupload hook: %v", err)
+		}
+	}
+
+	if bItem.ReuploadPage < 0 {
+		violate(ruleReuploadPage, "ReuploadPage %d is negative", bItem.ReuploadPage)
+	} else if bItem.ReuploadPage > 0 && bItem.ReuploadOrder != 0 {
+		violate(ruleReuploadPage, "only one of ReuploadPage and ReuploadOrder may be set")
+	}
+
+	switch {
+	case bItem.ForceReuploadAfterHours < 0 || bItem.NeverReuploadBeforeHours < 0:
+		violate(ruleAgeLimits, "ForceReuploadAfterHours and NeverReuploadBeforeHours must not be negative")
+	case bItem.ForceReuploadAfterHours > 0 && bItem.NeverReuploadBeforeHours >= bItem.ForceReuploadAfterHours:
+		violate(ruleAgeLimits, "NeverReuploadBeforeHours %d is not below ForceReuploadAfterHours %d", bItem.NeverReuploadBeforeHours, bItem.ForceReuploadAfterHours)
+	}
+
+	switch bItem.AdCondition {
+	case "", conditionNew, conditionUsed:
+	default:
+		violate(ruleCondition, "condition %q is neither %q nor %q", bItem.AdCondition, conditionNew, conditionUsed)
+	}
+
+	if bItem.WebhookURL != "" {
+		if err := validateWebhookURL(bItem.WebhookURL); err != nil {
+			violate(ruleWebhook, "invalid WebhookURL: %v", err)
+		}
+	}
+
+	switch bItem.RefreshStrategy {
+	case "", refreshRepost:
+	case refreshBump:
+		if !bItem.FallbackToRepost {
+			violate(ruleRefreshStrategy, "bolha client cannot bump ads, set FallbackToRepost to repost instead")
+		}
+	default:
+		violate(ruleRefreshStrategy, "refresh strategy %q is neither %q nor %q", bItem.RefreshStrategy, refreshRepost, refreshBump)
+	}
+
+	if bItem.AdPrice == invalidPrice {
+		violate(rulePrice, "price must be a non-negative amount of euros with at most two decimals")
+	}
+
+	switch bItem.priceType() {
+	case priceTypeFixed:
+		if bItem.AdPrice <= 0 && bItem.AdPrice != invalidPrice {
+			violate(rulePriceType, "fixed price must be above 0, got %s", bItem.AdPrice)
+		}
+	case priceTypeFree:
+		if bItem.AdPrice != 0 && bItem.AdPrice != invalidPrice {
+			violate(rulePriceType, "free item must have price 0, got %s", bItem.AdPrice)
+		}
+	case priceTypeNegotiable:
+	default:
+		violate(rulePriceType, "price type %q is not one of %q, %q or %q", bItem.AdPriceType, priceTypeFixed, priceTypeNegotiable, priceTypeFree)
+	}
+
+	rules := v.rules.forCategory(bItem.AdCategoryId)
+
+	if n := utf8.RuneCountInString(bItem.uploadTitle()); rules.MaxTitleLength > 0 && n > rules.MaxTitleLength {
+		violate(ruleMaxTitleLength, "title has %d characters, at most %d allowed", n, rules.MaxTitleLength)
+	}
+	// an outage of the images buckets does not make the item invalid, it
+	// is still observed but not uploaded, see imagebreaker.go. The length is
+	// the one of the description uploaded, with its variation and closing.
+	if _, err := v.m.resolveDescription(ctx, bItem); imagesOutage(err) {
+		v.imagesUnavailable(bItem, err)
+	} else if err != nil {
+		violate(ruleDescription, "could not resolve description: %v", err)
+	} else if n := utf8.RuneCountInString(bItem.uploadDescription()); rules.MaxDescriptionLength > 0 && n > rules.MaxDescriptionLength {
+		violate(ruleMaxDescriptionLength, "description has %d characters, at most %d allowed", n, rules.MaxDescriptionLength)
+	}
+	if strict && bItem.descriptionFallback != nil {
+		violate(ruleDescription, "could not read AdDescriptionKey %q: %v", bItem.AdDescriptionKey, bItem.descriptionFallback)
+	}
+	if bItem.AdPrice != invalidPrice && bItem.AdPrice < eurosPrice(rules.MinPrice) {
+		violate(ruleMinPrice, "price %s is below %d", bItem.AdPrice, rules.MinPrice)
+	}
+	if rules.MaxPrice > 0 && bItem.AdPrice > eurosPrice(rules.MaxPrice) {
+		violate(ruleMaxPrice, "price %s is above %d", bItem.AdPrice, rules.MaxPrice)
+	}
+
+	images, err := v.m.resolveImages(ctx, bItem)
+	if imagesOutage(err) {
+		v.imagesUnavailable(bItem, err)
+	} else if err != nil {
+		violate(ruleImages, "could not resolve images: %v", err)
+	} else if len(images) == 0 {
+		// almost always a data entry mistake, ads without images are not seen
+		empty := "AdImages is empty"
+		if bItem.AdImagesPrefix != "" {
+			empty = fmt.Sprintf("AdImagesPrefix %q lists no images", bItem.AdImagesPrefix)
+		}
+		if strict {
+			violate(ruleEmptyImages, "%s", empty)
+		} else if bItem.cfg.EmptyImagesPolicy == emptyImagesAllow {
+			v.m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "reason": empty}).Warn("item has no images, uploading it without any")
+			bItem.noImages = true
+		} else {
+			violate(ruleEmptyImages, "%s, set EMPTY_IMAGES_POLICY to %q to upload the item without images", empty, emptyImagesAllow)
+		}
+	} else if rules.MaxImages > 0 && len(images) > rules.MaxImages {
+		violate(ruleMaxImages, "%d images, at most %d allowed", len(images), rules.MaxImages)
+	}
+
+	if strict {
+		violations = append(violations, bItem.uploadLosses()...)
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{AdTitle: bItem.AdTitle, Violations: violations}
+	}
+
+	return nil
+}
+
+// imagesUnavailable remembers that the content of bItem could not be
+// resolved because the images buckets are unavailable
+func (v *validator) imagesUnavailable(bItem *BolhaItem, err error) {
+	v.m.log.WithField("AdTitle", bItem.AdTitle).WithError(err).Warn("images unavailable, item is only observed")
+	if bItem.imagesErr == nil {
+		bItem.imagesErr = err
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// set at build time, e.g.
+// go build -ldflags "-X main.gitCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+var (
+	gitCommit string
+	buildTime string
+)
+
+var version = readVersionInfo()
+
+// VersionInfo describes the build of the running lambda
+type VersionInfo struct {
+	GitCommit string `json:"gitCommit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+func (v VersionInfo) String() string {
+	return v.GitCommit + "@" + v.BuildTime
+}
+
+func (v VersionInfo) fields() log.Fields {
+	return log.Fields{
+		"gitCommit": v.GitCommit,
+		"buildTime": v.BuildTime,
+		"goVersion": v.GoVersion,
+	}
+}
+
+func readVersionInfo() VersionInfo {
+	v := VersionInfo{
+		GitCommit: gitCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	// fall back to vcs info embedded by the go toolchain when ldflags are not set
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		var modified bool
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if v.GitCommit == "" {
+					v.GitCommit = s.Value
+				}
+			case "vcs.time":
+				if v.BuildTime == "" {
+					v.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && gitCommit == "" && v.GitCommit != "" {
+			v.GitCommit += "-dirty"
+		}
+	}
+
+	if v.GitCommit == "" {
+		v.GitCommit = "unknown"
+	}
+	if v.BuildTime == "" {
+		v.BuildTime = "unknown"
+	}
+
+	return v
+}
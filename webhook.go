@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhookUploaded   = "uploaded"
+	webhookReuploaded = "reuploaded"
+
+	// hex hmac-sha256 of the body keyed with WEBHOOK_SECRET
+	webhookSignatureHeader = "X-Bolha-Signature"
+
+	webhookRetries    = 2
+	webhookRetryDelay = time.Second
+)
+
+// WebhookPayload is posted to the WebhookURL of an item once its ad changed
+type WebhookPayload struct {
+	Event         string    `json:"event"`
+	Table         string    `json:"table"`
+	AdTitle       string    `json:"adTitle"`
+	OldUploadedId int64     `json:"oldUploadedId,omitempty"`
+	NewUploadedId int64     `json:"newUploadedId"`
+	Timestamp     time.Time `json:"timestamp"`
+	RunId         string    `json:"runId"`
+}
+
+// validateWebhookURL accepts absolute http and https urls
+func validateWebhookURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) url", s)
+	}
+	return nil
+}
+
+// signWebhook returns the signature of body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// callWebhook posts the change of the uploaded id of bItem to its webhook.
+// Failures are logged and reported but never fail the item.
+func (m *monitor) callWebhook(ctx context.Context, bItem *BolhaItem, event string, oldId, newId int64, ir *ItemReport) {
+	if bItem.WebhookURL == "" {
+		return
+	}
+
+	p := WebhookPayload{
+		Event:         event,
+		Table:         m.table,
+		AdTitle:       bItem.AdTitle,
+		OldUploadedId: oldId,
+		NewUploadedId: newId,
+		Timestamp:     m.now().UTC(),
+		RunId:         m.runId,
+	}
+	if err := m.postWebhook(ctx, bItem.WebhookURL, &p); err != nil {
+		m.log.WithFields(log.Fields{"AdTitle": bItem.AdTitle, "event": event}).WithError(err).Warn("webhook failed")
+		ir.WebhookError = err.Error()
+	}
+}
+
+// postWebhook posts p to u, retrying failed attempts
+func (m *monitor) postWebhook(ctx context.Context, u string, p *WebhookPayload) error {
+	if m.cfg.WebhookSecret == "" {
+		return errors.New("WEBHOOK_SECRET is not set, not calling unsigned webhook")
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	sig := signWebhook(m.cfg.WebhookSecret, body)
+
+	m.log.WithFields(log.Fields{"AdTitle": p.AdTitle, "event": p.Event}).Info("calling webhook...")
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			m.log.WithFields(log.Fields{"AdTitle": p.AdTitle, "attempt": attempt + 1}).WithError(err).Warn("retrying webhook...")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-m.clock.After(time.Duration(attempt) * webhookRetryDelay):
+			}
+		}
+
+		if err = doWebhook(ctx, m.cfg.WebhookTimeout, u, body, sig); err == nil || attempt == webhookRetries {
+			return err
+		}
+	}
+}
+
+// doWebhook posts the signed body to u once
+func doWebhook(ctx context.Context, timeout time.Duration, u string, body []byte, sig string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, sig)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+const testWebhookSecret = "webhook secret"
+
+// webhookServer answers the statuses in turn, then 200, and records the
+// payloads whose signature checks out
+type webhookServer struct {
+	*httptest.Server
+	t testing.TB
+
+	mu       sync.Mutex
+	statuses []int
+	calls    int
+	payloads []map[string]interface{}
+}
+
+func newWebhookServer(t testing.TB, statuses ...int) *webhookServer {
+	ws := &webhookServer{t: t, statuses: statuses}
+	ws.Server = httptest.NewServer(http.HandlerFunc(ws.serve))
+	t.Cleanup(ws.Close)
+	return ws
+}
+
+func (ws *webhookServer) serve(w http.ResponseWriter, r *http.Request) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.calls++
+	if r.Method != http.MethodPost {
+		ws.t.Errorf("method %s, want POST", r.Method)
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		ws.t.Errorf("content type %q, want application/json", ct)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		ws.t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write(body)
+	if sig := r.Header.Get("X-Bolha-Signature"); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		ws.t.Errorf("signature %q does not match the body", sig)
+	} else {
+		var p map[string]interface{}
+		if err := json.Unmarshal(body, &p); err != nil {
+			ws.t.Errorf("payload %s: %v", body, err)
+		}
+		ws.payloads = append(ws.payloads, p)
+	}
+
+	if len(ws.statuses) > 0 {
+		w.WriteHeader(ws.statuses[0])
+		ws.statuses = ws.statuses[1:]
+	}
+}
+
+func newWebhookMonitor(secret string) *monitor {
+	return &monitor{
+		cfg:   &Config{WebhookSecret: secret, WebhookTimeout: time.Second},
+		table: "items",
+		runId: "run-1",
+		log:   runLogger("run-1"),
+		clock: harness.NewClock(scenarioStart),
+	}
+}
+
+func TestCallWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		statuses []int
+		calls    int
+		failed   bool
+	}{
+		{"delivered", testWebhookSecret, nil, 1, false},
+		{"delivered on the last retry", testWebhookSecret, []int{500, 502}, 3, false},
+		{"retries used up", testWebhookSecret, []int{500, 500, 500}, 3, true},
+		{"no secret", "", nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newWebhookServer(t, tt.statuses...)
+			m := newWebhookMonitor(tt.secret)
+			bItem := &BolhaItem{AdTitle: "Gorsko kolo", WebhookURL: ws.URL}
+			ir := &ItemReport{}
+
+			m.callWebhook(context.Background(), bItem, webhookReuploaded, 1000, 1001, ir)
+
+			if ws.calls != tt.calls {
+				t.Errorf("%d calls, want %d", ws.calls, tt.calls)
+			}
+			if failed := ir.WebhookError != ""; failed != tt.failed {
+				t.Errorf("webhook error %q, want failed %v", ir.WebhookError, tt.failed)
+			}
+			if tt.calls == 0 {
+				return
+			}
+
+			want := map[string]interface{}{
+				"event":         "reuploaded",
+				"table":         "items",
+				"adTitle":       "Gorsko kolo",
+				"oldUploadedId": float64(1000),
+				"newUploadedId": float64(1001),
+				"timestamp":     "2026-10-01T10:00:00Z",
+				"runId":         "run-1",
+			}
+			for i, p := range ws.payloads {
+				if !reflect.DeepEqual(p, want) {
+					t.Errorf("payload %d %v, want %v", i+1, p, want)
+				}
+			}
+		})
+	}
+}
+
+// The payload of a first upload has no old uploaded id
+func TestCallWebhookUploaded(t *testing.T) {
+	ws := newWebhookServer(t)
+	m := newWebhookMonitor(testWebhookSecret)
+	bItem := &BolhaItem{AdTitle: "Gorsko kolo", WebhookURL: ws.URL}
+
+	m.callWebhook(context.Background(), bItem, webhookUploaded, 0, 1000, &ItemReport{})
+
+	if len(ws.payloads) != 1 {
+		t.Fatalf("%d payloads, want 1", len(ws.payloads))
+	}
+	keys := make([]string, 0)
+	for k := range ws.payloads[0] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if want := []string{"adTitle", "event", "newUploadedId", "runId", "table", "timestamp"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("payload keys %v, want %v", keys, want)
+	}
+	if ev := ws.payloads[0]["event"]; ev != "uploaded" {
+		t.Errorf("event %v, want uploaded", ev)
+	}
+}
+
+// A reupload calls the webhook of the item, a failing webhook does not fail
+// the item
+func TestScenarioReuploadCallsWebhook(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			const title = "Gorsko kolo"
+			t.Setenv("WEBHOOK_SECRET", testWebhookSecret)
+
+			ws := newWebhookServer(t, status, status, status)
+			s := newScenario(t, "sinking", harness.Step{Orders: map[int64]int{1000: 40}})
+			s.update(title, map[string]interface{}{"WebhookURL": ws.URL})
+
+			report, err := s.run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir := itemReport(t, report, title)
+			if ir.Status != statusReuploaded {
+				t.Fatalf("status %q, want %q", ir.Status, statusReuploaded)
+			}
+			if failed := ir.WebhookError != ""; failed != (status != http.StatusOK) {
+				t.Errorf("webhook error %q with the webhook answering %d", ir.WebhookError, status)
+			}
+			if len(ws.payloads) == 0 {
+				t.Fatal("webhook not called")
+			}
+			if p := ws.payloads[0]; p["event"] != "reuploaded" || p["oldUploadedId"] != float64(1000) || p["newUploadedId"] != float64(1001) {
+				t.Errorf("payload %v, want the reupload of 1000 as 1001", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// concurrent UpdateItem calls when flushing deferred writes
+	itemWritesConcurrency = 4
+
+	// attempts of a throttled deferred write and the delay before the first
+	// retry, doubled for every further one
+	itemWriteAttempts   = 5
+	itemWriteRetryDelay = 200 * time.Millisecond
+
+	// time a queued write gets, including its retries, it outlives the
+	// run's context
+	queuedWriteTimeout = 15 * time.Second
+)
+
+// itemWrites collects attribute updates which do not have to be persisted
+// right away and writes them with a single UpdateItem per item at the end of
+// a run. BatchWriteItem only puts whole items and would overwrite changes
+// made to an item during the run, so it is not used here. Counters are
+// never set to a value read earlier, they are added to with ADD so an
+// increment made elsewhere in the meantime is not lost.
+//
+// With DEFERRED_WRITES_PER_SECOND the writes of an item are queued once the
+// item is done and written at that rate while the run goes on, so they do
+// not all land at its end. A full queue leaves them to the flush. Writes
+// which must not wait, like the uploaded id, never go through itemWrites.
+type itemWrites struct {
+	m     *monitor
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+	adds  map[string]map[string]int
+
+	// nil unless paced, see start
+	queue    chan queuedWrite
+	drained  chan struct{}
+	limiter  *rate.Limiter
+	maxDepth int
+	errs     []error
+}
+
+// queuedWrite holds the writes of an item released to the queue
+type queuedWrite struct {
+	adTitle string
+	attrs   map[string]types.AttributeValue
+	adds    map[string]int
+}
+
+func (m *monitor) newItemWrites() *itemWrites {
+	return &itemWrites{
+		m:     m,
+		items: make(map[string]map[string]types.AttributeValue),
+		adds:  make(map[string]map[string]int),
+	}
+}
+
+// start paces the writes of released items over the run, a no-op without
+// DEFERRED_WRITES_PER_SECOND
+func (w *itemWrites) start(ctx context.Context) {
+	cfg := w.m.cfg
+	if cfg.DeferredWritesPerSecond <= 0 || cfg.DeferredWritesQueue <= 0 {
+		return
+	}
+
+	w.queue = make(chan queuedWrite, cfg.DeferredWritesQueue)
+	w.drained = make(chan struct{})
+	w.limiter = rate.NewLimiter(rate.Limit(cfg.DeferredWritesPerSecond), 1)
+
+	go func() {
+		defer close(w.drained)
+
+		for qw := range w.queue {
+			// once the run is canceled the rest is written without pacing,
+			// but written all the same
+			_ = w.limiter.Wait(ctx)
+			wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), queuedWriteTimeout)
+			err := w.m.updateAttributesRetrying(wctx, qw.adTitle, qw.attrs, qw.adds)
+			cancel()
+			if err != nil {
+				w.mu.Lock()
+				w.errs = append(w.errs, fmt.Errorf("%s: %v", qw.adTitle, err))
+				w.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// release queues the pending writes of item adTitle, which is done for the
+// run. They stay pending if the queue is full or writes are not paced.
+func (w *itemWrites) release(adTitle string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.queue == nil || (w.items[adTitle] == nil && w.adds[adTitle] == nil) {
+		return
+	}
+
+	select {
+	case w.queue <- queuedWrite{adTitle: adTitle, attrs: w.items[adTitle], adds: w.adds[adTitle]}:
+		delete(w.items, adTitle)
+		delete(w.adds, adTitle)
+		if d := len(w.queue); d > w.maxDepth {
+			w.maxDepth = d
+		}
+	default:
+		w.m.log.WithField("AdTitle", adTitle).Debug("deferred writes queue full, leaving writes to the flush")
+	}
+}
+
+// pending reports whether item adTitle has deferred writes which are not
+// queued yet
+func (w *itemWrites) pending(adTitle string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.items[adTitle]) > 0 || len(w.adds[adTitle]) > 0
+}
+
+// set defers setting attribute name of item adTitle to v
+func (w *itemWrites) set(adTitle, name string, v types.AttributeValue) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.items[adTitle] == nil {
+		w.items[adTitle] = make(map[string]types.AttributeValue)
+	}
+	w.items[adTitle][name] = v
+	delete(w.adds[adTitle], name)
+}
+
+// add defers adding delta to the number attribute name of item adTitle, a
+// missing attribute counts as 0
+func (w *itemWrites) add(adTitle, name string, delta int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// an update cannot both set and add to an attribute
+	if v, ok := w.items[adTitle][name].(*types.AttributeValueMemberN); ok {
+		if n, err := strconv.Atoi(v.Value); err == nil {
+			w.items[adTitle][name] = &types.AttributeValueMemberN{Value: strconv.Itoa(n + delta)}
+			return
+		}
+	}
+
+	if w.adds[adTitle] == nil {
+		w.adds[adTitle] = make(map[string]int)
+	}
+	w.adds[adTitle][name] += delta
+}
+
+// flush writes all deferred updates, the queued ones first, returning the
+// first error
+func (w *itemWrites) flush(ctx context.Context) error {
+	start := w.m.now()
+	defer func() {
+		w.m.metrics.put("DeferredWritesFlushDuration", float64(w.m.now().Sub(start).Milliseconds()), unitMilliseconds)
+	}()
+
+	if w.queue != nil {
+		close(w.queue)
+		<-w.drained
+		w.queue = nil
+		w.m.metrics.put("DeferredWritesQueueDepth", float64(w.maxDepth), unitCount)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.errs) > 0 {
+		err := w.errs[0]
+		w.errs = nil
+		if ferr := w.flushPending(ctx); ferr != nil {
+			w.m.log.WithError(ferr).Warn("could not flush deferred writes")
+		}
+		return err
+	}
+
+	return w.flushPending(ctx)
+}
+
+// flushPending writes the updates which were not queued, w.mu is held
+func (w *itemWrites) flushPending(ctx context.Context) error {
+	titles := make(map[string]struct{}, len(w.items)+len(w.adds))
+	for adTitle := range w.items {
+		titles[adTitle] = struct{}{}
+	}
+	for adTitle := range w.adds {
+		titles[adTitle] = struct{}{}
+	}
+	if len(titles) == 0 {
+		return nil
+	}
+
+	w.m.log.WithField("items", len(titles)).Info("flushing deferred writes...")
+
+	var wg sync.WaitGroup
+
+	errChan := make(chan error, len(titles))
+	sem := make(chan struct{}, itemWritesConcurrency)
+
+	for adTitle := range titles {
+		adTitle1, attrs1, adds1 := adTitle, w.items[adTitle], w.adds[adTitle]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := w.m.updateAttributesRetrying(ctx, adTitle1, attrs1, adds1); err != nil {
+				errChan <- fmt.Errorf("%s: %v", adTitle1, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	w.items = make(map[string]map[string]types.AttributeValue)
+	w.adds = make(map[string]map[string]int)
+
+	for err := range errChan {
+		return err
+	}
+
+	return nil
+}
+
+// DYNAMODB
+
+// updateAttributes sets attrs and adds to the counters adds of item adTitle
+// in a single update
+func (m *monitor) updateAttributes(ctx context.Context, adTitle string, attrs map[string]types.AttributeValue, adds map[string]int) error {
+	expr, exprNames, exprValues := updateExpression(attrs, adds)
+	if expr == "" {
+		return nil
+	}
+
+	_, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames:  exprNames,
+		ExpressionAttributeValues: exprValues,
+		Key:                       map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression:          aws.String(expr),
+		TableName:                 aws.String(m.table),
+	})
+
+	return itemSizeError(adTitle, err)
+}
+
+// updateAttributesRetrying is updateAttributes retrying throttled updates
+// beyond the retries of the sdk, deferred writes are never given up on
+// because the table is busy
+func (m *monitor) updateAttributesRetrying(ctx context.Context, adTitle string, attrs map[string]types.AttributeValue, adds map[string]int) error {
+	delay := itemWriteRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := m.updateAttributes(ctx, adTitle, attrs, adds)
+		if err == nil || attempt == itemWriteAttempts || !throttled(err) {
+			return err
+		}
+
+		m.log.WithFields(log.Fields{"AdTitle": adTitle, "attempt": attempt}).WithError(err).Warn("deferred write throttled, retrying...")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-m.clock.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// incrementCounter adds delta to the number attribute attr of item adTitle
+// and returns the new value, a missing attribute counts as 0
+func (m *monitor) incrementCounter(ctx context.Context, adTitle, attr string, delta int) (int, error) {
+	m.log.WithFields(log.Fields{"AdTitle": adTitle, "attr": attr, "delta": delta}).Info("incrementing counter...")
+
+	expr, exprNames, exprValues := updateExpression(nil, map[string]int{attr: delta})
+
+	result, err := m.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames:  exprNames,
+		ExpressionAttributeValues: exprValues,
+		Key:                       map[string]types.AttributeValue{"AdTitle": &types.AttributeValueMemberS{Value: adTitle}},
+		UpdateExpression:          aws.String(expr),
+		ReturnValues:              types.ReturnValueUpdatedNew,
+		TableName:                 aws.String(m.table),
+	})
+	if err != nil {
+		return 0, itemSizeError(adTitle, err)
+	}
+
+	var n int
+	if err := attributevalue.Unmarshal(result.Attributes[attr], &n); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// HELPERS
+
+// updateExpression returns an update expression setting attrs and adding to
+// adds, names and values are placeholders so any attribute name is allowed.
+// Zero deltas are left out, the expression is empty if nothing is left.
+func updateExpression(attrs map[string]types.AttributeValue, adds map[string]int) (string, map[string]string, map[string]types.AttributeValue) {
+	setNames := make([]string, 0, len(attrs))
+	for name := range attrs {
+		setNames = append(setNames, name)
+	}
+	sort.Strings(setNames)
+
+	addNames := make([]string, 0, len(adds))
+	for name, delta := range adds {
+		if delta != 0 {
+			addNames = append(addNames, name)
+		}
+	}
+	sort.Strings(addNames)
+
+	exprNames := make(map[string]string, len(setNames)+len(addNames))
+	exprValues := make(map[string]types.AttributeValue, len(setNames)+len(addNames))
+	placeholders := func(name string) (string, string) {
+		i := len(exprNames)
+		n, v := fmt.Sprintf("#a%d", i), fmt.Sprintf(":v%d", i)
+		exprNames[n] = name
+		return n, v
+	}
+
+	sets := make([]string, len(setNames))
+	for i, name := range setNames {
+		n, v := placeholders(name)
+		exprValues[v] = attrs[name]
+		sets[i] = n + " = " + v
+	}
+	addExprs := make([]string, len(addNames))
+	for i, name := range addNames {
+		n, v := placeholders(name)
+		exprValues[v] = &types.AttributeValueMemberN{Value: strconv.Itoa(adds[name])}
+		addExprs[i] = n + " " + v
+	}
+
+	clauses := make([]string, 0, 2)
+	if len(sets) > 0 {
+		clauses = append(clauses, "SET "+strings.Join(sets, ", "))
+	}
+	if len(addExprs) > 0 {
+		clauses = append(clauses, "ADD "+strings.Join(addExprs, ", "))
+	}
+
+	return strings.Join(clauses, " "), exprNames, exprValues
+}
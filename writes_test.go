@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/seniorescobar/bolha-lambda-monitor/internal/harness"
+)
+
+// throttlingStore throttles the first throttles updates and, like the sdk,
+// refuses calls once their context is done
+type throttlingStore struct {
+	*harness.Store
+
+	mu        sync.Mutex
+	throttles int
+	updates   int
+}
+
+func (s *throttlingStore) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.updates++
+	throttle := s.updates <= s.throttles
+	s.mu.Unlock()
+
+	if throttle {
+		return nil, &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+	}
+	return s.Store.UpdateItem(ctx, params, optFns...)
+}
+
+func newWritesMonitor(throttles int) (*monitor, *throttlingStore) {
+	store := &throttlingStore{Store: harness.NewStore(), throttles: throttles}
+	store.AddTable("items", "AdTitle")
+
+	m := &monitor{
+		cfg:     &Config{DeferredWritesPerSecond: 1000, DeferredWritesQueue: 10},
+		table:   "items",
+		log:     runLogger("run-1"),
+		clock:   harness.NewClock(scenarioStart),
+		ddb:     store,
+		metrics: newMetricSet(),
+	}
+	return m, store
+}
+
+// Queued writes are retried while throttled and written even once the run
+// they were queued by is canceled
+func TestQueuedWritesThrottled(t *testing.T) {
+	tests := []struct {
+		name      string
+		throttles int
+		cancel    bool
+	}{
+		{"throttled", 2, false},
+		{"run canceled", 0, true},
+		{"throttled and run canceled", 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, store := newWritesMonitor(tt.throttles)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			w := m.newItemWrites()
+			w.start(ctx)
+			w.set("Gorsko kolo", "AdState", &types.AttributeValueMemberS{Value: adStateActive})
+			w.add("Gorsko kolo", "ReuploadGainCount", 1)
+			if tt.cancel {
+				cancel()
+			}
+			w.release("Gorsko kolo")
+			if w.pending("Gorsko kolo") {
+				t.Fatal("writes not queued")
+			}
+
+			if err := w.flush(context.Background()); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+			if store.updates != tt.throttles+1 {
+				t.Errorf("%d updates, want %d", store.updates, tt.throttles+1)
+			}
+			it := store.Item("items", "Gorsko kolo")
+			if it["AdState"] != adStateActive || it["ReuploadGainCount"] != float64(1) {
+				t.Errorf("item %v, want the queued writes", it)
+			}
+		})
+	}
+}
+
+// A write throttled past its attempts is reported by the flush
+func TestQueuedWritesGivenUp(t *testing.T) {
+	m, store := newWritesMonitor(itemWriteAttempts)
+
+	w := m.newItemWrites()
+	w.start(context.Background())
+	w.set("Gorsko kolo", "AdState", &types.AttributeValueMemberS{Value: adStateActive})
+	w.release("Gorsko kolo")
+
+	if err := w.flush(context.Background()); err == nil {
+		t.Error("flush succeeded, want the throttling error")
+	}
+	if store.updates != itemWriteAttempts {
+		t.Errorf("%d updates, want %d", store.updates, itemWriteAttempts)
+	}
+}
+
+func TestUpdateExpression(t *testing.T) {
+	s := func(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+	n := func(v string) types.AttributeValue { return &types.AttributeValueMemberN{Value: v} }
+
+	tests := []struct {
+		name   string
+		attrs  map[string]types.AttributeValue
+		adds   map[string]int
+		expr   string
+		names  map[string]string
+		values map[string]types.AttributeValue
+	}{
+		{
+			name:   "nothing",
+			expr:   "",
+			names:  map[string]string{},
+			values: map[string]types.AttributeValue{},
+		},
+		{
+			name:   "set only",
+			attrs:  map[string]types.AttributeValue{"LastCheckedAt": s("2026-10-01T12:00:00Z"), "AdState": s("active")},
+			expr:   "SET #a0 = :v0, #a1 = :v1",
+			names:  map[string]string{"#a0": "AdState", "#a1": "LastCheckedAt"},
+			values: map[string]types.AttributeValue{":v0": s("active"), ":v1": s("2026-10-01T12:00:00Z")},
+		},
+		{
+			name:   "add only",
+			adds:   map[string]int{"ReuploadGainSum": -3, "ReuploadGainCount": 1},
+			expr:   "ADD #a0 :v0, #a1 :v1",
+			names:  map[string]string{"#a0": "ReuploadGainCount", "#a1": "ReuploadGainSum"},
+			values: map[string]types.AttributeValue{":v0": n("1"), ":v1": n("-3")},
+		},
+		{
+			name:   "mixed",
+			attrs:  map[string]types.AttributeValue{"AdState": s("active")},
+			adds:   map[string]int{"FailCount": 1},
+			expr:   "SET #a0 = :v0 ADD #a1 :v1",
+			names:  map[string]string{"#a0": "AdState", "#a1": "FailCount"},
+			values: map[string]types.AttributeValue{":v0": s("active"), ":v1": n("1")},
+		},
+		{
+			name:   "zero delta only",
+			adds:   map[string]int{"FailCount": 0},
+			expr:   "",
+			names:  map[string]string{},
+			values: map[string]types.AttributeValue{},
+		},
+		{
+			name:   "zero delta left out",
+			attrs:  map[string]types.AttributeValue{"AdState": s("active")},
+			adds:   map[string]int{"FailCount": 0, "ReuploadGainCount": 2},
+			expr:   "SET #a0 = :v0 ADD #a1 :v1",
+			names:  map[string]string{"#a0": "AdState", "#a1": "ReuploadGainCount"},
+			values: map[string]types.AttributeValue{":v0": s("active"), ":v1": n("2")},
+		},
+		{
+			name:   "reserved and odd names",
+			attrs:  map[string]types.AttributeValue{"Name": s("x"), "a.b c": s("y")},
+			expr:   "SET #a0 = :v0, #a1 = :v1",
+			names:  map[string]string{"#a0": "Name", "#a1": "a.b c"},
+			values: map[string]types.AttributeValue{":v0": s("x"), ":v1": s("y")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, names, values := updateExpression(tt.attrs, tt.adds)
+			if expr != tt.expr {
+				t.Errorf("expression %q, want %q", expr, tt.expr)
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("names %v, want %v", names, tt.names)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("values %v, want %v", values, tt.values)
+			}
+		})
+	}
+}